@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"logsonic/pkg/backup"
+)
+
+// runBackupCommand asks the running server for a snapshot archive, so ingest
+// can continue while the backup is taken. The download is verified against its
+// manifest before it is moved into place.
+func runBackupCommand(args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	baseURL := fs.String("url", "", "LogSonic base URL (default: LOGSONIC_URL or http://localhost:8080)")
+	output := fs.String("o", "", "Archive path (default: logsonic-snapshot-<time>.tar.gz)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	url := strings.TrimRight(*baseURL, "/")
+	if url == "" {
+		url = strings.TrimRight(os.Getenv("LOGSONIC_URL"), "/")
+	}
+	if url == "" {
+		url = "http://localhost:8080"
+	}
+	path := *output
+	if path == "" {
+		path = fmt.Sprintf("logsonic-snapshot-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	}
+
+	resp, err := http.Post(url+"/api/v1/admin/snapshot", "application/json", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup: request failed: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		if err := decodeAPIResponse(resp, &struct{}{}); err != nil {
+			fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "backup: HTTP %d\n", resp.StatusCode)
+		}
+		return 1
+	}
+
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+		return 1
+	}
+	defer os.Remove(tmp)
+	written, err := io.Copy(out, resp.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup: download failed: %v\n", err)
+		return 1
+	}
+
+	in, err := os.Open(tmp)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+		return 1
+	}
+	manifest, err := backup.Verify(in)
+	in.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup: downloaded archive is invalid: %v\n", err)
+		return 1
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s: %d shards, %d files, %d bytes (%d uncompressed)\n",
+		path, len(manifest.Shards), len(manifest.Files), written, manifest.Size())
+	return 0
+}
+
+// runRestoreCommand extracts an archive into the storage directory. It writes
+// the shards directly, so the server must be stopped.
+func runRestoreCommand(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	storageFlag := fs.String("storage", "", "Path to storage directory (default: STORAGE_PATH or per-user app data dir)")
+	force := fs.Bool("force", false, "Replace the data already in the storage directory")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		printRestoreUsage()
+		return 2
+	}
+
+	storagePath, err := resolveStoragePath(*storageFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "restore: %v\n", err)
+		return 1
+	}
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "restore: %v\n", err)
+		return 1
+	}
+	defer in.Close()
+
+	manifest, err := backup.Restore(in, storagePath, backup.RestoreOptions{Force: *force})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "restore: %v\n", err)
+		if errors.Is(err, backup.ErrNotEmpty) {
+			fmt.Fprintln(os.Stderr, "pass -force to replace the existing data")
+		}
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "restored %d shards (%d files) from snapshot taken %s into %s\n",
+		len(manifest.Shards), len(manifest.Files), manifest.CreatedAt.Format(time.RFC3339), storagePath)
+	return 0
+}
+
+func printRestoreUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  logsonic restore [-storage DIR] [-force] snapshot.tar.gz")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Stop the LogSonic server before restoring.")
+}
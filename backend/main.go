@@ -31,6 +31,15 @@ func main() {
 	if len(os.Args) > 1 && os.Args[1] == "tail" {
 		os.Exit(runTailCommand(os.Args[2:]))
 	}
+	if len(os.Args) > 1 && os.Args[1] == "storage" {
+		os.Exit(runStorageCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		os.Exit(runBackupCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		os.Exit(runRestoreCommand(os.Args[2:]))
+	}
 
 	// Define command line flags
 	hostFlag := flag.String("host", "", "Host address to bind to (default: localhost or HOST env var)")
@@ -39,6 +48,11 @@ func main() {
 	openFlag := flag.Bool("open", false, "Open the web UI in your browser once the server starts")
 	autoPortFlag := flag.Bool("auto-port", true, "If the port is busy, bind the next free port instead of failing")
 	retentionFlag := flag.Int("retention-days", 0, "Delete indexed logs older than N days (0 = keep everything)")
+	maxStorageFlag := flag.Int64("max-storage-bytes", 0, "Evict the oldest shards once the storage dir exceeds N bytes (0 = no limit)")
+	shardFlag := flag.String("shard-granularity", "", "Time covered by each index shard: hour, day or week (default: persisted setting, else day)")
+	maxOpenShardsFlag := flag.Int("max-open-shards", 0, "Idle index shards kept open; older ones are closed until next used (default 64)")
+	regexpTimeoutFlag := flag.Duration("regex-timeout", 0, "Time one query may spend matching raw:~/regex/ terms (default 10s)")
+	regexpScanLimitFlag := flag.Int("regex-scan-limit", 0, "Stored lines one query may read to verify raw:~/regex/ terms (default 1000000)")
 	helpFlag := flag.Bool("help", false, "Show usage information")
 
 	// Parse command line arguments
@@ -73,17 +87,9 @@ func main() {
 		port = ":" + port
 	}
 
-	// Resolve storage path: flag > STORAGE_PATH env > per-user app data dir.
-	storagePath := *storageFlag
-	if storagePath == "" {
-		storagePath = os.Getenv("STORAGE_PATH")
-	}
-	if storagePath == "" {
-		p, derr := defaultStoragePath()
-		if derr != nil {
-			log.Fatalf("failed to resolve default storage path: %v", derr)
-		}
-		storagePath = p
+	storagePath, err := resolveStoragePath(*storageFlag)
+	if err != nil {
+		log.Fatalf("failed to resolve default storage path: %v", err)
 	}
 
 	// Get working directory for defaults
@@ -118,16 +124,62 @@ func main() {
 		}
 	}
 
+	maxStorageBytes := *maxStorageFlag
+	if maxStorageBytes == 0 {
+		if v := os.Getenv("MAX_STORAGE_BYTES"); v != "" {
+			if n, parseErr := strconv.ParseInt(v, 10, 64); parseErr == nil {
+				maxStorageBytes = n
+			}
+		}
+	}
+
+	shardGranularity := *shardFlag
+	if shardGranularity == "" {
+		shardGranularity = os.Getenv("SHARD_GRANULARITY")
+	}
+
+	maxOpenShards := *maxOpenShardsFlag
+	if maxOpenShards == 0 {
+		if v := os.Getenv("MAX_OPEN_SHARDS"); v != "" {
+			if n, parseErr := strconv.Atoi(v); parseErr == nil {
+				maxOpenShards = n
+			}
+		}
+	}
+
+	regexpTimeout := *regexpTimeoutFlag
+	if regexpTimeout == 0 {
+		if v := os.Getenv("REGEX_TIMEOUT"); v != "" {
+			if d, parseErr := time.ParseDuration(v); parseErr == nil {
+				regexpTimeout = d
+			}
+		}
+	}
+
+	regexpScanLimit := *regexpScanLimitFlag
+	if regexpScanLimit == 0 {
+		if v := os.Getenv("REGEX_SCAN_LIMIT"); v != "" {
+			if n, parseErr := strconv.Atoi(v); parseErr == nil {
+				regexpScanLimit = n
+			}
+		}
+	}
+
 	log.Println("Starting server from", host+port, "with storage path", storagePath)
 	cfg := server.Config{
-		Host:          host,
-		Port:          port,
-		StoragePath:   storagePath,
-		WorkDir:       workDir,
-		Timeout:       60 * time.Second,
-		OpenBrowser:   openBrowser,
-		AutoPort:      autoPort,
-		RetentionDays: retentionDays,
+		Host:             host,
+		Port:             port,
+		StoragePath:      storagePath,
+		WorkDir:          workDir,
+		Timeout:          60 * time.Second,
+		OpenBrowser:      openBrowser,
+		AutoPort:         autoPort,
+		RetentionDays:    retentionDays,
+		ShardGranularity: shardGranularity,
+		MaxStorageBytes:  maxStorageBytes,
+		MaxOpenShards:    maxOpenShards,
+		RegexpTimeout:    regexpTimeout,
+		RegexpScanLimit:  regexpScanLimit,
 	}
 
 	// Try to create the server
@@ -151,6 +203,19 @@ func main() {
 	}
 }
 
+// resolveStoragePath picks the storage directory: flag > STORAGE_PATH env >
+// per-user app data dir. Offline subcommands share it with the server so they
+// operate on the same directory by default.
+func resolveStoragePath(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if env := os.Getenv("STORAGE_PATH"); env != "" {
+		return env, nil
+	}
+	return defaultStoragePath()
+}
+
 // defaultStoragePath returns the per-user data directory for logsonic's indices,
 // following each platform's convention so the location is stable regardless of
 // the working directory (important for the .app, which launches with cwd "/"):
@@ -201,6 +266,10 @@ func printUsage() {
 	fmt.Println("  logsonic mcp [--url http://localhost:8080]   Start the MCP stdio server for AI clients")
 	fmt.Println("  logsonic tail -f /path/to/file [options]     Stream appended file lines into LogSonic")
 	fmt.Println("  cmd | logsonic tail - [options]              Stream stdin into LogSonic")
+	fmt.Println("  logsonic storage migrate [-storage DIR]      Re-partition shards into UTC boundaries (server stopped)")
+	fmt.Println("  logsonic storage upgrade [-storage DIR]      Rewrite legacy shards into the current index layout (server stopped)")
+	fmt.Println("  logsonic backup [-url URL] [-o FILE]         Download a snapshot archive from the running server")
+	fmt.Println("  logsonic restore [-storage DIR] [-force] FILE  Restore a snapshot archive (server stopped)")
 	fmt.Println("\nOptions:")
 	fmt.Println("  -host string      Host address to bind to (default: localhost or HOST env var)")
 	fmt.Println("  -port string      Port to listen on (default: 8080 or PORT env var)")
@@ -208,6 +277,11 @@ func printUsage() {
 	fmt.Println("  -open             Open the web UI in your browser once the server starts")
 	fmt.Println("  -auto-port        If the port is busy, bind the next free port instead of failing (default true; use -auto-port=false to disable)")
 	fmt.Println("  -retention-days N Delete indexed logs older than N days (0 = keep everything)")
+	fmt.Println("  -max-storage-bytes N  Evict the oldest shards once the storage dir exceeds N bytes (0 = no limit)")
+	fmt.Println("  -shard-granularity G  Time covered by each index shard: hour, day or week (persisted in the storage dir)")
+	fmt.Println("  -max-open-shards N    Idle index shards kept open; older ones are closed until next used (default 64)")
+	fmt.Println("  -regex-timeout D      Time one query may spend matching raw:~/regex/ terms (default 10s)")
+	fmt.Println("  -regex-scan-limit N   Stored lines one query may read to verify raw:~/regex/ terms (default 1000000)")
 	fmt.Println("  -help             Show this help message")
 	fmt.Println("\nEnvironment Variables:")
 	fmt.Println("  HOST                  Host address to bind to")
@@ -216,6 +290,11 @@ func printUsage() {
 	fmt.Println("  LOGSONIC_OPEN_BROWSER Open the web UI on start (1/true/yes/on)")
 	fmt.Println("  LOGSONIC_AUTO_PORT    Auto-select a free port if busy (1/true/yes/on)")
 	fmt.Println("  RETENTION_DAYS        Delete indexed logs older than N days")
+	fmt.Println("  MAX_STORAGE_BYTES     Evict the oldest shards once the storage dir exceeds N bytes")
+	fmt.Println("  SHARD_GRANULARITY     Time covered by each index shard: hour, day or week")
+	fmt.Println("  MAX_OPEN_SHARDS       Idle index shards kept open")
+	fmt.Println("  REGEX_TIMEOUT         Time one query may spend matching raw:~/regex/ terms")
+	fmt.Println("  REGEX_SCAN_LIMIT      Stored lines one query may read to verify raw:~/regex/ terms")
 	fmt.Println("\nStorage directory (default):")
 	fmt.Println("  macOS    ~/Library/Application Support/Logsonic")
 	fmt.Println("  Linux    $XDG_DATA_HOME/logsonic (or ~/.local/share/logsonic)")
@@ -0,0 +1,488 @@
+// Package backup produces and restores compressed snapshots of a LogSonic
+// storage directory: the Bleve shards plus the side files that live next to
+// them (workspaces, pattern timestamp settings and the log2grok catalog).
+//
+// An archive is a gzip-compressed tar whose first entry is manifest.json. The
+// manifest lists every other entry with its size and SHA-256, and restore
+// refuses an archive whose content does not match it.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidArchive = errors.New("invalid backup archive")
+	ErrNotEmpty       = errors.New("storage directory already contains data")
+)
+
+const (
+	FormatName    = "logsonic-backup"
+	FormatVersion = 1
+
+	manifestName = "manifest.json"
+	// maxManifestSize bounds the manifest read before any content is trusted.
+	maxManifestSize = 16 << 20
+)
+
+// sideEntries are the non-shard files and directories captured alongside the
+// shards. Anything else in the storage directory is left out of the archive.
+var sideEntries = []string{
+	"workspaces.json",
+	"pattern_timestamps.json",
+	"retention.json",
+	"field_schema.json",
+	"log2grok",
+}
+
+// Snapshotter writes a consistent copy of the index shards into a directory.
+// *storage.Storage implements it.
+type Snapshotter interface {
+	Snapshot(ctx context.Context, dir string) ([]string, error)
+}
+
+// Manifest describes the content of an archive.
+type Manifest struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Shards    []string  `json:"shards"`
+	Files     []File    `json:"files"`
+}
+
+// File is one regular file in the archive. Path uses forward slashes and is
+// relative to the storage directory.
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Size returns the total uncompressed size of the archived files.
+func (m Manifest) Size() int64 {
+	var total int64
+	for _, file := range m.Files {
+		total += file.Size
+	}
+	return total
+}
+
+// Snapshot is a staged copy of the storage directory, ready to be written as
+// an archive. Close removes the staging directory.
+type Snapshot struct {
+	Manifest Manifest
+	dir      string
+}
+
+// Stage copies the shards (through store) and the side files of storageDir
+// into a staging directory under storageDir and builds the manifest. Staging
+// first means errors surface before any archive byte is written, and the
+// archive is produced from files nothing else is writing to.
+func Stage(ctx context.Context, store Snapshotter, storageDir string) (*Snapshot, error) {
+	staging, err := os.MkdirTemp(storageDir, ".snapshot-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	snapshot := &Snapshot{dir: staging}
+	ok := false
+	defer func() {
+		if !ok {
+			snapshot.Close()
+		}
+	}()
+
+	shards, err := store.Snapshot(ctx, staging)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot shards: %w", err)
+	}
+	for _, name := range sideEntries {
+		if err := copyTree(filepath.Join(storageDir, name), filepath.Join(staging, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to copy %s: %w", name, err)
+		}
+	}
+
+	files, err := hashTree(staging)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(shards)
+	snapshot.Manifest = Manifest{
+		Format:    FormatName,
+		Version:   FormatVersion,
+		CreatedAt: time.Now().UTC(),
+		Shards:    shards,
+		Files:     files,
+	}
+	ok = true
+	return snapshot, nil
+}
+
+// WriteTo writes the staged snapshot as a gzip-compressed tar archive.
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	counter := &countingWriter{w: w}
+	gz := gzip.NewWriter(counter)
+	tw := tar.NewWriter(gz)
+
+	manifest, err := json.MarshalIndent(s.Manifest, "", "  ")
+	if err != nil {
+		return counter.n, err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     manifestName,
+		Mode:     0o644,
+		Size:     int64(len(manifest)),
+		ModTime:  s.Manifest.CreatedAt,
+	}); err != nil {
+		return counter.n, err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return counter.n, err
+	}
+
+	for _, file := range s.Manifest.Files {
+		if err := writeTarFile(tw, filepath.Join(s.dir, filepath.FromSlash(file.Path)), file, s.Manifest.CreatedAt); err != nil {
+			return counter.n, fmt.Errorf("failed to archive %s: %w", file.Path, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return counter.n, err
+	}
+	if err := gz.Close(); err != nil {
+		return counter.n, err
+	}
+	return counter.n, nil
+}
+
+// Close removes the staging directory.
+func (s *Snapshot) Close() error {
+	if s == nil || s.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(s.dir)
+}
+
+func writeTarFile(tw *tar.Writer, src string, file File, modTime time.Time) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     file.Path,
+		Mode:     0o644,
+		Size:     file.Size,
+		ModTime:  modTime,
+	}); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, in, file.Size)
+	return err
+}
+
+// RestoreOptions controls how Restore treats an existing storage directory.
+type RestoreOptions struct {
+	// Force replaces the shards and side files already in the directory.
+	// Without it, Restore only writes into a directory holding no LogSonic
+	// data.
+	Force bool
+}
+
+// Restore extracts an archive into storageDir. The archive is fully extracted
+// and checked against its manifest in a staging directory before anything in
+// storageDir is touched. The server must not be running against storageDir.
+func Restore(r io.Reader, storageDir string, options RestoreOptions) (Manifest, error) {
+	if err := os.MkdirAll(storageDir, 0o755); err != nil {
+		return Manifest{}, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	existing, err := existingEntries(storageDir)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if len(existing) > 0 && !options.Force {
+		return Manifest{}, fmt.Errorf("%w: %s (use force to replace it)", ErrNotEmpty, storageDir)
+	}
+
+	staging, err := os.MkdirTemp(storageDir, ".restore-")
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	manifest, err := extract(r, staging)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	for _, name := range existing {
+		if err := os.RemoveAll(filepath.Join(storageDir, name)); err != nil {
+			return manifest, fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+	staged, err := os.ReadDir(staging)
+	if err != nil {
+		return manifest, err
+	}
+	for _, entry := range staged {
+		if err := os.Rename(filepath.Join(staging, entry.Name()), filepath.Join(storageDir, entry.Name())); err != nil {
+			return manifest, fmt.Errorf("failed to install %s: %w", entry.Name(), err)
+		}
+	}
+	return manifest, nil
+}
+
+// Verify reads a whole archive and checks it against its manifest without
+// writing anything.
+func Verify(r io.Reader) (Manifest, error) {
+	return extract(r, "")
+}
+
+// extract reads the manifest, then every entry, checking each against the
+// manifest. Entries are written under dir unless dir is empty.
+func extract(r io.Reader, dir string) (Manifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != manifestName {
+		return Manifest{}, fmt.Errorf("%w: %s must be the first entry", ErrInvalidArchive, manifestName)
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(io.LimitReader(tr, maxManifestSize)).Decode(&manifest); err != nil {
+		return Manifest{}, fmt.Errorf("%w: unreadable manifest: %v", ErrInvalidArchive, err)
+	}
+	if manifest.Format != FormatName {
+		return Manifest{}, fmt.Errorf("%w: unknown format %q", ErrInvalidArchive, manifest.Format)
+	}
+	if manifest.Version < 1 || manifest.Version > FormatVersion {
+		return Manifest{}, fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, manifest.Version)
+	}
+
+	expected := make(map[string]File, len(manifest.Files))
+	for _, file := range manifest.Files {
+		if !validEntryPath(file.Path) {
+			return Manifest{}, fmt.Errorf("%w: unsafe path %q in manifest", ErrInvalidArchive, file.Path)
+		}
+		expected[file.Path] = file
+	}
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Manifest{}, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return Manifest{}, fmt.Errorf("%w: unexpected entry type for %q", ErrInvalidArchive, header.Name)
+		}
+		file, ok := expected[header.Name]
+		if !ok {
+			return Manifest{}, fmt.Errorf("%w: %q is not listed in the manifest", ErrInvalidArchive, header.Name)
+		}
+		delete(expected, header.Name)
+		if header.Size != file.Size {
+			return Manifest{}, fmt.Errorf("%w: %s has size %d, manifest says %d", ErrInvalidArchive, file.Path, header.Size, file.Size)
+		}
+		if err := extractFile(tr, dir, file); err != nil {
+			return Manifest{}, err
+		}
+	}
+	if len(expected) > 0 {
+		missing := make([]string, 0, len(expected))
+		for name := range expected {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return Manifest{}, fmt.Errorf("%w: missing %s", ErrInvalidArchive, strings.Join(missing, ", "))
+	}
+	return manifest, nil
+}
+
+func extractFile(r io.Reader, dir string, file File) error {
+	hash := sha256.New()
+	var sink io.Writer = hash
+	var out *os.File
+	if dir != "" {
+		target := filepath.Join(dir, filepath.FromSlash(file.Path))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		var err error
+		out, err = os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		sink = io.MultiWriter(out, hash)
+	}
+	if _, err := io.CopyN(sink, r, file.Size); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidArchive, file.Path, err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != file.SHA256 {
+		return fmt.Errorf("%w: checksum mismatch for %s", ErrInvalidArchive, file.Path)
+	}
+	if out != nil {
+		return out.Close()
+	}
+	return nil
+}
+
+// validEntryPath rejects absolute paths and anything escaping the storage
+// directory, and only admits the entries Stage writes.
+func validEntryPath(name string) bool {
+	if name == "" || strings.Contains(name, `\`) || path.IsAbs(name) || path.Clean(name) != name {
+		return false
+	}
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return false
+	}
+	top := strings.SplitN(name, "/", 2)[0]
+	return isArchivedEntry(top)
+}
+
+func isArchivedEntry(name string) bool {
+	if name == "storage.json" || (strings.HasPrefix(name, "logs-") && strings.HasSuffix(name, ".bleve")) {
+		return true
+	}
+	for _, side := range sideEntries {
+		if name == side {
+			return true
+		}
+	}
+	return false
+}
+
+// existingEntries lists the top-level names in dir that a restore replaces.
+func existingEntries(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if isArchivedEntry(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// hashTree lists every regular file under root with its size and checksum,
+// in path order.
+func hashTree(root string) ([]File, error) {
+	var files []File
+	err := filepath.WalkDir(root, func(current string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, current)
+		if err != nil {
+			return err
+		}
+		in, err := os.Open(current)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		hash := sha256.New()
+		size, err := io.Copy(hash, in)
+		if err != nil {
+			return err
+		}
+		files = append(files, File{
+			Path:   filepath.ToSlash(rel),
+			Size:   size,
+			SHA256: hex.EncodeToString(hash.Sum(nil)),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash snapshot: %w", err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// copyTree copies a file or a directory of regular files.
+func copyTree(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return copyFile(src, dst)
+	}
+	return filepath.WalkDir(src, func(current string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, current)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		return copyFile(current, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
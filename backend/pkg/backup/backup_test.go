@@ -0,0 +1,187 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"logsonic/pkg/storage"
+)
+
+func seedStorage(t *testing.T) (*storage.Storage, string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "source")
+	store, err := storage.NewStorage(dir)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	ts := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	logs := []map[string]interface{}{
+		{"timestamp": ts, "_raw": "first", "_src": "app.log", "_seq": int64(1)},
+		{"timestamp": ts.AddDate(0, 0, 1), "_raw": "second", "_src": "app.log", "_seq": int64(2)},
+	}
+	if err := store.Store(logs, "app.log"); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "workspaces.json"), []byte(`{"version":1,"workspaces":[]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "log2grok"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "log2grok", "patterns.json"), []byte(`{}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return store, dir
+}
+
+func archive(t *testing.T, store *storage.Storage, dir string) []byte {
+	t.Helper()
+	snapshot, err := Stage(context.Background(), store, dir)
+	if err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	defer snapshot.Close()
+	var buf bytes.Buffer
+	if _, err := snapshot.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestStageAndRestoreRoundTrip(t *testing.T) {
+	store, dir := seedStorage(t)
+	data := archive(t, store, dir)
+
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".snapshot-*")); len(matches) != 0 {
+		t.Fatalf("staging directory left behind: %v", matches)
+	}
+
+	// Ingest keeps working against the source after the snapshot.
+	if err := store.Store([]map[string]interface{}{{
+		"timestamp": time.Date(2024, 1, 17, 10, 0, 0, 0, time.UTC), "_raw": "later", "_src": "app.log",
+	}}, "app.log"); err != nil {
+		t.Fatalf("Store after snapshot: %v", err)
+	}
+
+	target := filepath.Join(t.TempDir(), "restored")
+	manifest, err := Restore(bytes.NewReader(data), target, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if len(manifest.Shards) != 2 || manifest.Shards[0] != "2024-01-15" || manifest.Shards[1] != "2024-01-16" {
+		t.Fatalf("manifest shards = %v", manifest.Shards)
+	}
+	for _, name := range []string{"workspaces.json", "storage.json", filepath.Join("log2grok", "patterns.json")} {
+		if _, err := os.Stat(filepath.Join(target, name)); err != nil {
+			t.Fatalf("expected %s to be restored: %v", name, err)
+		}
+	}
+
+	restored, err := storage.NewStorage(target)
+	if err != nil {
+		t.Fatalf("open restored storage: %v", err)
+	}
+	defer restored.Close()
+	dates, _ := restored.List()
+	if len(dates) != 2 {
+		t.Fatalf("restored shards = %v, want the two snapshotted days", dates)
+	}
+	result, err := restored.SearchPage(context.Background(), storage.SearchOptions{
+		StartDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		Limit:     10,
+		SortBy:    "timestamp",
+		SortOrder: "asc",
+	})
+	if err != nil {
+		t.Fatalf("SearchPage: %v", err)
+	}
+	if len(result.Logs) != 2 || result.Logs[0]["_raw"] != "first" || result.Logs[1]["_raw"] != "second" {
+		t.Fatalf("restored rows = %#v", result.Logs)
+	}
+}
+
+func TestRestoreRefusesExistingDataWithoutForce(t *testing.T) {
+	store, dir := seedStorage(t)
+	data := archive(t, store, dir)
+
+	target := t.TempDir()
+	if err := os.WriteFile(filepath.Join(target, "workspaces.json"), []byte(`{}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(bytes.NewReader(data), target, RestoreOptions{}); !errors.Is(err, ErrNotEmpty) {
+		t.Fatalf("expected ErrNotEmpty, got %v", err)
+	}
+	if _, err := Restore(bytes.NewReader(data), target, RestoreOptions{Force: true}); err != nil {
+		t.Fatalf("forced Restore: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(target, "workspaces.json")); string(b) != `{"version":1,"workspaces":[]}` {
+		t.Fatalf("workspaces.json not replaced: %s", b)
+	}
+}
+
+func TestRestoreRejectsTamperedArchive(t *testing.T) {
+	store, dir := seedStorage(t)
+	data := archive(t, store, dir)
+
+	// Rewrite the archive with one entry's content altered but its size kept.
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var out bytes.Buffer
+	gw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gw)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(tr)
+		if header.Name == "workspaces.json" {
+			content = bytes.Replace(content, []byte("1"), []byte("2"), 1)
+		}
+		_ = tw.WriteHeader(header)
+		_, _ = tw.Write(content)
+	}
+	tw.Close()
+	gw.Close()
+
+	target := filepath.Join(t.TempDir(), "restored")
+	if _, err := Restore(bytes.NewReader(out.Bytes()), target, RestoreOptions{}); !errors.Is(err, ErrInvalidArchive) {
+		t.Fatalf("expected ErrInvalidArchive, got %v", err)
+	}
+	if entries, _ := os.ReadDir(target); len(entries) != 0 {
+		t.Fatalf("a rejected archive must not leave files behind: %v", entries)
+	}
+	if _, err := Verify(bytes.NewReader(data)); err != nil {
+		t.Fatalf("Verify on the untouched archive: %v", err)
+	}
+}
+
+func TestValidEntryPath(t *testing.T) {
+	for _, name := range []string{"storage.json", "logs-2024-01-15.bleve/store/root.bolt", "log2grok/patterns.json"} {
+		if !validEntryPath(name) {
+			t.Errorf("validEntryPath(%q) = false", name)
+		}
+	}
+	for _, name := range []string{"", "/etc/passwd", "../x", "logs-2024-01-15.bleve/../../x", "other.txt", `log2grok\x`} {
+		if validEntryPath(name) {
+			t.Errorf("validEntryPath(%q) = true", name)
+		}
+	}
+}
@@ -164,19 +164,27 @@ WORKFLOW: call ping → log_info → query_logs. log_info reveals available sour
 
 DATE PARAMETERS: start_date and end_date are RFC3339 (e.g. 2025-01-15T10:00:00Z). Omit both to scan everything.
 
-BLEVE QUERY SYNTAX: bare term matches _raw; field:value for field scope; +required -excluded; | for OR; * wildcard; /regex/.
+BLEVE QUERY SYNTAX: bare term matches _raw; field:value for field scope; +required -excluded; | for OR; * wildcard; /regex/ (one indexed word).
+
+RAW REGEX: raw:~/timeout after \d+ms/ matches the whole raw line with a Go regex, ANDed with the rest of the query (-raw:~/.../ excludes). Include literal words so the index can narrow rows; otherwise QUERY_LIMIT_EXCEEDED asks for a smaller time range.
 
 SOURCE FILTER: comma-separated source names from log_info.source_names — faster than _src: in query.
 
-RESPONSE: JSON with logs[], count, total_count, available_columns, log_distribution, time_taken.`),
+PAGINATION: pass next_cursor (or prev_cursor) from the previous response as cursor to fetch the adjacent page. Cursors are cheaper than offset for deep pages and stay stable while logs are ingested; sorts on analyzed text fields page by offset only.
+
+HIGHLIGHT: highlight=true adds highlights[], one per row: the fields the query matched, each with [start, end) byte ranges of the value that matched.
+
+RESPONSE: JSON with logs[], count, total_count, available_columns, log_distribution, time_taken, next_cursor, prev_cursor.`),
 		mcp.WithNumber("limit", mcp.Description("Max logs returned (default 1000, max 10000)")),
 		mcp.WithNumber("offset", mcp.Description("Rows to skip for pagination")),
+		mcp.WithString("cursor", mcp.Description("next_cursor or prev_cursor from a previous query_logs response; replaces offset")),
 		mcp.WithString("sort_by", mcp.Description("Field to sort by (default: _timestamp)")),
 		mcp.WithString("sort_order", mcp.Description("asc or desc (default: desc — newest first)")),
 		mcp.WithString("start_date", mcp.Description("Inclusive start of time window, RFC3339")),
 		mcp.WithString("end_date", mcp.Description("Inclusive end of time window, RFC3339")),
 		mcp.WithString("query", mcp.Description("Bleve query string")),
 		mcp.WithString("source", mcp.Description("Comma-separated source filter, e.g. 'nginx,api-server'")),
+		mcp.WithBoolean("highlight", mcp.Description("Return highlights[] showing where each row matched the query")),
 	), func(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		p := url.Values{}
 		if v := req.GetInt("limit", 0); v > 0 {
@@ -185,6 +193,9 @@ RESPONSE: JSON with logs[], count, total_count, available_columns, log_distribut
 		if v := req.GetInt("offset", -1); v >= 0 {
 			p.Set("offset", fmt.Sprint(v))
 		}
+		if v := req.GetString("cursor", ""); v != "" {
+			p.Set("cursor", v)
+		}
 		if v := req.GetString("sort_by", ""); v != "" {
 			p.Set("sort_by", v)
 		}
@@ -203,6 +214,9 @@ RESPONSE: JSON with logs[], count, total_count, available_columns, log_distribut
 		if v := req.GetString("source", ""); v != "" {
 			p.Set("_src", v)
 		}
+		if req.GetBool("highlight", false) {
+			p.Set("highlight", "true")
+		}
 		data, err := c.get("/logs", p)
 		if err != nil {
 			return resultErr(err), nil
@@ -210,6 +224,212 @@ RESPONSE: JSON with logs[], count, total_count, available_columns, log_distribut
 		return resultText(data), nil
 	})
 
+	// ------------------------------------------------------------ stats_query
+	s.AddTool(mcp.NewTool("stats_query",
+		mcp.WithDescription(`Aggregate logs with a piped stats query instead of reading rows: counts, sums, averages and percentiles grouped by fields and time buckets.
+
+SYNTAX: <bleve query> | stats <agg>[, <agg>...] [by <field>[, ...]] [, span=<duration>] [| sort [-]<column>[, ...]] [| head N]
+  Aggregates: count, count(f), sum(f), avg(f), min(f), max(f), median(f), p1(f)..p100(f); rename with "as name".
+  span=5m (or 1h, 1d) adds a _time column of bucket starts. Use * as the query to aggregate everything.
+
+EXAMPLES:
+  level:ERROR | stats count by service | sort -count | head 10
+  * | stats count, p95(latency) by host, span=5m
+
+RESPONSE: JSON with columns[], rows[][] (aligned with columns), chart {x[], series[{name, values[]}]}, matched (rows the query selected).`),
+		mcp.WithString("query", mcp.Description("Bleve query followed by | stats ..."), mcp.Required()),
+		mcp.WithString("start_date", mcp.Description("Inclusive start of time window, RFC3339")),
+		mcp.WithString("end_date", mcp.Description("Inclusive end of time window, RFC3339")),
+		mcp.WithString("source", mcp.Description("Comma-separated source filter")),
+	), func(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		p := url.Values{}
+		p.Set("query", req.GetString("query", ""))
+		if v := req.GetString("start_date", ""); v != "" {
+			p.Set("start_date", v)
+		}
+		if v := req.GetString("end_date", ""); v != "" {
+			p.Set("end_date", v)
+		}
+		if v := req.GetString("source", ""); v != "" {
+			p.Set("_src", v)
+		}
+		data, err := c.get("/search", p)
+		if err != nil {
+			return resultErr(err), nil
+		}
+		return resultText(data), nil
+	})
+
+	// ------------------------------------------------------------ log_context
+	s.AddTool(mcp.NewTool("log_context",
+		mcp.WithDescription("Return the lines written just before and after one log row by the same source, in original order, ignoring any query. "+
+			"Use it after query_logs finds an error to see what led up to it. id is the row's _id from query_logs. "+
+			"RESPONSE: log, source, before[] and after[] (oldest first), more_before, more_after."),
+		mcp.WithString("id", mcp.Description("_id of a row returned by query_logs"), mcp.Required()),
+		mcp.WithNumber("before", mcp.Description("Rows before the log (default 20, max 1000)")),
+		mcp.WithNumber("after", mcp.Description("Rows after the log (default 20, max 1000)")),
+	), func(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		id := strings.TrimSpace(req.GetString("id", ""))
+		if id == "" {
+			return resultErr(fmt.Errorf("id is required")), nil
+		}
+		p := url.Values{}
+		if v := req.GetInt("before", -1); v >= 0 {
+			p.Set("before", fmt.Sprint(v))
+		}
+		if v := req.GetInt("after", -1); v >= 0 {
+			p.Set("after", fmt.Sprint(v))
+		}
+		data, err := c.get("/logs/"+url.PathEscape(id)+"/context", p)
+		if err != nil {
+			return resultErr(err), nil
+		}
+		return resultText(data), nil
+	})
+
+	// ----------------------------------------------------------- explain_query
+	s.AddTool(mcp.NewTool("explain_query",
+		mcp.WithDescription("Show how query_logs runs a query without returning rows. Use it when a query is slow or matches nothing. "+
+			"tree is the query after rewriting: each node's type, field, analyzer and the tokens its text becomes, and verified when "+
+			"phrases or raw:~/regex/ terms are checked against stored lines. shards[] are the shards the time range selects, with "+
+			"path facet (indexed timestamps) or legacy (stored timestamps read), candidates the index proposes and matches that remain. "+
+			"RESPONSE: tree, path, shards[], phases[] {name, time_taken, count} in microseconds, total."),
+		mcp.WithString("query", mcp.Description("Bleve query string, as passed to query_logs")),
+		mcp.WithString("start_date", mcp.Description("Inclusive start of time window, RFC3339")),
+		mcp.WithString("end_date", mcp.Description("Inclusive end of time window, RFC3339")),
+		mcp.WithString("source", mcp.Description("Comma-separated source filter")),
+	), func(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		p := url.Values{}
+		if v := req.GetString("query", ""); v != "" {
+			p.Set("query", v)
+		}
+		if v := req.GetString("start_date", ""); v != "" {
+			p.Set("start_date", v)
+		}
+		if v := req.GetString("end_date", ""); v != "" {
+			p.Set("end_date", v)
+		}
+		if v := req.GetString("source", ""); v != "" {
+			p.Set("_src", v)
+		}
+		data, err := c.get("/logs/explain", p)
+		if err != nil {
+			return resultErr(err), nil
+		}
+		return resultText(data), nil
+	})
+
+	// ------------------------------------------------------------ log_patterns
+	s.AddTool(mcp.NewTool("log_patterns",
+		mcp.WithDescription("Summarize matching logs as message templates instead of rows. Lines of the same shape are clustered, "+
+			"and the tokens they differ in become <*> slots; tokens with digits always do. Use it first on a noisy time range to see "+
+			"which kinds of lines dominate or which are rare, then pass a pattern's query to query_logs, with the same time range and "+
+			"source, to read its rows; a pattern without a query has no constant text to search for, so use its sample_ids. RESPONSE: patterns[] {template, query, count, first_seen, last_seen, sample_ids} by count, "+
+			"matched, clusters, truncated (more than 1,000,000 rows matched)."),
+		mcp.WithString("query", mcp.Description("Bleve query string selecting the logs to cluster")),
+		mcp.WithString("field", mcp.Description("Text field to cluster: _raw (default) or message")),
+		mcp.WithNumber("limit", mcp.Description("Max templates returned (default 50, max 1000)")),
+		mcp.WithString("start_date", mcp.Description("Inclusive start of time window, RFC3339")),
+		mcp.WithString("end_date", mcp.Description("Inclusive end of time window, RFC3339")),
+		mcp.WithString("source", mcp.Description("Comma-separated source filter")),
+	), func(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		p := url.Values{}
+		if v := req.GetString("query", ""); v != "" {
+			p.Set("query", v)
+		}
+		if v := req.GetString("field", ""); v != "" {
+			p.Set("field", v)
+		}
+		if v := req.GetInt("limit", 0); v > 0 {
+			p.Set("limit", fmt.Sprint(v))
+		}
+		if v := req.GetString("start_date", ""); v != "" {
+			p.Set("start_date", v)
+		}
+		if v := req.GetString("end_date", ""); v != "" {
+			p.Set("end_date", v)
+		}
+		if v := req.GetString("source", ""); v != "" {
+			p.Set("_src", v)
+		}
+		data, err := c.get("/logs/patterns", p)
+		if err != nil {
+			return resultErr(err), nil
+		}
+		return resultText(data), nil
+	})
+
+	// --------------------------------------------------------- compare_windows
+	s.AddTool(mcp.NewTool("compare_windows",
+		mcp.WithDescription("Answer \"what changed?\" between a selection window (usually the incident or spike) and a baseline "+
+			"window, over logs matching one query and source filter. Ranks field values and log templates more common in the "+
+			"selection: significant differences first (z-score >= 1.96), then by lift, the selection's share of rows over the "+
+			"baseline's. Without baseline_start/baseline_end the baseline is the same length just before start_date. "+
+			"RESPONSE: values[] {field, value, selection_count, baseline_count, lift, significance, significant, new}, "+
+			"patterns[] {template, query, selection_count, baseline_count, lift, significant, new, sample_ids}, "+
+			"selection_total, baseline_total. Pass a pattern's query or field:value to query_logs over the selection to read rows."),
+		mcp.WithString("start_date", mcp.Description("Inclusive start of the selection window, RFC3339"), mcp.Required()),
+		mcp.WithString("end_date", mcp.Description("Inclusive end of the selection window, RFC3339"), mcp.Required()),
+		mcp.WithString("baseline_start", mcp.Description("Inclusive start of the baseline window, RFC3339")),
+		mcp.WithString("baseline_end", mcp.Description("Inclusive end of the baseline window, RFC3339")),
+		mcp.WithString("query", mcp.Description("Bleve query string applied to both windows")),
+		mcp.WithString("source", mcp.Description("Comma-separated source filter")),
+		mcp.WithString("fields", mcp.Description("Comma-separated fields to compare (default: every field indexed as keywords, numbers or booleans, up to 50; name text fields to compare them)")),
+		mcp.WithNumber("limit", mcp.Description("Max values and templates returned (default 50, max 1000)")),
+	), func(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		p := url.Values{}
+		for _, name := range []string{"start_date", "end_date", "baseline_start", "baseline_end", "query", "fields"} {
+			if v := req.GetString(name, ""); v != "" {
+				p.Set(name, v)
+			}
+		}
+		if v := req.GetString("source", ""); v != "" {
+			p.Set("_src", v)
+		}
+		if v := req.GetInt("limit", 0); v > 0 {
+			p.Set("limit", fmt.Sprint(v))
+		}
+		data, err := c.get("/logs/compare", p)
+		if err != nil {
+			return resultErr(err), nil
+		}
+		return resultText(data), nil
+	})
+
+	// ----------------------------------------------------------- suggest_query
+	s.AddTool(mcp.NewTool("suggest_query",
+		mcp.WithDescription("Complete a partly written query_logs query: field names with the kinds they are indexed as, values "+
+			"a field actually holds ranked by how many logs hold them, and operators (+, -, phrase quotes, >, >=, <, <=). "+
+			"Use it to check a field name or a value's exact spelling before querying, e.g. prefix \"level:er\" or \"+stat\". "+
+			"Text fields complete from their lowercased words; values come from the newest 7 days of the range. RESPONSE: suggestions[] {text, kind field|value|operator, detail, count}, "+
+			"replace_from, replace_to: each text replaces prefix's characters between them."),
+		mcp.WithString("prefix", mcp.Description("Query typed so far"), mcp.Required()),
+		mcp.WithNumber("cursor_pos", mcp.Description("Cursor position in prefix, in characters (default: end of prefix)")),
+		mcp.WithNumber("limit", mcp.Description("Max suggestions returned (default 20, max 100)")),
+		mcp.WithString("start_date", mcp.Description("Inclusive start of time window, RFC3339")),
+		mcp.WithString("end_date", mcp.Description("Inclusive end of time window, RFC3339")),
+	), func(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		p := url.Values{}
+		p.Set("prefix", req.GetString("prefix", ""))
+		if v := req.GetInt("cursor_pos", -1); v >= 0 {
+			p.Set("cursor_pos", fmt.Sprint(v))
+		}
+		if v := req.GetInt("limit", 0); v > 0 {
+			p.Set("limit", fmt.Sprint(v))
+		}
+		if v := req.GetString("start_date", ""); v != "" {
+			p.Set("start_date", v)
+		}
+		if v := req.GetString("end_date", ""); v != "" {
+			p.Set("end_date", v)
+		}
+		data, err := c.get("/query/suggest", p)
+		if err != nil {
+			return resultErr(err), nil
+		}
+		return resultText(data), nil
+	})
+
 	// ------------------------------------------------------- list_grok_patterns
 	s.AddTool(mcp.NewTool("list_grok_patterns",
 		mcp.WithDescription("List the Grok patterns LogSonic uses to parse incoming logs. "+
@@ -282,17 +502,36 @@ RESPONSE: JSON with logs[], count, total_count, available_columns, log_distribut
 	// -------------------------------------------------------- log_distribution
 	s.AddTool(mcp.NewTool("log_distribution",
 		mcp.WithDescription("Return only the time-bucketed log distribution for a query (counts per time bucket, per source). "+
-			"Cheaper than query_logs when you just want to see 'when did errors spike' without inspecting individual rows."),
+			"Cheaper than query_logs when you just want to see 'when did errors spike' without inspecting individual rows. "+
+			"split_by adds split_counts per value of any field (e.g. level) to each bucket; agg + agg_field add a per-bucket value "+
+			"(e.g. agg=p95, agg_field=latency_ms), also per split value when split_by is set."),
 		mcp.WithString("query", mcp.Description("Bleve query to scope the distribution")),
 		mcp.WithString("source", mcp.Description("Comma-separated source filter")),
 		mcp.WithString("start_date", mcp.Description("Inclusive start of time window, RFC3339")),
 		mcp.WithString("end_date", mcp.Description("Inclusive end of time window, RFC3339")),
+		mcp.WithNumber("buckets", mcp.Description("Number of buckets (default 100, max 1000)")),
+		mcp.WithString("interval", mcp.Description("Bucket width such as 1m, 1h or 1d; overrides buckets")),
+		mcp.WithString("split_by", mcp.Description("Field whose values split each bucket's count")),
+		mcp.WithNumber("split_limit", mcp.Description("Split values to keep, most frequent first (default 10, max 100)")),
+		mcp.WithString("agg", mcp.Description("avg, sum, min, max, median or p1-p100 of agg_field per bucket")),
+		mcp.WithString("agg_field", mcp.Description("Numeric field to aggregate")),
 	), func(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		p := url.Values{}
 		p.Set("limit", "1") // suppress row payload; distribution is computed independently
 		if v := req.GetString("query", ""); v != "" {
 			p.Set("query", v)
 		}
+		if v := req.GetInt("buckets", 0); v > 0 {
+			p.Set("buckets", fmt.Sprint(v))
+		}
+		if v := req.GetInt("split_limit", 0); v > 0 {
+			p.Set("split_limit", fmt.Sprint(v))
+		}
+		for _, name := range []string{"interval", "split_by", "agg", "agg_field"} {
+			if v := req.GetString(name, ""); v != "" {
+				p.Set(name, v)
+			}
+		}
 		if v := req.GetString("source", ""); v != "" {
 			p.Set("_src", v)
 		}
@@ -0,0 +1,201 @@
+// Package retention persists per-source retention rules in the storage
+// directory.
+package retention
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"logsonic/pkg/types"
+)
+
+var (
+	ErrCorrupt    = errors.New("retention file is corrupt")
+	ErrValidation = errors.New("retention rule validation failed")
+)
+
+const (
+	// FileName is the rules file inside the storage directory.
+	FileName      = "retention.json"
+	schemaVersion = 1
+	maxRules      = 500
+	maxSourceLen  = 1024
+)
+
+type diskFile struct {
+	Version int                   `json:"version"`
+	Rules   []types.RetentionRule `json:"rules"`
+}
+
+// Store holds the rule set, keyed by source.
+type Store struct {
+	path    string
+	mu      sync.RWMutex
+	rules   []types.RetentionRule
+	loadErr error
+}
+
+func NewStore(dir string) (*Store, error) {
+	if dir == "" {
+		return nil, errors.New("retention: empty storage dir")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &Store{path: filepath.Join(dir, FileName)}
+	if err := s.load(); err != nil {
+		if errors.Is(err, ErrCorrupt) {
+			s.loadErr = err
+			return s, nil
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// Rules returns the rule set sorted by source.
+func (s *Store) Rules() ([]types.RetentionRule, error) {
+	if s == nil {
+		return nil, errors.New("retention store is unavailable")
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.loadErr != nil {
+		return nil, s.loadErr
+	}
+	return append([]types.RetentionRule{}, s.rules...), nil
+}
+
+// Replace validates and persists a new rule set. A replace also clears a
+// corrupt file, since the caller has supplied every rule.
+func (s *Store) Replace(rules []types.RetentionRule) ([]types.RetentionRule, error) {
+	if s == nil {
+		return nil, errors.New("retention store is unavailable")
+	}
+	next, err := normalize(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flush(next); err != nil {
+		return nil, err
+	}
+	s.rules, s.loadErr = next, nil
+	return append([]types.RetentionRule{}, next...), nil
+}
+
+// ParseMaxAge parses a rule age: a positive count followed by m, h, d or w.
+func ParseMaxAge(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 {
+		return 0, fmt.Errorf("%w: invalid max_age %q", ErrValidation, value)
+	}
+	units := map[byte]time.Duration{
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+	unit, ok := units[value[len(value)-1]]
+	count, err := strconv.Atoi(value[:len(value)-1])
+	if !ok || err != nil || count <= 0 {
+		return 0, fmt.Errorf("%w: invalid max_age %q (use e.g. 12h, 3d or 2w)", ErrValidation, value)
+	}
+	if count > int(100*365*24*time.Hour/unit) {
+		return 0, fmt.Errorf("%w: max_age %q is too long", ErrValidation, value)
+	}
+	return time.Duration(count) * unit, nil
+}
+
+func normalize(rules []types.RetentionRule) ([]types.RetentionRule, error) {
+	if len(rules) > maxRules {
+		return nil, fmt.Errorf("%w: maximum of %d rules", ErrValidation, maxRules)
+	}
+	seen := make(map[string]bool, len(rules))
+	out := make([]types.RetentionRule, 0, len(rules))
+	for _, rule := range rules {
+		rule.Source = strings.TrimSpace(rule.Source)
+		rule.MaxAge = strings.ToLower(strings.TrimSpace(rule.MaxAge))
+		if rule.Source == "" {
+			return nil, fmt.Errorf("%w: source is required", ErrValidation)
+		}
+		if len(rule.Source) > maxSourceLen {
+			return nil, fmt.Errorf("%w: source exceeds %d characters", ErrValidation, maxSourceLen)
+		}
+		if seen[rule.Source] {
+			return nil, fmt.Errorf("%w: duplicate rule for source %q", ErrValidation, rule.Source)
+		}
+		if _, err := ParseMaxAge(rule.MaxAge); err != nil {
+			return nil, err
+		}
+		seen[rule.Source] = true
+		out = append(out, rule)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Source < out[j].Source })
+	return out, nil
+}
+
+func (s *Store) load() error {
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(strings.TrimSpace(string(b))) == 0 {
+		return nil
+	}
+
+	var parsed diskFile
+	if err := json.Unmarshal(b, &parsed); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if parsed.Version != 0 && parsed.Version != schemaVersion {
+		return fmt.Errorf("%w: unsupported schema version %d", ErrCorrupt, parsed.Version)
+	}
+	rules, err := normalize(parsed.Rules)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	s.rules = rules
+	return nil
+}
+
+func (s *Store) flush(rules []types.RetentionRule) error {
+	b, err := json.MarshalIndent(diskFile{Version: schemaVersion, Rules: rules}, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
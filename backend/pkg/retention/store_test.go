@@ -0,0 +1,94 @@
+package retention
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"logsonic/pkg/types"
+)
+
+func TestStoreReplacePersists(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if rules, _ := store.Rules(); len(rules) != 0 {
+		t.Fatalf("expected no rules in a fresh dir, got %v", rules)
+	}
+
+	if _, err := store.Replace([]types.RetentionRule{
+		{Source: "nginx", MaxAge: "3d"},
+		{Source: " audit ", MaxAge: "365D"},
+	}); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+
+	reopened, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	rules, err := reopened.Rules()
+	if err != nil {
+		t.Fatalf("Rules: %v", err)
+	}
+	if len(rules) != 2 || rules[0] != (types.RetentionRule{Source: "audit", MaxAge: "365d"}) || rules[1].Source != "nginx" {
+		t.Fatalf("unexpected rules after reopen: %+v", rules)
+	}
+}
+
+func TestStoreRejectsInvalidRules(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	for _, rules := range [][]types.RetentionRule{
+		{{Source: "", MaxAge: "3d"}},
+		{{Source: "nginx", MaxAge: "3"}},
+		{{Source: "nginx", MaxAge: "0d"}},
+		{{Source: "nginx", MaxAge: "3y"}},
+		{{Source: "nginx", MaxAge: "3d"}, {Source: "nginx", MaxAge: "4d"}},
+	} {
+		if _, err := store.Replace(rules); !errors.Is(err, ErrValidation) {
+			t.Errorf("Replace(%+v) = %v, want ErrValidation", rules, err)
+		}
+	}
+}
+
+func TestStoreCorruptFileIsRecoverable(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte("{nope"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if _, err := store.Rules(); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+	if _, err := store.Replace([]types.RetentionRule{{Source: "nginx", MaxAge: "1w"}}); err != nil {
+		t.Fatalf("Replace over a corrupt file: %v", err)
+	}
+	if rules, err := store.Rules(); err != nil || len(rules) != 1 {
+		t.Fatalf("Rules after replace = %v, %v", rules, err)
+	}
+}
+
+func TestParseMaxAge(t *testing.T) {
+	cases := map[string]time.Duration{
+		"90m": 90 * time.Minute,
+		"12h": 12 * time.Hour,
+		"3d":  72 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+	}
+	for input, want := range cases {
+		got, err := ParseMaxAge(input)
+		if err != nil || got != want {
+			t.Errorf("ParseMaxAge(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+}
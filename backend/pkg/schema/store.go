@@ -0,0 +1,286 @@
+// Package schema persists per-source and per-pattern field type declarations
+// in the storage directory.
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"logsonic/pkg/storage"
+	"logsonic/pkg/types"
+)
+
+var (
+	ErrCorrupt    = errors.New("field schema file is corrupt")
+	ErrValidation = errors.New("field schema validation failed")
+)
+
+const (
+	// FileName is the schema file inside the storage directory.
+	FileName      = "field_schema.json"
+	schemaVersion = 1
+	maxRules      = 500
+	maxFields     = 1000
+	maxKeyLen     = 1024
+)
+
+type diskFile struct {
+	Version int                     `json:"version"`
+	Rules   []types.FieldSchemaRule `json:"rules"`
+}
+
+// Store holds the schema rules, keyed by source or pattern name.
+type Store struct {
+	path    string
+	mu      sync.RWMutex
+	rules   []types.FieldSchemaRule
+	loadErr error
+}
+
+func NewStore(dir string) (*Store, error) {
+	if dir == "" {
+		return nil, errors.New("schema: empty storage dir")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &Store{path: filepath.Join(dir, FileName)}
+	if err := s.load(); err != nil {
+		if errors.Is(err, ErrCorrupt) {
+			s.loadErr = err
+			return s, nil
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// Rules returns the source rules sorted by source, then the pattern rules
+// sorted by pattern.
+func (s *Store) Rules() ([]types.FieldSchemaRule, error) {
+	if s == nil {
+		return nil, errors.New("field schema store is unavailable")
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.loadErr != nil {
+		return nil, s.loadErr
+	}
+	return cloneRules(s.rules), nil
+}
+
+// Replace validates and persists a new rule set. A replace also clears a
+// corrupt file, since the caller has supplied every rule.
+func (s *Store) Replace(rules []types.FieldSchemaRule) ([]types.FieldSchemaRule, error) {
+	if s == nil {
+		return nil, errors.New("field schema store is unavailable")
+	}
+	next, err := normalize(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flush(next); err != nil {
+		return nil, err
+	}
+	s.rules, s.loadErr = next, nil
+	return cloneRules(next), nil
+}
+
+// Resolve returns the field types for rows of source parsed with the named
+// pattern. A source rule overrides a pattern rule field by field. It returns
+// nil when no rule applies, or the store is missing or corrupt, which leaves
+// every field to the dynamic mapping.
+func (s *Store) Resolve(source, pattern string) storage.FieldSchema {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var resolved storage.FieldSchema
+	apply := func(rule types.FieldSchemaRule) {
+		if resolved == nil {
+			resolved = make(storage.FieldSchema, len(rule.Fields))
+		}
+		for _, field := range rule.Fields {
+			resolved[field.Name] = storage.FieldSpec{Type: storage.FieldType(field.Type), StoredOnly: field.StoredOnly}
+		}
+	}
+	for _, rule := range s.rules {
+		if pattern != "" && rule.Pattern == pattern {
+			apply(rule)
+		}
+	}
+	for _, rule := range s.rules {
+		if source != "" && rule.Source == source {
+			apply(rule)
+		}
+	}
+	return resolved
+}
+
+// Declared returns the types any rule declares for each field.
+func (s *Store) Declared() map[string][]string {
+	declared := make(map[string][]string)
+	if s == nil {
+		return declared
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, rule := range s.rules {
+		for _, field := range rule.Fields {
+			fieldTypes := declared[field.Name]
+			if i := sort.SearchStrings(fieldTypes, field.Type); i == len(fieldTypes) || fieldTypes[i] != field.Type {
+				fieldTypes = append(fieldTypes, field.Type)
+				sort.Strings(fieldTypes)
+			}
+			declared[field.Name] = fieldTypes
+		}
+	}
+	return declared
+}
+
+func normalize(rules []types.FieldSchemaRule) ([]types.FieldSchemaRule, error) {
+	if len(rules) > maxRules {
+		return nil, fmt.Errorf("%w: maximum of %d rules", ErrValidation, maxRules)
+	}
+	seen := make(map[string]bool, len(rules))
+	out := make([]types.FieldSchemaRule, 0, len(rules))
+	for _, rule := range rules {
+		rule.Source = strings.TrimSpace(rule.Source)
+		rule.Pattern = strings.TrimSpace(rule.Pattern)
+		if (rule.Source == "") == (rule.Pattern == "") {
+			return nil, fmt.Errorf("%w: each rule needs exactly one of source or pattern", ErrValidation)
+		}
+		key := "source:" + rule.Source
+		if rule.Pattern != "" {
+			key = "pattern:" + rule.Pattern
+		}
+		if len(key) > maxKeyLen {
+			return nil, fmt.Errorf("%w: %s exceeds %d characters", ErrValidation, key, maxKeyLen)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("%w: duplicate rule for %s", ErrValidation, key)
+		}
+		seen[key] = true
+
+		fields, err := normalizeFields(rule.Fields)
+		if err != nil {
+			return nil, fmt.Errorf("%w (rule for %s)", err, key)
+		}
+		rule.Fields = fields
+		out = append(out, rule)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if (out[i].Source == "") != (out[j].Source == "") {
+			return out[i].Source != ""
+		}
+		return out[i].Source+out[i].Pattern < out[j].Source+out[j].Pattern
+	})
+	return out, nil
+}
+
+func normalizeFields(fields []types.FieldSchemaField) ([]types.FieldSchemaField, error) {
+	if len(fields) > maxFields {
+		return nil, fmt.Errorf("%w: maximum of %d fields per rule", ErrValidation, maxFields)
+	}
+	seen := make(map[string]bool, len(fields))
+	out := make([]types.FieldSchemaField, 0, len(fields))
+	for _, field := range fields {
+		field.Name = strings.TrimSpace(field.Name)
+		if field.Name == "" {
+			return nil, fmt.Errorf("%w: field name is required", ErrValidation)
+		}
+		if field.Name == "timestamp" || strings.HasPrefix(field.Name, "_") {
+			return nil, fmt.Errorf("%w: field %q is internal and cannot be declared", ErrValidation, field.Name)
+		}
+		if len(field.Name) > maxKeyLen {
+			return nil, fmt.Errorf("%w: field name exceeds %d characters", ErrValidation, maxKeyLen)
+		}
+		if seen[field.Name] {
+			return nil, fmt.Errorf("%w: duplicate field %q", ErrValidation, field.Name)
+		}
+		fieldType, err := storage.ParseFieldType(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrValidation, err)
+		}
+		field.Type = string(fieldType)
+		seen[field.Name] = true
+		out = append(out, field)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func cloneRules(rules []types.FieldSchemaRule) []types.FieldSchemaRule {
+	out := make([]types.FieldSchemaRule, len(rules))
+	for i, rule := range rules {
+		rule.Fields = append([]types.FieldSchemaField{}, rule.Fields...)
+		out[i] = rule
+	}
+	return out
+}
+
+func (s *Store) load() error {
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(strings.TrimSpace(string(b))) == 0 {
+		return nil
+	}
+
+	var parsed diskFile
+	if err := json.Unmarshal(b, &parsed); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if parsed.Version != 0 && parsed.Version != schemaVersion {
+		return fmt.Errorf("%w: unsupported schema version %d", ErrCorrupt, parsed.Version)
+	}
+	rules, err := normalize(parsed.Rules)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	s.rules = rules
+	return nil
+}
+
+func (s *Store) flush(rules []types.FieldSchemaRule) error {
+	b, err := json.MarshalIndent(diskFile{Version: schemaVersion, Rules: rules}, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,105 @@
+package schema
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"logsonic/pkg/storage"
+	"logsonic/pkg/types"
+)
+
+func TestStoreReplacePersists(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if _, err := store.Replace([]types.FieldSchemaRule{
+		{Pattern: "nginx_access", Fields: []types.FieldSchemaField{{Name: "status", Type: "long"}}},
+		{Source: " nginx ", Fields: []types.FieldSchemaField{{Name: "zip", Type: "Keyword"}, {Name: "client", Type: "ip"}}},
+	}); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+
+	reopened, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	rules, err := reopened.Rules()
+	if err != nil {
+		t.Fatalf("Rules: %v", err)
+	}
+	if len(rules) != 2 || rules[0].Source != "nginx" || rules[1].Pattern != "nginx_access" {
+		t.Fatalf("unexpected rules after reopen: %+v", rules)
+	}
+	if rules[0].Fields[0] != (types.FieldSchemaField{Name: "client", Type: "ip"}) || rules[0].Fields[1].Type != "keyword" {
+		t.Fatalf("expected sorted, normalized fields, got %+v", rules[0].Fields)
+	}
+}
+
+func TestStoreResolvePrefersSourceRules(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if _, err := store.Replace([]types.FieldSchemaRule{
+		{Pattern: "access", Fields: []types.FieldSchemaField{{Name: "status", Type: "long"}, {Name: "bytes", Type: "long"}}},
+		{Source: "edge", Fields: []types.FieldSchemaField{{Name: "status", Type: "keyword", StoredOnly: true}}},
+	}); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+
+	resolved := store.Resolve("edge", "access")
+	if resolved["status"] != (storage.FieldSpec{Type: storage.FieldKeyword, StoredOnly: true}) || resolved["bytes"].Type != storage.FieldLong {
+		t.Fatalf("unexpected resolution %+v", resolved)
+	}
+	if resolved := store.Resolve("other", ""); resolved != nil {
+		t.Fatalf("expected no schema for an unknown source, got %+v", resolved)
+	}
+	if declared := store.Declared()["status"]; len(declared) != 2 || declared[0] != "keyword" || declared[1] != "long" {
+		t.Fatalf("Declared = %v", declared)
+	}
+}
+
+func TestStoreRejectsInvalidRules(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	field := []types.FieldSchemaField{{Name: "status", Type: "long"}}
+	for _, rules := range [][]types.FieldSchemaRule{
+		{{Fields: field}},
+		{{Source: "nginx", Pattern: "access", Fields: field}},
+		{{Source: "nginx", Fields: field}, {Source: "nginx", Fields: field}},
+		{{Source: "nginx", Fields: []types.FieldSchemaField{{Name: "status", Type: "integer"}}}},
+		{{Source: "nginx", Fields: []types.FieldSchemaField{{Name: "_raw", Type: "text"}}}},
+		{{Source: "nginx", Fields: []types.FieldSchemaField{{Name: "timestamp", Type: "date"}}}},
+		{{Source: "nginx", Fields: []types.FieldSchemaField{{Name: "a", Type: "long"}, {Name: "a", Type: "text"}}}},
+	} {
+		if _, err := store.Replace(rules); !errors.Is(err, ErrValidation) {
+			t.Errorf("Replace(%+v) = %v, want ErrValidation", rules, err)
+		}
+	}
+}
+
+func TestStoreCorruptFileIsRecoverable(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte("{nope"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if _, err := store.Rules(); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+	if resolved := store.Resolve("nginx", ""); resolved != nil {
+		t.Fatalf("a corrupt file must not resolve rules, got %+v", resolved)
+	}
+	if _, err := store.Replace([]types.FieldSchemaRule{{Source: "nginx", Fields: []types.FieldSchemaField{{Name: "status", Type: "long"}}}}); err != nil {
+		t.Fatalf("Replace over a corrupt file: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"logsonic/pkg/backup"
+	"logsonic/pkg/storage"
+	"logsonic/pkg/types"
+)
+
+// @Summary Download a snapshot of the storage directory
+// @Description Streams a gzip-compressed tar archive of every index shard plus workspaces.json, pattern_timestamps.json, retention.json, field_schema.json and the log2grok catalog. The copy is consistent while ingest continues; the archive starts with a manifest of file sizes and SHA-256 checksums that restore verifies.
+// @Tags admin
+// @Produce application/gzip
+// @Success 200 {file} binary "Snapshot archive"
+// @Failure 500 {object} types.ErrorResponse "Snapshot failed"
+// @Router /admin/snapshot [post]
+func (h *Services) HandleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
+	}
+
+	snapshot, err := backup.Stage(r.Context(), h.storage, h.StoragePath)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, http.StatusInternalServerError, "SNAPSHOT_ERROR", "Failed to snapshot storage", err.Error())
+		return
+	}
+	defer snapshot.Close()
+
+	name := fmt.Sprintf("logsonic-snapshot-%s.tar.gz", snapshot.Manifest.CreatedAt.Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Header().Set("X-Logsonic-Snapshot-Files", fmt.Sprint(len(snapshot.Manifest.Files)))
+	started := time.Now()
+	written, err := snapshot.WriteTo(w)
+	if err != nil {
+		// Headers are gone; a truncated body fails the client's manifest check.
+		log.Printf("snapshot: write failed after %d bytes: %v", written, err)
+		return
+	}
+	log.Printf("snapshot: wrote %d files (%d bytes compressed) in %s",
+		len(snapshot.Manifest.Files), written, time.Since(started).Round(time.Millisecond))
+}
+
+// shardUpgradeJob tracks the background shard upgrade started through the
+// admin API. Only one run is allowed at a time.
+type shardUpgradeJob struct {
+	mu     sync.Mutex
+	status types.ShardUpgradeStatus
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (j *shardUpgradeJob) snapshot() types.ShardUpgradeStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// stop cancels a running upgrade and waits for it to leave the current shard
+// in a consistent state.
+func (j *shardUpgradeJob) stop() {
+	j.mu.Lock()
+	cancel, done := j.cancel, j.done
+	j.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// @Summary Get legacy shard upgrade status
+// @Description Lists shards written with an older index mapping or numeric encoding, and reports the progress of a running or finished upgrade.
+// @Tags admin
+// @Produce json
+// @Success 200 {object} types.ShardUpgradeStatus "Upgrade status"
+// @Failure 500 {object} types.ErrorResponse "Failed to inspect shards"
+// @Router /admin/upgrade [get]
+func (h *Services) HandleUpgradeStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	status := h.upgrade.snapshot()
+	if !status.Running {
+		pending, err := h.storage.PendingUpgrades()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "UPGRADE_ERROR", "Failed to inspect shards", err.Error())
+			return
+		}
+		status.PendingShards = pending
+	}
+	if status.Status == "" {
+		status.Status = "idle"
+	}
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// @Summary Upgrade legacy shards
+// @Description Starts a background job that rewrites every pending shard into the current index mapping and compact numeric encoding, so queries over them use the fast facet path. Document IDs are kept. Poll GET /admin/upgrade for progress.
+// @Tags admin
+// @Produce json
+// @Success 202 {object} types.ShardUpgradeStatus "Upgrade started"
+// @Failure 409 {object} types.ErrorResponse "An upgrade is already running"
+// @Failure 500 {object} types.ErrorResponse "Failed to inspect shards"
+// @Router /admin/upgrade [post]
+func (h *Services) HandleUpgradeStart(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	pending, err := h.storage.PendingUpgrades()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "UPGRADE_ERROR", "Failed to inspect shards", err.Error())
+		return
+	}
+
+	job := &h.upgrade
+	job.mu.Lock()
+	if job.status.Running {
+		job.mu.Unlock()
+		writeError(w, http.StatusConflict, "UPGRADE_RUNNING", "A shard upgrade is already running", "")
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	started := time.Now().UTC()
+	job.status = types.ShardUpgradeStatus{
+		Status:        "running",
+		Running:       true,
+		PendingShards: pending,
+		ShardCount:    len(pending),
+		StartedAt:     &started,
+	}
+	job.cancel, job.done = cancel, make(chan struct{})
+	status := job.status
+	job.mu.Unlock()
+
+	go h.runShardUpgrade(ctx)
+
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+func (h *Services) runShardUpgrade(ctx context.Context) {
+	job := &h.upgrade
+	result, err := h.storage.UpgradeShards(ctx, func(p storage.UpgradeProgress) {
+		job.mu.Lock()
+		job.status.CurrentShard = p.Shard
+		job.status.ShardIndex = p.ShardIndex
+		job.status.ShardCount = p.ShardCount
+		job.status.ShardDocs = p.Docs
+		job.status.ShardDocTotal = p.DocTotal
+		if p.Done {
+			job.status.ShardsUpgraded++
+			job.status.DocsRewritten += p.Docs
+			job.status.PendingShards = removeString(job.status.PendingShards, p.Shard)
+		}
+		job.mu.Unlock()
+		if p.Done {
+			h.InvalidateInfoCache()
+		}
+	})
+
+	job.mu.Lock()
+	finished := time.Now().UTC()
+	job.status.Running = false
+	job.status.FinishedAt = &finished
+	job.status.ShardsUpgraded = result.ShardsUpgraded
+	job.status.DocsRewritten = result.DocsRewritten
+	job.status.CurrentShard = ""
+	job.status.Status = "completed"
+	if err != nil {
+		job.status.Status = "failed"
+		job.status.Error = err.Error()
+		log.Printf("upgrade: stopped after %d shards: %v", result.ShardsUpgraded, err)
+	} else {
+		log.Printf("upgrade: rewrote %d shards (%d documents)", result.ShardsUpgraded, result.DocsRewritten)
+	}
+	done := job.done
+	job.cancel, job.done = nil, nil
+	job.mu.Unlock()
+	close(done)
+}
+
+func removeString(values []string, target string) []string {
+	out := values[:0:0]
+	for _, v := range values {
+		if v != target {
+			out = append(out, v)
+		}
+	}
+	return out
+}
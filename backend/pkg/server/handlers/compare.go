@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	storagepkg "logsonic/pkg/storage"
+	"logsonic/pkg/types"
+)
+
+// @Summary Compare two time windows
+// @Description Finds what changed between a selection window (start_date to end_date) and a baseline window, over the logs matching the same query and _src parameters as GET /logs. Field values are counted in each window with the same per-field facets as GET /logs/facets, and log templates are mined from both windows together as GET /logs/patterns mines them. Values and templates more common in the selection are ranked: significant differences (two-proportion z-score of at least 1.96) first, then by lift, the selection's share of rows over the baseline's, smoothed by one row so values new in the selection get a finite lift. Without baseline_start and baseline_end, the baseline is the window of the same length just before the selection.
+// @Tags logs
+// @Produce json
+// @Param query query string false "Optional search query to filter logs in both windows"
+// @Param _src query string false "Optional comma-separated source filter"
+// @Param start_date query string false "Start of the selection window (default: one year ago)"
+// @Param end_date query string false "End of the selection window (default: now)"
+// @Param baseline_start query string false "Start of the baseline window (default: the selection's length before start_date)"
+// @Param baseline_end query string false "End of the baseline window (default: just before start_date)"
+// @Param fields query string false "Comma-separated fields to compare (default: every field indexed as keywords, numbers or booleans, up to 50; name text fields to compare them)"
+// @Param pattern_field query string false "Text field whose templates are compared: _raw or message (default: _raw)"
+// @Param limit query int false "Maximum number of values and of templates to return (default: 50, max: 1000)"
+// @Success 200 {object} types.CompareResponse
+// @Failure 400 {object} types.ErrorResponse "Bad request due to invalid parameters"
+// @Failure 500 {object} types.ErrorResponse "Internal server error"
+// @Failure 504 {object} types.ErrorResponse "Stopped by the request timeout"
+// @Router /logs/compare [get]
+func (h *Services) HandleCompare(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
+	}
+
+	query := r.URL.Query()
+	startTime := time.Now()
+	filter := parseLogFilter(query)
+	if filter.EndDate.Before(filter.StartDate) {
+		writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid time range", "end_date must not be before start_date")
+		return
+	}
+	baselineEnd := filter.StartDate.Add(-time.Nanosecond)
+	baselineStart := baselineEnd.Add(-filter.EndDate.Sub(filter.StartDate))
+	if value := query.Get("baseline_start"); value != "" {
+		parsed, ok := parseDateParam(value)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid baseline_start parameter", "baseline_start must be a date or Unix seconds")
+			return
+		}
+		baselineStart = parsed
+	}
+	if value := query.Get("baseline_end"); value != "" {
+		parsed, ok := parseDateParam(value)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid baseline_end parameter", "baseline_end must be a date or Unix seconds")
+			return
+		}
+		baselineEnd = parsed
+	}
+	if baselineEnd.Before(baselineStart) {
+		writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid baseline range", "baseline_end must not be before baseline_start")
+		return
+	}
+	patternField := query.Get("pattern_field")
+	if patternField == "" {
+		patternField = "_raw"
+	}
+	if patternField != "_raw" && patternField != "message" {
+		writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid pattern_field parameter", "Pattern field must be _raw or message")
+		return
+	}
+	limit := storagepkg.DefaultCompareLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 || parsed > storagepkg.MaxCompareLimit {
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid limit parameter",
+				fmt.Sprintf("Limit must be a positive integer no greater than %d", storagepkg.MaxCompareLimit))
+			return
+		}
+		limit = parsed
+	}
+	var fields []string
+	for _, field := range strings.Split(query.Get("fields"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+
+	response := types.CompareResponse{
+		Status:        "success",
+		Query:         filter.Query,
+		Values:        []types.CompareValue{},
+		Patterns:      []types.ComparePattern{},
+		Fields:        []string{},
+		StartDate:     filter.StartDate.Format(time.RFC3339),
+		EndDate:       filter.EndDate.Format(time.RFC3339),
+		BaselineStart: baselineStart.Format(time.RFC3339),
+		BaselineEnd:   baselineEnd.Format(time.RFC3339),
+	}
+	if filter.NoSources {
+		response.TimeTaken = int(time.Since(startTime).Microseconds())
+		_ = json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	result, err := h.storage.Compare(r.Context(), storagepkg.CompareOptions{
+		Query:          filter.Query,
+		Sources:        filter.Sources,
+		SelectionStart: filter.StartDate,
+		SelectionEnd:   filter.EndDate,
+		BaselineStart:  baselineStart,
+		BaselineEnd:    baselineEnd,
+		Fields:         fields,
+		PatternField:   patternField,
+		Limit:          limit,
+	})
+	if err != nil {
+		writeQueryError(w, err, "Window comparison", "Failed to compare windows")
+		return
+	}
+
+	for _, value := range result.Values {
+		response.Values = append(response.Values, types.CompareValue{
+			Field:          value.Field,
+			Value:          value.Value,
+			SelectionCount: value.SelectionCount,
+			BaselineCount:  value.BaselineCount,
+			Lift:           value.Lift,
+			Significance:   value.Significance,
+			Significant:    value.Significant,
+			New:            value.New,
+			Approximate:    value.Approximate,
+		})
+	}
+	for _, pattern := range result.Patterns {
+		response.Patterns = append(response.Patterns, types.ComparePattern{
+			Template:       pattern.Template,
+			Query:          pattern.Query,
+			SelectionCount: pattern.SelectionCount,
+			BaselineCount:  pattern.BaselineCount,
+			Lift:           pattern.Lift,
+			Significance:   pattern.Significance,
+			Significant:    pattern.Significant,
+			New:            pattern.New,
+			SampleIDs:      pattern.SampleIDs,
+		})
+	}
+	response.Fields = append(response.Fields, result.Fields...)
+	response.SelectionTotal = result.SelectionTotal
+	response.BaselineTotal = result.BaselineTotal
+	response.Truncated = result.Truncated
+	response.TimeTaken = int(time.Since(startTime).Microseconds())
+	_ = json.NewEncoder(w).Encode(response)
+}
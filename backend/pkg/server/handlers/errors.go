@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	storagepkg "logsonic/pkg/storage"
+	"logsonic/pkg/types"
+)
+
+// writeError writes the JSON error body every handler responds with.
+func writeError(w http.ResponseWriter, status int, code, message, details string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(types.ErrorResponse{
+		Status:  "error",
+		Error:   message,
+		Code:    code,
+		Details: details,
+	})
+}
+
+// writeQueryError reports an error from a storage read that ran the
+// caller's query. A malformed query or one past its regex limits is the
+// caller's to fix; a timeout or cancellation names the read as action, e.g.
+// "Facet search"; anything else is reported as failure.
+func writeQueryError(w http.ResponseWriter, err error, action, failure string) {
+	switch {
+	case errors.Is(err, storagepkg.ErrInvalidQuery), errors.Is(err, storagepkg.ErrInvalidPipeline):
+		writeError(w, http.StatusBadRequest, "INVALID_QUERY", "Invalid query", err.Error())
+	case errors.Is(err, storagepkg.ErrRegexpLimit):
+		writeError(w, http.StatusBadRequest, "QUERY_LIMIT_EXCEEDED", "Regex query limit exceeded", err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, "SEARCH_TIMEOUT", action+" timed out", err.Error())
+	case errors.Is(err, context.Canceled):
+		writeError(w, http.StatusRequestTimeout, "SEARCH_CANCELED", action+" was canceled", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "READ_ERROR", failure, err.Error())
+	}
+}
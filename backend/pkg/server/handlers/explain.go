@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	storagepkg "logsonic/pkg/storage"
+	"logsonic/pkg/types"
+)
+
+// @Summary Explain a log query
+// @Description Shows how GET /logs runs a query and where the time goes: the query tree after rewriting, with each term's analyzer and tokens and whether matches are verified against stored values; the shards the time range selects; whether each shard filters timestamps with the index (facet) or by reading stored values (legacy); and per shard and phase, the rows the index proposes (candidates), the rows that match and the time each took. Takes the same query, _src, start_date and end_date parameters as GET /logs.
+// @Tags logs
+// @Produce json
+// @Param query query string false "Search query to explain"
+// @Param _src query string false "Optional comma-separated source filter"
+// @Param start_date query string false "Start of the time range (default: one year ago)"
+// @Param end_date query string false "End of the time range (default: now)"
+// @Success 200 {object} types.ExplainResponse
+// @Failure 400 {object} types.ErrorResponse "Bad request due to invalid parameters"
+// @Failure 500 {object} types.ErrorResponse "Internal server error"
+// @Failure 504 {object} types.ErrorResponse "Stopped by the request timeout"
+// @Router /logs/explain [get]
+func (h *Services) HandleExplain(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
+	}
+
+	startTime := time.Now()
+	filter := parseLogFilter(r.URL.Query())
+	if filter.EndDate.Before(filter.StartDate) {
+		writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid time range", "end_date must not be before start_date")
+		return
+	}
+	sources := filter.Sources
+	if filter.NoSources {
+		// Explain the query over no sources as over all of them; the tree and
+		// shards are what matter, and /logs would not run it at all.
+		sources = nil
+	}
+
+	result, err := h.storage.Explain(r.Context(), storagepkg.ExplainOptions{
+		Query:     filter.Query,
+		StartDate: filter.StartDate,
+		EndDate:   filter.EndDate,
+		Sources:   sources,
+	})
+	if err != nil {
+		writeQueryError(w, err, "Query explain", "Failed to explain query")
+		return
+	}
+
+	response := types.ExplainResponse{
+		Status:    "success",
+		Query:     filter.Query,
+		Tree:      explainNode(result.Query),
+		Path:      result.Path,
+		Shards:    make([]types.ExplainShard, 0, len(result.Shards)),
+		Phases:    make([]types.ExplainPhase, 0, len(result.Phases)),
+		Total:     result.Total,
+		StartDate: filter.StartDate.Format(time.RFC3339),
+		EndDate:   filter.EndDate.Format(time.RFC3339),
+	}
+	for _, shard := range result.Shards {
+		response.Shards = append(response.Shards, types.ExplainShard{
+			Key:           shard.Key,
+			Docs:          shard.Docs,
+			Path:          shard.Path,
+			Candidates:    shard.Candidates,
+			Matches:       shard.Matches,
+			CandidateTime: int(shard.CandidateTime.Microseconds()),
+			MatchTime:     int(shard.MatchTime.Microseconds()),
+		})
+	}
+	for _, phase := range result.Phases {
+		response.Phases = append(response.Phases, types.ExplainPhase{
+			Name:      phase.Name,
+			TimeTaken: int(phase.Time.Microseconds()),
+			Count:     phase.Count,
+		})
+	}
+	response.TimeTaken = int(time.Since(startTime).Microseconds())
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func explainNode(node storagepkg.ExplainNode) types.ExplainNode {
+	converted := types.ExplainNode{
+		Type:     node.Type,
+		Role:     node.Role,
+		Field:    node.Field,
+		Text:     node.Text,
+		Analyzer: node.Analyzer,
+		Tokens:   node.Tokens,
+		Verified: node.Verified,
+	}
+	for _, child := range node.Children {
+		converted.Children = append(converted.Children, explainNode(child))
+	}
+	return converted
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	storagepkg "logsonic/pkg/storage"
+)
+
+// exportFlushRows is how many rows are buffered before they are flushed to
+// the client, so a slow export still shows progress.
+const exportFlushRows = 1000
+
+var exportFormats = map[string]struct {
+	contentType string
+	extension   string
+}{
+	"ndjson": {"application/x-ndjson", "ndjson"},
+	"csv":    {"text/csv; charset=utf-8", "csv"},
+	"raw":    {"text/plain; charset=utf-8", "log"},
+}
+
+// @Summary Export matching logs
+// @Description Streams every log selected by the same query, _src, start_date, end_date, sort_by and sort_order parameters as GET /logs, with no row limit, as NDJSON (one JSON object per line), CSV (a header row, then one row per log) or raw (each log's _raw line). Rows are read in batches, so memory stays flat for any result size, and the request is not bound by the API timeout. Sorting by an analyzed text field is rejected, since it cannot be streamed.
+// @Tags logs
+// @Produce application/x-ndjson,text/csv,text/plain
+// @Param format query string false "ndjson, csv or raw (default: ndjson)"
+// @Param fields query string false "Comma-separated columns to export (default: every field); ignored by raw"
+// @Param query query string false "Optional search query to filter logs"
+// @Param _src query string false "Optional comma-separated source filter"
+// @Param start_date query string false "Start of the time range (default: one year ago)"
+// @Param end_date query string false "End of the time range (default: now)"
+// @Param sort_by query string false "Field to sort by (default: timestamp)"
+// @Param sort_order query string false "Sort order (asc or desc, default: desc)"
+// @Success 200 {file} binary "Exported logs"
+// @Failure 400 {object} types.ErrorResponse "Bad request due to invalid parameters"
+// @Failure 500 {object} types.ErrorResponse "Internal server error"
+// @Router /logs/export [get]
+func (h *Services) HandleExport(w http.ResponseWriter, r *http.Request) {
+	// Errors are JSON; a started export replaces this with its format's type.
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if _, ok := exportFormats[format]; !ok {
+		writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid format parameter", "Format must be ndjson, csv or raw")
+		return
+	}
+	sortBy := query.Get("sort_by")
+	if sortBy == "" {
+		sortBy = "timestamp"
+	}
+	sortOrder := query.Get("sort_order")
+	if sortOrder == "" {
+		sortOrder = "desc"
+	}
+	if sortOrder != "asc" && sortOrder != "desc" {
+		writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid sort_order parameter", "Sort order must be 'asc' or 'desc'")
+		return
+	}
+	var fields []string
+	for _, field := range strings.Split(query.Get("fields"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	if format == "raw" {
+		fields = []string{"_raw"}
+	}
+
+	filter := parseLogFilter(query)
+	options := storagepkg.ExportOptions{
+		Query:     filter.Query,
+		StartDate: filter.StartDate,
+		EndDate:   filter.EndDate,
+		Sources:   filter.Sources,
+		SortBy:    sortBy,
+		SortOrder: sortOrder,
+		Fields:    fields,
+		Empty:     filter.NoSources, // a CSV export still gets its header row
+	}
+
+	started := time.Now()
+	var out *exportWriter
+	rows, err := h.storage.Export(r.Context(), options,
+		func(columns []string) error {
+			name := fmt.Sprintf("logsonic-export-%s.%s", started.UTC().Format("20060102T150405Z"), exportFormats[format].extension)
+			w.Header().Set("Content-Type", exportFormats[format].contentType)
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+			w.WriteHeader(http.StatusOK)
+			out = newExportWriter(w, format, columns)
+			return out.begin()
+		},
+		func(row map[string]interface{}) error {
+			return out.write(row)
+		})
+	if err == nil && out != nil {
+		err = out.flush()
+	}
+	if err != nil && out != nil {
+		// Headers are gone; the client sees a truncated body.
+		log.Printf("export: stopped after %d rows: %v", rows, err)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, storagepkg.ErrInvalidExport):
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid export parameter", err.Error())
+		default:
+			writeQueryError(w, err, "Export", "Failed to export logs")
+		}
+		return
+	}
+}
+
+// exportWriter encodes exported rows in one format, flushing them to the
+// client every exportFlushRows rows.
+type exportWriter struct {
+	format     string
+	columns    []string
+	buffer     *bufio.Writer
+	csv        *csv.Writer
+	json       *json.Encoder
+	controller *http.ResponseController
+	pending    int
+}
+
+func newExportWriter(w http.ResponseWriter, format string, columns []string) *exportWriter {
+	buffer := bufio.NewWriter(w)
+	out := &exportWriter{
+		format:     format,
+		columns:    columns,
+		buffer:     buffer,
+		controller: http.NewResponseController(w),
+	}
+	switch format {
+	case "csv":
+		out.csv = csv.NewWriter(buffer)
+	case "ndjson":
+		out.json = json.NewEncoder(buffer)
+		out.json.SetEscapeHTML(false)
+	}
+	return out
+}
+
+func (e *exportWriter) begin() error {
+	if e.csv != nil {
+		return e.csv.Write(e.columns)
+	}
+	return nil
+}
+
+func (e *exportWriter) write(row map[string]interface{}) error {
+	var err error
+	switch e.format {
+	case "csv":
+		record := make([]string, len(e.columns))
+		for i, column := range e.columns {
+			record[i] = exportCell(row[column])
+		}
+		err = e.csv.Write(record)
+	case "ndjson":
+		err = e.json.Encode(row)
+	default:
+		raw, ok := row["_raw"].(string)
+		if !ok {
+			return nil
+		}
+		if _, err = e.buffer.WriteString(raw); err == nil {
+			err = e.buffer.WriteByte('\n')
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if e.pending++; e.pending >= exportFlushRows {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *exportWriter) flush() error {
+	e.pending = 0
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if err := e.buffer.Flush(); err != nil {
+		return err
+	}
+	if err := e.controller.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// exportCell formats a stored value as one CSV cell.
+func exportCell(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case time.Time:
+		return typed.UTC().Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(typed)
+	default:
+		encoded, err := json.Marshal(typed)
+		if err != nil {
+			return fmt.Sprint(typed)
+		}
+		return string(encoded)
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	storagepkg "logsonic/pkg/storage"
+	"logsonic/pkg/types"
+)
+
+const (
+	facetDefaultSize = 10
+	facetMaxSize     = 100
+)
+
+// @Summary Top values of a field
+// @Description Counts the most frequent values of one field among the logs selected by the same query, _src, start_date and end_date parameters as GET /logs. Values outside the top size are summed in other; matching logs without the field are counted in missing. Works for dynamic and declared fields in current and legacy shards.
+// @Tags logs
+// @Produce json
+// @Param field query string true "Field to count values of"
+// @Param size query integer false "Values to return (default: 10, max: 100)"
+// @Param query query string false "Optional search query to filter logs"
+// @Param _src query string false "Optional comma-separated source filter"
+// @Param start_date query string false "Start of the time range (default: one year ago)"
+// @Param end_date query string false "End of the time range (default: now)"
+// @Success 200 {object} types.FacetResponse
+// @Failure 400 {object} types.ErrorResponse "Bad request due to invalid parameters"
+// @Failure 500 {object} types.ErrorResponse "Internal server error"
+// @Failure 504 {object} types.ErrorResponse "Stopped by the request timeout"
+// @Router /logs/facets [get]
+func (h *Services) HandleFacets(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
+	}
+
+	query := r.URL.Query()
+	startTime := time.Now()
+	field := query.Get("field")
+	if field == "" {
+		writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Missing field parameter", "field names the field to count values of")
+		return
+	}
+	size := facetDefaultSize
+	if sizeStr := query.Get("size"); sizeStr != "" {
+		parsed, err := strconv.Atoi(sizeStr)
+		if err != nil || parsed <= 0 || parsed > facetMaxSize {
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid size parameter",
+				fmt.Sprintf("Size must be a positive integer no greater than %d", facetMaxSize))
+			return
+		}
+		size = parsed
+	}
+
+	filter := parseLogFilter(query)
+	response := types.FacetResponse{
+		Status:    "success",
+		Field:     field,
+		Values:    []types.FacetValueCount{},
+		Size:      size,
+		Query:     filter.Query,
+		StartDate: filter.StartDate.Format(time.RFC3339),
+		EndDate:   filter.EndDate.Format(time.RFC3339),
+	}
+	if filter.NoSources {
+		response.TimeTaken = int(time.Since(startTime).Microseconds())
+		_ = json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	result, err := h.storage.FieldFacet(r.Context(), storagepkg.FacetOptions{
+		Field:     field,
+		Query:     filter.Query,
+		StartDate: filter.StartDate,
+		EndDate:   filter.EndDate,
+		Sources:   filter.Sources,
+		Size:      size,
+	})
+	if err != nil {
+		writeQueryError(w, err, "Facet search", "Failed to count field values")
+		return
+	}
+
+	for _, value := range result.Values {
+		response.Values = append(response.Values, types.FacetValueCount{Value: value.Value, Count: value.Count})
+	}
+	response.Other = result.Other
+	response.Missing = result.Missing
+	response.Total = result.Total
+	response.TimeTaken = int(time.Since(startTime).Microseconds())
+	_ = json.NewEncoder(w).Encode(response)
+}
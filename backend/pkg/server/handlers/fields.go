@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	storagepkg "logsonic/pkg/storage"
+	"logsonic/pkg/types"
+)
+
+// @Summary Describe the fields of matching logs
+// @Description Reports every field of the logs selected by the same query, _src, start_date and end_date parameters as GET /logs: the kind it is indexed as (text, keyword, number, bool or date, or mixed across shards), how many logs hold it, its distinct values (exact up to 10,000, then estimated within a few percent), the minimum, maximum and mean of its numbers and its most frequent values. Up to 200,000 logs are read; counts are over those, and truncated reports that more matched.
+// @Tags fields
+// @Produce json
+// @Param samples query int false "Most frequent values to return per field (default: 5, max: 50)"
+// @Param query query string false "Optional search query to filter logs"
+// @Param _src query string false "Optional comma-separated source filter"
+// @Param start_date query string false "Start of the time range (default: one year ago)"
+// @Param end_date query string false "End of the time range (default: now)"
+// @Success 200 {object} types.FieldsResponse
+// @Failure 400 {object} types.ErrorResponse "Bad request due to invalid parameters"
+// @Failure 500 {object} types.ErrorResponse "Internal server error"
+// @Failure 504 {object} types.ErrorResponse "Stopped by the request timeout"
+// @Router /fields [get]
+func (h *Services) HandleFields(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
+	}
+
+	query := r.URL.Query()
+	startTime := time.Now()
+	samples := storagepkg.DefaultCatalogSamples
+	if samplesStr := query.Get("samples"); samplesStr != "" {
+		parsed, err := strconv.Atoi(samplesStr)
+		if err != nil || parsed <= 0 || parsed > storagepkg.MaxCatalogSamples {
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid samples parameter",
+				fmt.Sprintf("Samples must be a positive integer no greater than %d", storagepkg.MaxCatalogSamples))
+			return
+		}
+		samples = parsed
+	}
+
+	filter := parseLogFilter(query)
+	response := types.FieldsResponse{
+		Status:    "success",
+		Query:     filter.Query,
+		Fields:    []types.FieldStats{},
+		StartDate: filter.StartDate.Format(time.RFC3339),
+		EndDate:   filter.EndDate.Format(time.RFC3339),
+	}
+	if filter.NoSources {
+		response.TimeTaken = int(time.Since(startTime).Microseconds())
+		_ = json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	result, err := h.storage.FieldCatalog(r.Context(), storagepkg.FieldCatalogOptions{
+		Query:     filter.Query,
+		StartDate: filter.StartDate,
+		EndDate:   filter.EndDate,
+		Sources:   filter.Sources,
+		Samples:   samples,
+	})
+	if err != nil {
+		writeQueryError(w, err, "Field catalog", "Failed to describe fields")
+		return
+	}
+
+	for _, field := range result.Fields {
+		described := types.FieldStats{
+			Name:          field.Name,
+			Type:          field.Type,
+			Types:         field.Types,
+			Count:         field.Count,
+			Distinct:      field.Distinct,
+			DistinctExact: field.DistinctExact,
+			Min:           field.Min,
+			Max:           field.Max,
+			Avg:           field.Avg,
+			TopValues:     make([]types.FacetValueCount, 0, len(field.TopValues)),
+		}
+		for _, value := range field.TopValues {
+			described.TopValues = append(described.TopValues, types.FacetValueCount{Value: value.Value, Count: value.Count})
+		}
+		response.Fields = append(response.Fields, described)
+	}
+	response.Total = result.Total
+	response.Truncated = result.Truncated
+	response.TimeTaken = int(time.Since(startTime).Microseconds())
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"log"
+	"logsonic/pkg/retention"
+	"logsonic/pkg/schema"
 	"logsonic/pkg/storage"
 	"logsonic/pkg/timeresolve"
 	"logsonic/pkg/workspaces"
@@ -19,10 +21,21 @@ type Services struct {
 	// anchor / year strategy / timezone on next import.
 	PatternTimestamps *timeresolve.LibraryStore
 	Workspaces        *workspaces.Store
+	Retention         *retention.Store
+	// Schemas declares field types per source or pattern, applied at ingest.
+	Schemas *schema.Store
+
+	// MaxStorageBytes caps the storage directory size; the oldest shards are
+	// evicted to stay under it. 0 disables the quota.
+	MaxStorageBytes int64
 
 	storageInfoCache any
 	infoCacheMutex   sync.RWMutex
 	cacheValid       bool
+
+	upgrade   shardUpgradeJob
+	quota     storageQuota
+	retention sourceRetention
 }
 
 // NewHandler wires up the HTTP service surface. Pattern + decode logic
@@ -39,20 +52,37 @@ func NewHandler(storage storage.StorageInterface, storagePath string) *Services
 	if err != nil {
 		log.Printf("workspaces: failed to open workspaces.json: %v", err)
 	}
+	retentionStore, err := retention.NewStore(storagePath)
+	if err != nil {
+		log.Printf("retention: failed to open retention.json: %v", err)
+	}
+	schemaStore, err := schema.NewStore(storagePath)
+	if err != nil {
+		log.Printf("schema: failed to open field_schema.json: %v", err)
+	}
 	svc := &Services{
 		storage:           storage,
 		StoragePath:       storagePath,
 		PatternTimestamps: store,
 		Workspaces:        workspaceStore,
+		Retention:         retentionStore,
+		Schemas:           schemaStore,
 		storageInfoCache:  nil,
 		cacheValid:        false,
 	}
+	svc.retention.kick = make(chan struct{}, 1)
 	svc.Live = NewTailManager(storage, svc.InvalidateInfoCache)
+	svc.Live.schemas = schemaStore
 	return svc
 }
 
-// CloseStorage cleanly shuts down all open Bleve indices.
+// CloseStorage cleanly shuts down all open Bleve indices. A running shard
+// upgrade is cancelled, and quota and retention sweeps are stopped first, so
+// none of them touches shards under Close.
 func (s *Services) CloseStorage() error {
+	s.upgrade.stop()
+	s.stopStorageQuota()
+	s.stopSourceRetention()
 	type closer interface {
 		Close() error
 	}
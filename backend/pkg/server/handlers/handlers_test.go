@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"logsonic/pkg/backup"
 	storagepkg "logsonic/pkg/storage"
 	"logsonic/pkg/types"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -32,8 +36,57 @@ type mockStorage struct {
 	clearErr    error
 	baseDir     string
 	docCounts   map[string]uint64
-	searchCalls int
 	pageCalls   int
+	lastPage    storagepkg.SearchOptions
+
+	// pending lists shards reported by PendingUpgrades; UpgradeShards blocks
+	// on upgradeGate when it is set.
+	pending     []string
+	upgradeGate chan struct{}
+
+	quotaResult storagepkg.SizeQuotaResult
+	quotaCalls  int
+	purged      []string
+
+	deleteQueries []storagepkg.DeleteQueryOptions
+	deleteResult  storagepkg.DeleteQueryResult
+
+	storeOptions    []storagepkg.StoreOptions
+	storeDuplicates int // reported by StoreWithOptions for deduplicating stores
+	fieldTypes      storagepkg.FieldTypeReport
+
+	facetCalls  []storagepkg.FacetOptions
+	facetResult storagepkg.FacetResult
+
+	statsCalls  []storagepkg.StatsOptions
+	statsResult storagepkg.StatsResult
+
+	contextCalls  []storagepkg.ContextOptions
+	contextResult storagepkg.ContextResult
+
+	explainCalls  []storagepkg.ExplainOptions
+	explainResult storagepkg.ExplainResult
+
+	highlights []storagepkg.Highlights // returned by SearchPage when asked
+
+	exportCalls   []storagepkg.ExportOptions
+	exportColumns []string
+	exportRows    []map[string]interface{}
+
+	patternsCalls  []storagepkg.PatternOptions
+	patternsResult storagepkg.PatternResult
+
+	compareCalls  []storagepkg.CompareOptions
+	compareResult storagepkg.CompareResult
+
+	transactionCalls  []storagepkg.TransactionOptions
+	transactionResult storagepkg.TransactionResult
+
+	catalogCalls  []storagepkg.FieldCatalogOptions
+	catalogResult storagepkg.FieldCatalogResult
+
+	suggestCalls  []storagepkg.SuggestOptions
+	suggestResult storagepkg.SuggestResult
 }
 
 func newMockStorage() *mockStorage {
@@ -49,6 +102,143 @@ func (m *mockStorage) Store(logs []map[string]interface{}, source string) error
 	return err
 }
 
+func (m *mockStorage) StoreWithOptions(logs []map[string]interface{}, source string, options storagepkg.StoreOptions) (storagepkg.StoreResult, error) {
+	m.storeOptions = append(m.storeOptions, options)
+	ids, err := m.StoreWithIDs(logs, source)
+	result := storagepkg.StoreResult{IDs: ids}
+	if options.SkipDuplicates {
+		result.Duplicates = m.storeDuplicates
+	}
+	return result, err
+}
+
+func (m *mockStorage) FieldFacet(ctx context.Context, options storagepkg.FacetOptions) (storagepkg.FacetResult, error) {
+	m.facetCalls = append(m.facetCalls, options)
+	if err := ctx.Err(); err != nil {
+		return storagepkg.FacetResult{}, err
+	}
+	if m.searchErr != nil {
+		return storagepkg.FacetResult{}, m.searchErr
+	}
+	return m.facetResult, nil
+}
+
+func (m *mockStorage) LogContext(ctx context.Context, options storagepkg.ContextOptions) (storagepkg.ContextResult, error) {
+	m.contextCalls = append(m.contextCalls, options)
+	if err := ctx.Err(); err != nil {
+		return storagepkg.ContextResult{}, err
+	}
+	if m.searchErr != nil {
+		return storagepkg.ContextResult{}, m.searchErr
+	}
+	return m.contextResult, nil
+}
+
+func (m *mockStorage) Explain(ctx context.Context, options storagepkg.ExplainOptions) (storagepkg.ExplainResult, error) {
+	m.explainCalls = append(m.explainCalls, options)
+	if err := ctx.Err(); err != nil {
+		return storagepkg.ExplainResult{}, err
+	}
+	if m.searchErr != nil {
+		return storagepkg.ExplainResult{}, m.searchErr
+	}
+	return m.explainResult, nil
+}
+
+func (m *mockStorage) Patterns(ctx context.Context, options storagepkg.PatternOptions) (storagepkg.PatternResult, error) {
+	m.patternsCalls = append(m.patternsCalls, options)
+	if err := ctx.Err(); err != nil {
+		return storagepkg.PatternResult{}, err
+	}
+	if m.searchErr != nil {
+		return storagepkg.PatternResult{}, m.searchErr
+	}
+	return m.patternsResult, nil
+}
+
+func (m *mockStorage) Compare(ctx context.Context, options storagepkg.CompareOptions) (storagepkg.CompareResult, error) {
+	m.compareCalls = append(m.compareCalls, options)
+	if err := ctx.Err(); err != nil {
+		return storagepkg.CompareResult{}, err
+	}
+	if m.searchErr != nil {
+		return storagepkg.CompareResult{}, m.searchErr
+	}
+	return m.compareResult, nil
+}
+
+func (m *mockStorage) Transactions(ctx context.Context, options storagepkg.TransactionOptions) (storagepkg.TransactionResult, error) {
+	m.transactionCalls = append(m.transactionCalls, options)
+	if err := ctx.Err(); err != nil {
+		return storagepkg.TransactionResult{}, err
+	}
+	if m.searchErr != nil {
+		return storagepkg.TransactionResult{}, m.searchErr
+	}
+	return m.transactionResult, nil
+}
+
+func (m *mockStorage) FieldCatalog(ctx context.Context, options storagepkg.FieldCatalogOptions) (storagepkg.FieldCatalogResult, error) {
+	m.catalogCalls = append(m.catalogCalls, options)
+	if err := ctx.Err(); err != nil {
+		return storagepkg.FieldCatalogResult{}, err
+	}
+	if m.searchErr != nil {
+		return storagepkg.FieldCatalogResult{}, m.searchErr
+	}
+	return m.catalogResult, nil
+}
+
+func (m *mockStorage) Suggest(ctx context.Context, options storagepkg.SuggestOptions) (storagepkg.SuggestResult, error) {
+	m.suggestCalls = append(m.suggestCalls, options)
+	if err := ctx.Err(); err != nil {
+		return storagepkg.SuggestResult{}, err
+	}
+	if m.searchErr != nil {
+		return storagepkg.SuggestResult{}, m.searchErr
+	}
+	return m.suggestResult, nil
+}
+
+func (m *mockStorage) Export(
+	ctx context.Context,
+	options storagepkg.ExportOptions,
+	start func(columns []string) error,
+	emit func(log map[string]interface{}) error,
+) (int, error) {
+	m.exportCalls = append(m.exportCalls, options)
+	if m.searchErr != nil {
+		return 0, m.searchErr
+	}
+	if err := start(m.exportColumns); err != nil {
+		return 0, err
+	}
+	for i, row := range m.exportRows {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := emit(row); err != nil {
+			return i, err
+		}
+	}
+	return len(m.exportRows), nil
+}
+
+func (m *mockStorage) Stats(ctx context.Context, options storagepkg.StatsOptions) (storagepkg.StatsResult, error) {
+	m.statsCalls = append(m.statsCalls, options)
+	if err := ctx.Err(); err != nil {
+		return storagepkg.StatsResult{}, err
+	}
+	if m.searchErr != nil {
+		return storagepkg.StatsResult{}, m.searchErr
+	}
+	return m.statsResult, nil
+}
+
+func (m *mockStorage) FieldTypeConflicts() (storagepkg.FieldTypeReport, error) {
+	return m.fieldTypes, nil
+}
+
 func (m *mockStorage) StoreWithIDs(logs []map[string]interface{}, source string) ([]string, error) {
 	if m.storeErr != nil {
 		return nil, m.storeErr
@@ -65,16 +255,9 @@ func (m *mockStorage) StoreWithIDs(logs []map[string]interface{}, source string)
 	return ids, nil
 }
 
-func (m *mockStorage) Search(query string, startDate, endDate *time.Time, sources []string) ([]map[string]interface{}, time.Duration, error) {
-	m.searchCalls++
-	if m.searchErr != nil {
-		return nil, 0, m.searchErr
-	}
-	return m.logs, time.Millisecond, nil
-}
-
 func (m *mockStorage) SearchPage(ctx context.Context, options storagepkg.SearchOptions) (storagepkg.SearchPageResult, error) {
 	m.pageCalls++
+	m.lastPage = options
 	if err := ctx.Err(); err != nil {
 		return storagepkg.SearchPageResult{}, err
 	}
@@ -91,11 +274,21 @@ func (m *mockStorage) SearchPage(ctx context.Context, options storagepkg.SearchO
 		}
 		logs = logs[options.Offset:end]
 	}
-	return storagepkg.SearchPageResult{
+	result := storagepkg.SearchPageResult{
 		Logs:       logs,
 		TotalCount: len(m.logs),
 		QueryTime:  time.Millisecond,
-	}, nil
+	}
+	if options.Offset+len(logs) < len(m.logs) {
+		result.NextCursor = "next"
+	}
+	if options.Offset > 0 || options.Cursor != "" {
+		result.PrevCursor = "prev"
+	}
+	if options.Highlight {
+		result.Highlights = m.highlights
+	}
+	return result, nil
 }
 
 func (m *mockStorage) List() ([]string, error) { return m.listDates, nil }
@@ -118,6 +311,48 @@ func (m *mockStorage) DeleteByIds(ids []string) (int, error) { return len(ids),
 
 func (m *mockStorage) PruneOlderThan(maxAge time.Duration) (int, error) { return 0, nil }
 
+func (m *mockStorage) DeleteSourceBefore(ctx context.Context, source string, cutoff time.Time) (storagepkg.SourcePurgeResult, error) {
+	m.purged = append(m.purged, source)
+	return storagepkg.SourcePurgeResult{Deleted: 1}, ctx.Err()
+}
+
+func (m *mockStorage) DeleteByQuery(ctx context.Context, options storagepkg.DeleteQueryOptions) (storagepkg.DeleteQueryResult, error) {
+	m.deleteQueries = append(m.deleteQueries, options)
+	result := m.deleteResult
+	if options.DryRun {
+		result.Deleted = 0
+	}
+	if m.searchErr != nil {
+		return result, m.searchErr
+	}
+	return result, ctx.Err()
+}
+
+func (m *mockStorage) EnforceSizeQuota(maxBytes int64) (storagepkg.SizeQuotaResult, error) {
+	m.quotaCalls++
+	return m.quotaResult, nil
+}
+
+func (m *mockStorage) Snapshot(ctx context.Context, dir string) ([]string, error) {
+	return m.listDates, ctx.Err()
+}
+
+func (m *mockStorage) PendingUpgrades() ([]string, error) { return m.pending, nil }
+
+func (m *mockStorage) UpgradeShards(ctx context.Context, progress func(storagepkg.UpgradeProgress)) (storagepkg.UpgradeResult, error) {
+	if m.upgradeGate != nil {
+		select {
+		case <-m.upgradeGate:
+		case <-ctx.Done():
+			return storagepkg.UpgradeResult{}, ctx.Err()
+		}
+	}
+	for i, shard := range m.pending {
+		progress(storagepkg.UpgradeProgress{Shard: shard, ShardIndex: i + 1, ShardCount: len(m.pending), Docs: 1, DocTotal: 1, Done: true})
+	}
+	return storagepkg.UpgradeResult{ShardsUpgraded: len(m.pending), DocsRewritten: len(m.pending)}, nil
+}
+
 // ---------------------------------------------------------------------------
 // Test helpers
 // ---------------------------------------------------------------------------
@@ -221,6 +456,31 @@ func TestHandleIngestStart_Success(t *testing.T) {
 	sessionMapMutex.Unlock()
 }
 
+func TestHandleIngestStart_KeepsDeduplicateOption(t *testing.T) {
+	h, _ := setupHandler(t)
+
+	body, _ := json.Marshal(types.IngestSessionOptions{
+		Name:        "test-pattern",
+		Pattern:     "%{GREEDYDATA:message}",
+		Source:      "test.log",
+		Deduplicate: true,
+	})
+	w := httptest.NewRecorder()
+	h.HandleIngestStart(w, httptest.NewRequest(http.MethodPost, "/api/v1/ingest/start", bytes.NewReader(body)))
+	var resp types.IngestResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("ingest/start: %d, %v", w.Code, err)
+	}
+
+	sessionMapMutex.Lock()
+	session := sessionMap[resp.SessionID]
+	delete(sessionMap, resp.SessionID)
+	sessionMapMutex.Unlock()
+	if !session.Options.Deduplicate || !storeOptions(h.Schemas, session.Options).SkipDuplicates {
+		t.Fatalf("session options lost deduplicate: %+v", session.Options)
+	}
+}
+
 func TestHandleIngestStart_MethodNotAllowed(t *testing.T) {
 	h, _ := setupHandler(t)
 	req := httptest.NewRequest(http.MethodGet, "/api/v1/ingest/start", nil)
@@ -604,6 +864,56 @@ func TestHandleInfo_RefreshParam(t *testing.T) {
 	}
 }
 
+func TestHandleInfo_ReportsQuotaEvictions(t *testing.T) {
+	h, store := setupHandler(t)
+	store.listDates = []string{"2024-01-16"}
+	h.MaxStorageBytes = 1000
+	store.quotaResult = storagepkg.SizeQuotaResult{
+		BytesBefore: 1800,
+		BytesAfter:  900,
+		Evicted: []storagepkg.ShardEviction{
+			{Shard: "2024-01-14", Bytes: 500, Docs: 5},
+			{Shard: "2024-01-15", Bytes: 400, Docs: 4},
+		},
+	}
+	h.EnforceStorageQuota()
+
+	w := httptest.NewRecorder()
+	h.HandleInfo(w, httptest.NewRequest(http.MethodGet, "/api/v1/info", nil))
+	var resp types.SystemInfoResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	quota := resp.StorageQuota
+	if quota == nil || quota.MaxBytes != 1000 || quota.UsedBytes != 900 || quota.EvictedShards != 2 || quota.EvictedBytes != 900 {
+		t.Fatalf("unexpected quota report %+v", quota)
+	}
+	if len(quota.RecentEvictions) != 2 || quota.RecentEvictions[0].Shard != "2024-01-15" {
+		t.Fatalf("expected most recent eviction first, got %+v", quota.RecentEvictions)
+	}
+}
+
+func TestNoteIngested_TriggersQuotaCheck(t *testing.T) {
+	h, store := setupHandler(t)
+	h.MaxStorageBytes = 100 // checked after every 10 ingested bytes
+
+	h.noteIngested(5)
+	h.noteIngested(6)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		h.quota.run.Lock()
+		calls := store.quotaCalls
+		h.quota.run.Unlock()
+		if calls == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected one quota check after a large ingest, got %d", calls)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // HandleParse
 // ---------------------------------------------------------------------------
@@ -924,8 +1234,8 @@ func TestHandleReadAll_EmptySourceFilterReturnsNoRows(t *testing.T) {
 	if w.Code != http.StatusOK {
 		t.Errorf("expected 200, got %d", w.Code)
 	}
-	if store.searchCalls != 0 || store.pageCalls != 0 {
-		t.Fatalf("expected empty source filter to skip storage search, got legacy=%d page=%d calls", store.searchCalls, store.pageCalls)
+	if store.pageCalls != 0 {
+		t.Fatalf("expected empty source filter to skip storage search, got %d page calls", store.pageCalls)
 	}
 
 	var resp types.LogResponse
@@ -952,8 +1262,125 @@ func TestHandleReadAll_UsesBoundedTimestampPage(t *testing.T) {
 	if w.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
 	}
-	if store.pageCalls != 1 || store.searchCalls != 0 {
-		t.Fatalf("expected bounded page path, got page=%d legacy=%d", store.pageCalls, store.searchCalls)
+	if store.pageCalls != 1 {
+		t.Fatalf("expected bounded page path, got page=%d", store.pageCalls)
+	}
+}
+
+func TestHandleReadAll_PassesCursors(t *testing.T) {
+	h, store := setupHandler(t)
+	for i := 0; i < 3; i++ {
+		store.logs = append(store.logs, map[string]interface{}{"message": i})
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/logs?limit=1&cursor=abc", nil)
+	w := httptest.NewRecorder()
+
+	h.HandleReadAll(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if store.lastPage.Cursor != "abc" {
+		t.Fatalf("cursor not passed to storage: %+v", store.lastPage)
+	}
+	var response types.LogResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.NextCursor != "next" || response.PrevCursor != "prev" {
+		t.Fatalf("cursors = %q/%q, want next/prev", response.NextCursor, response.PrevCursor)
+	}
+}
+
+func TestHandleReadAll_RejectsInvalidCursor(t *testing.T) {
+	h, store := setupHandler(t)
+	w := httptest.NewRecorder()
+	h.HandleReadAll(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs?cursor=abc&offset=10", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for cursor with offset, got %d: %s", w.Code, w.Body.String())
+	}
+	if store.pageCalls != 0 {
+		t.Fatal("rejected cursor reached storage")
+	}
+
+	store.searchErr = fmt.Errorf("decode: %w", storagepkg.ErrInvalidCursor)
+	w = httptest.NewRecorder()
+	h.HandleReadAll(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs?cursor=abc", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a cursor storage rejects, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleReadAll_RejectsDeepStoredSortOffset(t *testing.T) {
+	h, store := setupHandler(t)
+	store.searchErr = fmt.Errorf("%w: message is sorted by stored value", storagepkg.ErrOffsetTooDeep)
+	w := httptest.NewRecorder()
+	h.HandleReadAll(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs?sort_by=message&offset=20000", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+	var response types.ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.Code != "INVALID_PARAMETER" {
+		t.Fatalf("code = %q, want INVALID_PARAMETER", response.Code)
+	}
+}
+
+func TestHandleReadAll_PassesHistogramOptions(t *testing.T) {
+	h, store := setupHandler(t)
+	w := httptest.NewRecorder()
+	h.HandleReadAll(w, httptest.NewRequest(http.MethodGet,
+		"/api/v1/logs?interval=1d&split_by=level&split_limit=5&agg=p95&agg_field=latency", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	want := storagepkg.HistogramOptions{
+		Interval:       24 * time.Hour,
+		SplitBy:        "level",
+		SplitLimit:     5,
+		Aggregate:      "p95",
+		AggregateField: "latency",
+	}
+	if store.lastPage.Histogram != want {
+		t.Fatalf("histogram = %+v, want %+v", store.lastPage.Histogram, want)
+	}
+
+	for _, params := range []string{"buckets=0", "buckets=1001", "interval=500ms", "interval=x", "split_limit=101", "agg=avg"} {
+		calls := store.pageCalls
+		w := httptest.NewRecorder()
+		h.HandleReadAll(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs?"+params, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d: %s", params, w.Code, w.Body.String())
+		}
+		if store.pageCalls != calls {
+			t.Fatalf("%s: rejected histogram reached storage", params)
+		}
+	}
+
+	store.searchErr = fmt.Errorf("%w: interval too small", storagepkg.ErrInvalidHistogram)
+	w = httptest.NewRecorder()
+	h.HandleReadAll(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs?interval=1s", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a histogram storage rejects, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleReadAll_ReportsRegexpLimit(t *testing.T) {
+	h, store := setupHandler(t)
+	store.searchErr = fmt.Errorf("%w: ran out of time", storagepkg.ErrRegexpLimit)
+	w := httptest.NewRecorder()
+	h.HandleReadAll(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs?query=raw:~/x%2B/", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+	var response types.ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.Code != "QUERY_LIMIT_EXCEEDED" {
+		t.Fatalf("code = %q, want QUERY_LIMIT_EXCEEDED", response.Code)
 	}
 }
 
@@ -967,7 +1394,7 @@ func TestHandleReadAll_RejectsOversizedPage(t *testing.T) {
 	if w.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
 	}
-	if store.pageCalls != 0 || store.searchCalls != 0 {
+	if store.pageCalls != 0 {
 		t.Fatal("oversized page reached storage")
 	}
 }
@@ -1141,3 +1568,874 @@ func waitLiveEvent(t *testing.T, sub *liveSubscriber) liveEvent {
 		return liveEvent{}
 	}
 }
+
+// ---------------------------------------------------------------------------
+// HandleSnapshot
+// ---------------------------------------------------------------------------
+
+func TestHandleSnapshot_StreamsVerifiableArchive(t *testing.T) {
+	h, _ := setupHandler(t)
+	if _, err := h.Workspaces.Create(testHandlerWorkspace("snapshot me")); err != nil {
+		t.Fatalf("create workspace: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.HandleSnapshot(w, httptest.NewRequest(http.MethodPost, "/api/v1/admin/snapshot", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/gzip" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	manifest, err := backup.Verify(w.Body)
+	if err != nil {
+		t.Fatalf("archive does not verify: %v", err)
+	}
+	found := false
+	for _, file := range manifest.Files {
+		if file.Path == "workspaces.json" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("workspaces.json missing from manifest: %+v", manifest.Files)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(h.StoragePath, ".snapshot-*")); len(matches) != 0 {
+		t.Fatalf("staging directory left behind: %v", matches)
+	}
+}
+
+func TestHandleSnapshot_RejectsGet(t *testing.T) {
+	h, _ := setupHandler(t)
+	w := httptest.NewRecorder()
+	h.HandleSnapshot(w, httptest.NewRequest(http.MethodGet, "/api/v1/admin/snapshot", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", w.Code)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Shard upgrade
+// ---------------------------------------------------------------------------
+
+func TestHandleUpgrade_RunsInBackground(t *testing.T) {
+	h, mock := setupHandler(t)
+	mock.pending = []string{"2024-01-15", "2024-01-16"}
+	mock.upgradeGate = make(chan struct{})
+
+	w := httptest.NewRecorder()
+	h.HandleUpgradeStart(w, httptest.NewRequest(http.MethodPost, "/api/v1/admin/upgrade", nil))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleUpgradeStart(w, httptest.NewRequest(http.MethodPost, "/api/v1/admin/upgrade", nil))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 while running, got %d", w.Code)
+	}
+
+	h.upgrade.mu.Lock()
+	done := h.upgrade.done
+	h.upgrade.mu.Unlock()
+	close(mock.upgradeGate)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upgrade did not finish")
+	}
+
+	mock.pending = nil // everything has been rewritten
+	w = httptest.NewRecorder()
+	h.HandleUpgradeStatus(w, httptest.NewRequest(http.MethodGet, "/api/v1/admin/upgrade", nil))
+	var status types.ShardUpgradeStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatalf("decode status: %v", err)
+	}
+	if status.Status != "completed" || status.ShardsUpgraded != 2 || len(status.PendingShards) != 0 {
+		t.Fatalf("unexpected final status %+v", status)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Retention
+// ---------------------------------------------------------------------------
+
+func TestHandleRetention_PutAndGet(t *testing.T) {
+	h, mock := setupHandler(t)
+
+	body := bytes.NewReader([]byte(`{"rules":[{"source":"nginx","max_age":"3d"},{"source":"audit","max_age":"365d"}]}`))
+	w := httptest.NewRecorder()
+	h.HandlePutRetention(w, httptest.NewRequest(http.MethodPut, "/api/v1/retention", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	h.ApplySourceRetention(context.Background())
+	if len(mock.purged) != 2 || mock.purged[0] != "audit" || mock.purged[1] != "nginx" {
+		t.Fatalf("expected both sources purged, got %v", mock.purged)
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleGetRetention(w, httptest.NewRequest(http.MethodGet, "/api/v1/retention", nil))
+	var resp types.RetentionResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Rules) != 2 || resp.Rules[1] != (types.RetentionRule{Source: "nginx", MaxAge: "3d"}) {
+		t.Fatalf("unexpected rules %+v", resp.Rules)
+	}
+	if resp.LastRun == nil || resp.LastRun.Deleted != 2 {
+		t.Fatalf("expected the last sweep to be reported, got %+v", resp.LastRun)
+	}
+}
+
+func TestHandleRetention_RejectsInvalidRule(t *testing.T) {
+	h, _ := setupHandler(t)
+	w := httptest.NewRecorder()
+	h.HandlePutRetention(w, httptest.NewRequest(http.MethodPut, "/api/v1/retention", bytes.NewReader([]byte(`{"rules":[{"source":"nginx","max_age":"soon"}]}`))))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestWriteQueryError_MapsStorageErrors(t *testing.T) {
+	for _, tc := range []struct {
+		err     error
+		status  int
+		code    string
+		message string
+	}{
+		{fmt.Errorf("%w: syntax error", storagepkg.ErrInvalidQuery), http.StatusBadRequest, "INVALID_QUERY", "Invalid query"},
+		{fmt.Errorf("%w: unknown command", storagepkg.ErrInvalidPipeline), http.StatusBadRequest, "INVALID_QUERY", "Invalid query"},
+		{fmt.Errorf("%w: ran out of time", storagepkg.ErrRegexpLimit), http.StatusBadRequest, "QUERY_LIMIT_EXCEEDED", "Regex query limit exceeded"},
+		{fmt.Errorf("scan: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, "SEARCH_TIMEOUT", "Facet search timed out"},
+		{context.Canceled, http.StatusRequestTimeout, "SEARCH_CANCELED", "Facet search was canceled"},
+		{fmt.Errorf("disk full"), http.StatusInternalServerError, "READ_ERROR", "Failed to count field values"},
+	} {
+		w := httptest.NewRecorder()
+		writeQueryError(w, tc.err, "Facet search", "Failed to count field values")
+		var response types.ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("%v: decode response: %v", tc.err, err)
+		}
+		if w.Code != tc.status || response.Code != tc.code || response.Error != tc.message || response.Details != tc.err.Error() {
+			t.Fatalf("%v: got %d %+v, want %d %s %q", tc.err, w.Code, response, tc.status, tc.code, tc.message)
+		}
+	}
+}
+
+func TestHandleFacets_PassesFilterAndReturnsValues(t *testing.T) {
+	h, store := setupHandler(t)
+	store.facetResult = storagepkg.FacetResult{
+		Field:   "status",
+		Values:  []storagepkg.FacetValue{{Value: float64(200), Count: 7}, {Value: float64(500), Count: 2}},
+		Other:   1,
+		Missing: 3,
+		Total:   13,
+	}
+	w := httptest.NewRecorder()
+	h.HandleFacets(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs/facets?field=status&size=2&query=level:error&_src=nginx&start_date=2024-01-14&end_date=2024-01-16", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(store.facetCalls) != 1 {
+		t.Fatalf("expected one facet call, got %d", len(store.facetCalls))
+	}
+	call := store.facetCalls[0]
+	if call.Field != "status" || call.Size != 2 || call.Query != "level:error" || len(call.Sources) != 1 || call.Sources[0] != "nginx" {
+		t.Fatalf("unexpected facet options: %+v", call)
+	}
+
+	var resp types.FacetResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Values) != 2 || resp.Values[0].Value != float64(200) || resp.Values[0].Count != 7 {
+		t.Fatalf("unexpected values: %+v", resp.Values)
+	}
+	if resp.Other != 1 || resp.Missing != 3 || resp.Total != 13 {
+		t.Fatalf("other/missing/total = %d/%d/%d", resp.Other, resp.Missing, resp.Total)
+	}
+}
+
+func TestHandleFacets_RejectsInvalidParameters(t *testing.T) {
+	h, store := setupHandler(t)
+	for _, target := range []string{
+		"/api/v1/logs/facets",
+		"/api/v1/logs/facets?field=status&size=0",
+		"/api/v1/logs/facets?field=status&size=101",
+	} {
+		w := httptest.NewRecorder()
+		h.HandleFacets(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", target, w.Code)
+		}
+	}
+	if len(store.facetCalls) != 0 {
+		t.Fatal("rejected request reached storage")
+	}
+}
+
+func TestHandleSearch_ReturnsTableAndChart(t *testing.T) {
+	h, store := setupHandler(t)
+	count := 3.0
+	store.statsResult = storagepkg.StatsResult{
+		Columns: []string{"service", "count"},
+		Rows:    [][]interface{}{{"api", 3}},
+		Chart:   storagepkg.StatsChart{X: []interface{}{"api"}, Series: []storagepkg.StatsSeries{{Name: "count", Values: []*float64{&count}}}},
+		Matched: 3,
+	}
+	target := "/api/v1/search?" + url.Values{"query": {"level:ERROR | stats count by service"}, "_src": {"nginx"}}.Encode()
+	w := httptest.NewRecorder()
+	h.HandleSearch(w, httptest.NewRequest(http.MethodGet, target, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(store.statsCalls) != 1 || store.statsCalls[0].Pipeline != "level:ERROR | stats count by service" || store.statsCalls[0].Sources[0] != "nginx" {
+		t.Fatalf("unexpected stats calls: %+v", store.statsCalls)
+	}
+	var resp types.SearchResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Rows) != 1 || resp.Rows[0][0] != "api" || resp.Rows[0][1] != float64(3) || resp.Matched != 3 {
+		t.Fatalf("unexpected rows: %+v", resp)
+	}
+	if len(resp.Chart.Series) != 1 || *resp.Chart.Series[0].Values[0] != 3 {
+		t.Fatalf("unexpected chart: %+v", resp.Chart)
+	}
+}
+
+func TestHandleSearch_RejectsInvalidPipeline(t *testing.T) {
+	h, store := setupHandler(t)
+	w := httptest.NewRecorder()
+	h.HandleSearch(w, httptest.NewRequest(http.MethodGet, "/api/v1/search", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a query, got %d", w.Code)
+	}
+
+	store.searchErr = fmt.Errorf("%w: unknown command", storagepkg.ErrInvalidPipeline)
+	w = httptest.NewRecorder()
+	h.HandleSearch(w, httptest.NewRequest(http.MethodGet, "/api/v1/search?query=x", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid pipeline, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleSearch_EmptySourceFilterMatchesNothing(t *testing.T) {
+	h, store := setupHandler(t)
+	w := httptest.NewRecorder()
+	h.HandleSearch(w, httptest.NewRequest(http.MethodGet, "/api/v1/search?_src=&query=*+%7C+stats+count", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(store.statsCalls) != 0 {
+		t.Fatalf("storage should not be called, got %+v", store.statsCalls)
+	}
+	var resp types.SearchResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Rows) != 0 || resp.Matched != 0 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+}
+
+func TestHandleDeleteByQuery_DryRunAndDelete(t *testing.T) {
+	h, mock := setupHandler(t)
+	mock.deleteResult = storagepkg.DeleteQueryResult{Matched: 3, Deleted: 3, ShardsScanned: 2, ShardsRemoved: []string{"2024-01-14"}}
+
+	h.HandleInfo(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/info", nil))
+	w := httptest.NewRecorder()
+	h.HandleDeleteByQuery(w, httptest.NewRequest(http.MethodDelete, "/api/v1/logs/query?query=level:DEBUG&_src=nginx&start_date=2024-01-14&end_date=2024-01-16&dry_run=true", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp types.DeleteByQueryResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !resp.DryRun || resp.Matched != 3 || resp.Deleted != 0 || resp.Limit != deleteQueryDefaultLimit {
+		t.Fatalf("unexpected dry run response %+v", resp)
+	}
+	if !h.cacheValid {
+		t.Fatal("a dry run must not invalidate the info cache")
+	}
+	got := mock.deleteQueries[0]
+	if got.Query != "level:DEBUG" || len(got.Sources) != 1 || got.Sources[0] != "nginx" || !got.DryRun {
+		t.Fatalf("unexpected storage options %+v", got)
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleDeleteByQuery(w, httptest.NewRequest(http.MethodDelete, "/api/v1/logs/query?query=level:DEBUG&limit=500", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	resp = types.DeleteByQueryResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Deleted != 3 || len(resp.ShardsRemoved) != 1 || mock.deleteQueries[1].MaxDocs != 500 {
+		t.Fatalf("unexpected delete response %+v", resp)
+	}
+	if h.cacheValid {
+		t.Fatal("expected the info cache to be invalidated")
+	}
+}
+
+func TestHandleDeleteByQuery_RejectsInvalidLimit(t *testing.T) {
+	h, mock := setupHandler(t)
+	for _, target := range []string{"/api/v1/logs/query?limit=0", "/api/v1/logs/query?limit=5000000", "/api/v1/logs/query?dry_run=maybe"} {
+		w := httptest.NewRecorder()
+		h.HandleDeleteByQuery(w, httptest.NewRequest(http.MethodDelete, target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, w.Code)
+		}
+	}
+	if len(mock.deleteQueries) != 0 {
+		t.Fatalf("storage should not be called, got %d calls", len(mock.deleteQueries))
+	}
+}
+
+func TestHandleDeleteByQuery_TimeoutReportsRowsDeleted(t *testing.T) {
+	h, mock := setupHandler(t)
+	mock.deleteResult = storagepkg.DeleteQueryResult{Matched: 7, Deleted: 7}
+	mock.searchErr = fmt.Errorf("delete batch: %w", context.DeadlineExceeded)
+
+	h.HandleInfo(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/info", nil))
+	w := httptest.NewRecorder()
+	h.HandleDeleteByQuery(w, httptest.NewRequest(http.MethodDelete, "/api/v1/logs/query?query=level:DEBUG", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d: %s", w.Code, w.Body.String())
+	}
+	var response types.ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if response.Code != "SEARCH_TIMEOUT" || !strings.HasPrefix(response.Details, "7 log(s) deleted before stopping") {
+		t.Fatalf("unexpected error response %+v", response)
+	}
+	if h.cacheValid {
+		t.Fatal("rows deleted before the timeout must invalidate the info cache")
+	}
+}
+
+func TestQueryHandlers_RejectInvalidQuery(t *testing.T) {
+	h, store := setupHandler(t)
+	store.searchErr = fmt.Errorf("%w: syntax error", storagepkg.ErrInvalidQuery)
+	for name, serve := range map[string]func(w http.ResponseWriter){
+		"read": func(w http.ResponseWriter) {
+			h.HandleReadAll(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs?query=level:(", nil))
+		},
+		"delete": func(w http.ResponseWriter) {
+			h.HandleDeleteByQuery(w, httptest.NewRequest(http.MethodDelete, "/api/v1/logs/query?query=level:(", nil))
+		},
+		"facets": func(w http.ResponseWriter) {
+			h.HandleFacets(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs/facets?field=status&query=level:(", nil))
+		},
+	} {
+		w := httptest.NewRecorder()
+		serve(w)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d: %s", name, w.Code, w.Body.String())
+		}
+		var response types.ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("%s: decode response: %v", name, err)
+		}
+		if response.Code != "INVALID_QUERY" {
+			t.Fatalf("%s: code = %q, want INVALID_QUERY", name, response.Code)
+		}
+	}
+}
+
+func TestHandleSchema_PutAppliesAtIngestAndFlagsConflicts(t *testing.T) {
+	h, mock := setupHandler(t)
+
+	body := bytes.NewReader([]byte(`{"rules":[{"source":"nginx","fields":[{"name":"status","type":"long"},{"name":"zip","type":"keyword"}]}]}`))
+	w := httptest.NewRecorder()
+	h.HandlePutSchema(w, httptest.NewRequest(http.MethodPut, "/api/v1/schema", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleGetSchema(w, httptest.NewRequest(http.MethodGet, "/api/v1/schema", nil))
+	var resp types.FieldSchemaResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Rules) != 1 || len(resp.Rules[0].Fields) != 2 || len(resp.Types) != len(storagepkg.FieldTypes) {
+		t.Fatalf("unexpected schema response %+v", resp)
+	}
+
+	if h.Live.schemas != h.Schemas {
+		t.Fatal("live tailing should apply the same schema as imports")
+	}
+	if got := h.Schemas.Resolve("nginx", ""); got["zip"].Type != storagepkg.FieldKeyword {
+		t.Fatalf("expected zip to resolve as keyword, got %+v", got)
+	}
+
+	mock.listDates = []string{"2024-01-15"}
+	mock.fieldTypes = storagepkg.FieldTypeReport{Conflicts: []storagepkg.FieldTypeConflict{
+		{Field: "status", Kinds: map[string]int{"number": 3, "text": 1}},
+	}}
+	w = httptest.NewRecorder()
+	h.HandleInfo(w, httptest.NewRequest(http.MethodGet, "/api/v1/info", nil))
+	var info types.SystemInfoResponse
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	conflicts := info.StorageInfo.FieldTypeConflicts
+	if len(conflicts) != 1 || conflicts[0].Field != "status" || len(conflicts[0].Declared) != 1 || conflicts[0].Declared[0] != "long" {
+		t.Fatalf("unexpected conflicts %+v", conflicts)
+	}
+}
+
+func TestHandleSchema_RejectsUnknownType(t *testing.T) {
+	h, _ := setupHandler(t)
+	w := httptest.NewRecorder()
+	h.HandlePutSchema(w, httptest.NewRequest(http.MethodPut, "/api/v1/schema", bytes.NewReader([]byte(`{"rules":[{"source":"nginx","fields":[{"name":"status","type":"integer"}]}]}`))))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestHandleLogContext_PassesRowCounts(t *testing.T) {
+	h, store := setupHandler(t)
+	store.contextResult = storagepkg.ContextResult{
+		Log:       map[string]interface{}{"_id": "abc", "_raw": "boom"},
+		Source:    "app.log",
+		Before:    []map[string]interface{}{{"_raw": "before"}},
+		After:     []map[string]interface{}{},
+		MoreAfter: true,
+	}
+	w := httptest.NewRecorder()
+	h.HandleLogContext(w, requestWithWorkspaceID(http.MethodGet, "/api/v1/logs/abc/context?before=5", "abc", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	want := storagepkg.ContextOptions{ID: "abc", Before: 5, After: 20}
+	if len(store.contextCalls) != 1 || store.contextCalls[0] != want {
+		t.Fatalf("context calls = %+v, want %+v", store.contextCalls, want)
+	}
+	var response types.LogContextResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.Source != "app.log" || len(response.Before) != 1 || response.After == nil || !response.MoreAfter || response.MoreBefore {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+}
+
+func TestHandleLogContext_RejectsInvalidRequests(t *testing.T) {
+	h, store := setupHandler(t)
+	for _, target := range []string{"?before=-1", "?after=1001", "?before=x"} {
+		w := httptest.NewRecorder()
+		h.HandleLogContext(w, requestWithWorkspaceID(http.MethodGet, "/api/v1/logs/abc/context"+target, "abc", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d: %s", target, w.Code, w.Body.String())
+		}
+	}
+	if len(store.contextCalls) != 0 {
+		t.Fatal("rejected request reached storage")
+	}
+
+	store.searchErr = fmt.Errorf("%w: abc", storagepkg.ErrLogNotFound)
+	w := httptest.NewRecorder()
+	h.HandleLogContext(w, requestWithWorkspaceID(http.MethodGet, "/api/v1/logs/abc/context", "abc", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown ID, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleExplain_ReportsTreeShardsAndPhases(t *testing.T) {
+	h, store := setupHandler(t)
+	store.explainResult = storagepkg.ExplainResult{
+		Query: storagepkg.ExplainNode{Type: "boolean", Children: []storagepkg.ExplainNode{
+			{Type: "stored_phrase", Role: "must", Field: "_raw", Text: "disk full", Tokens: []string{"disk", "full"}, Verified: true},
+		}},
+		Path:   storagepkg.ExplainPathLegacy,
+		Shards: []storagepkg.ExplainShard{{Key: "2024-01-15", Docs: 10, Path: storagepkg.ExplainPathLegacy, Candidates: 4, Matches: 1, MatchTime: 2 * time.Millisecond}},
+		Phases: []storagepkg.ExplainPhase{{Name: "matches", Time: 2 * time.Millisecond, Count: 1}},
+		Total:  1,
+	}
+	w := httptest.NewRecorder()
+	h.HandleExplain(w, httptest.NewRequest(http.MethodGet, `/api/v1/logs/explain?query=%2B_raw:%22disk%20full%22&_src=app.log&start_date=2024-01-15T00:00:00Z&end_date=2024-01-16T00:00:00Z`, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(store.explainCalls) != 1 || store.explainCalls[0].Query != `+_raw:"disk full"` ||
+		len(store.explainCalls[0].Sources) != 1 || store.explainCalls[0].Sources[0] != "app.log" {
+		t.Fatalf("explain calls = %+v", store.explainCalls)
+	}
+	var response types.ExplainResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.Path != "legacy" || response.Total != 1 || len(response.Tree.Children) != 1 ||
+		!response.Tree.Children[0].Verified || response.Tree.Children[0].Role != "must" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if len(response.Shards) != 1 || response.Shards[0].Candidates != 4 || response.Shards[0].MatchTime != 2000 ||
+		len(response.Phases) != 1 || response.Phases[0].TimeTaken != 2000 {
+		t.Fatalf("unexpected shards or phases: %+v %+v", response.Shards, response.Phases)
+	}
+
+	store.searchErr = fmt.Errorf("%w: syntax error", storagepkg.ErrInvalidQuery)
+	w = httptest.NewRecorder()
+	h.HandleExplain(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs/explain?query=level:(", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid query, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandlePatterns_ReturnsTemplatesWithDrillDownQueries(t *testing.T) {
+	h, store := setupHandler(t)
+	stamp := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	store.patternsResult = storagepkg.PatternResult{
+		Patterns: []storagepkg.Pattern{{
+			Template:  "user <*> logged in",
+			Query:     `+message:"user" +message:"logged in"`,
+			Count:     3,
+			FirstSeen: stamp,
+			LastSeen:  stamp.Add(time.Minute),
+			SampleIDs: []string{"a", "b", "c"},
+		}},
+		Matched:  4,
+		Missing:  1,
+		Clusters: 2,
+	}
+	w := httptest.NewRecorder()
+	h.HandlePatterns(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs/patterns?field=message&limit=1&query=level:error&_src=app.log", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(store.patternsCalls) != 1 || store.patternsCalls[0].Field != "message" || store.patternsCalls[0].Limit != 1 ||
+		store.patternsCalls[0].Query != "level:error" || len(store.patternsCalls[0].Sources) != 1 {
+		t.Fatalf("patterns calls = %+v", store.patternsCalls)
+	}
+	var response types.PatternsResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.Field != "message" || response.Matched != 4 || response.Missing != 1 || response.Clusters != 2 || len(response.Patterns) != 1 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	pattern := response.Patterns[0]
+	if pattern.Template != "user <*> logged in" || pattern.Count != 3 || pattern.FirstSeen != "2024-01-15T10:00:00Z" ||
+		pattern.LastSeen != "2024-01-15T10:01:00Z" || len(pattern.SampleIDs) != 3 || pattern.Query == "" {
+		t.Fatalf("unexpected pattern: %+v", pattern)
+	}
+
+	for _, target := range []string{"/api/v1/logs/patterns?field=level", "/api/v1/logs/patterns?limit=0", "/api/v1/logs/patterns?limit=1001"} {
+		w = httptest.NewRecorder()
+		h.HandlePatterns(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", target, w.Code)
+		}
+	}
+	if len(store.patternsCalls) != 1 {
+		t.Fatalf("invalid requests reached storage: %+v", store.patternsCalls)
+	}
+}
+
+func TestHandleCompare_DefaultsBaselineToPrecedingWindow(t *testing.T) {
+	h, store := setupHandler(t)
+	store.compareResult = storagepkg.CompareResult{
+		Values: []storagepkg.CompareValue{
+			{Field: "status", Value: 503.0, SelectionCount: 20, Lift: 21, Significance: 5.2, Significant: true, New: true},
+		},
+		Patterns: []storagepkg.ComparePattern{
+			{Template: "upstream <*> refused connection", Query: `+_raw:"upstream"`, SelectionCount: 20, Lift: 21, New: true, SampleIDs: []string{"a"}},
+		},
+		Fields:         []string{"status"},
+		SelectionTotal: 40,
+		BaselineTotal:  40,
+	}
+	w := httptest.NewRecorder()
+	h.HandleCompare(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs/compare?start_date=2024-01-15T11:00:00Z&end_date=2024-01-15T12:00:00Z&fields=status,%20host&limit=5", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(store.compareCalls) != 1 {
+		t.Fatalf("compare calls = %+v", store.compareCalls)
+	}
+	call := store.compareCalls[0]
+	if !call.BaselineEnd.Equal(time.Date(2024, 1, 15, 10, 59, 59, 999999999, time.UTC)) ||
+		!call.BaselineStart.Equal(time.Date(2024, 1, 15, 9, 59, 59, 999999999, time.UTC)) ||
+		len(call.Fields) != 2 || call.Fields[1] != "host" || call.Limit != 5 || call.PatternField != "_raw" {
+		t.Fatalf("compare options = %+v", call)
+	}
+	var response types.CompareResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(response.Values) != 1 || response.Values[0].Value != 503.0 || !response.Values[0].New ||
+		len(response.Patterns) != 1 || response.Patterns[0].Template != "upstream <*> refused connection" ||
+		response.SelectionTotal != 40 || response.BaselineStart != "2024-01-15T09:59:59Z" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	for _, target := range []string{
+		"/api/v1/logs/compare?baseline_start=2024-01-15&baseline_end=2024-01-14",
+		"/api/v1/logs/compare?baseline_start=yesterday-ish",
+		"/api/v1/logs/compare?pattern_field=level",
+		"/api/v1/logs/compare?limit=-1",
+	} {
+		w = httptest.NewRecorder()
+		h.HandleCompare(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", target, w.Code)
+		}
+	}
+	if len(store.compareCalls) != 1 {
+		t.Fatalf("invalid requests reached storage: %+v", store.compareCalls)
+	}
+}
+
+func TestHandleTransactions_PassesGroupingAndPaging(t *testing.T) {
+	h, store := setupHandler(t)
+	stamp := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	store.transactionResult = storagepkg.TransactionResult{
+		Transactions: []storagepkg.Transaction{{
+			Fields:   map[string]interface{}{"trace_id": "t1"},
+			Start:    stamp,
+			End:      stamp.Add(1500 * time.Millisecond),
+			Duration: 1500 * time.Millisecond,
+			Count:    3,
+			Errors:   1,
+			Sources:  []string{"app.log", "db.log"},
+			EventIDs: []string{"a", "b", "c"},
+			Query:    `+trace_id:"t1"`,
+		}},
+		Total:   7,
+		Grouped: 12,
+	}
+	w := httptest.NewRecorder()
+	h.HandleTransactions(w, httptest.NewRequest(http.MethodGet,
+		"/api/v1/logs/transactions?fields=trace_id,%20request_id&max_span=5m&max_pause=30s&sort_by=duration&sort_order=asc&limit=1&offset=2&error_query=status:>=500", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(store.transactionCalls) != 1 {
+		t.Fatalf("transaction calls = %+v", store.transactionCalls)
+	}
+	call := store.transactionCalls[0]
+	if len(call.Fields) != 2 || call.Fields[1] != "request_id" || call.MaxSpan != 5*time.Minute || call.MaxPause != 30*time.Second ||
+		call.SortBy != "duration" || call.SortOrder != "asc" || call.Limit != 1 || call.Offset != 2 || call.ErrorQuery != "status:>=500" {
+		t.Fatalf("transaction options = %+v", call)
+	}
+	var response types.TransactionsResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.Total != 7 || response.Grouped != 12 || len(response.Transactions) != 1 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	transaction := response.Transactions[0]
+	if transaction.Duration != 1500000 || !transaction.Error || transaction.Errors != 1 || transaction.Count != 3 ||
+		transaction.Fields["trace_id"] != "t1" || transaction.End != "2024-01-15T10:00:01.5Z" || len(transaction.Sources) != 2 {
+		t.Fatalf("unexpected transaction: %+v", transaction)
+	}
+
+	for _, target := range []string{
+		"/api/v1/logs/transactions",
+		"/api/v1/logs/transactions?fields=trace_id&max_span=soon",
+		"/api/v1/logs/transactions?fields=trace_id&max_pause=-1s",
+		"/api/v1/logs/transactions?fields=trace_id&sort_by=count",
+		"/api/v1/logs/transactions?fields=trace_id&offset=-1",
+	} {
+		w = httptest.NewRecorder()
+		h.HandleTransactions(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", target, w.Code)
+		}
+	}
+	if len(store.transactionCalls) != 1 {
+		t.Fatalf("invalid requests reached storage: %+v", store.transactionCalls)
+	}
+}
+
+func TestHandleFields_DescribesEachField(t *testing.T) {
+	h, store := setupHandler(t)
+	minimum, maximum, mean := 10.0, 50.0, 30.0
+	store.catalogResult = storagepkg.FieldCatalogResult{
+		Fields: []storagepkg.FieldStats{
+			{Name: "latency", Type: "number", Types: []string{"number"}, Count: 3, Distinct: 3, DistinctExact: true,
+				Min: &minimum, Max: &maximum, Avg: &mean, TopValues: []storagepkg.FacetValue{{Value: 10.0, Count: 1}}},
+			{Name: "level", Type: "text", Types: []string{"text"}, Count: 3, Distinct: 2, DistinctExact: true,
+				TopValues: []storagepkg.FacetValue{{Value: "info", Count: 2}}},
+		},
+		Total: 3,
+	}
+	w := httptest.NewRecorder()
+	h.HandleFields(w, httptest.NewRequest(http.MethodGet, "/api/v1/fields?samples=1&_src=app.log&query=level:info", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(store.catalogCalls) != 1 || store.catalogCalls[0].Samples != 1 || store.catalogCalls[0].Query != "level:info" ||
+		len(store.catalogCalls[0].Sources) != 1 {
+		t.Fatalf("catalog calls = %+v", store.catalogCalls)
+	}
+	body := w.Body.String()
+	var response types.FieldsResponse
+	if err := json.NewDecoder(strings.NewReader(body)).Decode(&response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.Total != 3 || len(response.Fields) != 2 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	latency, level := response.Fields[0], response.Fields[1]
+	if latency.Type != "number" || latency.Avg == nil || *latency.Avg != 30 || latency.TopValues[0].Value != 10.0 {
+		t.Fatalf("unexpected latency: %+v", latency)
+	}
+	if level.Min != nil || level.Distinct != 2 || level.TopValues[0].Value != "info" {
+		t.Fatalf("unexpected level: %+v", level)
+	}
+	if strings.Count(body, `"avg"`) != 1 {
+		t.Fatalf("numeric statistics should be omitted for text fields: %s", body)
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleFields(w, httptest.NewRequest(http.MethodGet, "/api/v1/fields?samples=51", nil))
+	if w.Code != http.StatusBadRequest || len(store.catalogCalls) != 1 {
+		t.Fatalf("expected 400 without a storage call, got %d", w.Code)
+	}
+}
+
+func TestHandleQuerySuggest_ReturnsCompletionsForTheCursor(t *testing.T) {
+	h, store := setupHandler(t)
+	store.suggestResult = storagepkg.SuggestResult{
+		Suggestions: []storagepkg.Suggestion{
+			{Text: "level:error", Kind: storagepkg.SuggestValue, Count: 2},
+			{Text: "level:eek", Kind: storagepkg.SuggestValue, Count: 1},
+		},
+		ReplaceFrom: 0,
+		ReplaceTo:   7,
+		Field:       "level",
+	}
+	w := httptest.NewRecorder()
+	h.HandleQuerySuggest(w, httptest.NewRequest(http.MethodGet, "/api/v1/query/suggest?prefix=level%3Ae%C3%A9+x&cursor_pos=7&limit=5", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(store.suggestCalls) != 1 || store.suggestCalls[0].Text != "level:eé x" || store.suggestCalls[0].Cursor != 7 ||
+		store.suggestCalls[0].Limit != 5 {
+		t.Fatalf("suggest calls = %+v", store.suggestCalls)
+	}
+	var response types.QuerySuggestResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.CursorPos != 7 || response.ReplaceTo != 7 || response.Field != "level" || len(response.Suggestions) != 2 ||
+		response.Suggestions[0].Text != "level:error" || response.Suggestions[0].Kind != "value" || response.Suggestions[0].Count != 2 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	// The cursor defaults to the end of the prefix, counted in characters.
+	w = httptest.NewRecorder()
+	h.HandleQuerySuggest(w, httptest.NewRequest(http.MethodGet, "/api/v1/query/suggest?prefix=%C3%A9t", nil))
+	if w.Code != http.StatusOK || store.suggestCalls[1].Cursor != 2 {
+		t.Fatalf("default cursor: %d, calls = %+v", w.Code, store.suggestCalls)
+	}
+
+	for _, target := range []string{
+		"/api/v1/query/suggest?prefix=abc&cursor_pos=4",
+		"/api/v1/query/suggest?prefix=abc&cursor_pos=-1",
+		"/api/v1/query/suggest?prefix=abc&limit=101",
+	} {
+		w = httptest.NewRecorder()
+		h.HandleQuerySuggest(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", target, w.Code)
+		}
+	}
+	if len(store.suggestCalls) != 2 {
+		t.Fatalf("invalid requests reached storage: %+v", store.suggestCalls)
+	}
+}
+
+func TestHandleExport_WritesEachFormat(t *testing.T) {
+	h, store := setupHandler(t)
+	stamp := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	store.exportColumns = []string{"timestamp", "level", "latency"}
+	store.exportRows = []map[string]interface{}{
+		{"timestamp": stamp, "level": "ERROR", "latency": 12.5, "_raw": "boom, again"},
+		{"timestamp": stamp.Add(time.Second), "level": "a \"quoted\" value"},
+	}
+
+	w := httptest.NewRecorder()
+	h.HandleExport(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs/export?format=csv&fields=timestamp,level,latency&sort_order=asc", nil))
+	want := "timestamp,level,latency\n2024-01-15T10:00:00Z,ERROR,12.5\n2024-01-15T10:00:01Z,\"a \"\"quoted\"\" value\",\n"
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Fatalf("csv export = %d %q", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Fatalf("csv content type = %q", ct)
+	}
+	call := store.exportCalls[0]
+	if call.SortBy != "timestamp" || call.SortOrder != "asc" || len(call.Fields) != 3 {
+		t.Fatalf("export options = %+v", call)
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleExport(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs/export", nil))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	var first map[string]interface{}
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[0]), &first) != nil || first["_raw"] != "boom, again" {
+		t.Fatalf("ndjson export = %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleExport(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs/export?format=raw&fields=level", nil))
+	if w.Body.String() != "boom, again\n" || store.exportCalls[2].Fields[0] != "_raw" || store.exportCalls[2].Empty {
+		t.Fatalf("raw export = %q with %+v", w.Body.String(), store.exportCalls[2])
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleExport(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs/export?format=csv&_src=", nil))
+	if w.Code != http.StatusOK || !store.exportCalls[3].Empty {
+		t.Fatalf("an empty source filter must export nothing, got %d with %+v", w.Code, store.exportCalls[3])
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleExport(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs/export?format=xml", nil))
+	if w.Code != http.StatusBadRequest || len(store.exportCalls) != 4 {
+		t.Fatalf("expected 400 for an unknown format, got %d", w.Code)
+	}
+	store.searchErr = fmt.Errorf("%w: message is sorted by stored value", storagepkg.ErrInvalidExport)
+	w = httptest.NewRecorder()
+	h.HandleExport(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs/export?sort_by=message", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unstreamable sort, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleReadAll_ReturnsHighlights(t *testing.T) {
+	h, store := setupHandler(t)
+	store.logs = []map[string]interface{}{{"_raw": "disk full", "latency": 250.0}}
+	store.highlights = []storagepkg.Highlights{{"_raw": {{0, 4}}, "latency": {}}}
+
+	w := httptest.NewRecorder()
+	h.HandleReadAll(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs?highlight=true", nil))
+	if w.Code != http.StatusOK || !store.lastPage.Highlight {
+		t.Fatalf("expected a highlighted search, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"highlights":[{"_raw":[[0,4]],"latency":[]}]`) {
+		t.Fatalf("unexpected body: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleReadAll(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs", nil))
+	if store.lastPage.Highlight || strings.Contains(w.Body.String(), "highlights") {
+		t.Fatalf("highlights returned without highlight=true: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleReadAll(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs?highlight=maybe", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
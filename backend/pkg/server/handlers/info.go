@@ -54,6 +54,9 @@ func (h *Services) HandleInfo(w http.ResponseWriter, r *http.Request) {
 		StorageDirectory string   `json:"storage_directory"`
 		StorageSize      int64    `json:"storage_size_bytes"`
 		SourceNames      []string `json:"source_names"`
+
+		FieldTypeConflicts  []types.FieldTypeConflict `json:"field_type_conflicts"`
+		FieldTypesUnchecked int                       `json:"field_types_unchecked_shards"`
 	}
 
 	// Storage Information section - check cache first
@@ -115,6 +118,18 @@ func (h *Services) HandleInfo(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		conflicts, unchecked, err := h.fieldTypeConflicts()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(types.ErrorResponse{
+				Status:  "error",
+				Error:   "Failed to check field types",
+				Code:    "FIELD_TYPES_ERROR",
+				Details: err.Error(),
+			})
+			return
+		}
+
 		// Get storage directory size
 		storagePath = h.storage.BaseDir()
 
@@ -156,6 +171,9 @@ func (h *Services) HandleInfo(w http.ResponseWriter, r *http.Request) {
 			StorageDirectory: storagePath,
 			StorageSize:      storageSize,
 			SourceNames:      sourceNames,
+
+			FieldTypeConflicts:  conflicts,
+			FieldTypesUnchecked: unchecked,
 		}
 
 		// Cache the storage info
@@ -167,6 +185,7 @@ func (h *Services) HandleInfo(w http.ResponseWriter, r *http.Request) {
 
 	// Set the storage info in the response
 	response.StorageInfo = storageInfo
+	response.StorageQuota = h.storageQuotaInfo()
 
 	// System Information - always compute fresh
 	hostname, _ := os.Hostname()
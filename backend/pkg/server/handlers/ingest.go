@@ -206,7 +206,8 @@ func (h *Services) HandleIngest(w http.ResponseWriter, r *http.Request) {
 	results := sessionDecoder.DecodeConcurrent(logs, 0)
 	jsonOutput, successCount, failedCount, _ := postProcess(results, sessionOptions, sessionSeq)
 
-	if err := h.storage.Store(jsonOutput, sessionOptions.Source); err != nil {
+	stored, err := h.storage.StoreWithOptions(jsonOutput, sessionOptions.Source, storeOptions(h.Schemas, sessionOptions))
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(types.ErrorResponse{
 			Status:  "error",
@@ -218,12 +219,14 @@ func (h *Services) HandleIngest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.InvalidateInfoCache()
+	h.noteIngested(ingestedBytes(logs))
 
 	json.NewEncoder(w).Encode(types.IngestResponse{
-		Status:    "success",
-		Processed: successCount,
-		Failed:    failedCount,
-		SessionID: req.SessionID,
+		Status:     "success",
+		Processed:  successCount,
+		Failed:     failedCount,
+		Duplicates: stored.Duplicates,
+		SessionID:  req.SessionID,
 	})
 }
 
@@ -317,8 +320,9 @@ func (h *Services) HandleIngestStart(w http.ResponseWriter, r *http.Request) {
 		TimestampConfig: req.TimestampConfig,
 		// Meta is freely passed through so callers can stamp every
 		// record with additional fields.
-		Meta:      req.Meta,
-		Multiline: req.Multiline,
+		Meta:        req.Meta,
+		Multiline:   req.Multiline,
+		Deduplicate: req.Deduplicate,
 	}
 
 	sessionMapMutex.Lock()
@@ -339,7 +343,7 @@ func (h *Services) HandleIngestStart(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary End log ingest session
-// @Description End the specified log ingest session and cleanup its resources
+// @Description End the specified log ingest session and cleanup its resources. A multiline record still open is stored first; the counts cover it.
 // @Tags ingest
 // @Accept json
 // @Produce json
@@ -375,6 +379,9 @@ func (h *Services) HandleIngestEnd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	response := types.IngestResponse{
+		Status: "success",
+	}
 	if req.SessionID != "" {
 		sessionMapMutex.Lock()
 		session, exists := sessionMap[req.SessionID]
@@ -382,36 +389,38 @@ func (h *Services) HandleIngestEnd(w http.ResponseWriter, r *http.Request) {
 		sessionMapMutex.Unlock()
 
 		if exists {
-			h.flushSessionMultiline(session, req.SessionID)
+			response.Processed, response.Failed, response.Duplicates = h.flushSessionMultiline(session, req.SessionID)
 		}
 	}
 
-	json.NewEncoder(w).Encode(types.IngestResponse{
-		Status: "success",
-	})
+	json.NewEncoder(w).Encode(response)
 }
 
 // flushSessionMultiline decodes and stores any still-open trailing
 // multiline record. Used by /ingest/end and by session expiry so the
 // last record isn't dropped when nothing arrived to prove it complete.
-func (h *Services) flushSessionMultiline(session IngestSession, sessionID string) {
+// It returns the processed, failed and duplicate counts of the flushed
+// record, as /ingest reports them for each chunk.
+func (h *Services) flushSessionMultiline(session IngestSession, sessionID string) (int, int, int) {
 	if session.Multiline == nil {
-		return
+		return 0, 0, 0
 	}
 	final := session.Multiline.Flush()
 	if len(final) == 0 {
-		return
+		return 0, 0, 0
 	}
 	results := session.Decoder.DecodeConcurrent(final, 0)
-	jsonOutput, _, _, _ := postProcess(results, session.Options, session.Seq)
+	jsonOutput, successCount, failedCount, _ := postProcess(results, session.Options, session.Seq)
 	if len(jsonOutput) == 0 {
-		return
+		return successCount, failedCount, 0
 	}
-	if err := h.storage.Store(jsonOutput, session.Options.Source); err != nil {
+	stored, err := h.storage.StoreWithOptions(jsonOutput, session.Options.Source, storeOptions(h.Schemas, session.Options))
+	if err != nil {
 		log.Printf("ingest: failed to store trailing multiline record for session %s: %v", sessionID, err)
-		return
+		return 0, failedCount + len(jsonOutput), 0
 	}
 	h.InvalidateInfoCache()
+	return successCount, failedCount, stored.Duplicates
 }
 
 // expireStaleSessions removes sessions older than SessionTimeout and
@@ -18,6 +18,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"logsonic/pkg/schema"
 	storagepkg "logsonic/pkg/storage"
 	"logsonic/pkg/timeresolve"
 	"logsonic/pkg/types"
@@ -38,6 +39,7 @@ const (
 type TailManager struct {
 	storage    storagepkg.StorageInterface
 	invalidate func()
+	schemas    *schema.Store // field types for stored rows; nil applies none
 
 	mu          sync.RWMutex
 	sources     map[string]*TailSource
@@ -624,23 +626,29 @@ func (s *TailSource) processFoldedLines(lines []string) error {
 		return nil
 	}
 
-	ids, err := s.manager.storage.StoreWithIDs(parsed, s.opts.Source)
+	stored, err := s.manager.storage.StoreWithOptions(parsed, s.opts.Source, storeOptions(s.manager.schemas, s.opts))
 	if err != nil {
 		return err
 	}
+	ids := stored.IDs
 	if s.manager.invalidate != nil {
 		s.manager.invalidate()
 	}
 
+	published := parsed[:0]
 	for i := range parsed {
 		if i < len(ids) {
+			if ids[i] == "" {
+				continue // skipped as a duplicate
+			}
 			parsed[i]["_id"] = ids[i]
 		} else {
 			parsed[i]["_id"] = storagepkg.BuildDocID(parsed[i], s.opts.Source, i)
 		}
 		delete(parsed[i], "_seq")
+		published = append(published, parsed[i])
 	}
-	s.manager.publishRows(s.id, parsed)
+	s.manager.publishRows(s.id, published)
 	return nil
 }
 
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	storagepkg "logsonic/pkg/storage"
+	"logsonic/pkg/types"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const contextDefaultRows = 20
+
+// @Summary Rows around a log
+// @Description Returns a log and the logs of the same source written just before and after it, in original order: by timestamp, then ingest sequence. The query that found the log plays no part, and the rows may come from neighbouring shards.
+// @Tags logs
+// @Produce json
+// @Param id path string true "Log ID (_id)"
+// @Param before query integer false "Rows before the log (default: 20, max: 1000)"
+// @Param after query integer false "Rows after the log (default: 20, max: 1000)"
+// @Success 200 {object} types.LogContextResponse
+// @Failure 400 {object} types.ErrorResponse "Bad request due to invalid parameters"
+// @Failure 404 {object} types.ErrorResponse "No log has this ID"
+// @Failure 500 {object} types.ErrorResponse "Internal server error"
+// @Failure 504 {object} types.ErrorResponse "Stopped by the request timeout"
+// @Router /logs/{id}/context [get]
+func (h *Services) HandleLogContext(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
+	}
+
+	startTime := time.Now()
+	query := r.URL.Query()
+	options := storagepkg.ContextOptions{
+		ID:     chi.URLParam(r, "id"),
+		Before: contextDefaultRows,
+		After:  contextDefaultRows,
+	}
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{{"before", &options.Before}, {"after", &options.After}} {
+		valueStr := query.Get(param.name)
+		if valueStr == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(valueStr)
+		if err != nil || parsed < 0 || parsed > storagepkg.MaxContextRows {
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid "+param.name+" parameter",
+				fmt.Sprintf("%s must be an integer between 0 and %d", param.name, storagepkg.MaxContextRows))
+			return
+		}
+		*param.value = parsed
+	}
+
+	result, err := h.storage.LogContext(r.Context(), options)
+	if err != nil {
+		switch {
+		case errors.Is(err, storagepkg.ErrLogNotFound):
+			writeError(w, http.StatusNotFound, "NOT_FOUND", "Log not found", err.Error())
+		default:
+			writeQueryError(w, err, "Context search", "Failed to read log context")
+		}
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(types.LogContextResponse{
+		Status:     "success",
+		Log:        result.Log,
+		Source:     result.Source,
+		Before:     result.Before,
+		After:      result.After,
+		MoreBefore: result.MoreBefore,
+		MoreAfter:  result.MoreAfter,
+		TimeTaken:  int(time.Since(startTime).Microseconds()),
+	})
+}
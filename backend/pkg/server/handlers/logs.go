@@ -8,7 +8,7 @@ import (
 	storagepkg "logsonic/pkg/storage"
 	"logsonic/pkg/types"
 	"net/http"
-	"sort"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -22,13 +22,21 @@ import (
 // @Accept json
 // @Produce json
 // @Param limit query integer false "Maximum number of logs to return (default: 1000)"
-// @Param offset query integer false "Number of logs to skip (default: 0)"
+// @Param offset query integer false "Number of logs to skip (default: 0; at most 10000 when sorting by a stored-value field)"
+// @Param cursor query string false "next_cursor or prev_cursor from a previous response; replaces offset"
 // @Param sort_by query string false "Field to sort by (default: timestamp)"
 // @Param sort_order query string false "Sort order (asc or desc, default: desc)"
 // @Param start_date query string false "Start date for log retrieval (RFC3339 format)"
 // @Param end_date query string false "End date for log retrieval (RFC3339 format)"
-// @Param query query string false "Optional search query to filter logs"
+// @Param query query string false "Optional search query to filter logs; raw:~/regex/ matches the raw line against a Go regex"
 // @Param _src query string false "Optional comma-separated source filter"
+// @Param buckets query integer false "Number of histogram buckets (default: 100, max: 1000)"
+// @Param interval query string false "Histogram bucket width from start_date, e.g. 30s, 5m, 1h or 1d; overrides buckets"
+// @Param split_by query string false "Field whose values split each bucket's count; number, keyword and boolean fields are counted from the index, others (or any split with agg) by reading every matching row"
+// @Param split_limit query integer false "Split values to report, most frequent overall first (default: 10, max: 100); the rest are counted in split_other"
+// @Param agg query string false "Per-bucket aggregate of agg_field: avg, sum, min, max, median or p1-p100"
+// @Param agg_field query string false "Numeric field the aggregate is computed over"
+// @Param highlight query boolean false "Return highlights: per row, the fields the query matched and the byte ranges of each that matched"
 // @Success 200 {object} types.LogResponse "Logs with pagination, sorting, and time distribution metadata"
 // @Failure 400 {object} types.ErrorResponse "Bad request due to invalid parameters"
 // @Failure 500 {object} types.ErrorResponse "Internal server error"
@@ -95,6 +103,11 @@ func (h *Services) HandleReadAll(w http.ResponseWriter, r *http.Request) {
 	if sortByParam := query.Get("sort_by"); sortByParam != "" {
 		sortBy = sortByParam
 	}
+	cursor := query.Get("cursor")
+	if cursor != "" && offset > 0 {
+		writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid cursor parameter", "Cursor cannot be combined with offset")
+		return
+	}
 
 	sortOrder := "desc"
 	if sortOrderParam := query.Get("sort_order"); sortOrderParam != "" {
@@ -111,157 +124,84 @@ func (h *Services) HandleReadAll(w http.ResponseWriter, r *http.Request) {
 		sortOrder = sortOrderParam
 	}
 
-	// Handle optional search query
-	searchQuery := query.Get("query")
-
-	// Set default date range (1 year ago to now)
-	now := time.Now()
-	startDate := now.AddDate(-1, 0, 0)
-	endDate := now
-
-	// Parse optional start_date and end_date
-	if startDateStr := query.Get("start_date"); startDateStr != "" {
-		var parsedStartDate time.Time
-		var err error
-
-		// Try parsing with dateparse which handles multiple formats
-		parsedStartDate, err = dateparse.ParseAny(startDateStr)
-
-		// If parsing fails, try parsing as Unix timestamp
-		if err != nil {
-			unixTimestamp, err := strconv.ParseInt(startDateStr, 10, 64)
-			if err == nil {
-				parsedStartDate = time.Unix(unixTimestamp, 0)
-			}
-		}
-
-		// If parsing succeeds, update startDate
-		if err == nil {
-			startDate = parsedStartDate
-		}
-	}
-
-	if endDateStr := query.Get("end_date"); endDateStr != "" {
-		var parsedEndDate time.Time
-		var err error
-
-		// Use same parsing approach as start_date for consistency
-		parsedEndDate, err = dateparse.ParseAny(endDateStr)
-
-		// If parsing fails, try parsing as Unix timestamp
+	highlight := false
+	if highlightStr := query.Get("highlight"); highlightStr != "" {
+		parsed, err := strconv.ParseBool(highlightStr)
 		if err != nil {
-			unixTimestamp, err := strconv.ParseInt(endDateStr, 10, 64)
-			if err == nil {
-				parsedEndDate = time.Unix(unixTimestamp, 0)
-			}
-		}
-
-		// If parsing succeeds, update endDate
-		if err == nil {
-			endDate = parsedEndDate
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid highlight parameter", "Highlight must be true or false")
+			return
 		}
+		highlight = parsed
 	}
 
-	// Ensure that we preserve the full time components (hours, minutes, seconds) of dates
-	// for accurate filtering, not just the calendar date
-
-	// Source filter. Omitting _src means "all sources" for API compatibility.
-	// Supplying _src= means "no sources selected", which the UI uses for
-	// Deselect All.
-	sourceValues, sourceFilterPresent := query["_src"]
-	sourceFilter := strings.Join(sourceValues, ",")
-	sources := []string{}
-	if sourceFilterPresent {
-		for _, source := range strings.Split(sourceFilter, ",") {
-			source = strings.TrimSpace(source)
-			if source != "" {
-				sources = append(sources, source)
-			}
-		}
-		if len(sources) == 0 {
-			totalTime := time.Since(startTime)
-			json.NewEncoder(w).Encode(types.LogResponse{
-				Status:           "success",
-				TotalCount:       0,
-				IndexQueryTime:   0,
-				TimeTaken:        int(totalTime.Microseconds()),
-				Offset:           0,
-				Limit:            limit,
-				Count:            0,
-				Logs:             []map[string]interface{}{},
-				SortBy:           sortBy,
-				SortOrder:        sortOrder,
-				Query:            searchQuery,
-				StartDate:        startDate.Format(time.RFC3339),
-				EndDate:          endDate.Format(time.RFC3339),
-				AvailableColumns: []string{},
-				LogDistribution:  []types.LogDistributionEntry{},
-			})
-			return
-		}
+	histogram, details := parseHistogramOptions(query)
+	if details != "" {
+		writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid histogram parameter", details)
+		return
 	}
 
-	var allLogs []map[string]interface{}
-	var pageLogs []map[string]interface{}
-	var availableColumns []string
-	var logDistributionEntries []types.LogDistributionEntry
-	var totalCount int
-	var indexQueryTime time.Duration
-	var err error
-
-	if sortBy == "timestamp" {
-		pageResult, searchErr := h.storage.SearchPage(r.Context(), storagepkg.SearchOptions{
-			Query:     searchQuery,
-			StartDate: startDate,
-			EndDate:   endDate,
-			Sources:   sources,
-			Limit:     limit,
-			Offset:    offset,
-			SortBy:    sortBy,
-			SortOrder: sortOrder,
+	filter := parseLogFilter(query)
+	searchQuery, startDate, endDate, sources := filter.Query, filter.StartDate, filter.EndDate, filter.Sources
+	if filter.NoSources {
+		totalTime := time.Since(startTime)
+		json.NewEncoder(w).Encode(types.LogResponse{
+			Status:           "success",
+			TotalCount:       0,
+			IndexQueryTime:   0,
+			TimeTaken:        int(totalTime.Microseconds()),
+			Offset:           0,
+			Limit:            limit,
+			Count:            0,
+			Logs:             []map[string]interface{}{},
+			SortBy:           sortBy,
+			SortOrder:        sortOrder,
+			Query:            searchQuery,
+			StartDate:        startDate.Format(time.RFC3339),
+			EndDate:          endDate.Format(time.RFC3339),
+			AvailableColumns: []string{},
+			LogDistribution:  []types.LogDistributionEntry{},
 		})
-		err = searchErr
-		if err == nil {
-			pageLogs = pageResult.Logs
-			totalCount = pageResult.TotalCount
-			availableColumns = pageResult.AvailableColumns
-			indexQueryTime = pageResult.QueryTime
-			logDistributionEntries = make([]types.LogDistributionEntry, len(pageResult.Distribution))
-			for i, bucket := range pageResult.Distribution {
-				logDistributionEntries[i] = types.LogDistributionEntry{
-					StartTime:    bucket.StartTime.Format(time.RFC3339),
-					EndTime:      bucket.EndTime.Format(time.RFC3339),
-					Count:        bucket.Count,
-					SourceCounts: bucket.SourceCounts,
-				}
-			}
-		}
-	} else {
-		// Arbitrary dynamic fields do not have doc values in existing indexes.
-		// Preserve their legacy behavior until the cursor contract can expose a
-		// bounded supported-sort policy.
-		allLogs, indexQueryTime, err = h.storage.Search(searchQuery, &startDate, &endDate, sources)
-		if err == nil {
-			totalCount = len(allLogs)
-			endIndex := offset + limit
-			if endIndex > totalCount {
-				endIndex = totalCount
-			}
-			if offset >= totalCount {
-				offset = 0
-				endIndex = 0
-			}
-			allLogs, availableColumns = sortLogs(allLogs, sortBy, sortOrder)
-			logDistributionEntries, _ = calculateLogDistribution(allLogs)
-			pageLogs = allLogs[offset:endIndex]
-		}
+		return
 	}
 
+	pageResult, err := h.storage.SearchPage(r.Context(), storagepkg.SearchOptions{
+		Query:     searchQuery,
+		StartDate: startDate,
+		EndDate:   endDate,
+		Sources:   sources,
+		Limit:     limit,
+		Offset:    offset,
+		Cursor:    cursor,
+		SortBy:    sortBy,
+		SortOrder: sortOrder,
+		Histogram: histogram,
+		Highlight: highlight,
+	})
 	if err != nil {
 		statusCode := http.StatusInternalServerError
 		code := "READ_ERROR"
 		errorMessage := "Failed to read logs"
-		if errors.Is(err, context.DeadlineExceeded) {
+		if errors.Is(err, storagepkg.ErrInvalidQuery) {
+			statusCode = http.StatusBadRequest
+			code = "INVALID_QUERY"
+			errorMessage = "Invalid query"
+		} else if errors.Is(err, storagepkg.ErrInvalidCursor) {
+			statusCode = http.StatusBadRequest
+			code = "INVALID_PARAMETER"
+			errorMessage = "Invalid cursor parameter"
+		} else if errors.Is(err, storagepkg.ErrOffsetTooDeep) {
+			statusCode = http.StatusBadRequest
+			code = "INVALID_PARAMETER"
+			errorMessage = "Invalid offset parameter"
+		} else if errors.Is(err, storagepkg.ErrInvalidHistogram) {
+			statusCode = http.StatusBadRequest
+			code = "INVALID_PARAMETER"
+			errorMessage = "Invalid histogram parameter"
+		} else if errors.Is(err, storagepkg.ErrRegexpLimit) {
+			statusCode = http.StatusBadRequest
+			code = "QUERY_LIMIT_EXCEEDED"
+			errorMessage = "Regex query limit exceeded"
+		} else if errors.Is(err, context.DeadlineExceeded) {
 			statusCode = http.StatusGatewayTimeout
 			code = "SEARCH_TIMEOUT"
 			errorMessage = "Log search timed out"
@@ -280,9 +220,28 @@ func (h *Services) HandleReadAll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	pageLogs, totalCount := pageResult.Logs, pageResult.TotalCount
+	var highlights []map[string][][2]int
+	for _, rowHighlights := range pageResult.Highlights {
+		highlights = append(highlights, rowHighlights)
+	}
 	if offset >= totalCount {
 		offset = 0
 		pageLogs = []map[string]interface{}{}
+		highlights = nil
+	}
+	logDistributionEntries := make([]types.LogDistributionEntry, len(pageResult.Distribution))
+	for i, bucket := range pageResult.Distribution {
+		logDistributionEntries[i] = types.LogDistributionEntry{
+			StartTime:    bucket.StartTime.Format(time.RFC3339),
+			EndTime:      bucket.EndTime.Format(time.RFC3339),
+			Count:        bucket.Count,
+			SourceCounts: bucket.SourceCounts,
+			SplitCounts:  bucket.SplitCounts,
+			SplitOther:   bucket.SplitOther,
+			Value:        bucket.Value,
+			SplitValues:  bucket.SplitValues,
+		}
 	}
 
 	totalTime := time.Since(startTime)
@@ -291,7 +250,7 @@ func (h *Services) HandleReadAll(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(types.LogResponse{
 		Status:           "success",
 		TotalCount:       totalCount,
-		IndexQueryTime:   int(indexQueryTime.Microseconds()),
+		IndexQueryTime:   int(pageResult.QueryTime.Microseconds()),
 		TimeTaken:        int(totalTime.Microseconds()),
 		Offset:           offset,
 		Limit:            limit,
@@ -302,162 +261,109 @@ func (h *Services) HandleReadAll(w http.ResponseWriter, r *http.Request) {
 		Query:            searchQuery,
 		StartDate:        startDate.Format(time.RFC3339),
 		EndDate:          endDate.Format(time.RFC3339),
-		AvailableColumns: availableColumns,
+		AvailableColumns: pageResult.AvailableColumns,
 		LogDistribution:  logDistributionEntries,
+		NextCursor:       pageResult.NextCursor,
+		PrevCursor:       pageResult.PrevCursor,
+		Highlights:       highlights,
 	})
 }
 
-// calculateLogDistribution calculates time-based distribution of logs
-// Returns distribution entries and unique sources
-func calculateLogDistribution(allLogs []map[string]interface{}) ([]types.LogDistributionEntry, map[string]bool) {
-	// Track unique sources
-	uniqueSources := make(map[string]bool)
-	var logDistributionEntries []types.LogDistributionEntry
-
-	// Initialize count of logs without timestamps
-	logsWithoutTimestamp := 0
-
-	if len(allLogs) > 0 {
-		// Find the first and last log timestamps
-		var firstLogTime, lastLogTime time.Time
-
-		// Find the lowest and highest timestamp in all logs
-		for _, log := range allLogs {
-			// Track unique sources regardless of timestamp
-			if src, exists := log["_src"]; exists && src != nil {
-				srcStr := fmt.Sprintf("%v", src)
-				uniqueSources[srcStr] = true
-			}
-
-			ts, exists := log["timestamp"]
-			if !exists || ts == nil {
-				logsWithoutTimestamp++
-				continue
-			}
-
-			timestamp, ok := ts.(time.Time)
-			if !ok {
-				logsWithoutTimestamp++
-				continue
-			}
-
-			if firstLogTime.IsZero() || timestamp.Before(firstLogTime) {
-				firstLogTime = timestamp
-			}
-			if lastLogTime.IsZero() || timestamp.After(lastLogTime) {
-				lastLogTime = timestamp
-			}
+// parseHistogramOptions reads the histogram parameters of HandleReadAll,
+// returning details of the first invalid one. Checks that depend on the
+// time range are left to storage.
+func parseHistogramOptions(query url.Values) (storagepkg.HistogramOptions, string) {
+	histogram := storagepkg.HistogramOptions{
+		SplitBy:        query.Get("split_by"),
+		Aggregate:      query.Get("agg"),
+		AggregateField: query.Get("agg_field"),
+	}
+	if bucketsStr := query.Get("buckets"); bucketsStr != "" {
+		buckets, err := strconv.Atoi(bucketsStr)
+		if err != nil || buckets <= 0 || buckets > storagepkg.MaxDistributionBuckets {
+			return histogram, fmt.Sprintf("buckets must be an integer between 1 and %d", storagepkg.MaxDistributionBuckets)
 		}
+		histogram.Buckets = buckets
+	}
+	if intervalStr := query.Get("interval"); intervalStr != "" {
+		interval, err := storagepkg.ParseSpan(intervalStr)
+		if err != nil {
+			return histogram, "interval must be a duration of at least 1s, e.g. 30s, 5m, 1h or 1d"
+		}
+		histogram.Interval = interval
+	}
+	if limitStr := query.Get("split_limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > storagepkg.MaxSplitValues {
+			return histogram, fmt.Sprintf("split_limit must be an integer between 1 and %d", storagepkg.MaxSplitValues)
+		}
+		histogram.SplitLimit = limit
+	}
+	if (histogram.Aggregate == "") != (histogram.AggregateField == "") {
+		return histogram, "agg and agg_field must be given together"
+	}
+	return histogram, ""
+}
 
-		// If valid timestamps found, calculate distribution
-		if !firstLogTime.IsZero() && !lastLogTime.IsZero() {
-			// Calculate time bucket size
-			timeDiff := lastLogTime.Sub(firstLogTime)
-			maxBuckets := 100 // Maximum number of buckets
-
-			// Ensure minimum bucket duration is 1 second
-			minBucketDuration := time.Second
-
-			// Calculate bucket duration based on time difference
-			bucketDuration := timeDiff / time.Duration(maxBuckets)
-
-			// Ensure bucket duration is at least 1 second
-			if bucketDuration < minBucketDuration {
-				bucketDuration = minBucketDuration
-			}
-
-			// Calculate actual number of buckets needed
-			numBuckets := int(timeDiff / bucketDuration)
-			if numBuckets > maxBuckets {
-				numBuckets = maxBuckets
-			}
-			if numBuckets < 1 {
-				numBuckets = 1
-			}
-
-			// Recalculate bucket duration to ensure even distribution
-			bucketDuration = timeDiff / time.Duration(numBuckets)
-
-			// Initialize distribution buckets
-			logDistributionEntries = make([]types.LogDistributionEntry, numBuckets)
-			for i := 0; i < numBuckets; i++ {
-				bucketStart := firstLogTime.Add(time.Duration(i) * bucketDuration)
-				bucketEnd := bucketStart.Add(bucketDuration)
-				logDistributionEntries[i] = types.LogDistributionEntry{
-					StartTime:    bucketStart.Format(time.RFC3339),
-					EndTime:      bucketEnd.Format(time.RFC3339),
-					Count:        0,
-					SourceCounts: make(map[string]int),
-				}
-			}
+// logFilter is the query, source and time-range selection shared by the
+// endpoints that read or delete logs.
+type logFilter struct {
+	Query     string
+	StartDate time.Time
+	EndDate   time.Time
+	Sources   []string
+	// NoSources is set when _src is present but empty, which the UI sends
+	// for Deselect All: nothing matches.
+	NoSources bool
+}
 
-			// Count logs in each bucket
-			bucketCounts := 0
-			outOfBoundsCounts := 0
-
-			for _, log := range allLogs {
-				// Parse log timestamp
-				ts, exists := log["timestamp"]
-				if !exists || ts == nil {
-					continue
-				}
-
-				logTime, ok := ts.(time.Time)
-				if !ok {
-					continue
-				}
-
-				// Get source, default to "unknown" if not available
-				source := "unknown"
-				if src, exists := log["_src"]; exists && src != nil {
-					source = fmt.Sprintf("%v", src)
-				}
-
-				// For the last bucket, include logs exactly at the end time
-				isInTimeRange := (logTime.Equal(firstLogTime) || logTime.After(firstLogTime)) &&
-					(logTime.Before(lastLogTime) || logTime.Equal(lastLogTime))
-
-				if !isInTimeRange {
-					outOfBoundsCounts++
-					continue
-				}
-
-				// Calculate bucket index with protection against division issues
-				timeSinceStart := logTime.Sub(firstLogTime)
-				if timeSinceStart < 0 {
-					outOfBoundsCounts++
-					continue
-				}
-
-				// Use float calculation to avoid integer division issues
-				bucketFloat := float64(timeSinceStart) / float64(bucketDuration)
-				bucketIndex := int(bucketFloat)
-
-				// Handle edge case for logs exactly at the end of the range
-				if logTime.Equal(lastLogTime) {
-					bucketIndex = numBuckets - 1
-				}
-
-				// Ensure bucket index is within bounds
-				if bucketIndex >= 0 && bucketIndex < numBuckets {
-					logDistributionEntries[bucketIndex].Count++
-					logDistributionEntries[bucketIndex].SourceCounts[source]++
-					bucketCounts++
-				} else {
-					outOfBoundsCounts++
-				}
-			}
+// parseLogFilter reads query, _src, start_date and end_date. The range
+// defaults to the last year; dates that fail to parse keep the default.
+func parseLogFilter(query url.Values) logFilter {
+	// Set default date range (1 year ago to now)
+	now := time.Now()
+	filter := logFilter{
+		Query:     query.Get("query"),
+		StartDate: now.AddDate(-1, 0, 0),
+		EndDate:   now,
+		Sources:   []string{},
+	}
+	if parsed, ok := parseDateParam(query.Get("start_date")); ok {
+		filter.StartDate = parsed
+	}
+	if parsed, ok := parseDateParam(query.Get("end_date")); ok {
+		filter.EndDate = parsed
+	}
 
-			// Verify that all logs with timestamps are counted
-			logsWithTimestamps := len(allLogs) - logsWithoutTimestamp
-			if bucketCounts != logsWithTimestamps {
-				// Production code should not have console logs
-				// Distribution count mismatch is handled gracefully anyway
+	// Source filter. Omitting _src means "all sources" for API compatibility.
+	// Supplying _src= means "no sources selected", which the UI uses for
+	// Deselect All.
+	sourceValues, sourceFilterPresent := query["_src"]
+	if sourceFilterPresent {
+		for _, source := range strings.Split(strings.Join(sourceValues, ","), ",") {
+			source = strings.TrimSpace(source)
+			if source != "" {
+				filter.Sources = append(filter.Sources, source)
 			}
 		}
+		filter.NoSources = len(filter.Sources) == 0
 	}
+	return filter
+}
 
-	return logDistributionEntries, uniqueSources
+// parseDateParam accepts any format dateparse understands, or Unix seconds.
+// The full time of day is kept for accurate filtering, not just the date.
+func parseDateParam(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if parsed, err := dateparse.ParseAny(value); err == nil {
+		return parsed, true
+	}
+	if unixTimestamp, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unixTimestamp, 0), true
+	}
+	return time.Time{}, false
 }
 
 // @Summary Clear all logs
@@ -565,159 +471,100 @@ func (h *Services) HandleDeleteByIds(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// sortLogs sorts logs by the given field/order and returns the reordered slice
-// alongside the available column names. The input slice is not modified.
-func sortLogs(logs []map[string]interface{}, sortBy, sortOrder string) ([]map[string]interface{}, []string) {
-	// Create a slice of indices to sort
-	indices := make([]int, len(logs))
-	for i := range indices {
-		indices[i] = i
-	}
-
-	// Extract unique column keys while processing logs
-	columnKeysMap := make(map[string]bool)
-
-	// Precompute comparison values (and the per-row `_seq` tie-breaker)
-	// to avoid repeated work inside the sort comparator.
-	comparisonValues := make([]interface{}, len(logs))
-	seqValues := make([]int64, len(logs))
-	for i, log := range logs {
-		// Extract column keys. `_seq` is internal ordering metadata: keep
-		// it out of the column list (and it's stripped from the response
-		// below) so it never surfaces in the UI.
-		for key := range log {
-			if key == "_seq" {
-				continue
-			}
-			columnKeysMap[key] = true
-		}
-		seqValues[i] = asSeq(log["_seq"])
-
-		val, exists := log[sortBy]
-		if !exists {
-			comparisonValues[i] = nil
-			continue
-		}
-
-		// Attempt to parse as timestamp first (most common case)
-		if ts, ok := val.(time.Time); ok {
-			comparisonValues[i] = ts
-			continue
-		}
+const (
+	// deleteQueryDefaultLimit and deleteQueryMaxLimit bound how many rows
+	// one delete-by-query request removes, keeping it inside the request
+	// timeout. Larger purges repeat the request while it reports truncated.
+	deleteQueryDefaultLimit = 100_000
+	deleteQueryMaxLimit     = 1_000_000
+)
 
-		// Attempt to parse as float
-		// Check if val interface is a number
-		if num, ok := val.(float64); ok {
-			comparisonValues[i] = num
-			continue
-		}
+// @Summary Delete logs matching a query
+// @Description Deletes the logs selected by the same query, _src, start_date and end_date parameters as GET /logs, visiting only the shards that overlap the time range. Sources match exactly. At most limit rows are deleted per request; when truncated is true, repeat the request to continue. Rows deleted before a timeout or cancellation stay deleted. Shards left empty are dropped. With dry_run=true the matching rows are only counted.
+// @Tags logs
+// @Produce json
+// @Param query query string false "Search query selecting the logs to delete"
+// @Param _src query string false "Comma-separated source filter"
+// @Param start_date query string false "Start of the time range (default: one year ago)"
+// @Param end_date query string false "End of the time range (default: now)"
+// @Param dry_run query boolean false "Only count the matching logs"
+// @Param limit query integer false "Maximum rows to delete in this request (default: 100000, max: 1000000)"
+// @Success 200 {object} types.DeleteByQueryResponse
+// @Failure 400 {object} types.ErrorResponse "Bad request due to invalid parameters"
+// @Failure 500 {object} types.ErrorResponse "Internal server error"
+// @Failure 504 {object} types.ErrorResponse "Stopped by the request timeout"
+// @Router /logs/query [delete]
+func (h *Services) HandleDeleteByQuery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 
-		// Fallback to string
-		comparisonValues[i] = val
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
 	}
 
-	// Use a custom sorting algorithm with precomputed values. When the
-	// primary key ties, fall back to the monotonic ingest sequence so
-	// equal-timestamp (or sub-second-less) logs keep their original file
-	// order deterministically instead of sort.Slice's unstable result.
-	sort.Slice(indices, func(i, j int) bool {
-		oi, oj := indices[i], indices[j]
-		if c := comparePrimary(comparisonValues[oi], comparisonValues[oj], sortOrder); c != 0 {
-			return c < 0
+	query := r.URL.Query()
+	startTime := time.Now()
+
+	limit := deleteQueryDefaultLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err != nil || parsedLimit <= 0 || parsedLimit > deleteQueryMaxLimit {
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid limit parameter",
+				fmt.Sprintf("Limit must be a positive integer no greater than %d", deleteQueryMaxLimit))
+			return
 		}
-		// Tie-break in the same direction as the primary sort so that
-		// reversing sort_order reverses the whole list cleanly.
-		if sortOrder == "asc" {
-			return seqValues[oi] < seqValues[oj]
+		limit = parsedLimit
+	}
+	dryRun := false
+	if dryRunStr := query.Get("dry_run"); dryRunStr != "" {
+		parsed, err := strconv.ParseBool(dryRunStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid dry_run parameter", "dry_run must be true or false")
+			return
 		}
-		return seqValues[oi] > seqValues[oj]
-	})
-
-	// Build the sorted result — returned directly to avoid a copy back into logs.
-	sortedLogs := make([]map[string]interface{}, len(logs))
-	for i, idx := range indices {
-		l := logs[idx]
-		delete(l, "_seq") // internal ordering key — not part of the response
-		sortedLogs[i] = l
+		dryRun = parsed
 	}
 
-	// Convert column keys map to sorted slice
-	availableColumns := make([]string, 0, len(columnKeysMap))
-	for key := range columnKeysMap {
-		availableColumns = append(availableColumns, key)
+	filter := parseLogFilter(query)
+	response := types.DeleteByQueryResponse{
+		Status:        "success",
+		DryRun:        dryRun,
+		Limit:         limit,
+		ShardsRemoved: []string{},
+		Query:         filter.Query,
+		StartDate:     filter.StartDate.Format(time.RFC3339),
+		EndDate:       filter.EndDate.Format(time.RFC3339),
 	}
-
-	// Sort column names for consistent ordering
-	sort.Strings(availableColumns)
-
-	return sortedLogs, availableColumns
-}
-
-// comparePrimary orders two precomputed sort values, returning -1 when a
-// sorts before b, 1 when after, and 0 when equal (so the caller can apply
-// a tie-breaker). nil always sorts last regardless of direction; the
-// type-specific comparison is computed ascending and flipped for desc.
-func comparePrimary(a, b interface{}, sortOrder string) int {
-	switch {
-	case a == nil && b == nil:
-		return 0
-	case a == nil:
-		return 1
-	case b == nil:
-		return -1
-	}
-
-	nat := 0
-	switch av := a.(type) {
-	case time.Time:
-		if bv, ok := b.(time.Time); ok {
-			switch {
-			case av.Before(bv):
-				nat = -1
-			case av.After(bv):
-				nat = 1
-			}
-		} else {
-			nat = strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
-		}
-	case float64:
-		if bv, ok := b.(float64); ok {
-			switch {
-			case av < bv:
-				nat = -1
-			case av > bv:
-				nat = 1
-			}
-		} else {
-			nat = strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
-		}
-	case string:
-		if bv, ok := b.(string); ok {
-			nat = strings.Compare(av, bv)
-		} else {
-			nat = strings.Compare(av, fmt.Sprintf("%v", b))
-		}
-	default:
-		nat = strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+	if filter.NoSources {
+		response.TimeTaken = int(time.Since(startTime).Microseconds())
+		json.NewEncoder(w).Encode(response)
+		return
 	}
 
-	if sortOrder != "asc" {
-		nat = -nat
+	result, err := h.storage.DeleteByQuery(r.Context(), storagepkg.DeleteQueryOptions{
+		Query:     filter.Query,
+		StartDate: filter.StartDate,
+		EndDate:   filter.EndDate,
+		Sources:   filter.Sources,
+		MaxDocs:   limit,
+		DryRun:    dryRun,
+	})
+	if result.Deleted > 0 {
+		// Invalidate the system info cache since log data has changed
+		h.InvalidateInfoCache()
+	}
+	if err != nil {
+		// Deletes are not rolled back, so the error reports how many rows
+		// went before it.
+		writeQueryError(w, fmt.Errorf("%d log(s) deleted before stopping: %w", result.Deleted, err), "Delete by query", "Failed to delete logs")
+		return
 	}
-	return nat
-}
 
-// asSeq coerces a stored `_seq` value to int64. Bleve round-trips numeric
-// stored fields as float64, so accept both; missing/foreign values sort as
-// 0 (only relevant for legacy documents written before `_seq` existed).
-func asSeq(v interface{}) int64 {
-	switch n := v.(type) {
-	case int64:
-		return n
-	case float64:
-		return int64(n)
-	case int:
-		return int64(n)
-	}
-	return 0
+	response.Matched = result.Matched
+	response.Deleted = result.Deleted
+	response.Truncated = result.Truncated
+	response.ShardsScanned = result.ShardsScanned
+	response.ShardsRemoved = append(response.ShardsRemoved, result.ShardsRemoved...)
+	response.TimeTaken = int(time.Since(startTime).Microseconds())
+	json.NewEncoder(w).Encode(response)
 }
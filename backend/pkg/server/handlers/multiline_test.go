@@ -264,6 +264,57 @@ func TestIngestMultiline_RecordSplitAcrossChunks(t *testing.T) {
 	}
 }
 
+// The trailing record flushed by /ingest/end is stored like any other chunk,
+// so its counts, duplicates included, are reported rather than dropped.
+func TestIngestEnd_ReportsFlushedRecordDuplicates(t *testing.T) {
+	h, store := setupHandler(t)
+	store.storeDuplicates = 1
+
+	startBody, _ := json.Marshal(types.IngestSessionOptions{
+		Name:    "multiline-test",
+		Pattern: "%{GREEDYDATA:message}",
+		Source:  "app.log",
+		Multiline: &types.MultilineConfig{
+			Enabled:       true,
+			Mode:          "header",
+			HeaderPattern: `^\d{4}-\d{2}-\d{2}`,
+		},
+		Deduplicate: true,
+	})
+	startW := httptest.NewRecorder()
+	h.HandleIngestStart(startW, httptest.NewRequest(http.MethodPost, "/api/v1/ingest/start", bytes.NewReader(startBody)))
+	var startResp types.IngestResponse
+	if err := json.NewDecoder(startW.Body).Decode(&startResp); err != nil {
+		t.Fatalf("decode start response: %v", err)
+	}
+	sessionID := startResp.SessionID
+	t.Cleanup(func() {
+		sessionMapMutex.Lock()
+		delete(sessionMap, sessionID)
+		sessionMapMutex.Unlock()
+	})
+
+	chunk, _ := json.Marshal(types.IngestRequest{
+		SessionID: sessionID,
+		Logs:      []string{"2024-01-01 12:00:00 ERROR something broke", "  at com.example.Foo.bar(Foo.java:42)"},
+	})
+	h.HandleIngest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/ingest", bytes.NewReader(chunk)))
+
+	endBody, _ := json.Marshal(types.IngestRequest{SessionID: sessionID})
+	endW := httptest.NewRecorder()
+	h.HandleIngestEnd(endW, httptest.NewRequest(http.MethodPost, "/api/v1/ingest/end", bytes.NewReader(endBody)))
+	var endResp types.IngestResponse
+	if err := json.NewDecoder(endW.Body).Decode(&endResp); err != nil {
+		t.Fatalf("decode end response: %v", err)
+	}
+	if endResp.Processed != 1 || endResp.Duplicates != 1 {
+		t.Fatalf("expected the flushed record reported as a duplicate, got %+v", endResp)
+	}
+	if len(store.storeOptions) != 1 || !store.storeOptions[0].SkipDuplicates {
+		t.Fatalf("flushed record must be stored with the session's options: %+v", store.storeOptions)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Live tail multiline: a record spanning two processLines batches must
 // still be published as a single row.
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	storagepkg "logsonic/pkg/storage"
+	"logsonic/pkg/types"
+)
+
+// @Summary Cluster matching logs into patterns
+// @Description Groups the values of a text field among the logs selected by the same query, _src, start_date and end_date parameters as GET /logs into templates, Drain-style: lines of the same shape share a template, and the tokens they differ in become <*> slots. Tokens holding a digit always count as variable. Each template comes with its row count, first and last timestamps, up to five sample IDs and a query that, with the same time range and sources, drills into its rows; a template with no searchable constant text, such as one made only of <*> slots, has no query. Templates are sorted by count; at most 1,000,000 rows are clustered, and truncated reports when more matched.
+// @Tags logs
+// @Produce json
+// @Param field query string false "Text field to cluster: _raw or message (default: _raw)"
+// @Param limit query int false "Maximum number of templates to return (default: 50, max: 1000)"
+// @Param query query string false "Optional search query to filter logs"
+// @Param _src query string false "Optional comma-separated source filter"
+// @Param start_date query string false "Start of the time range (default: one year ago)"
+// @Param end_date query string false "End of the time range (default: now)"
+// @Success 200 {object} types.PatternsResponse
+// @Failure 400 {object} types.ErrorResponse "Bad request due to invalid parameters"
+// @Failure 500 {object} types.ErrorResponse "Internal server error"
+// @Failure 504 {object} types.ErrorResponse "Stopped by the request timeout"
+// @Router /logs/patterns [get]
+func (h *Services) HandlePatterns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
+	}
+
+	query := r.URL.Query()
+	startTime := time.Now()
+	field := query.Get("field")
+	if field == "" {
+		field = "_raw"
+	}
+	if field != "_raw" && field != "message" {
+		writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid field parameter", "Field must be _raw or message")
+		return
+	}
+	limit := storagepkg.DefaultPatternLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 || parsed > storagepkg.MaxPatternLimit {
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid limit parameter",
+				fmt.Sprintf("Limit must be a positive integer no greater than %d", storagepkg.MaxPatternLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	filter := parseLogFilter(query)
+	response := types.PatternsResponse{
+		Status:    "success",
+		Query:     filter.Query,
+		Field:     field,
+		Patterns:  []types.Pattern{},
+		StartDate: filter.StartDate.Format(time.RFC3339),
+		EndDate:   filter.EndDate.Format(time.RFC3339),
+	}
+	if filter.NoSources {
+		response.TimeTaken = int(time.Since(startTime).Microseconds())
+		_ = json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	result, err := h.storage.Patterns(r.Context(), storagepkg.PatternOptions{
+		Query:     filter.Query,
+		StartDate: filter.StartDate,
+		EndDate:   filter.EndDate,
+		Sources:   filter.Sources,
+		Field:     field,
+		Limit:     limit,
+	})
+	if err != nil {
+		writeQueryError(w, err, "Pattern clustering", "Failed to cluster logs")
+		return
+	}
+
+	for _, pattern := range result.Patterns {
+		response.Patterns = append(response.Patterns, types.Pattern{
+			Template:  pattern.Template,
+			Query:     pattern.Query,
+			Count:     pattern.Count,
+			FirstSeen: pattern.FirstSeen.Format(time.RFC3339Nano),
+			LastSeen:  pattern.LastSeen.Format(time.RFC3339Nano),
+			SampleIDs: pattern.SampleIDs,
+		})
+	}
+	response.Matched = result.Matched
+	response.Missing = result.Missing
+	response.Unclustered = result.Unclustered
+	response.Clusters = result.Clusters
+	response.Truncated = result.Truncated
+	response.TimeTaken = int(time.Since(startTime).Microseconds())
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	storagepkg "logsonic/pkg/storage"
+	"logsonic/pkg/types"
+)
+
+// @Summary Complete a query as it is typed
+// @Description Completes the query term ending at cursor_pos: field names before a colon, with the kinds each is indexed as; values of the field after it, read from the index's term dictionaries and ranked by how many logs hold them; and operators such as +, -, phrase quotes and numeric ranges where they fit. Each suggestion's text replaces the characters of prefix from replace_from to replace_to. Field names come from the cached descriptions of the days between start_date and end_date, and values from the newest 7 of those days. Neither runs a search or opens older days, so it is cheap enough to call on each keystroke.
+// @Tags query
+// @Produce json
+// @Param prefix query string false "Query typed so far"
+// @Param cursor_pos query int false "Cursor position in prefix, in characters (default: end of prefix)"
+// @Param limit query int false "Maximum number of suggestions to return (default: 20, max: 100)"
+// @Param start_date query string false "Start of the time range (default: one year ago)"
+// @Param end_date query string false "End of the time range (default: now)"
+// @Success 200 {object} types.QuerySuggestResponse
+// @Failure 400 {object} types.ErrorResponse "Bad request due to invalid parameters"
+// @Failure 500 {object} types.ErrorResponse "Internal server error"
+// @Failure 504 {object} types.ErrorResponse "Stopped by the request timeout"
+// @Router /query/suggest [get]
+func (h *Services) HandleQuerySuggest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
+	}
+
+	query := r.URL.Query()
+	startTime := time.Now()
+	prefix := query.Get("prefix")
+	length := utf8.RuneCountInString(prefix)
+	cursor := length
+	if cursorStr := query.Get("cursor_pos"); cursorStr != "" {
+		parsed, err := strconv.Atoi(cursorStr)
+		if err != nil || parsed < 0 || parsed > length {
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid cursor_pos parameter",
+				fmt.Sprintf("Cursor position must be an integer between 0 and %d, the length of prefix in characters", length))
+			return
+		}
+		cursor = parsed
+	}
+	limit := storagepkg.DefaultSuggestLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 || parsed > storagepkg.MaxSuggestLimit {
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid limit parameter",
+				fmt.Sprintf("Limit must be a positive integer no greater than %d", storagepkg.MaxSuggestLimit))
+			return
+		}
+		limit = parsed
+	}
+	filter := parseLogFilter(query)
+
+	result, err := h.storage.Suggest(r.Context(), storagepkg.SuggestOptions{
+		Text:      prefix,
+		Cursor:    cursor,
+		StartDate: filter.StartDate,
+		EndDate:   filter.EndDate,
+		Limit:     limit,
+	})
+	if err != nil {
+		writeQueryError(w, err, "Query suggestion", "Failed to suggest completions")
+		return
+	}
+
+	response := types.QuerySuggestResponse{
+		Status:      "success",
+		Prefix:      prefix,
+		CursorPos:   cursor,
+		Suggestions: make([]types.QuerySuggestion, 0, len(result.Suggestions)),
+		ReplaceFrom: result.ReplaceFrom,
+		ReplaceTo:   result.ReplaceTo,
+		Field:       result.Field,
+	}
+	for _, suggestion := range result.Suggestions {
+		response.Suggestions = append(response.Suggestions, types.QuerySuggestion{
+			Text:   suggestion.Text,
+			Kind:   suggestion.Kind,
+			Detail: suggestion.Detail,
+			Count:  suggestion.Count,
+		})
+	}
+	response.TimeTaken = int(time.Since(startTime).Microseconds())
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"logsonic/pkg/types"
+)
+
+const (
+	// maxRecentEvictions bounds the eviction history reported by /info.
+	maxRecentEvictions = 50
+
+	// quotaCheckBytes is how much ingested input triggers an early quota
+	// check between daily sweeps. Small quotas are checked proportionally
+	// sooner so a single large import cannot overshoot them by much.
+	quotaCheckBytes = 64 << 20
+)
+
+// storageQuota tracks size-quota enforcement for /info and decides when an
+// ingest is large enough to warrant an early check.
+type storageQuota struct {
+	run      sync.Mutex // serializes enforcement runs
+	closed   bool       // set under run once storage is shutting down
+	ingested atomic.Int64
+
+	mu   sync.Mutex
+	info types.StorageQuotaInfo
+}
+
+// EnforceStorageQuota evicts the oldest shards until the storage directory
+// fits MaxStorageBytes and records what was removed for /info. It is a no-op
+// when no quota is configured.
+func (h *Services) EnforceStorageQuota() {
+	if h.MaxStorageBytes <= 0 {
+		return
+	}
+	h.quota.run.Lock()
+	defer h.quota.run.Unlock()
+	h.enforceStorageQuotaLocked()
+}
+
+// enforceStorageQuotaLocked runs one quota check; h.quota.run must be held.
+func (h *Services) enforceStorageQuotaLocked() {
+	if h.quota.closed {
+		return
+	}
+	h.quota.ingested.Store(0)
+
+	result, err := h.storage.EnforceSizeQuota(h.MaxStorageBytes)
+	now := time.Now().UTC()
+
+	h.quota.mu.Lock()
+	info := &h.quota.info
+	info.LastCheckedAt = &now
+	info.LastError = ""
+	if err != nil {
+		info.LastError = err.Error()
+	}
+	if result.BytesAfter > 0 {
+		info.UsedBytes = result.BytesAfter
+	}
+	evicted := make([]types.ShardEviction, 0, len(result.Evicted))
+	for _, e := range result.Evicted {
+		evicted = append(evicted, types.ShardEviction{Shard: e.Shard, Bytes: e.Bytes, Docs: e.Docs, EvictedAt: now})
+		info.EvictedShards++
+		info.EvictedBytes += e.Bytes
+	}
+	// Newest first: this run's evictions (reversed, as they come oldest
+	// first) ahead of earlier ones.
+	for i, j := 0, len(evicted)-1; i < j; i, j = i+1, j-1 {
+		evicted[i], evicted[j] = evicted[j], evicted[i]
+	}
+	info.RecentEvictions = append(evicted, info.RecentEvictions...)
+	if len(info.RecentEvictions) > maxRecentEvictions {
+		info.RecentEvictions = info.RecentEvictions[:maxRecentEvictions]
+	}
+	h.quota.mu.Unlock()
+
+	if err != nil {
+		log.Printf("quota: enforcement failed: %v", err)
+	}
+	if len(result.Evicted) > 0 {
+		log.Printf("quota: evicted %d shard(s) to fit %d bytes (now %d bytes)",
+			len(result.Evicted), h.MaxStorageBytes, result.BytesAfter)
+		h.InvalidateInfoCache()
+	}
+}
+
+// noteIngested records n bytes of accepted input and starts a background
+// quota check once enough has accumulated since the last one.
+func (h *Services) noteIngested(n int64) {
+	if h.MaxStorageBytes <= 0 || n <= 0 {
+		return
+	}
+	threshold := int64(quotaCheckBytes)
+	if tenth := h.MaxStorageBytes / 10; tenth > 0 && tenth < threshold {
+		threshold = tenth
+	}
+	if h.quota.ingested.Add(n) < threshold {
+		return
+	}
+	h.quota.ingested.Store(0)
+	go func() {
+		// A check already in progress covers this ingest too.
+		if !h.quota.run.TryLock() {
+			return
+		}
+		defer h.quota.run.Unlock()
+		h.enforceStorageQuotaLocked()
+	}()
+}
+
+// stopStorageQuota waits for a running check and disables later ones.
+func (h *Services) stopStorageQuota() {
+	h.quota.run.Lock()
+	h.quota.closed = true
+	h.quota.run.Unlock()
+}
+
+// ingestedBytes is the accepted input size of an ingest batch.
+func ingestedBytes(lines []string) int64 {
+	var n int64
+	for _, line := range lines {
+		n += int64(len(line))
+	}
+	return n
+}
+
+// storageQuotaInfo returns the quota report for /info, or nil when no quota
+// is configured.
+func (h *Services) storageQuotaInfo() *types.StorageQuotaInfo {
+	if h.MaxStorageBytes <= 0 {
+		return nil
+	}
+	h.quota.mu.Lock()
+	defer h.quota.mu.Unlock()
+	info := h.quota.info
+	info.MaxBytes = h.MaxStorageBytes
+	info.RecentEvictions = append([]types.ShardEviction{}, h.quota.info.RecentEvictions...)
+	return &info
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"logsonic/pkg/retention"
+	"logsonic/pkg/types"
+)
+
+// sourceRetentionInterval is how often per-source rules are applied between
+// rule changes. Rules are as short as hours, so daily is too coarse.
+const sourceRetentionInterval = time.Hour
+
+// sourceRetention runs the per-source retention sweep and remembers the last
+// result for the API.
+type sourceRetention struct {
+	kick chan struct{} // buffered; a rule change requests an early sweep
+
+	run    sync.Mutex // held for the duration of a sweep
+	closed bool       // set under run once storage is shutting down
+
+	mu   sync.Mutex
+	last *types.RetentionRunInfo
+}
+
+// @Summary Get per-source retention rules
+// @Description Lists the retention rules keyed by source (_src) and the result of the latest sweep.
+// @Tags retention
+// @Produce json
+// @Success 200 {object} types.RetentionResponse
+// @Failure 500 {object} types.ErrorResponse
+// @Router /retention [get]
+func (h *Services) HandleGetRetention(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
+	}
+	rules, err := h.Retention.Rules()
+	if err != nil {
+		writeRetentionStoreError(w, err)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(types.RetentionResponse{Status: "success", Rules: rules, LastRun: h.lastSourceRetention()})
+}
+
+// @Summary Replace per-source retention rules
+// @Description Replaces every retention rule. Each rule deletes rows of one source older than max_age (e.g. "3d", "12h", "2w"); shards left empty are dropped. Sources without a rule are kept until age or size retention removes their shard. A sweep starts right away and then runs hourly.
+// @Tags retention
+// @Accept json
+// @Produce json
+// @Param request body types.RetentionRequest true "Retention rules"
+// @Success 200 {object} types.RetentionResponse
+// @Failure 400 {object} types.ErrorResponse
+// @Failure 500 {object} types.ErrorResponse
+// @Router /retention [put]
+func (h *Services) HandlePutRetention(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
+	}
+	var req types.RetentionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body", err.Error())
+		return
+	}
+	rules, err := h.Retention.Replace(req.Rules)
+	if err != nil {
+		writeRetentionStoreError(w, err)
+		return
+	}
+	select {
+	case h.retention.kick <- struct{}{}:
+	default: // a sweep is already queued
+	}
+	_ = json.NewEncoder(w).Encode(types.RetentionResponse{Status: "success", Rules: rules, LastRun: h.lastSourceRetention()})
+}
+
+// StartSourceRetention applies the per-source rules now, hourly, and after
+// every rule change until ctx is cancelled.
+func (h *Services) StartSourceRetention(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(sourceRetentionInterval)
+		defer ticker.Stop()
+		for {
+			h.ApplySourceRetention(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-h.retention.kick:
+			}
+		}
+	}()
+}
+
+// ApplySourceRetention runs one sweep: for every rule, rows of that source
+// older than its max age are deleted and emptied shards dropped.
+func (h *Services) ApplySourceRetention(ctx context.Context) {
+	h.retention.run.Lock()
+	defer h.retention.run.Unlock()
+	if h.retention.closed {
+		return
+	}
+
+	rules, err := h.Retention.Rules()
+	if err != nil || len(rules) == 0 {
+		if err != nil {
+			log.Printf("retention: failed to load rules: %v", err)
+		}
+		return
+	}
+
+	run := types.RetentionRunInfo{StartedAt: time.Now().UTC(), ShardsRemoved: []string{}}
+	for _, rule := range rules {
+		maxAge, err := retention.ParseMaxAge(rule.MaxAge)
+		if err != nil {
+			continue // rejected on save; only a hand-edited file gets here
+		}
+		result, err := h.storage.DeleteSourceBefore(ctx, rule.Source, run.StartedAt.Add(-maxAge))
+		run.Deleted += result.Deleted
+		run.ShardsRemoved = append(run.ShardsRemoved, result.ShardsRemoved...)
+		if err != nil {
+			run.Error = err.Error()
+			log.Printf("retention: purge of %s failed: %v", rule.Source, err)
+			break
+		}
+		if result.Deleted > 0 {
+			log.Printf("retention: deleted %d row(s) of %s older than %s", result.Deleted, rule.Source, rule.MaxAge)
+		}
+	}
+	run.FinishedAt = time.Now().UTC()
+
+	h.retention.mu.Lock()
+	h.retention.last = &run
+	h.retention.mu.Unlock()
+	if run.Deleted > 0 {
+		h.InvalidateInfoCache()
+	}
+}
+
+// stopSourceRetention waits for a running sweep and disables later ones.
+func (h *Services) stopSourceRetention() {
+	h.retention.run.Lock()
+	h.retention.closed = true
+	h.retention.run.Unlock()
+}
+
+func (h *Services) lastSourceRetention() *types.RetentionRunInfo {
+	h.retention.mu.Lock()
+	defer h.retention.mu.Unlock()
+	if h.retention.last == nil {
+		return nil
+	}
+	last := *h.retention.last
+	return &last
+}
+
+func writeRetentionStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, retention.ErrValidation):
+		writeError(w, http.StatusBadRequest, "INVALID_RETENTION_RULE", "Invalid retention rule", err.Error())
+	case errors.Is(err, retention.ErrCorrupt):
+		writeError(w, http.StatusInternalServerError, "RETENTION_CORRUPT", "Saved retention rules file is corrupt", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "RETENTION_STORE_ERROR", "Retention store error", err.Error())
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"logsonic/pkg/schema"
+	"logsonic/pkg/storage"
+	"logsonic/pkg/types"
+)
+
+// @Summary Get the field type schema
+// @Description Lists the field type rules keyed by source (_src) or grok pattern name, and the supported types.
+// @Tags schema
+// @Produce json
+// @Success 200 {object} types.FieldSchemaResponse
+// @Failure 500 {object} types.ErrorResponse
+// @Router /schema [get]
+func (h *Services) HandleGetSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
+	}
+	rules, err := h.Schemas.Rules()
+	if err != nil {
+		writeSchemaStoreError(w, err)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(schemaResponse(rules))
+}
+
+// @Summary Replace the field type schema
+// @Description Replaces every field type rule. Each rule names a source or a grok pattern and declares fields as keyword, text, long, double, bool, ip or date, optionally stored-only. Rows ingested afterwards index declared fields as their type instead of coercing numeric-looking strings; values that do not parse are kept stored-only and reported under storage_info.field_type_conflicts on /info. Rows already stored keep their types.
+// @Tags schema
+// @Accept json
+// @Produce json
+// @Param request body types.FieldSchemaRequest true "Field type rules"
+// @Success 200 {object} types.FieldSchemaResponse
+// @Failure 400 {object} types.ErrorResponse
+// @Failure 500 {object} types.ErrorResponse
+// @Router /schema [put]
+func (h *Services) HandlePutSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
+	}
+	var req types.FieldSchemaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body", err.Error())
+		return
+	}
+	rules, err := h.Schemas.Replace(req.Rules)
+	if err != nil {
+		writeSchemaStoreError(w, err)
+		return
+	}
+	// Declared types are part of the conflict report on /info.
+	h.InvalidateInfoCache()
+	_ = json.NewEncoder(w).Encode(schemaResponse(rules))
+}
+
+func schemaResponse(rules []types.FieldSchemaRule) types.FieldSchemaResponse {
+	response := types.FieldSchemaResponse{Status: "success", Rules: rules, Types: make([]string, 0, len(storage.FieldTypes))}
+	for _, fieldType := range storage.FieldTypes {
+		response.Types = append(response.Types, string(fieldType))
+	}
+	return response
+}
+
+// fieldTypeConflicts reports inconsistently indexed fields for /info, with the
+// types the schema declares for each.
+func (h *Services) fieldTypeConflicts() ([]types.FieldTypeConflict, int, error) {
+	report, err := h.storage.FieldTypeConflicts()
+	if err != nil {
+		return nil, 0, err
+	}
+	declared := h.Schemas.Declared()
+	conflicts := make([]types.FieldTypeConflict, 0, len(report.Conflicts))
+	for _, conflict := range report.Conflicts {
+		conflicts = append(conflicts, types.FieldTypeConflict{
+			Field:    conflict.Field,
+			Kinds:    conflict.Kinds,
+			Rejected: conflict.Rejected,
+			Declared: declared[conflict.Field],
+		})
+	}
+	return conflicts, report.UncheckedShards, nil
+}
+
+func writeSchemaStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, schema.ErrValidation):
+		writeError(w, http.StatusBadRequest, "INVALID_SCHEMA_RULE", "Invalid field schema rule", err.Error())
+	case errors.Is(err, schema.ErrCorrupt):
+		writeError(w, http.StatusInternalServerError, "SCHEMA_CORRUPT", "Saved field schema file is corrupt", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "SCHEMA_STORE_ERROR", "Field schema store error", err.Error())
+	}
+}
+
+// storeOptions returns how a session's rows are stored: its source and
+// pattern's declared field types, and whether duplicates are skipped.
+func storeOptions(schemas *schema.Store, options types.IngestSessionOptions) storage.StoreOptions {
+	return storage.StoreOptions{
+		Schema:         schemas.Resolve(options.Source, options.Name),
+		SkipDuplicates: options.Deduplicate,
+	}
+}
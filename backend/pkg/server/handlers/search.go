@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	storagepkg "logsonic/pkg/storage"
+	"logsonic/pkg/types"
+)
+
+// @Summary Run a piped stats query
+// @Description Filters logs with a Bleve query string, then aggregates them with piped commands, e.g. `level:ERROR | stats count, p95(latency) by service, span=5m | sort -count | head 20`. stats must come first and takes count, count(f), sum(f), avg(f), min(f), max(f), median(f) and p1(f)-p100(f), each optionally renamed with as, grouped by fields and, with span=, by time bucket. sort orders by columns (prefix - for descending) and head keeps the first rows (default 10). Rows missing a by field are left out. Percentiles of groups over 10000 values are estimated from a sample.
+// @Tags search
+// @Produce json
+// @Param query query string true "Query string followed by | stats ... and optional | sort ... and | head N"
+// @Param _src query string false "Optional comma-separated source filter"
+// @Param start_date query string false "Start of the time range (default: one year ago)"
+// @Param end_date query string false "End of the time range (default: now)"
+// @Success 200 {object} types.SearchResponse
+// @Failure 400 {object} types.ErrorResponse "The pipeline does not parse or makes too many groups"
+// @Failure 500 {object} types.ErrorResponse "Internal server error"
+// @Failure 504 {object} types.ErrorResponse "Stopped by the request timeout"
+// @Router /search [get]
+func (h *Services) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
+	}
+
+	startTime := time.Now()
+	filter := parseLogFilter(r.URL.Query())
+	if filter.Query == "" {
+		writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Missing query parameter",
+			"query holds a query string and a stats command, e.g. level:ERROR | stats count by service")
+		return
+	}
+	response := types.SearchResponse{
+		Status:    "success",
+		Query:     filter.Query,
+		Columns:   []string{},
+		Rows:      [][]interface{}{},
+		Chart:     types.SearchChart{X: []interface{}{}, Series: []types.SearchSeries{}},
+		StartDate: filter.StartDate.Format(time.RFC3339),
+		EndDate:   filter.EndDate.Format(time.RFC3339),
+	}
+	if filter.NoSources {
+		response.TimeTaken = int(time.Since(startTime).Microseconds())
+		_ = json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	result, err := h.storage.Stats(r.Context(), storagepkg.StatsOptions{
+		Pipeline:  filter.Query,
+		StartDate: filter.StartDate,
+		EndDate:   filter.EndDate,
+		Sources:   filter.Sources,
+	})
+	if err != nil {
+		writeQueryError(w, err, "Search", "Failed to run search")
+		return
+	}
+
+	response.Columns = append(response.Columns, result.Columns...)
+	response.Rows = append(response.Rows, result.Rows...)
+	response.Chart.X = append(response.Chart.X, result.Chart.X...)
+	for _, series := range result.Chart.Series {
+		response.Chart.Series = append(response.Chart.Series, types.SearchSeries{Name: series.Name, Values: series.Values})
+	}
+	response.Matched = result.Matched
+	response.TimeTaken = int(time.Since(startTime).Microseconds())
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	storagepkg "logsonic/pkg/storage"
+	"logsonic/pkg/types"
+)
+
+// @Summary Group matching logs into transactions
+// @Description Groups the logs selected by the same query, _src, start_date and end_date parameters as GET /logs by the values of one or more correlation fields, such as trace_id, across sources and days. Rows missing a correlation field are left out. A group is split into several transactions where a row is more than max_pause after the previous one, or more than max_span after the transaction's first row. Each transaction reports its start, end, duration in microseconds, row count, sources, the first 20 row IDs, a query finding its rows and an error flag set when any row matches error_query. At most 1,000,000 rows are grouped; truncated reports when more matched.
+// @Tags logs
+// @Produce json
+// @Param fields query string true "Comma-separated correlation fields; rows sharing all their values are grouped"
+// @Param max_span query string false "Longest transaction, as a Go duration such as 5m (default: unbounded)"
+// @Param max_pause query string false "Longest gap between rows of one transaction, such as 30s (default: unbounded)"
+// @Param error_query query string false "Query marking failed rows (default: level is error, err, fatal, critical, crit, panic, alert or emerg)"
+// @Param sort_by query string false "start or duration (default: start)"
+// @Param sort_order query string false "asc or desc (default: desc)"
+// @Param limit query int false "Maximum number of transactions to return (default: 50, max: 1000)"
+// @Param offset query int false "Transactions to skip (default: 0)"
+// @Param query query string false "Optional search query to filter logs"
+// @Param _src query string false "Optional comma-separated source filter"
+// @Param start_date query string false "Start of the time range (default: one year ago)"
+// @Param end_date query string false "End of the time range (default: now)"
+// @Success 200 {object} types.TransactionsResponse
+// @Failure 400 {object} types.ErrorResponse "Bad request due to invalid parameters"
+// @Failure 500 {object} types.ErrorResponse "Internal server error"
+// @Failure 504 {object} types.ErrorResponse "Stopped by the request timeout"
+// @Router /logs/transactions [get]
+func (h *Services) HandleTransactions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		return
+	}
+
+	query := r.URL.Query()
+	startTime := time.Now()
+	var fields []string
+	for _, field := range strings.Split(query.Get("fields"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Missing fields parameter", "fields names the correlation fields to group by, such as trace_id")
+		return
+	}
+	var maxSpan, maxPause time.Duration
+	for name, target := range map[string]*time.Duration{"max_span": &maxSpan, "max_pause": &maxPause} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", fmt.Sprintf("Invalid %s parameter", name),
+				fmt.Sprintf("%s must be a positive duration such as 30s or 5m", name))
+			return
+		}
+		*target = parsed
+	}
+	sortBy := query.Get("sort_by")
+	if sortBy == "" {
+		sortBy = "start"
+	}
+	if sortBy != "start" && sortBy != "duration" {
+		writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid sort_by parameter", "Sort by must be 'start' or 'duration'")
+		return
+	}
+	sortOrder := query.Get("sort_order")
+	if sortOrder == "" {
+		sortOrder = "desc"
+	}
+	if sortOrder != "asc" && sortOrder != "desc" {
+		writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid sort_order parameter", "Sort order must be 'asc' or 'desc'")
+		return
+	}
+	limit := storagepkg.DefaultTransactionLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 || parsed > storagepkg.MaxTransactionLimit {
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid limit parameter",
+				fmt.Sprintf("Limit must be a positive integer no greater than %d", storagepkg.MaxTransactionLimit))
+			return
+		}
+		limit = parsed
+	}
+	offset := 0
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		parsed, err := strconv.Atoi(offsetStr)
+		if err != nil || parsed < 0 {
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid offset parameter", "Offset must be a non-negative integer")
+			return
+		}
+		offset = parsed
+	}
+
+	filter := parseLogFilter(query)
+	response := types.TransactionsResponse{
+		Status:       "success",
+		Query:        filter.Query,
+		Fields:       fields,
+		Transactions: []types.Transaction{},
+		Limit:        limit,
+		Offset:       offset,
+		StartDate:    filter.StartDate.Format(time.RFC3339),
+		EndDate:      filter.EndDate.Format(time.RFC3339),
+	}
+	if filter.NoSources {
+		response.TimeTaken = int(time.Since(startTime).Microseconds())
+		_ = json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	result, err := h.storage.Transactions(r.Context(), storagepkg.TransactionOptions{
+		Query:      filter.Query,
+		StartDate:  filter.StartDate,
+		EndDate:    filter.EndDate,
+		Sources:    filter.Sources,
+		Fields:     fields,
+		MaxSpan:    maxSpan,
+		MaxPause:   maxPause,
+		ErrorQuery: query.Get("error_query"),
+		SortBy:     sortBy,
+		SortOrder:  sortOrder,
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, storagepkg.ErrInvalidTransactions):
+			writeError(w, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid transactions parameter", err.Error())
+		default:
+			writeQueryError(w, err, "Transaction grouping", "Failed to group transactions")
+		}
+		return
+	}
+
+	for _, transaction := range result.Transactions {
+		response.Transactions = append(response.Transactions, types.Transaction{
+			Fields:   transaction.Fields,
+			Start:    transaction.Start.Format(time.RFC3339Nano),
+			End:      transaction.End.Format(time.RFC3339Nano),
+			Duration: transaction.Duration.Microseconds(),
+			Count:    transaction.Count,
+			Error:    transaction.Errors > 0,
+			Errors:   transaction.Errors,
+			Sources:  transaction.Sources,
+			EventIDs: transaction.EventIDs,
+			Query:    transaction.Query,
+		})
+	}
+	response.Total = result.Total
+	response.Grouped = result.Grouped
+	response.Ungrouped = result.Ungrouped
+	response.Truncated = result.Truncated
+	response.TimeTaken = int(time.Since(startTime).Microseconds())
+	_ = json.NewEncoder(w).Encode(response)
+}
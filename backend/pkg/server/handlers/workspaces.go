@@ -20,7 +20,7 @@ import (
 func (h *Services) HandleListWorkspaces(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if r.Method != http.MethodGet {
-		writeWorkspaceError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
 		return
 	}
 	items, err := h.workspaceStore().List()
@@ -44,13 +44,13 @@ func (h *Services) HandleListWorkspaces(w http.ResponseWriter, r *http.Request)
 func (h *Services) HandleCreateWorkspace(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if r.Method != http.MethodPost {
-		writeWorkspaceError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
 		return
 	}
 
 	var req types.Workspace
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeWorkspaceError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body", err.Error())
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body", err.Error())
 		return
 	}
 	ws, err := h.workspaceStore().Create(req)
@@ -73,7 +73,7 @@ func (h *Services) HandleCreateWorkspace(w http.ResponseWriter, r *http.Request)
 func (h *Services) HandleGetWorkspace(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if r.Method != http.MethodGet {
-		writeWorkspaceError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
 		return
 	}
 	ws, err := h.workspaceStore().Get(chi.URLParam(r, "id"))
@@ -98,13 +98,13 @@ func (h *Services) HandleGetWorkspace(w http.ResponseWriter, r *http.Request) {
 func (h *Services) HandleUpdateWorkspace(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if r.Method != http.MethodPut {
-		writeWorkspaceError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
 		return
 	}
 
 	var req types.Workspace
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeWorkspaceError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body", err.Error())
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body", err.Error())
 		return
 	}
 	ws, err := h.workspaceStore().Update(chi.URLParam(r, "id"), req)
@@ -125,7 +125,7 @@ func (h *Services) HandleUpdateWorkspace(w http.ResponseWriter, r *http.Request)
 func (h *Services) HandleDeleteWorkspace(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if r.Method != http.MethodDelete {
-		writeWorkspaceError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
 		return
 	}
 	if err := h.workspaceStore().Delete(chi.URLParam(r, "id")); err != nil {
@@ -146,7 +146,7 @@ func (h *Services) HandleDeleteWorkspace(w http.ResponseWriter, r *http.Request)
 func (h *Services) HandleDuplicateWorkspace(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if r.Method != http.MethodPost {
-		writeWorkspaceError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", "")
 		return
 	}
 	ws, err := h.workspaceStore().Duplicate(chi.URLParam(r, "id"))
@@ -165,24 +165,14 @@ func (h *Services) workspaceStore() *workspaces.Store {
 func writeWorkspaceStoreError(w http.ResponseWriter, err error) {
 	switch {
 	case errors.Is(err, workspaces.ErrNotFound):
-		writeWorkspaceError(w, http.StatusNotFound, "WORKSPACE_NOT_FOUND", "Workspace not found", err.Error())
+		writeError(w, http.StatusNotFound, "WORKSPACE_NOT_FOUND", "Workspace not found", err.Error())
 	case errors.Is(err, workspaces.ErrExists):
-		writeWorkspaceError(w, http.StatusConflict, "WORKSPACE_EXISTS", "Workspace already exists", err.Error())
+		writeError(w, http.StatusConflict, "WORKSPACE_EXISTS", "Workspace already exists", err.Error())
 	case errors.Is(err, workspaces.ErrValidation):
-		writeWorkspaceError(w, http.StatusBadRequest, "INVALID_WORKSPACE", "Invalid workspace", err.Error())
+		writeError(w, http.StatusBadRequest, "INVALID_WORKSPACE", "Invalid workspace", err.Error())
 	case errors.Is(err, workspaces.ErrCorrupt):
-		writeWorkspaceError(w, http.StatusInternalServerError, "WORKSPACES_CORRUPT", "Saved workspaces file is corrupt", err.Error())
+		writeError(w, http.StatusInternalServerError, "WORKSPACES_CORRUPT", "Saved workspaces file is corrupt", err.Error())
 	default:
-		writeWorkspaceError(w, http.StatusInternalServerError, "WORKSPACE_STORE_ERROR", "Workspace store error", err.Error())
+		writeError(w, http.StatusInternalServerError, "WORKSPACE_STORE_ERROR", "Workspace store error", err.Error())
 	}
 }
-
-func writeWorkspaceError(w http.ResponseWriter, status int, code, message, details string) {
-	w.WriteHeader(status)
-	_ = json.NewEncoder(w).Encode(types.ErrorResponse{
-		Status:  "error",
-		Error:   message,
-		Code:    code,
-		Details: details,
-	})
-}
@@ -53,6 +53,23 @@ type Config struct {
 	// RetentionDays deletes indexed logs older than N days on startup and once
 	// a day thereafter. 0 disables retention (keep everything).
 	RetentionDays int
+
+	// ShardGranularity selects how much time each index shard covers: "hour",
+	// "day" or "week". Empty keeps the value persisted in the storage dir.
+	ShardGranularity string
+
+	// MaxStorageBytes evicts the oldest shards until the storage dir fits the
+	// quota, on startup, once a day and after large ingests. 0 disables it.
+	MaxStorageBytes int64
+
+	// MaxOpenShards bounds the idle index shards kept open. Shards are opened
+	// on first use; 0 uses the storage default.
+	MaxOpenShards int
+
+	// RegexpTimeout and RegexpScanLimit bound what one query's raw:~/regex/
+	// terms may spend verifying matches; 0 uses the storage defaults.
+	RegexpTimeout   time.Duration
+	RegexpScanLimit int
 }
 
 type Server struct {
@@ -73,7 +90,12 @@ func NewServer(cfg Config) (*Server, error) {
 	docs.SwaggerInfo.BasePath = "/api/v1"
 	docs.SwaggerInfo.Schemes = []string{"http"}
 
-	store, err := storage.NewStorage(cfg.StoragePath)
+	store, err := storage.NewStorageWithOptions(cfg.StoragePath, storage.Options{
+		ShardGranularity: storage.ShardGranularity(cfg.ShardGranularity),
+		MaxOpenShards:    cfg.MaxOpenShards,
+		RegexpTimeout:    cfg.RegexpTimeout,
+		RegexpScanLimit:  cfg.RegexpScanLimit,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize storage: %w", err)
 	}
@@ -130,6 +152,7 @@ func NewServer(cfg Config) (*Server, error) {
 
 	// Initialize handler
 	h := handlers.NewHandler(store, cfg.StoragePath)
+	h.MaxStorageBytes = cfg.MaxStorageBytes
 	srv := &Server{
 		services: h,
 		store:    store,
@@ -232,6 +255,11 @@ func NewServer(cfg Config) (*Server, error) {
 	// security headers, and CORS from the root router.
 	r.Get("/api/v1/live/events", h.HandleLiveEvents)
 	r.Post("/api/v1/live/stdin", h.HandleLiveStdin)
+	// Snapshot archives grow with the storage directory and can take longer
+	// than the API timeout to stream.
+	r.Post("/api/v1/admin/snapshot", h.HandleSnapshot)
+	// Exports stream every matching row, for as long as that takes.
+	r.Get("/api/v1/logs/export", h.HandleExport)
 
 	// Set up API routes
 	r.Group(func(r chi.Router) {
@@ -257,10 +285,19 @@ func NewServer(cfg Config) (*Server, error) {
 			// Parse endpoints
 			r.Post("/parse", h.HandleParse)
 			r.Post("/timestamp/preview", h.HandleTimestampPreview)
+			r.Get("/search", h.HandleSearch)
+			r.Get("/query/suggest", h.HandleQuerySuggest)
 			r.Route("/logs", func(r chi.Router) {
 				r.Get("/", h.HandleReadAll)
+				r.Get("/facets", h.HandleFacets)
+				r.Get("/explain", h.HandleExplain)
+				r.Get("/patterns", h.HandlePatterns)
+				r.Get("/compare", h.HandleCompare)
+				r.Get("/transactions", h.HandleTransactions)
+				r.Get("/{id}/context", h.HandleLogContext)
 				r.Delete("/", h.HandleClear)
 				r.Delete("/ids", h.HandleDeleteByIds)
+				r.Delete("/query", h.HandleDeleteByQuery)
 			})
 			r.Route("/workspaces", func(r chi.Router) {
 				r.Get("/", h.HandleListWorkspaces)
@@ -271,6 +308,13 @@ func NewServer(cfg Config) (*Server, error) {
 				r.Delete("/{id}", h.HandleDeleteWorkspace)
 			})
 			r.Get("/info", h.HandleInfo)
+			r.Get("/retention", h.HandleGetRetention)
+			r.Put("/retention", h.HandlePutRetention)
+			r.Get("/fields", h.HandleFields)
+			r.Get("/schema", h.HandleGetSchema)
+			r.Put("/schema", h.HandlePutSchema)
+			r.Get("/admin/upgrade", h.HandleUpgradeStatus)
+			r.Post("/admin/upgrade", h.HandleUpgradeStart)
 
 			// Live-tail controls are short-lived JSON calls and can use the
 			// normal API timeout/throttle budget.
@@ -324,6 +368,8 @@ func (s *Server) Start() error {
 
 	// Apply retention now and once a day; cancelled on shutdown.
 	s.startRetention(cleanupCtx)
+	// Per-source rules run hourly and whenever they change.
+	s.services.StartSourceRetention(cleanupCtx)
 
 	// Open the web UI once the listener is up (the serve goroutine starts
 	// below, so a short delay avoids racing the first request).
@@ -400,10 +446,11 @@ func (s *Server) listen() (net.Listener, int, error) {
 	return nil, 0, fmt.Errorf("no free port found in range %d-%d", basePort, basePort+portScanRange-1)
 }
 
-// startRetention deletes indices older than RetentionDays now, then once a day
-// until ctx is cancelled. A non-positive RetentionDays disables it entirely.
+// startRetention deletes indices older than RetentionDays and evicts the
+// oldest shards beyond MaxStorageBytes now, then once a day until ctx is
+// cancelled. It does nothing when neither policy is configured.
 func (s *Server) startRetention(ctx context.Context) {
-	if s.config.RetentionDays <= 0 {
+	if s.config.RetentionDays <= 0 && s.config.MaxStorageBytes <= 0 {
 		return
 	}
 	maxAge := time.Duration(s.config.RetentionDays) * 24 * time.Hour
@@ -412,11 +459,12 @@ func (s *Server) startRetention(ctx context.Context) {
 		removed, err := s.store.PruneOlderThan(maxAge)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "retention: prune failed: %v\n", err)
-			return
-		}
-		if removed > 0 {
+		} else if removed > 0 {
 			fmt.Printf("retention: removed %d index(es) older than %d day(s)\n", removed, s.config.RetentionDays)
+			s.services.InvalidateInfoCache()
 		}
+		// Age first, so the quota only evicts what retention kept.
+		s.services.EnforceStorageQuota()
 	}
 
 	prune() // sweep once at startup
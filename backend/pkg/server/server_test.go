@@ -72,6 +72,35 @@ func TestLiveEventsRouteStreamsHello(t *testing.T) {
 	}
 }
 
+func TestExportRouteIgnoresAPITimeout(t *testing.T) {
+	srv, err := NewServer(Config{
+		Host:        "localhost",
+		Port:        ":0",
+		StoragePath: t.TempDir(),
+		Timeout:     time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.services.CloseStorage() })
+
+	httpServer := httptest.NewServer(srv.router)
+	defer httpServer.Close()
+
+	resp, err := http.Get(httpServer.URL + "/api/v1/logs/export?format=csv")
+	if err != nil {
+		t.Fatalf("GET export: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read export: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "_id\n" {
+		t.Fatalf("expected 200 with a header row, got %d: %q", resp.StatusCode, body)
+	}
+}
+
 func TestListenAutoPortSkipsBusyPort(t *testing.T) {
 	busy, busyPort := reserveBusyPortWithFreeNext(t)
 	defer busy.Close()
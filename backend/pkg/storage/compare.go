@@ -0,0 +1,341 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultCompareLimit and MaxCompareLimit bound the values and templates
+	// Compare returns.
+	DefaultCompareLimit = 50
+	MaxCompareLimit     = 1000
+
+	// maxCompareFields bounds the fields Compare facets when none are named.
+	maxCompareFields = 50
+
+	// compareSelectionValues is how many of a field's most frequent values
+	// in the selection are compared; the baseline is faceted to MaxFacetSize
+	// so their counts there are found.
+	compareSelectionValues = 100
+
+	// compareSignificance is the z-score from which a difference counts as
+	// significant, about 95% two-sided.
+	compareSignificance = 1.96
+)
+
+// CompareOptions selects the rows of a selection and a baseline window,
+// with one query and one set of sources, and what is compared between them.
+type CompareOptions struct {
+	Query          string
+	Sources        []string
+	SelectionStart time.Time
+	SelectionEnd   time.Time
+	BaselineStart  time.Time
+	BaselineEnd    time.Time
+	Fields         []string // fields to compare; empty picks the keyword, numeric and boolean ones
+	PatternField   string   // text field whose templates are compared; defaults to _raw
+	Limit          int      // values and templates returned
+}
+
+// CompareValue is one field value and how much more often the selection
+// holds it than the baseline.
+type CompareValue struct {
+	Field          string
+	Value          interface{}
+	SelectionCount int
+	BaselineCount  int
+	Lift           float64 // selection rate over baseline rate, both smoothed
+	Significance   float64 // two-proportion z-score
+	Significant    bool    // Significance reached compareSignificance
+	New            bool    // absent from the baseline
+	Approximate    bool    // BaselineCount is a bound; the value ranked past the baseline's top values
+}
+
+// ComparePattern is one template and how much more often the selection
+// holds it than the baseline.
+type ComparePattern struct {
+	Template       string
+	Query          string
+	SelectionCount int
+	BaselineCount  int
+	Lift           float64
+	Significance   float64
+	Significant    bool
+	New            bool
+	SampleIDs      []string
+}
+
+// CompareResult ranks what the selection holds more of than the baseline:
+// significant differences first, then by lift.
+type CompareResult struct {
+	Values         []CompareValue
+	Patterns       []ComparePattern
+	Fields         []string // fields compared
+	SelectionTotal int
+	BaselineTotal  int
+	Truncated      bool // a window held more than PatternScanLimit rows to cluster
+	QueryTime      time.Duration
+}
+
+// Compare finds the field values and log templates over-represented in the
+// selection window against the baseline. Values are counted with FieldFacet
+// in each window; templates are mined from both windows by one pattern
+// miner, so each line of either window joins the same templates. A value
+// or template is ranked by its lift, the ratio of its share of the
+// selection's rows to its share of the baseline's, with one row added to
+// each side so values new in the selection get a finite lift, and by a
+// two-proportion z-test of the same shares.
+func (s *Storage) Compare(ctx context.Context, options CompareOptions) (CompareResult, error) {
+	started := time.Now()
+	result := CompareResult{Values: []CompareValue{}, Patterns: []ComparePattern{}}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if options.PatternField == "" {
+		options.PatternField = "_raw"
+	}
+	if options.Limit == 0 {
+		options.Limit = DefaultCompareLimit
+	}
+	if options.Limit < 0 || options.Limit > MaxCompareLimit {
+		return result, fmt.Errorf("limit must be between 1 and %d", MaxCompareLimit)
+	}
+	keywordFields, err := s.keywordFields()
+	if err != nil {
+		return result, err
+	}
+	baseQuery, err := buildPageQuery(options.Query, options.Sources, keywordFields, s.newRegexpBudget())
+	if err != nil {
+		return result, err
+	}
+	dates, err := s.List()
+	if err != nil {
+		return result, fmt.Errorf("failed to list existing dates: %w", err)
+	}
+
+	fields := deduplicateStrings(options.Fields)
+	if len(fields) == 0 {
+		if fields, err = s.compareFields(dates, options); err != nil {
+			return result, err
+		}
+	}
+	result.Fields = fields
+
+	totalsKnown := false
+	for _, field := range fields {
+		selection, err := s.FieldFacet(ctx, FacetOptions{
+			Field: field, Query: options.Query, Sources: options.Sources,
+			StartDate: options.SelectionStart, EndDate: options.SelectionEnd, Size: compareSelectionValues,
+		})
+		if err != nil {
+			return result, err
+		}
+		baseline, err := s.FieldFacet(ctx, FacetOptions{
+			Field: field, Query: options.Query, Sources: options.Sources,
+			StartDate: options.BaselineStart, EndDate: options.BaselineEnd, Size: MaxFacetSize,
+		})
+		if err != nil {
+			return result, err
+		}
+		result.SelectionTotal, result.BaselineTotal, totalsKnown = selection.Total, baseline.Total, true
+
+		baselineCounts := make(map[interface{}]int, len(baseline.Values))
+		for _, value := range baseline.Values {
+			baselineCounts[value.Value] = value.Count
+		}
+		for _, value := range selection.Values {
+			compared := CompareValue{Field: field, Value: value.Value, SelectionCount: value.Count}
+			count, ok := baselineCounts[value.Value]
+			if !ok && baseline.Other > 0 {
+				// Ranked past the baseline's top values: it has at most as many
+				// rows as the last of them, and no more than Other holds.
+				count = baseline.Other
+				if n := len(baseline.Values); n > 0 {
+					count = min(count, baseline.Values[n-1].Count)
+				}
+				compared.Approximate = true
+			}
+			compared.BaselineCount = count
+			compared.New = count == 0
+			compared.Lift, compared.Significance = compareShares(value.Count, selection.Total, count, baseline.Total)
+			compared.Significant = compared.Significance >= compareSignificance
+			if compared.Lift > 1 {
+				result.Values = append(result.Values, compared)
+			}
+		}
+	}
+
+	miner := newPatternMiner()
+	type windowCounts struct {
+		selection, baseline int
+		samples             []string // from the selection
+	}
+	counts := make(map[*patternCluster]*windowCounts)
+	var selectionScan, baselineScan patternScan
+	err = s.minePatterns(ctx, miner, baseQuery, dates, options.BaselineStart, options.BaselineEnd, options.PatternField, &baselineScan,
+		func(cluster *patternCluster, _ string) {
+			if counts[cluster] == nil {
+				counts[cluster] = &windowCounts{}
+			}
+			counts[cluster].baseline++
+		})
+	if err != nil {
+		return result, err
+	}
+	err = s.minePatterns(ctx, miner, baseQuery, dates, options.SelectionStart, options.SelectionEnd, options.PatternField, &selectionScan,
+		func(cluster *patternCluster, id string) {
+			if counts[cluster] == nil {
+				counts[cluster] = &windowCounts{}
+			}
+			counts[cluster].selection++
+			if len(counts[cluster].samples) < patternSamples {
+				counts[cluster].samples = append(counts[cluster].samples, id)
+			}
+		})
+	if err != nil {
+		return result, err
+	}
+	result.Truncated = selectionScan.truncated || baselineScan.truncated
+	selectionTotal := selectionScan.matched + selectionScan.missing
+	baselineTotal := baselineScan.matched + baselineScan.missing
+	if !totalsKnown {
+		result.SelectionTotal, result.BaselineTotal = selectionTotal, baselineTotal
+	}
+	for cluster, count := range counts {
+		if count.selection == 0 {
+			continue
+		}
+		compared := ComparePattern{
+			Template:       strings.Join(cluster.tokens, " "),
+			Query:          patternQuery(options.PatternField, cluster.tokens),
+			SelectionCount: count.selection,
+			BaselineCount:  count.baseline,
+			New:            count.baseline == 0,
+			SampleIDs:      count.samples,
+		}
+		compared.Lift, compared.Significance = compareShares(count.selection, selectionTotal, count.baseline, baselineTotal)
+		compared.Significant = compared.Significance >= compareSignificance
+		if compared.Lift > 1 {
+			result.Patterns = append(result.Patterns, compared)
+		}
+	}
+
+	sort.Slice(result.Values, func(i, j int) bool {
+		a, b := result.Values[i], result.Values[j]
+		if order := compareRank(a.Significant, a.Lift, a.Significance, b.Significant, b.Lift, b.Significance); order != 0 {
+			return order < 0
+		}
+		if a.Field != b.Field {
+			return a.Field < b.Field
+		}
+		return compareStoredValues(a.Value, b.Value, false) < 0
+	})
+	sort.Slice(result.Patterns, func(i, j int) bool {
+		a, b := result.Patterns[i], result.Patterns[j]
+		if order := compareRank(a.Significant, a.Lift, a.Significance, b.Significant, b.Lift, b.Significance); order != 0 {
+			return order < 0
+		}
+		return a.Template < b.Template
+	})
+	result.Values = result.Values[:min(len(result.Values), options.Limit)]
+	result.Patterns = result.Patterns[:min(len(result.Patterns), options.Limit)]
+	result.QueryTime = time.Since(started)
+	return result, nil
+}
+
+// compareFields picks the fields worth faceting in either window: every
+// field each shard the windows touch counts from its terms, as keywords,
+// numbers or booleans of one kind. Text, dates, internal fields and fields
+// only older shards hold would be counted by scanning stored values, once
+// per field and window, so they are compared only when named.
+func (s *Storage) compareFields(dates []string, options CompareOptions) ([]string, error) {
+	selected := intersectingDates(dates, options.SelectionStart, options.SelectionEnd, s.shardSlack())
+	selected = append(selected, intersectingDates(dates, options.BaselineStart, options.BaselineEnd, s.shardSlack())...)
+	kinds := make(map[string]string)
+	excluded := make(map[string]bool)
+	var metas []shardMeta
+	for _, date := range deduplicateStrings(selected) {
+		meta, err := s.shardMetadata(date, false)
+		if errors.Is(err, errShardNotFound) {
+			continue // removed since List
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata for %s: %w", date, err)
+		}
+		metas = append(metas, meta)
+		for _, field := range meta.Fields {
+			kinds[field] = ""
+		}
+	}
+	for _, meta := range metas {
+		for field := range kinds {
+			kind, ok := facetTermKind(meta, field)
+			switch {
+			case !ok || field == options.PatternField:
+				excluded[field] = true
+			case kind == "":
+				// not in this shard
+			case kinds[field] == "":
+				kinds[field] = kind
+			case kinds[field] != kind:
+				excluded[field] = true
+			}
+		}
+	}
+	var fields []string
+	for field, kind := range kinds {
+		if kind != "" && !excluded[field] {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields[:min(len(fields), maxCompareFields)], nil
+}
+
+// compareShares returns the smoothed lift of count rows out of total in the
+// selection against the baseline, and the z-score of the difference between
+// the two shares. The z-score is 0 when either window is empty.
+func compareShares(selectionCount, selectionTotal, baselineCount, baselineTotal int) (float64, float64) {
+	lift := (float64(selectionCount+1) / float64(selectionTotal+1)) /
+		(float64(baselineCount+1) / float64(baselineTotal+1))
+	if selectionTotal == 0 || baselineTotal == 0 {
+		return lift, 0
+	}
+	selectionShare := float64(selectionCount) / float64(selectionTotal)
+	baselineShare := float64(baselineCount) / float64(baselineTotal)
+	pooled := float64(selectionCount+baselineCount) / float64(selectionTotal+baselineTotal)
+	deviation := math.Sqrt(pooled * (1 - pooled) * (1/float64(selectionTotal) + 1/float64(baselineTotal)))
+	if deviation == 0 {
+		return lift, 0
+	}
+	return lift, (selectionShare - baselineShare) / deviation
+}
+
+// compareRank orders significant differences first, then by lift, then by
+// z-score, returning -1 when a ranks above b.
+func compareRank(aSignificant bool, aLift, aScore float64, bSignificant bool, bLift, bScore float64) int {
+	switch {
+	case aSignificant != bSignificant:
+		if aSignificant {
+			return -1
+		}
+		return 1
+	case aLift != bLift:
+		if aLift > bLift {
+			return -1
+		}
+		return 1
+	case aScore != bScore:
+		if aScore > bScore {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCompareRanksValuesAndTemplatesOverRepresentedInSelection(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	var logs []map[string]interface{}
+	for i := 0; i < 40; i++ {
+		logs = append(logs, map[string]interface{}{
+			"timestamp": base.Add(time.Duration(i) * time.Minute),
+			"_raw":      fmt.Sprintf("GET /api/items/%d served by web-1", i),
+			"_src":      "app.log",
+			"host":      "web-1",
+			"status":    "200",
+		})
+	}
+	spike := base.Add(time.Hour)
+	for i := 0; i < 40; i++ {
+		row := map[string]interface{}{
+			"timestamp": spike.Add(time.Duration(i) * time.Minute),
+			"_raw":      fmt.Sprintf("GET /api/items/%d served by web-1", i),
+			"_src":      "app.log",
+			"host":      "web-1",
+			"status":    "200",
+		}
+		if i%2 == 0 {
+			row["_raw"] = fmt.Sprintf("upstream db-%d refused connection", i)
+			row["host"] = "web-2"
+			row["status"] = "503"
+		}
+		logs = append(logs, row)
+	}
+	if _, err := store.StoreWithIDs(logs, "app.log"); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+
+	result, err := store.Compare(context.Background(), CompareOptions{
+		SelectionStart: spike,
+		SelectionEnd:   spike.Add(time.Hour - time.Second),
+		BaselineStart:  base,
+		BaselineEnd:    spike.Add(-time.Second),
+	})
+	if err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+	if result.SelectionTotal != 40 || result.BaselineTotal != 40 {
+		t.Fatalf("totals = %d, %d", result.SelectionTotal, result.BaselineTotal)
+	}
+	// host is undeclared text, which would be counted by a scan, so only
+	// status, indexed as numbers, is picked.
+	if len(result.Fields) != 1 || result.Fields[0] != "status" {
+		t.Fatalf("fields = %v", result.Fields)
+	}
+	if len(result.Values) != 1 {
+		t.Fatalf("values = %+v", result.Values)
+	}
+	if value := result.Values[0]; fmt.Sprint(value.Value) != "503" || value.SelectionCount != 20 || value.BaselineCount != 0 ||
+		!value.New || !value.Significant || value.Lift <= 10 {
+		t.Fatalf("value = %+v", value)
+	}
+
+	if len(result.Patterns) != 1 {
+		t.Fatalf("patterns = %+v", result.Patterns)
+	}
+	pattern := result.Patterns[0]
+	if pattern.Template != "upstream <*> refused connection" || pattern.SelectionCount != 20 || !pattern.New ||
+		!pattern.Significant || len(pattern.SampleIDs) != patternSamples {
+		t.Fatalf("pattern = %+v", pattern)
+	}
+
+	narrowed, err := store.Compare(context.Background(), CompareOptions{
+		SelectionStart: spike,
+		SelectionEnd:   spike.Add(time.Hour - time.Second),
+		BaselineStart:  base,
+		BaselineEnd:    spike.Add(-time.Second),
+		Fields:         []string{"host"},
+		Limit:          1,
+	})
+	if err != nil {
+		t.Fatalf("Compare with fields: %v", err)
+	}
+	if len(narrowed.Fields) != 1 || len(narrowed.Values) != 1 {
+		t.Fatalf("narrowed = %+v", narrowed)
+	}
+	if value := narrowed.Values[0]; value.Field != "host" || value.Value != "web-2" || value.SelectionCount != 20 || !value.New || !value.Significant {
+		t.Fatalf("named text field value = %+v", value)
+	}
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/document"
+	index "github.com/blevesearch/bleve_index_api"
+)
+
+// fingerprintField indexes each record's fingerprint as one exact term. It
+// is not stored: rewrites recompute it from _raw, _src and timestamp.
+const fingerprintField = "_fp"
+
+// recordFingerprint identifies a record by its raw line, source and
+// timestamp, so importing the same line twice gives the same fingerprint. It
+// reports false for rows without a raw line or timestamp.
+func recordFingerprint(row map[string]interface{}) (string, bool) {
+	raw, ok := row["_raw"].(string)
+	if !ok {
+		return "", false
+	}
+	timestamp, ok := row["timestamp"].(time.Time)
+	if !ok {
+		return "", false
+	}
+	source, _ := row["_src"].(string)
+
+	hash := sha256.New()
+	hash.Write([]byte(raw))
+	hash.Write([]byte{0})
+	hash.Write([]byte(source))
+	hash.Write([]byte{0})
+	var nanos [8]byte
+	binary.BigEndian.PutUint64(nanos[:], uint64(timestamp.UnixNano()))
+	hash.Write(nanos[:])
+	return hex.EncodeToString(hash.Sum(nil)[:16]), true
+}
+
+// addFingerprint indexes the row's fingerprint, when it has one, on doc.
+func addFingerprint(doc *document.Document, row map[string]interface{}) {
+	fingerprint, ok := recordFingerprint(row)
+	if !ok {
+		return
+	}
+	doc.AddField(document.NewTextFieldCustom(fingerprintField, nil, []byte(fingerprint),
+		index.IndexField|index.SkipFreqNorm, exactTermAnalyzer))
+}
+
+// fingerprintIndex answers whether a shard already holds a fingerprint. It
+// reads one snapshot of the shard, so rows written after it was opened are
+// not seen; callers track their own batch and hold the shard's dedupe lock
+// until it is written.
+type fingerprintIndex struct {
+	reader index.IndexReader
+}
+
+func openFingerprintIndex(shard bleve.Index) (*fingerprintIndex, error) {
+	advanced, err := shard.Advanced()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := advanced.Reader()
+	if err != nil {
+		return nil, err
+	}
+	return &fingerprintIndex{reader: reader}, nil
+}
+
+func (f *fingerprintIndex) contains(fingerprint string) (bool, error) {
+	termReader, err := f.reader.TermFieldReader(context.Background(), []byte(fingerprint), fingerprintField, false, false, false)
+	if err != nil {
+		return false, err
+	}
+	defer termReader.Close()
+	match, err := termReader.Next(nil)
+	return match != nil, err
+}
+
+func (f *fingerprintIndex) Close() error {
+	return f.reader.Close()
+}
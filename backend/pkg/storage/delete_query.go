@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/blevesearch/bleve/v2"
+	blevesearch "github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// DeleteQueryOptions selects the rows DeleteByQuery removes. Query and the
+// time range mean what they do for SearchPage; Sources must match _src
+// exactly, so "nginx" never takes "nginx-audit" rows with it.
+type DeleteQueryOptions struct {
+	Query     string
+	StartDate time.Time
+	EndDate   time.Time
+	Sources   []string
+	MaxDocs   int  // stop after this many matching rows; must be positive
+	DryRun    bool // count the matching rows without deleting them
+}
+
+// DeleteQueryResult summarises a DeleteByQuery run. On error it reports the
+// work done before the failure.
+type DeleteQueryResult struct {
+	Matched       int
+	Deleted       int
+	Truncated     bool // MaxDocs was reached; more rows may match
+	ShardsScanned int
+	ShardsRemoved []string
+}
+
+// DeleteByQuery deletes the rows matching options, visiting only the shards
+// that intersect the time range, one page at a time. It stops at
+// options.MaxDocs matches so a broad query cannot hold the write lease
+// indefinitely, and between pages when ctx is cancelled; rows deleted by then
+// stay deleted. Shards left empty are dropped. With DryRun set, it only counts.
+func (s *Storage) DeleteByQuery(ctx context.Context, options DeleteQueryOptions) (DeleteQueryResult, error) {
+	var result DeleteQueryResult
+	if options.MaxDocs <= 0 {
+		return result, fmt.Errorf("max docs must be positive")
+	}
+	if options.EndDate.Before(options.StartDate) {
+		return result, nil
+	}
+	keywordFields, err := s.keywordFields()
+	if err != nil {
+		return result, err
+	}
+	baseQuery, err := buildPageQuery(options.Query, options.Sources, keywordFields, s.newRegexpBudget())
+	if err != nil {
+		return result, err
+	}
+
+	emptied, err := s.deleteQueryRows(ctx, baseQuery, options, &result)
+	if err != nil {
+		return result, err
+	}
+	for _, key := range emptied {
+		removed, err := s.removeShardIfEmpty(key)
+		if err != nil {
+			return result, err
+		}
+		if removed {
+			result.ShardsRemoved = append(result.ShardsRemoved, key)
+		}
+	}
+	return result, nil
+}
+
+// deleteQueryRows walks the intersecting shards under the write lease,
+// returning the shards left empty.
+func (s *Storage) deleteQueryRows(ctx context.Context, baseQuery query.Query, options DeleteQueryOptions, result *DeleteQueryResult) ([]string, error) {
+	if !options.DryRun {
+		s.writeMu.RLock()
+		defer s.writeMu.RUnlock()
+	}
+
+	keys, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var emptied []string
+	for _, key := range intersectingDates(keys, options.StartDate, options.EndDate, s.shardSlack()) {
+		if result.Matched >= options.MaxDocs {
+			result.Truncated = true
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return emptied, err
+		}
+		meta, err := s.shardMetadata(key, false)
+		if errors.Is(err, errShardNotFound) {
+			continue
+		}
+		if err != nil {
+			return emptied, fmt.Errorf("failed to get index for date %s: %w", key, err)
+		}
+		if !holdsAnySource(meta, options.Sources) {
+			continue // nothing to match; skip opening the shard
+		}
+		result.ShardsScanned++
+		deleted, remaining, err := s.deleteQueryRowsInShard(ctx, key, baseQuery, options, result)
+		if err != nil {
+			return emptied, fmt.Errorf("failed to delete from index %s: %w", key, err)
+		}
+		if deleted > 0 && remaining == 0 {
+			emptied = append(emptied, key)
+		}
+	}
+	return emptied, nil
+}
+
+// deleteQueryRowsInShard deletes one shard's matches, adding them to result,
+// and reports the rows it deleted and the documents the shard has left.
+func (s *Storage) deleteQueryRowsInShard(ctx context.Context, key string, baseQuery query.Query, options DeleteQueryOptions, result *DeleteQueryResult) (int, uint64, error) {
+	index, release, err := s.acquireIndex(key, false)
+	if errors.Is(err, errShardNotFound) {
+		return 0, 0, nil // removed since List
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer release()
+
+	searchQuery := baseQuery
+	if timestampIsIndexed(index) {
+		inclusive := true
+		timeQuery := query.NewDateRangeInclusiveQuery(options.StartDate, options.EndDate, &inclusive, &inclusive)
+		timeQuery.SetField("timestamp")
+		searchQuery = bleve.NewConjunctionQuery(searchQuery, timeQuery)
+	}
+	sources := make(map[string]bool, len(options.Sources))
+	for _, source := range options.Sources {
+		sources[source] = true
+	}
+
+	deleted := 0
+	var searchAfter []string
+	for result.Matched < options.MaxDocs {
+		if err := ctx.Err(); err != nil {
+			return deleted, 0, err
+		}
+		request := bleve.NewSearchRequest(searchQuery)
+		request.Size = migrateBatchSize
+		request.Fields = []string{"_src", "timestamp"}
+		request.SortByCustom(blevesearch.SortOrder{&blevesearch.SortDocID{}})
+		if len(searchAfter) > 0 {
+			request.SetSearchAfter(searchAfter)
+		}
+		page, err := index.SearchInContext(ctx, request)
+		if err != nil {
+			return deleted, 0, err
+		}
+		if len(page.Hits) == 0 {
+			break
+		}
+
+		batch := index.NewBatch()
+		for _, hit := range page.Hits {
+			if len(sources) > 0 {
+				if source, _ := hit.Fields["_src"].(string); !sources[source] {
+					continue
+				}
+			}
+			// Same time test as SearchPage, which also covers legacy shards
+			// without timestamp postings.
+			value, _ := hit.Fields["timestamp"].(string)
+			timestamp, err := dateparse.ParseAny(value)
+			if err != nil || timestamp.Before(options.StartDate) || timestamp.After(options.EndDate) {
+				continue
+			}
+			if result.Matched >= options.MaxDocs {
+				result.Truncated = true
+				break
+			}
+			result.Matched++
+			batch.Delete(hit.ID)
+		}
+		if !options.DryRun && batch.Size() > 0 {
+			if err := s.writeShard(key, index, batch, shardAdditions{}, true); err != nil {
+				return deleted, 0, err
+			}
+			result.Deleted += batch.Size()
+			deleted += batch.Size()
+		}
+
+		searchAfter = page.Hits[len(page.Hits)-1].Sort
+		if len(page.Hits) < request.Size || len(searchAfter) == 0 {
+			break
+		}
+	}
+	if deleted == 0 {
+		return 0, 0, nil
+	}
+	remaining, err := index.DocCount()
+	return deleted, remaining, err
+}
+
+// holdsAnySource reports whether a shard may hold rows of the given sources.
+// Shards whose source list is unknown, and empty filters, always qualify.
+func holdsAnySource(meta shardMeta, sources []string) bool {
+	if len(sources) == 0 || !meta.SourcesKnown {
+		return true
+	}
+	for _, source := range sources {
+		if i := sort.SearchStrings(meta.Sources, source); i < len(meta.Sources) && meta.Sources[i] == source {
+			return true
+		}
+	}
+	return false
+}
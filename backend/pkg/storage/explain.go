@@ -0,0 +1,373 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// Paths a shard's rows are counted by.
+const (
+	// ExplainPathFacet filters timestamps with the index and counts the
+	// histogram with a date facet.
+	ExplainPathFacet = "facet"
+
+	// ExplainPathLegacy reads every match's stored timestamp, for shards
+	// written before timestamps were indexed.
+	ExplainPathLegacy = "legacy"
+)
+
+// ExplainOptions selects the query Explain runs, as SearchPage takes it.
+type ExplainOptions struct {
+	Query     string
+	StartDate time.Time
+	EndDate   time.Time
+	Sources   []string
+}
+
+// ExplainResult describes how a query is run and what each step costs.
+type ExplainResult struct {
+	Query     ExplainNode
+	Path      string // ExplainPathLegacy when any shard lacks timestamp postings
+	Shards    []ExplainShard
+	Phases    []ExplainPhase
+	Total     int // rows matching the query
+	QueryTime time.Duration
+}
+
+// ExplainNode is one query of the tree the read paths run, after the query
+// string is parsed and rewritten by optimizeQuery.
+type ExplainNode struct {
+	Type     string // e.g. match, stored_phrase, all_fields or boolean
+	Field    string // empty for every searchable field
+	Text     string // the term, phrase, pattern or range searched for
+	Analyzer string
+	Tokens   []string // what Analyzer makes of Text, or a regex's candidate terms
+	Verified bool     // candidates are checked against stored values
+	Role     string   // must, should, must_not or filter under a boolean
+	Children []ExplainNode
+}
+
+// ExplainShard is what the query did in one shard selected by the range.
+type ExplainShard struct {
+	Key           string
+	Docs          uint64
+	Path          string // ExplainPathFacet or ExplainPathLegacy
+	Candidates    int    // rows in range the index selects before verification
+	Matches       int    // rows in range matching the query
+	CandidateTime time.Duration
+	MatchTime     time.Duration
+}
+
+// ExplainPhase is the time a step of the query took over every shard, and the
+// shards or rows it produced. The matches phase runs the whole query again,
+// so its time less that of candidates is roughly the cost of verification.
+type ExplainPhase struct {
+	Name  string // parse, select_shards, candidates or matches
+	Time  time.Duration
+	Count int
+}
+
+// Explain runs a query the way SearchPage filters it and reports the query
+// tree, the shards the range selects and, per shard, how many rows the
+// inverted index proposes and how many survive stored-value verification of
+// phrases and raw:~/regex/ terms. Excluded terms stay verified in the
+// candidate count, since their unverified candidates would exclude too much.
+func (s *Storage) Explain(ctx context.Context, options ExplainOptions) (ExplainResult, error) {
+	started := time.Now()
+	result := ExplainResult{Path: ExplainPathFacet, Shards: []ExplainShard{}}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if options.EndDate.Before(options.StartDate) {
+		return result, fmt.Errorf("end date must not be before start date")
+	}
+
+	phaseStarted := time.Now()
+	keywordFields, err := s.keywordFields()
+	if err != nil {
+		return result, err
+	}
+	budget := s.newRegexpBudget()
+	searchQuery, err := buildPageQuery(options.Query, options.Sources, keywordFields, budget)
+	if err != nil {
+		return result, err
+	}
+	candidateQuery, err := buildPageQuery(options.Query, options.Sources, keywordFields, budget)
+	if err != nil {
+		return result, err
+	}
+	skipVerification(candidateQuery, false)
+	result.Query = describeQuery(searchQuery, buildIndexMapping())
+	result.Phases = append(result.Phases, ExplainPhase{Name: "parse", Time: time.Since(phaseStarted)})
+
+	phaseStarted = time.Now()
+	dates, err := s.List()
+	if err != nil {
+		return result, fmt.Errorf("failed to list existing dates: %w", err)
+	}
+	type explainedShard struct {
+		key   string
+		index bleve.Index
+	}
+	var shards []explainedShard
+	for _, date := range intersectingDates(dates, options.StartDate, options.EndDate, s.shardSlack()) {
+		index, release, err := s.acquireIndex(date, false)
+		if errors.Is(err, errShardNotFound) {
+			continue // removed since List
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to get index for date %s: %w", date, err)
+		}
+		defer release()
+		shards = append(shards, explainedShard{key: date, index: index})
+		if !timestampIsIndexed(index) {
+			result.Path = ExplainPathLegacy
+		}
+	}
+	result.Phases = append(result.Phases, ExplainPhase{Name: "select_shards", Time: time.Since(phaseStarted), Count: len(shards)})
+
+	candidates := ExplainPhase{Name: "candidates"}
+	matches := ExplainPhase{Name: "matches"}
+	for _, shard := range shards {
+		explained := ExplainShard{Key: shard.key, Path: ExplainPathFacet}
+		if !timestampIsIndexed(shard.index) {
+			explained.Path = ExplainPathLegacy
+		}
+		if explained.Docs, err = shard.index.DocCount(); err != nil {
+			return result, fmt.Errorf("failed to count documents for date %s: %w", shard.key, err)
+		}
+
+		phaseStarted = time.Now()
+		if explained.Candidates, err = countShardMatches(ctx, shard.index, candidateQuery, options.StartDate, options.EndDate); err != nil {
+			return result, err
+		}
+		explained.CandidateTime = time.Since(phaseStarted)
+		phaseStarted = time.Now()
+		if explained.Matches, err = countShardMatches(ctx, shard.index, searchQuery, options.StartDate, options.EndDate); err != nil {
+			return result, err
+		}
+		explained.MatchTime = time.Since(phaseStarted)
+
+		candidates.Time += explained.CandidateTime
+		candidates.Count += explained.Candidates
+		matches.Time += explained.MatchTime
+		matches.Count += explained.Matches
+		result.Shards = append(result.Shards, explained)
+	}
+	result.Phases = append(result.Phases, candidates, matches)
+	result.Total = matches.Count
+	result.QueryTime = time.Since(started)
+	return result, nil
+}
+
+// countShardMatches counts the rows of one shard matching searchQuery inside
+// [start, end], with a size-zero search where timestamps are indexed and by
+// reading stored timestamps where they are not.
+func countShardMatches(ctx context.Context, index bleve.Index, searchQuery query.Query, start, end time.Time) (int, error) {
+	if !timestampIsIndexed(index) {
+		count := 0
+		err := scanShardMatches(ctx, index, searchQuery, start, end, nil,
+			func(string, map[string]interface{}, time.Time) { count++ })
+		return count, err
+	}
+	inclusive := true
+	timeQuery := query.NewDateRangeInclusiveQuery(start, end, &inclusive, &inclusive)
+	timeQuery.SetField("timestamp")
+	request := bleve.NewSearchRequest(bleve.NewConjunctionQuery(searchQuery, timeQuery))
+	request.Size = 0
+	result, err := index.SearchInContext(ctx, request)
+	if err != nil {
+		return 0, err
+	}
+	return int(result.Total), nil
+}
+
+// skipVerification makes the phrases and raw:~/regex/ terms of a query tree
+// built for Explain return their index candidates unchecked, except under a
+// negation, where fewer checks would exclude more rows.
+func skipVerification(input query.Query, negated bool) {
+	switch typed := input.(type) {
+	case *storedPhraseQuery:
+		typed.unverified = !negated
+	case *rawRegexpQuery:
+		typed.unverified = !negated
+	case *query.ConjunctionQuery:
+		for _, child := range typed.Conjuncts {
+			skipVerification(child, negated)
+		}
+	case *query.DisjunctionQuery:
+		for _, child := range typed.Disjuncts {
+			skipVerification(child, negated)
+		}
+	case *query.BooleanQuery:
+		for _, child := range []query.Query{typed.Must, typed.Should, typed.Filter} {
+			if child != nil {
+				skipVerification(child, negated)
+			}
+		}
+		if typed.MustNot != nil {
+			skipVerification(typed.MustNot, !negated)
+		}
+	}
+}
+
+// describeQuery turns a query tree into ExplainNodes, analyzing text with
+// indexMapping the way the shards do.
+func describeQuery(input query.Query, indexMapping mapping.IndexMapping) ExplainNode {
+	analyze := func(node *ExplainNode, analyzerName string) {
+		if analyzerName == "" {
+			analyzerName = indexMapping.AnalyzerNameForPath(node.Field)
+		}
+		node.Analyzer = analyzerName
+		analyzer := indexMapping.AnalyzerNamed(analyzerName)
+		if analyzer == nil {
+			return
+		}
+		node.Tokens = []string{}
+		for _, token := range analyzer.Analyze([]byte(node.Text)) {
+			node.Tokens = append(node.Tokens, string(token.Term))
+		}
+	}
+	children := func(node *ExplainNode, role string, queries ...query.Query) {
+		for _, child := range queries {
+			described := describeQuery(child, indexMapping)
+			described.Role = role
+			node.Children = append(node.Children, described)
+		}
+	}
+
+	var node ExplainNode
+	switch typed := input.(type) {
+	case *query.MatchAllQuery:
+		node.Type = "match_all"
+	case *query.MatchNoneQuery:
+		node.Type = "match_none"
+	case *query.MatchQuery:
+		node = ExplainNode{Type: "match", Field: typed.FieldVal, Text: typed.Match}
+		analyze(&node, typed.Analyzer)
+	case *storedPhraseQuery:
+		node = ExplainNode{Type: "stored_phrase", Field: typed.field, Text: typed.phrase}
+		analyze(&node, typed.analyzer)
+		node.Verified = len(node.Tokens) > 1
+	case *allFieldsQuery:
+		node.Type = "all_fields"
+		children(&node, "", typed.child)
+	case *rawRegexpQuery:
+		node = ExplainNode{Type: "raw_regexp", Field: "_raw", Text: typed.pattern.String(), Verified: true}
+		node.Analyzer = indexMapping.AnalyzerNameForPath("_raw")
+		node.Tokens = []string{}
+		if analyzer := indexMapping.AnalyzerNamed(node.Analyzer); analyzer != nil {
+			for _, candidate := range rawRegexpCandidates(typed.pattern, analyzer, typed.budget) {
+				switch candidate := candidate.(type) {
+				case *query.TermQuery:
+					node.Tokens = append(node.Tokens, candidate.Term)
+				case *query.PrefixQuery:
+					node.Tokens = append(node.Tokens, candidate.Prefix+"*")
+				case *rawTermScanQuery:
+					node.Tokens = append(node.Tokens, candidate.wildcard())
+				}
+			}
+		}
+	case *query.TermQuery:
+		node = ExplainNode{Type: "term", Field: typed.FieldVal, Text: typed.Term}
+	case *query.PrefixQuery:
+		node = ExplainNode{Type: "prefix", Field: typed.FieldVal, Text: typed.Prefix}
+	case *query.WildcardQuery:
+		node = ExplainNode{Type: "wildcard", Field: typed.FieldVal, Text: typed.Wildcard}
+	case *query.RegexpQuery:
+		node = ExplainNode{Type: "regexp", Field: typed.FieldVal, Text: typed.Regexp}
+	case *query.FuzzyQuery:
+		node = ExplainNode{Type: "fuzzy", Field: typed.FieldVal, Text: typed.Term + "~" + strconv.Itoa(typed.Fuzziness)}
+	case *query.TermRangeQuery:
+		node = ExplainNode{Type: "term_range", Field: typed.FieldVal}
+		lower, lowerOK := decodeNumericTerm(typed.Min)
+		upper, upperOK := decodeNumericTerm(typed.Max)
+		if lowerOK && upperOK {
+			node.Type = "numeric_range"
+		} else {
+			lower, upper = typed.Min, typed.Max
+		}
+		node.Text = formatRange(lower, upper, typed.InclusiveMin, typed.InclusiveMax)
+	case *query.NumericRangeQuery:
+		node = ExplainNode{Type: "numeric_range", Field: typed.FieldVal}
+		lower, upper := "*", "*"
+		if typed.Min != nil {
+			lower = strconv.FormatFloat(*typed.Min, 'g', -1, 64)
+		}
+		if typed.Max != nil {
+			upper = strconv.FormatFloat(*typed.Max, 'g', -1, 64)
+		}
+		node.Text = formatRange(lower, upper, typed.InclusiveMin, typed.InclusiveMax)
+	case *query.DateRangeQuery:
+		node = ExplainNode{Type: "date_range", Field: typed.FieldVal}
+		lower, upper := "*", "*"
+		if !typed.Start.IsZero() {
+			lower = typed.Start.UTC().Format(time.RFC3339Nano)
+		}
+		if !typed.End.IsZero() {
+			upper = typed.End.UTC().Format(time.RFC3339Nano)
+		}
+		node.Text = formatRange(lower, upper, typed.InclusiveStart, typed.InclusiveEnd)
+	case *query.DocIDQuery:
+		node.Type = "doc_id"
+		for i, id := range typed.IDs {
+			if i > 0 {
+				node.Text += " "
+			}
+			node.Text += id
+		}
+	case *query.ConjunctionQuery:
+		node.Type = "conjunction"
+		children(&node, "", typed.Conjuncts...)
+	case *query.DisjunctionQuery:
+		node.Type = "disjunction"
+		if typed.Min > 0 {
+			node.Text = "min " + strconv.FormatFloat(typed.Min, 'g', -1, 64)
+		}
+		children(&node, "", typed.Disjuncts...)
+	case *query.BooleanQuery:
+		node.Type = "boolean"
+		for _, clause := range []struct {
+			role  string
+			query query.Query
+		}{{"must", typed.Must}, {"should", typed.Should}, {"must_not", typed.MustNot}, {"filter", typed.Filter}} {
+			if clause.query != nil {
+				children(&node, clause.role, clause.query)
+			}
+		}
+	default:
+		node.Type = fmt.Sprintf("%T", input)
+	}
+	return node
+}
+
+// decodeNumericTerm reads a bound of a rewritten numeric range as the number
+// it encodes, or "*" for an open end.
+func decodeNumericTerm(term string) (string, bool) {
+	bound, ok := decodeNumericBound(term)
+	switch {
+	case !ok:
+		return "", false
+	case bound == nil:
+		return "*", true
+	}
+	return strconv.FormatFloat(*bound, 'g', -1, 64), true
+}
+
+func formatRange(lower, upper string, inclusiveLower, inclusiveUpper *bool) string {
+	open, close := "(", ")"
+	if inclusiveLower == nil || *inclusiveLower {
+		open = "["
+	}
+	if inclusiveUpper != nil && *inclusiveUpper {
+		close = "]"
+	}
+	return open + lower + ", " + upper + close
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExplainCountsCandidatesAndMatches(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	logs := []map[string]interface{}{
+		{"timestamp": base, "_raw": "request timeout", "latency": "250"},
+		{"timestamp": base.Add(time.Minute), "_raw": "timeout on request", "latency": "300"},
+		{"timestamp": base.Add(2 * time.Minute), "_raw": "request timeout", "latency": "50"},
+		{"timestamp": base.Add(24 * time.Hour), "_raw": "request timeout", "latency": "400"},
+		{"timestamp": base.Add(48 * time.Hour), "_raw": "request timeout", "latency": "500"},
+	}
+	if _, err := store.StoreWithIDs(logs, "app.log"); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+
+	result, err := store.Explain(context.Background(), ExplainOptions{
+		Query:     `+_raw:"request timeout" +latency:>100`,
+		StartDate: base,
+		EndDate:   base.Add(36 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Explain: %v", err)
+	}
+	if result.Total != 2 || result.Path != ExplainPathFacet {
+		t.Fatalf("total = %d, path = %q", result.Total, result.Path)
+	}
+	if len(result.Shards) != 2 || result.Shards[0].Candidates != 2 || result.Shards[0].Matches != 1 ||
+		result.Shards[1].Candidates != 1 || result.Shards[1].Matches != 1 || result.Shards[0].Path != ExplainPathFacet {
+		t.Fatalf("shards = %+v", result.Shards)
+	}
+	var names []string
+	for _, phase := range result.Phases {
+		names = append(names, phase.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"parse", "select_shards", "candidates", "matches"}) ||
+		result.Phases[1].Count != 2 || result.Phases[2].Count != 3 || result.Phases[3].Count != 2 {
+		t.Fatalf("phases = %+v", result.Phases)
+	}
+
+	tree := result.Query
+	if tree.Type != "boolean" || len(tree.Children) != 1 || tree.Children[0].Role != "must" {
+		t.Fatalf("tree = %+v", tree)
+	}
+	clauses := tree.Children[0].Children
+	if len(clauses) != 2 {
+		t.Fatalf("clauses = %+v", clauses)
+	}
+	phrase, numeric := clauses[0], clauses[1]
+	if phrase.Type != "stored_phrase" || phrase.Field != "_raw" || !phrase.Verified ||
+		!reflect.DeepEqual(phrase.Tokens, []string{"request", "timeout"}) {
+		t.Fatalf("phrase = %+v", phrase)
+	}
+	if numeric.Type != "numeric_range" || numeric.Field != "latency" || numeric.Text != "(100, *]" {
+		t.Fatalf("numeric = %+v", numeric)
+	}
+}
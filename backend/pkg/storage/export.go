@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// ErrInvalidExport is returned for export options that cannot be streamed.
+var ErrInvalidExport = errors.New("invalid export")
+
+// ExportOptions selects the rows Export streams, as SearchPage takes them,
+// and the columns each row carries.
+type ExportOptions struct {
+	Query     string
+	StartDate time.Time
+	EndDate   time.Time
+	Sources   []string
+	SortBy    string
+	SortOrder string
+	Fields    []string // columns to keep; empty keeps every stored field and _id
+	Empty     bool     // match nothing, e.g. for a source filter naming no sources; start still runs
+}
+
+// exportShards is what Export learns about its shards before streaming.
+type exportShards struct {
+	keys              []string
+	timestampsIndexed bool
+	columns           map[string]struct{}
+}
+
+// Export streams every row matching options in sort order. It calls start
+// once with the columns rows may carry, then emit for each row, reading the
+// shards in batches resumed by search-after so memory stays flat however
+// many rows match. Shards are pinned only while a batch is read, never
+// while rows are written, so a slow client does not hold up Clear,
+// retention or deletes. Only sorts Bleve can run from indexed terms are
+// streamed; a stored-value sort would have to hold every row. An error from
+// start or emit stops the export and is returned as is.
+func (s *Storage) Export(
+	ctx context.Context,
+	options ExportOptions,
+	start func(columns []string) error,
+	emit func(log map[string]interface{}) error,
+) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if options.SortBy == "" {
+		options.SortBy = "timestamp"
+	}
+	if options.SortOrder != "asc" && options.SortOrder != "desc" {
+		return 0, fmt.Errorf("%w: sort order must be asc or desc", ErrInvalidExport)
+	}
+	keywordFields, err := s.keywordFields()
+	if err != nil {
+		return 0, err
+	}
+	baseQuery, err := buildPageQuery(options.Query, options.Sources, keywordFields, s.newRegexpBudget())
+	if err != nil {
+		return 0, err
+	}
+
+	dates, err := s.List()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list existing dates: %w", err)
+	}
+	var selected []string
+	if !options.Empty && !options.EndDate.Before(options.StartDate) {
+		selected = intersectingDates(dates, options.StartDate, options.EndDate, s.shardSlack())
+	}
+	shards, err := s.inspectExportShards(selected, options)
+	if err != nil {
+		return 0, err
+	}
+
+	columns := deduplicateStrings(options.Fields)
+	if len(columns) == 0 {
+		for field := range shards.columns {
+			columns = append(columns, field)
+		}
+		sort.Strings(columns)
+	}
+	if err := start(columns); err != nil {
+		return 0, err
+	}
+	if len(shards.keys) == 0 {
+		return 0, nil
+	}
+
+	searchQuery := baseQuery
+	if shards.timestampsIndexed {
+		inclusive := true
+		timeQuery := query.NewDateRangeInclusiveQuery(options.StartDate, options.EndDate, &inclusive, &inclusive)
+		timeQuery.SetField("timestamp")
+		searchQuery = bleve.NewConjunctionQuery(searchQuery, timeQuery)
+	}
+	requestFields := []string{"*"}
+	if len(options.Fields) > 0 {
+		requestFields = append([]string{"timestamp"}, columns...)
+	}
+	rows := 0
+	var searchAfter []string
+	for {
+		if err := ctx.Err(); err != nil {
+			return rows, err
+		}
+		request := bleve.NewSearchRequest(searchQuery)
+		request.Size = searchScanBatchSize
+		request.Fields = requestFields
+		request.SortByCustom(pageSortOrder(options.SortBy, options.SortOrder))
+		if len(searchAfter) > 0 {
+			request.SetSearchAfter(searchAfter)
+		}
+		result, err := s.searchExportBatch(ctx, shards.keys, request)
+		if err != nil {
+			return rows, err
+		}
+		for _, hit := range result.Hits {
+			log, timestamp, ok := pageHitToLog(hit.ID, hit.Fields)
+			if !ok || timestamp.Before(options.StartDate) || timestamp.After(options.EndDate) {
+				continue
+			}
+			if len(options.Fields) > 0 {
+				for field := range log {
+					if !slices.Contains(columns, field) {
+						delete(log, field)
+					}
+				}
+			}
+			if err := emit(log); err != nil {
+				return rows, err
+			}
+			rows++
+		}
+		if len(result.Hits) < request.Size {
+			return rows, nil
+		}
+		searchAfter = hitSortKey(result.Hits[len(result.Hits)-1])
+		if len(searchAfter) == 0 {
+			return rows, nil
+		}
+	}
+}
+
+// inspectExportShards pins the selected shards just long enough to learn
+// whether they all index timestamps, which columns they hold and whether
+// options.SortBy can be streamed from them.
+func (s *Storage) inspectExportShards(selected []string, options ExportOptions) (exportShards, error) {
+	shards := exportShards{timestampsIndexed: true, columns: map[string]struct{}{"_id": {}}}
+	indexes := make([]bleve.Index, 0, len(selected))
+	for _, date := range selected {
+		index, release, err := s.acquireIndex(date, false)
+		if errors.Is(err, errShardNotFound) {
+			continue // removed since List
+		}
+		if err != nil {
+			return shards, fmt.Errorf("failed to get index for date %s: %w", date, err)
+		}
+		defer release()
+		indexes = append(indexes, index)
+		shards.keys = append(shards.keys, date)
+		if !timestampIsIndexed(index) {
+			shards.timestampsIndexed = false
+		}
+		if len(options.Fields) > 0 {
+			continue
+		}
+		fields, err := index.Fields()
+		if err != nil {
+			return shards, fmt.Errorf("failed to list fields for date %s: %w", date, err)
+		}
+		for _, field := range fields {
+			if field != "_seq" && field != "_all" && field != fingerprintField {
+				shards.columns[field] = struct{}{}
+			}
+		}
+	}
+	if options.SortBy != "timestamp" && len(indexes) > 0 {
+		sortable, err := s.docValueSortable(shards.keys, indexes, options.SortBy)
+		if err != nil {
+			return shards, err
+		}
+		if !sortable {
+			return shards, fmt.Errorf("%w: %s is sorted by stored value, which cannot be streamed; sort by timestamp or a number, keyword, date or boolean field", ErrInvalidExport, options.SortBy)
+		}
+	}
+	return shards, nil
+}
+
+// searchExportBatch runs one search-after batch of an export over the
+// shards still on disk, releasing them before the hits are returned.
+func (s *Storage) searchExportBatch(ctx context.Context, keys []string, request *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	indexes := make([]bleve.Index, 0, len(keys))
+	for _, key := range keys {
+		index, release, err := s.acquireIndex(key, false)
+		if errors.Is(err, errShardNotFound) {
+			continue // removed since the export started
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get index for date %s: %w", key, err)
+		}
+		defer release()
+		indexes = append(indexes, index)
+	}
+	if len(indexes) == 0 {
+		return &bleve.SearchResult{}, nil
+	}
+	return searchShards(ctx, bleve.NewIndexAlias(indexes...), request)
+}
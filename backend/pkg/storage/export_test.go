@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExportStreamsEveryRowInSortOrder(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	logs := make([]map[string]interface{}, 0, searchScanBatchSize+5)
+	for i := 0; i < searchScanBatchSize+5; i++ {
+		logs = append(logs, map[string]interface{}{
+			"timestamp": base.Add(time.Duration(i) * time.Minute),
+			"_raw":      "line",
+			"level":     "INFO",
+			"latency":   float64(i % 7),
+		})
+	}
+	if _, err := store.StoreWithIDs(logs, "app.log"); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+	options := ExportOptions{
+		StartDate: base.Add(2 * time.Minute),
+		EndDate:   base.Add(48 * time.Hour),
+		SortBy:    "timestamp",
+		SortOrder: "asc",
+		Fields:    []string{"timestamp", "latency"},
+	}
+
+	var columns []string
+	var previous time.Time
+	rows, err := store.Export(context.Background(), options,
+		func(selected []string) error {
+			columns = selected
+			return nil
+		},
+		func(log map[string]interface{}) error {
+			timestamp := log["timestamp"].(time.Time)
+			if timestamp.Before(previous) {
+				t.Fatalf("row at %s follows %s", timestamp, previous)
+			}
+			previous = timestamp
+			if len(log) != 2 || log["latency"] == nil {
+				t.Fatalf("row carries %v", log)
+			}
+			// A slow client must not keep shards pinned.
+			store.mu.RLock()
+			defer store.mu.RUnlock()
+			for key, shard := range store.indices {
+				if shard.refs != 0 {
+					t.Fatalf("shard %s pinned while a row is written", key)
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if rows != searchScanBatchSize+3 || !reflect.DeepEqual(columns, []string{"timestamp", "latency"}) {
+		t.Fatalf("rows = %d, columns = %v", rows, columns)
+	}
+
+	columns = nil
+	rows, err = store.Export(context.Background(), ExportOptions{StartDate: options.StartDate, EndDate: options.EndDate, SortOrder: "asc", Empty: true},
+		func(selected []string) error {
+			columns = selected
+			return nil
+		},
+		func(log map[string]interface{}) error {
+			t.Fatalf("an empty export emitted %v", log)
+			return nil
+		})
+	if err != nil || rows != 0 || !reflect.DeepEqual(columns, []string{"_id"}) {
+		t.Fatalf("empty export = %d rows, columns %v: %v", rows, columns, err)
+	}
+
+	stop := errors.New("client went away")
+	emitted := 0
+	rows, err = store.Export(context.Background(), options,
+		func([]string) error { return nil },
+		func(map[string]interface{}) error {
+			if emitted++; emitted == 3 {
+				return stop
+			}
+			return nil
+		})
+	if !errors.Is(err, stop) || rows != 2 {
+		t.Fatalf("expected the emit error after 2 rows, got %d, %v", rows, err)
+	}
+
+	options.SortBy = "_raw"
+	if _, err := store.Export(context.Background(), options, func([]string) error { return nil },
+		func(map[string]interface{}) error { return nil }); !errors.Is(err, ErrInvalidExport) {
+		t.Fatalf("expected ErrInvalidExport for a stored-value sort, got %v", err)
+	}
+}
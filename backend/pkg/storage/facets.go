@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/numeric"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+const (
+	// MaxFacetSize bounds the values one facet request returns.
+	MaxFacetSize = 1000
+
+	// facetOversample asks each shard for more terms than are returned, so a
+	// value ranked just below the cut in one shard still reaches the merge.
+	facetOversample = 4
+
+	// maxFacetScanValues bounds the distinct values a stored-value scan
+	// tracks. Values first seen after that are counted in Other.
+	maxFacetScanValues = 100_000
+)
+
+// FacetOptions selects the rows and field FieldFacet counts. Query, the time
+// range and Sources mean what they do for SearchPage.
+type FacetOptions struct {
+	Field     string
+	Query     string
+	StartDate time.Time
+	EndDate   time.Time
+	Sources   []string
+	Size      int // values to return; must be positive
+}
+
+// FacetValue is one value of a faceted field and the rows holding it.
+// Numbers are float64 and booleans bool; anything else is the stored text.
+type FacetValue struct {
+	Value interface{}
+	Count int
+}
+
+// FacetResult holds a field's most frequent values among the matching rows,
+// most frequent first. Rows holding several values count once per value.
+type FacetResult struct {
+	Field     string
+	Values    []FacetValue
+	Other     int // values outside the top Size
+	Missing   int // matching rows without the field
+	Total     int // matching rows
+	QueryTime time.Duration
+}
+
+// FieldFacet counts the values of options.Field among the rows SearchPage
+// would match. Shards written with the current layout that index the field
+// as numbers, keywords or booleans are counted from their terms by a Bleve
+// facet, whose whole terms are the values. Other shards, and text, dates and
+// internal fields, are counted from stored values by a scan: analyzed text
+// would count tokens, and dates and older numeric encodings index several
+// terms per value.
+func (s *Storage) FieldFacet(ctx context.Context, options FacetOptions) (FacetResult, error) {
+	started := time.Now()
+	result := FacetResult{Field: options.Field, Values: []FacetValue{}}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if options.Field == "" {
+		return result, fmt.Errorf("facet field is required")
+	}
+	if options.Size <= 0 || options.Size > MaxFacetSize {
+		return result, fmt.Errorf("facet size must be between 1 and %d", MaxFacetSize)
+	}
+	if options.EndDate.Before(options.StartDate) {
+		return result, nil
+	}
+	keywordFields, err := s.keywordFields()
+	if err != nil {
+		return result, err
+	}
+	baseQuery, err := buildPageQuery(options.Query, options.Sources, keywordFields, s.newRegexpBudget())
+	if err != nil {
+		return result, err
+	}
+
+	dates, err := s.List()
+	if err != nil {
+		return result, fmt.Errorf("failed to list existing dates: %w", err)
+	}
+	counts := newFacetCounts()
+	var termShards []bleve.Index
+	kind := ""
+	for _, date := range intersectingDates(dates, options.StartDate, options.EndDate, s.shardSlack()) {
+		index, release, err := s.acquireIndex(date, false)
+		if errors.Is(err, errShardNotFound) {
+			continue // removed since List
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to get index for date %s: %w", date, err)
+		}
+		defer release()
+		meta, err := s.shardMetadata(date, false)
+		if err != nil {
+			return result, fmt.Errorf("failed to read metadata for %s: %w", date, err)
+		}
+		if shardKind, ok := facetTermKind(meta, options.Field); ok && (kind == "" || shardKind == "" || shardKind == kind) {
+			if shardKind != "" {
+				kind = shardKind
+			}
+			termShards = append(termShards, index)
+			continue
+		}
+		if err := scanFacetValues(ctx, index, baseQuery, options, counts); err != nil {
+			return result, err
+		}
+	}
+
+	if len(termShards) > 0 {
+		if err := facetTerms(ctx, bleve.NewIndexAlias(termShards...), baseQuery, options, kind, counts); err != nil {
+			return result, err
+		}
+	}
+	counts.top(options.Size, &result)
+	result.QueryTime = time.Since(started)
+	return result, nil
+}
+
+// facetTermKind reports the kind of value a shard's terms for field can be
+// read back as, or "" when the shard does not index the field. It reports
+// false when the terms are not whole values.
+func facetTermKind(meta shardMeta, field string) (string, bool) {
+	if !meta.Current || !meta.KindsKnown || strings.HasPrefix(field, "_") || field == "timestamp" {
+		return "", false
+	}
+	kinds := meta.Kinds[field]
+	switch {
+	case len(kinds) == 0:
+		return "", true
+	case len(kinds) > 1:
+		return "", false
+	}
+	switch kinds[0] {
+	case kindKeyword, kindNumber, kindBool:
+		return kinds[0], true
+	}
+	return "", false
+}
+
+// facetTerms counts field's terms across shards that all index it as kind.
+func facetTerms(ctx context.Context, alias bleve.Index, baseQuery query.Query, options FacetOptions, kind string, counts *facetCounts) error {
+	inclusive := true
+	timeQuery := query.NewDateRangeInclusiveQuery(options.StartDate, options.EndDate, &inclusive, &inclusive)
+	timeQuery.SetField("timestamp")
+	request := bleve.NewSearchRequest(bleve.NewConjunctionQuery(baseQuery, timeQuery))
+	request.Size = 0
+	request.AddFacet("values", bleve.NewFacetRequest(options.Field, options.Size*facetOversample))
+	searchResult, err := searchShards(ctx, alias, request)
+	if err != nil {
+		return err
+	}
+	counts.total += int(searchResult.Total)
+	facet := searchResult.Facets["values"]
+	if facet == nil {
+		counts.missing += int(searchResult.Total)
+		return nil
+	}
+	counts.missing += facet.Missing
+	counts.other += facet.Other
+	if facet.Terms == nil {
+		return nil
+	}
+	for _, term := range facet.Terms.Terms() {
+		value, ok := facetTermValue(term.Term, kind)
+		if !ok {
+			counts.other += term.Count
+			continue
+		}
+		counts.add(value, term.Count)
+	}
+	return nil
+}
+
+// facetTermValue reads an indexed term back as the value it was indexed from.
+func facetTermValue(term, kind string) (interface{}, bool) {
+	switch kind {
+	case kindNumber:
+		coded := numeric.PrefixCoded(term)
+		if shift, err := coded.Shift(); err != nil || shift != 0 {
+			return nil, false
+		}
+		number, err := coded.Int64()
+		if err != nil {
+			return nil, false
+		}
+		return numeric.Int64ToFloat64(number), true
+	case kindBool:
+		return term == "T", term == "T" || term == "F"
+	}
+	return term, true
+}
+
+// scanFacetValues counts field's stored values among one shard's matches.
+func scanFacetValues(ctx context.Context, index bleve.Index, baseQuery query.Query, options FacetOptions, counts *facetCounts) error {
+	return scanShardMatches(ctx, index, baseQuery, options.StartDate, options.EndDate, []string{options.Field},
+		func(_ string, fields map[string]interface{}, _ time.Time) {
+			counts.total++
+			switch values := fields[options.Field].(type) {
+			case nil:
+				counts.missing++
+			case []interface{}:
+				for _, value := range values {
+					counts.add(value, 1)
+				}
+			default:
+				counts.add(values, 1)
+			}
+		})
+}
+
+// facetCounts accumulates a facet across shards.
+type facetCounts struct {
+	values  map[interface{}]int
+	other   int
+	missing int
+	total   int
+}
+
+func newFacetCounts() *facetCounts {
+	return &facetCounts{values: make(map[interface{}]int)}
+}
+
+func (c *facetCounts) add(value interface{}, count int) {
+	if _, ok := c.values[value]; !ok && len(c.values) >= maxFacetScanValues {
+		c.other += count
+		return
+	}
+	c.values[value] += count
+}
+
+// top fills result with the size most frequent values, ties broken by
+// value, counting the rest in Other.
+func (c *facetCounts) top(size int, result *FacetResult) {
+	values := make([]FacetValue, 0, len(c.values))
+	for value, count := range c.values {
+		values = append(values, FacetValue{Value: value, Count: count})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return compareStoredValues(values[i].Value, values[j].Value, false) < 0
+	})
+	result.Other = c.other
+	if len(values) > size {
+		for _, value := range values[size:] {
+			result.Other += value.Count
+		}
+		values = values[:size]
+	}
+	result.Values = append(result.Values, values...)
+	result.Missing = c.missing
+	result.Total = c.total
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+)
+
+func TestFieldFacetCountsCurrentAndLegacyShards(t *testing.T) {
+	dir := t.TempDir()
+	legacyDay := time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC)
+	indexMapping := buildIndexMapping().(*mapping.IndexMappingImpl)
+	indexMapping.DefaultMapping.Properties["timestamp"].Fields[0].Index = false
+	legacy, err := bleve.New(filepath.Join(dir, "logs-2024-01-16.bleve"), indexMapping)
+	if err != nil {
+		t.Fatalf("create legacy index: %v", err)
+	}
+	if err := legacy.Close(); err != nil {
+		t.Fatalf("close legacy index: %v", err)
+	}
+	store := &Storage{baseDir: dir}
+	if _, err := store.StoreWithIDs([]map[string]interface{}{
+		{"timestamp": legacyDay.Add(time.Hour), "_raw": "l1", "status": "200", "level": "ERROR"},
+		{"timestamp": legacyDay.Add(2 * time.Hour), "_raw": "l2", "status": "500", "level": "ERROR"},
+		{"timestamp": legacyDay.Add(20 * time.Hour), "_raw": "late", "status": "500", "level": "ERROR"},
+	}, "app.log"); err != nil {
+		t.Fatalf("store legacy logs: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close legacy store: %v", err)
+	}
+
+	// The current shard is written by a fresh Storage so it gets the current
+	// layout and recorded kinds.
+	current, err := NewStorage(dir)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	t.Cleanup(func() { _ = current.Close() })
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	if _, err := current.StoreWithOptions([]map[string]interface{}{
+		{"timestamp": day.Add(time.Hour), "_raw": "c1", "status": "200", "level": "ERROR", "path": "/a"},
+		{"timestamp": day.Add(2 * time.Hour), "_raw": "c2", "status": "200", "level": "Info", "path": "/a"},
+		{"timestamp": day.Add(3 * time.Hour), "_raw": "c3", "status": "404", "path": "/b"},
+	}, "app.log", StoreOptions{Schema: FieldSchema{"path": {Type: FieldKeyword}}}); err != nil {
+		t.Fatalf("store current logs: %v", err)
+	}
+
+	facet := func(field string, size int) FacetResult {
+		t.Helper()
+		result, err := current.FieldFacet(context.Background(), FacetOptions{
+			Field:     field,
+			StartDate: day,
+			EndDate:   legacyDay.Add(12 * time.Hour),
+			Size:      size,
+		})
+		if err != nil {
+			t.Fatalf("FieldFacet(%s): %v", field, err)
+		}
+		return result
+	}
+
+	// Numbers merge across the term facet and the legacy scan, and the row
+	// outside the time range is not counted.
+	status := facet("status", 2)
+	if want := []FacetValue{{Value: float64(200), Count: 3}, {Value: float64(404), Count: 1}}; !reflect.DeepEqual(status.Values, want) {
+		t.Fatalf("status values = %#v", status.Values)
+	}
+	if status.Other != 1 || status.Missing != 0 || status.Total != 5 {
+		t.Fatalf("status other/missing/total = %d/%d/%d", status.Other, status.Missing, status.Total)
+	}
+
+	// Text keeps the stored value rather than the analyzed token.
+	level := facet("level", 10)
+	if want := []FacetValue{{Value: "ERROR", Count: 3}, {Value: "Info", Count: 1}}; !reflect.DeepEqual(level.Values, want) {
+		t.Fatalf("level values = %#v", level.Values)
+	}
+	if level.Missing != 1 {
+		t.Fatalf("level missing = %d, want 1", level.Missing)
+	}
+
+	path := facet("path", 10)
+	if want := []FacetValue{{Value: "/a", Count: 2}, {Value: "/b", Count: 1}}; !reflect.DeepEqual(path.Values, want) {
+		t.Fatalf("path values = %#v", path.Values)
+	}
+	if path.Missing != 2 {
+		t.Fatalf("path missing = %d, want 2", path.Missing)
+	}
+
+	filtered, err := current.FieldFacet(context.Background(), FacetOptions{
+		Field:     "status",
+		Query:     "level:error",
+		StartDate: day,
+		EndDate:   legacyDay.Add(12 * time.Hour),
+		Size:      10,
+	})
+	if err != nil {
+		t.Fatalf("FieldFacet with query: %v", err)
+	}
+	if want := []FacetValue{{Value: float64(200), Count: 2}, {Value: float64(500), Count: 1}}; !reflect.DeepEqual(filtered.Values, want) {
+		t.Fatalf("filtered status values = %#v", filtered.Values)
+	}
+}
@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultCatalogSamples and MaxCatalogSamples bound the top values
+	// FieldCatalog returns per field.
+	DefaultCatalogSamples = 5
+	MaxCatalogSamples     = 50
+
+	// CatalogScanLimit bounds the rows one FieldCatalog call reads; counts
+	// are over the rows read, and the result is marked truncated past it.
+	CatalogScanLimit = 200_000
+
+	// catalogMaxValues bounds the distinct values counted exactly per field.
+	// Past it, distinct counts come from a HyperLogLog sketch and values
+	// first seen later are left out of the top values.
+	catalogMaxValues = 10_000
+
+	// catalogSketchBits sets the sketch's 2^bits registers, for a standard
+	// error of about 1.6%.
+	catalogSketchBits = 12
+
+	// catalogMixedType is reported for fields indexed as several kinds.
+	catalogMixedType = "mixed"
+)
+
+// FieldCatalogOptions selects the rows FieldCatalog describes, as SearchPage
+// takes them.
+type FieldCatalogOptions struct {
+	Query     string
+	StartDate time.Time
+	EndDate   time.Time
+	Sources   []string
+	Samples   int // top values per field; defaults to DefaultCatalogSamples
+}
+
+// FieldStats describes one field among the rows read.
+type FieldStats struct {
+	Name          string
+	Type          string   // text, keyword, number, bool, date, or mixed when Types has several
+	Types         []string // kinds the field is indexed as, sorted
+	Count         int      // rows holding the field
+	Distinct      int      // distinct values, estimated past catalogMaxValues
+	DistinctExact bool
+	Min, Max, Avg *float64 // over numeric values, when there are any
+	TopValues     []FacetValue
+}
+
+// FieldCatalogResult describes every field of the matching rows, by name.
+type FieldCatalogResult struct {
+	Fields    []FieldStats
+	Total     int  // rows read
+	Truncated bool // CatalogScanLimit rows were read and the rest skipped
+	QueryTime time.Duration
+}
+
+// FieldCatalog reads the stored values of the rows a query matches and
+// reports, per field, the kinds it is indexed as, how many rows hold it,
+// how many distinct values it has, the range and mean of its numbers and
+// its most frequent values. Kinds come from shard metadata; fields of
+// shards without it get the kind of their stored values.
+func (s *Storage) FieldCatalog(ctx context.Context, options FieldCatalogOptions) (FieldCatalogResult, error) {
+	started := time.Now()
+	result := FieldCatalogResult{Fields: []FieldStats{}}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if options.Samples == 0 {
+		options.Samples = DefaultCatalogSamples
+	}
+	if options.Samples < 0 || options.Samples > MaxCatalogSamples {
+		return result, fmt.Errorf("samples must be between 1 and %d", MaxCatalogSamples)
+	}
+	if options.EndDate.Before(options.StartDate) {
+		result.QueryTime = time.Since(started)
+		return result, nil
+	}
+	keywordFields, err := s.keywordFields()
+	if err != nil {
+		return result, err
+	}
+	baseQuery, err := buildPageQuery(options.Query, options.Sources, keywordFields, s.newRegexpBudget())
+	if err != nil {
+		return result, err
+	}
+
+	dates, err := s.List()
+	if err != nil {
+		return result, fmt.Errorf("failed to list existing dates: %w", err)
+	}
+	seed := maphash.MakeSeed()
+	stats := make(map[string]*fieldAccumulator)
+	kinds := make(map[string]map[string]bool)
+	// Past CatalogScanLimit the scan is stopped by canceling its context.
+	scanCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	for _, date := range intersectingDates(dates, options.StartDate, options.EndDate, s.shardSlack()) {
+		index, release, err := s.acquireIndex(date, false)
+		if errors.Is(err, errShardNotFound) {
+			continue // removed since List
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to get index for date %s: %w", date, err)
+		}
+		meta, err := s.shardMetadata(date, false)
+		if err != nil {
+			release()
+			return result, fmt.Errorf("failed to read metadata for %s: %w", date, err)
+		}
+		shardKinds := meta.Kinds
+		if !meta.KindsKnown {
+			shardKinds = nil
+		}
+		err = scanShardMatches(scanCtx, index, baseQuery, options.StartDate, options.EndDate, []string{"*"},
+			func(_ string, fields map[string]interface{}, _ time.Time) {
+				if result.Truncated {
+					return
+				}
+				if result.Total >= CatalogScanLimit {
+					result.Truncated = true
+					stop()
+					return
+				}
+				result.Total++
+				for field, value := range fields {
+					if value == nil || field == "timestamp" || field == "_seq" || field == "_id" || field == fingerprintField {
+						continue
+					}
+					accumulator := stats[field]
+					if accumulator == nil {
+						accumulator = newFieldAccumulator(seed)
+						stats[field] = accumulator
+						kinds[field] = make(map[string]bool)
+					}
+					accumulator.addRow(value)
+					if known := shardKinds[field]; len(known) > 0 {
+						for _, kind := range known {
+							kinds[field][kind] = true
+						}
+					} else {
+						kinds[field][storedValueKind(value)] = true
+					}
+				}
+			})
+		release()
+		if result.Truncated && ctx.Err() == nil {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	for field, accumulator := range stats {
+		described := accumulator.describe(field, options.Samples)
+		for kind := range kinds[field] {
+			described.Types = append(described.Types, kind)
+		}
+		sort.Strings(described.Types)
+		described.Type = catalogMixedType
+		if len(described.Types) == 1 {
+			described.Type = described.Types[0]
+		}
+		result.Fields = append(result.Fields, described)
+	}
+	sort.Slice(result.Fields, func(i, j int) bool { return result.Fields[i].Name < result.Fields[j].Name })
+	result.QueryTime = time.Since(started)
+	return result, nil
+}
+
+// storedValueKind guesses the kind a stored value was indexed as, for
+// shards and internal fields that did not record it.
+func storedValueKind(value interface{}) string {
+	if values, ok := value.([]interface{}); ok && len(values) > 0 {
+		value = values[0]
+	}
+	switch value.(type) {
+	case float64:
+		return kindNumber
+	case bool:
+		return kindBool
+	}
+	return kindText
+}
+
+// fieldAccumulator gathers one field's statistics while rows are read.
+type fieldAccumulator struct {
+	rows     int
+	values   map[interface{}]int
+	overflow bool
+	sketch   *distinctSketch
+
+	numbers  int
+	sum      float64
+	min, max float64
+}
+
+func newFieldAccumulator(seed maphash.Seed) *fieldAccumulator {
+	return &fieldAccumulator{values: make(map[interface{}]int), sketch: newDistinctSketch(seed)}
+}
+
+// addRow counts one row's value, each element of a multi-valued field once.
+func (a *fieldAccumulator) addRow(value interface{}) {
+	a.rows++
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+		a.sketch.add(value)
+		if _, seen := a.values[value]; seen || len(a.values) < catalogMaxValues {
+			a.values[value]++
+		} else {
+			a.overflow = true
+		}
+		if number, ok := value.(float64); ok {
+			if a.numbers == 0 || number < a.min {
+				a.min = number
+			}
+			if a.numbers == 0 || number > a.max {
+				a.max = number
+			}
+			a.numbers++
+			a.sum += number
+		}
+	}
+}
+
+func (a *fieldAccumulator) describe(field string, samples int) FieldStats {
+	stats := FieldStats{Name: field, Count: a.rows, Distinct: len(a.values), DistinctExact: !a.overflow, TopValues: []FacetValue{}}
+	if a.overflow {
+		stats.Distinct = max(a.sketch.estimate(), len(a.values))
+	}
+	if a.numbers > 0 {
+		minimum, maximum, mean := a.min, a.max, a.sum/float64(a.numbers)
+		stats.Min, stats.Max, stats.Avg = &minimum, &maximum, &mean
+	}
+	for value, count := range a.values {
+		stats.TopValues = append(stats.TopValues, FacetValue{Value: value, Count: count})
+	}
+	sort.Slice(stats.TopValues, func(i, j int) bool {
+		if stats.TopValues[i].Count != stats.TopValues[j].Count {
+			return stats.TopValues[i].Count > stats.TopValues[j].Count
+		}
+		return compareStoredValues(stats.TopValues[i].Value, stats.TopValues[j].Value, false) < 0
+	})
+	stats.TopValues = stats.TopValues[:min(len(stats.TopValues), samples)]
+	return stats
+}
+
+// distinctSketch estimates how many distinct values it was given, in fixed
+// memory, with HyperLogLog.
+type distinctSketch struct {
+	seed      maphash.Seed
+	registers []uint8
+}
+
+func newDistinctSketch(seed maphash.Seed) *distinctSketch {
+	return &distinctSketch{seed: seed, registers: make([]uint8, 1<<catalogSketchBits)}
+}
+
+func (d *distinctSketch) add(value interface{}) {
+	var key string
+	switch typed := value.(type) {
+	case string:
+		key = "s" + typed
+	case float64:
+		key = "n" + strconv.FormatFloat(typed, 'g', -1, 64)
+	default:
+		key = fmt.Sprintf("%T:%v", typed, typed)
+	}
+	hash := maphash.String(d.seed, key)
+	register := hash >> (64 - catalogSketchBits)
+	// The sentinel bit bounds the rank when the remaining bits are zero.
+	rank := uint8(bits.LeadingZeros64(hash<<catalogSketchBits|1<<(catalogSketchBits-1)) + 1)
+	if rank > d.registers[register] {
+		d.registers[register] = rank
+	}
+}
+
+func (d *distinctSketch) estimate() int {
+	m := float64(len(d.registers))
+	sum, zeros := 0.0, 0
+	for _, rank := range d.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Small cardinalities are counted more closely from empty registers.
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int(math.Round(estimate))
+}
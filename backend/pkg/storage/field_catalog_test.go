@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFieldCatalogDescribesMatchingFields(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	logs := []map[string]interface{}{
+		{"timestamp": base, "_raw": "a", "_src": "app.log", "level": "info", "latency": "10"},
+		{"timestamp": base.Add(time.Second), "_raw": "b", "_src": "app.log", "level": "info", "latency": "30"},
+		{"timestamp": base.Add(2 * time.Second), "_raw": "c", "_src": "app.log", "level": "error", "latency": "50", "user": "alice"},
+		{"timestamp": base.Add(48 * time.Hour), "_raw": "d", "_src": "app.log", "level": "debug", "latency": "1000"},
+	}
+	if _, err := store.StoreWithIDs(logs, "app.log"); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+
+	result, err := store.FieldCatalog(context.Background(), FieldCatalogOptions{
+		StartDate: base,
+		EndDate:   base.Add(time.Hour),
+		Samples:   1,
+	})
+	if err != nil {
+		t.Fatalf("FieldCatalog: %v", err)
+	}
+	if result.Total != 3 || result.Truncated {
+		t.Fatalf("result = %+v", result)
+	}
+	byName := map[string]FieldStats{}
+	var names []string
+	for _, field := range result.Fields {
+		byName[field.Name] = field
+		names = append(names, field.Name)
+	}
+	if fmt.Sprint(names) != "[_raw _src latency level user]" {
+		t.Fatalf("fields = %v", names)
+	}
+	latency := byName["latency"]
+	if latency.Type != kindNumber || latency.Count != 3 || latency.Distinct != 3 || !latency.DistinctExact ||
+		latency.Min == nil || *latency.Min != 10 || *latency.Max != 50 || *latency.Avg != 30 {
+		t.Fatalf("latency = %+v", latency)
+	}
+	level := byName["level"]
+	if level.Type != kindText || level.Count != 3 || level.Distinct != 2 || level.Min != nil ||
+		len(level.TopValues) != 1 || level.TopValues[0].Value != "info" || level.TopValues[0].Count != 2 {
+		t.Fatalf("level = %+v", level)
+	}
+	if user := byName["user"]; user.Count != 1 || user.Distinct != 1 {
+		t.Fatalf("user = %+v", user)
+	}
+}
+
+func TestDistinctSketchEstimatesLargeCardinalities(t *testing.T) {
+	accumulator := newFieldAccumulator(maphash.MakeSeed())
+	for i := 0; i < 50_000; i++ {
+		accumulator.addRow(fmt.Sprintf("user-%d", i))
+	}
+	stats := accumulator.describe("user", 3)
+	if stats.DistinctExact {
+		t.Fatalf("distinct count should be estimated past %d values", catalogMaxValues)
+	}
+	if math.Abs(float64(stats.Distinct)-50_000)/50_000 > 0.05 {
+		t.Fatalf("distinct estimate = %d, want about 50000", stats.Distinct)
+	}
+}
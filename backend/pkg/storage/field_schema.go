@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/blevesearch/bleve/v2/analysis"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/single"
+	"github.com/blevesearch/bleve/v2/document"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+	index "github.com/blevesearch/bleve_index_api"
+)
+
+// FieldType is the declared type of a parsed field. Without a declaration a
+// field keeps the dynamic mapping, where any string that parses as a number
+// is indexed as one; a field that is usually numeric but sometimes "N/A"
+// then mixes kinds across documents and shards.
+type FieldType string
+
+const (
+	FieldKeyword FieldType = "keyword" // the whole value as one exact term
+	FieldText    FieldType = "text"    // tokenized for full-text search
+	FieldLong    FieldType = "long"
+	FieldDouble  FieldType = "double"
+	FieldBool    FieldType = "bool"
+	FieldIP      FieldType = "ip" // normalized address, one exact term
+	FieldDate    FieldType = "date"
+)
+
+// FieldTypes lists every declarable type.
+var FieldTypes = []FieldType{FieldKeyword, FieldText, FieldLong, FieldDouble, FieldBool, FieldIP, FieldDate}
+
+// ParseFieldType validates a type name.
+func ParseFieldType(value string) (FieldType, error) {
+	for _, fieldType := range FieldTypes {
+		if strings.EqualFold(strings.TrimSpace(value), string(fieldType)) {
+			return fieldType, nil
+		}
+	}
+	return "", fmt.Errorf("unknown field type %q", value)
+}
+
+// FieldSpec declares how one field is stored.
+type FieldSpec struct {
+	Type       FieldType
+	StoredOnly bool // keep the value for display but leave it out of the index
+}
+
+// FieldSchema maps field names to their declarations. Names starting with
+// an underscore and "timestamp" are internal and never declared.
+type FieldSchema map[string]FieldSpec
+
+// Value kinds recorded per field in shard metadata. They follow what the
+// index holds, so long and double are both "number" and ip is "keyword".
+const (
+	kindText    = "text"
+	kindKeyword = "keyword"
+	kindNumber  = "number"
+	kindBool    = "bool"
+	kindDate    = "date"
+)
+
+// exactTermAnalyzer indexes a whole value as one term. It is shared so
+// fieldKind can tell keyword fields from analyzed text.
+var exactTermAnalyzer analysis.Analyzer = &analysis.DefaultAnalyzer{Tokenizer: single.NewSingleTokenTokenizer()}
+
+// convert returns value as the spec's type, or false when it does not parse.
+func (spec FieldSpec) convert(value interface{}) (interface{}, bool) {
+	switch spec.Type {
+	case FieldKeyword, FieldText:
+		return stringValue(value), true
+	case FieldLong:
+		switch v := value.(type) {
+		case int64:
+			return v, true
+		case int:
+			return int64(v), true
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+				return int64(v), true
+			}
+		case string:
+			n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			return n, err == nil
+		}
+	case FieldDouble:
+		switch v := value.(type) {
+		case float64:
+			return v, true
+		case int64:
+			return float64(v), true
+		case int:
+			return float64(v), true
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			return f, err == nil && !math.IsNaN(f) && !math.IsInf(f, 0)
+		}
+	case FieldBool:
+		switch v := value.(type) {
+		case bool:
+			return v, true
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			return b, err == nil
+		}
+	case FieldIP:
+		if v, ok := value.(string); ok {
+			addr, err := netip.ParseAddr(strings.TrimSpace(v))
+			return addr.Unmap().String(), err == nil
+		}
+	case FieldDate:
+		switch v := value.(type) {
+		case time.Time:
+			return v, true
+		case string:
+			t, err := dateparse.ParseAny(strings.TrimSpace(v))
+			return t, err == nil
+		}
+	}
+	return nil, false
+}
+
+func stringValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// addDeclaredField appends value to doc as the spec's type. A value the type
+// refuses is kept as stored-only text so the row still shows it, and false is
+// returned.
+func addDeclaredField(doc *document.Document, indexMapping mapping.IndexMapping, name string, spec FieldSpec, value interface{}) (bool, error) {
+	converted, ok := spec.convert(value)
+	if !ok {
+		doc.AddField(document.NewTextFieldWithIndexingOptions(name, nil, []byte(stringValue(value)), index.StoreField))
+		return false, nil
+	}
+
+	options := index.StoreField
+	if !spec.StoredOnly {
+		options |= index.IndexField | index.SkipFreqNorm
+	}
+	switch spec.Type {
+	case FieldKeyword, FieldIP:
+		doc.AddField(document.NewTextFieldCustom(name, nil, []byte(converted.(string)), options, exactTermAnalyzer))
+	case FieldText:
+		doc.AddField(document.NewTextFieldCustom(name, nil, []byte(converted.(string)), options, indexMapping.AnalyzerNamed(standard.Name)))
+	case FieldLong:
+		doc.AddField(newCompactNumericField(name, nil, float64(converted.(int64)), options))
+	case FieldDouble:
+		doc.AddField(newCompactNumericField(name, nil, converted.(float64), options))
+	case FieldBool:
+		doc.AddField(document.NewBooleanFieldWithIndexingOptions(name, nil, converted.(bool), options))
+	case FieldDate:
+		field, err := document.NewDateTimeFieldWithIndexingOptions(name, nil, converted.(time.Time), time.RFC3339, options)
+		if err != nil {
+			return false, fmt.Errorf("build date field %q: %w", name, err)
+		}
+		doc.AddField(field)
+	}
+	return true, nil
+}
+
+// addDocumentKinds records the kind of every indexed field of doc.
+func addDocumentKinds(kinds map[string][]string, doc *document.Document) {
+	for _, field := range doc.Fields {
+		name := field.Name()
+		if !field.Options().IsIndexed() || name == "timestamp" || strings.HasPrefix(name, "_") {
+			continue
+		}
+		kinds[name] = mergeSorted(kinds[name], []string{fieldKind(field)})
+	}
+}
+
+func fieldKind(field document.Field) string {
+	switch typed := field.(type) {
+	case *document.TextField:
+		if typed.Analyzer() == exactTermAnalyzer {
+			return kindKeyword
+		}
+		return kindText
+	case *compactNumericField, *document.NumericField:
+		return kindNumber
+	case *document.BooleanField:
+		return kindBool
+	case *document.DateTimeField:
+		return kindDate
+	}
+	return kindText
+}
+
+// FieldTypeConflict is a field whose values are not indexed consistently.
+type FieldTypeConflict struct {
+	Field    string
+	Kinds    map[string]int // shards indexing the field as each kind of value
+	Rejected uint64         // values kept stored-only because their declared type refused them
+}
+
+// FieldTypeReport lists the fields indexed as more than one kind of value, or
+// holding values their declared type refused. Shards written before kinds
+// were recorded are counted as unchecked; rows added to them since are still
+// inspected.
+type FieldTypeReport struct {
+	Conflicts       []FieldTypeConflict
+	UncheckedShards int
+}
+
+// FieldTypeConflicts reports inconsistently indexed fields from shard
+// metadata.
+func (s *Storage) FieldTypeConflicts() (FieldTypeReport, error) {
+	report := FieldTypeReport{Conflicts: []FieldTypeConflict{}}
+	keys, err := s.List()
+	if err != nil {
+		return report, err
+	}
+
+	fields := make(map[string]*FieldTypeConflict)
+	entry := func(field string) *FieldTypeConflict {
+		if fields[field] == nil {
+			fields[field] = &FieldTypeConflict{Field: field, Kinds: map[string]int{}}
+		}
+		return fields[field]
+	}
+	for _, key := range keys {
+		meta, err := s.shardMetadata(key, false)
+		if err != nil {
+			continue // removed since List, or unreadable; /info reports neither
+		}
+		if !meta.KindsKnown {
+			report.UncheckedShards++
+		}
+		for field, kinds := range meta.Kinds {
+			for _, kind := range kinds {
+				entry(field).Kinds[kind]++
+			}
+		}
+		for field, rejected := range meta.Rejected {
+			entry(field).Rejected += rejected
+		}
+	}
+
+	for _, conflict := range fields {
+		if len(conflict.Kinds) > 1 || conflict.Rejected > 0 {
+			report.Conflicts = append(report.Conflicts, *conflict)
+		}
+	}
+	sort.Slice(report.Conflicts, func(i, j int) bool { return report.Conflicts[i].Field < report.Conflicts[j].Field })
+	return report, nil
+}
+
+// keywordFields lists the fields any shard indexes as exact terms, so query
+// strings can match them without text analysis.
+func (s *Storage) keywordFields() (map[string]bool, error) {
+	keys, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fields := make(map[string]bool)
+	for _, key := range keys {
+		meta := s.loadMetaLocked(key)
+		if meta == nil {
+			continue
+		}
+		for field, kinds := range meta.Kinds {
+			if i := sort.SearchStrings(kinds, kindKeyword); i < len(kinds) && kinds[i] == kindKeyword {
+				fields[field] = true
+			}
+		}
+	}
+	return fields, nil
+}
+
+// useExactTerms makes field-qualified matches on keyword fields compare
+// whole values instead of analyzed tokens.
+func useExactTerms(input query.Query, fields map[string]bool) {
+	switch typed := input.(type) {
+	case *query.MatchQuery:
+		if fields[typed.FieldVal] {
+			typed.Analyzer = keyword.Name
+		}
+	case *storedPhraseQuery:
+		if fields[typed.field] {
+			typed.analyzer = keyword.Name
+		}
+	case *query.ConjunctionQuery:
+		for _, child := range typed.Conjuncts {
+			useExactTerms(child, fields)
+		}
+	case *query.DisjunctionQuery:
+		for _, child := range typed.Disjuncts {
+			useExactTerms(child, fields)
+		}
+	case *query.BooleanQuery:
+		for _, child := range []query.Query{typed.Must, typed.Should, typed.MustNot, typed.Filter} {
+			if child != nil {
+				useExactTerms(child, fields)
+			}
+		}
+	}
+}
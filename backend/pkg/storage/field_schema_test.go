@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStoreWithOptions_IndexesDeclaredTypes(t *testing.T) {
+	store, dir := setupTestStorage(t)
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	schema := FieldSchema{
+		"status": {Type: FieldLong},
+		"zip":    {Type: FieldKeyword},
+		"method": {Type: FieldKeyword},
+		"client": {Type: FieldIP},
+		"note":   {Type: FieldText, StoredOnly: true},
+	}
+	logs := []map[string]interface{}{
+		{"timestamp": day.Add(time.Hour), "_raw": "a", "_src": "nginx", "status": "200", "zip": "01234", "method": "GET", "client": "10.0.0.1", "note": "first"},
+		{"timestamp": day.Add(2 * time.Hour), "_raw": "b", "_src": "nginx", "status": "N/A", "zip": "99999", "method": "POST", "client": "::ffff:10.0.0.2", "note": "second"},
+	}
+	if _, err := store.StoreWithOptions(logs, "nginx", StoreOptions{Schema: schema}); err != nil {
+		t.Fatalf("StoreWithOptions: %v", err)
+	}
+
+	search := func(query string) []map[string]interface{} {
+		t.Helper()
+		result, err := store.SearchPage(context.Background(), SearchOptions{
+			Query:     query,
+			StartDate: day,
+			EndDate:   day.AddDate(0, 0, 1),
+			Limit:     10,
+			SortBy:    "timestamp",
+			SortOrder: "asc",
+		})
+		if err != nil {
+			t.Fatalf("SearchPage(%q): %v", query, err)
+		}
+		return result.Logs
+	}
+	if rows := search("status:>=100"); len(rows) != 1 || rows[0]["_raw"] != "a" {
+		t.Fatalf("numeric range on a declared long: %v", rows)
+	}
+	if rows := search("zip:01234"); len(rows) != 1 || rows[0]["zip"] != "01234" {
+		t.Fatalf("keyword zip must keep its leading zero: %v", rows)
+	}
+	if rows := search("method:GET"); len(rows) != 1 {
+		t.Fatalf("keyword match must compare the whole value: %v", rows)
+	}
+	if rows := search("note:first"); len(rows) != 0 {
+		t.Fatalf("stored-only fields are not searchable: %v", rows)
+	}
+	rows := search("")
+	if len(rows) != 2 || rows[1]["status"] != "N/A" || rows[0]["note"] != "first" {
+		t.Fatalf("refused and stored-only values must still be shown: %v", rows)
+	}
+	if rows := search(`client:"10.0.0.2"`); len(rows) != 1 {
+		t.Fatalf("ip values are stored normalized: %v", rows)
+	}
+
+	// The refused value is reported, and the report survives a restart.
+	store.Close()
+	reopened, err := NewStorage(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	report, err := reopened.FieldTypeConflicts()
+	if err != nil {
+		t.Fatalf("FieldTypeConflicts: %v", err)
+	}
+	if report.UncheckedShards != 0 || len(report.Conflicts) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if conflict := report.Conflicts[0]; conflict.Field != "status" || conflict.Rejected != 1 || conflict.Kinds["number"] != 1 {
+		t.Fatalf("unexpected conflict %+v", conflict)
+	}
+}
+
+func TestFieldTypeConflicts_FlagsMixedDynamicKinds(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	for i, status := range []string{"200", "N/A", "404"} {
+		row := map[string]interface{}{"timestamp": day.AddDate(0, 0, i), "_raw": status, "_src": "nginx", "status": status, "path": "/"}
+		if err := store.Store([]map[string]interface{}{row}, "nginx"); err != nil {
+			t.Fatalf("store: %v", err)
+		}
+	}
+
+	report, err := store.FieldTypeConflicts()
+	if err != nil {
+		t.Fatalf("FieldTypeConflicts: %v", err)
+	}
+	if len(report.Conflicts) != 1 {
+		t.Fatalf("expected only status to conflict, got %+v", report.Conflicts)
+	}
+	if conflict := report.Conflicts[0]; conflict.Field != "status" || conflict.Kinds["number"] != 2 || conflict.Kinds["text"] != 1 {
+		t.Fatalf("unexpected conflict %+v", conflict)
+	}
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2/analysis"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// Highlights maps the fields of a row that a query matched to the byte
+// ranges [start, end) of their stored values that matched, in order and
+// without overlaps. A field matched as a whole, such as a number inside a
+// range, has no ranges.
+type Highlights map[string][][2]int
+
+// highlightClause finds where one query term matched a stored value.
+type highlightClause struct {
+	field string // empty for every field, as allFieldsQuery searches
+	match func(field string, value interface{}) ([][2]int, bool)
+}
+
+// highlighter locates a query's matches in rows' stored values. Term
+// vectors are not indexed (see buildOptimizedDocument), so values are
+// analyzed again the way the index analyzed them and the resulting token
+// offsets compared with the query's terms.
+type highlighter struct {
+	indexMapping  mapping.IndexMapping
+	keywordFields map[string]bool
+	clauses       []highlightClause
+}
+
+func newHighlighter(searchQuery query.Query, keywordFields map[string]bool) *highlighter {
+	h := &highlighter{indexMapping: buildIndexMapping(), keywordFields: keywordFields}
+	h.collect(searchQuery)
+	return h
+}
+
+// highlight returns where the query matched row.
+func (h *highlighter) highlight(row map[string]interface{}) Highlights {
+	highlights := Highlights{}
+	for _, clause := range h.clauses {
+		fields := []string{clause.field}
+		if clause.field == "" {
+			fields = fields[:0]
+			for field := range row {
+				if field != "_id" && field != "timestamp" {
+					fields = append(fields, field)
+				}
+			}
+		}
+		for _, field := range fields {
+			value, ok := row[field]
+			if !ok {
+				continue
+			}
+			if ranges, matched := clause.match(field, value); matched {
+				highlights[field] = append(highlights[field], ranges...)
+			}
+		}
+	}
+	for field, ranges := range highlights {
+		highlights[field] = mergeRanges(ranges)
+	}
+	return highlights
+}
+
+// collect adds a clause for each term that can make a row match. Excluded
+// terms never do, so they are skipped.
+func (h *highlighter) collect(input query.Query) {
+	switch typed := input.(type) {
+	case *query.ConjunctionQuery:
+		for _, child := range typed.Conjuncts {
+			h.collect(child)
+		}
+	case *query.DisjunctionQuery:
+		for _, child := range typed.Disjuncts {
+			h.collect(child)
+		}
+	case *query.BooleanQuery:
+		for _, child := range []query.Query{typed.Must, typed.Should, typed.Filter} {
+			if child != nil {
+				h.collect(child)
+			}
+		}
+	case *allFieldsQuery:
+		h.collect(typed.child)
+	case *query.MatchQuery:
+		h.addTokens(typed.FieldVal, typed.Analyzer, func(analyzer analysis.Analyzer) func(string) bool {
+			terms := analyzer.Analyze([]byte(typed.Match))
+			return func(term string) bool {
+				return slices.ContainsFunc(terms, func(token *analysis.Token) bool {
+					wanted := string(token.Term)
+					return wanted == term || typed.Fuzziness > 0 && editDistanceAtMost(wanted, term, typed.Fuzziness)
+				})
+			}
+		})
+	case *query.TermQuery:
+		h.addTokens(typed.FieldVal, "", func(analysis.Analyzer) func(string) bool {
+			return func(term string) bool { return term == typed.Term }
+		})
+	case *query.PrefixQuery:
+		h.addTokens(typed.FieldVal, "", func(analysis.Analyzer) func(string) bool {
+			return func(term string) bool { return strings.HasPrefix(term, typed.Prefix) }
+		})
+	case *query.FuzzyQuery:
+		h.addTokens(typed.FieldVal, "", func(analysis.Analyzer) func(string) bool {
+			return func(term string) bool {
+				return strings.HasPrefix(term, typed.Term[:min(typed.Prefix, len(typed.Term))]) &&
+					editDistanceAtMost(typed.Term, term, typed.Fuzziness)
+			}
+		})
+	case *query.WildcardQuery:
+		pattern := regexp.QuoteMeta(typed.Wildcard)
+		pattern = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(pattern)
+		h.addTermPattern(typed.FieldVal, pattern)
+	case *query.RegexpQuery:
+		h.addTermPattern(typed.FieldVal, typed.Regexp)
+	case *storedPhraseQuery:
+		h.addPhrase(typed)
+	case *rawRegexpQuery:
+		h.clauses = append(h.clauses, highlightClause{field: "_raw", match: func(_ string, value interface{}) ([][2]int, bool) {
+			text, ok := value.(string)
+			if !ok {
+				return nil, false
+			}
+			var ranges [][2]int
+			for _, found := range typed.pattern.FindAllStringIndex(text, -1) {
+				ranges = append(ranges, [2]int{found[0], found[1]})
+			}
+			return ranges, len(ranges) > 0
+		}})
+	case *query.TermRangeQuery:
+		lower, lowerOK := decodeNumericBound(typed.Min)
+		upper, upperOK := decodeNumericBound(typed.Max)
+		if lowerOK && upperOK {
+			h.addNumericRange(typed.FieldVal, lower, upper, typed.InclusiveMin, typed.InclusiveMax)
+		}
+	case *query.NumericRangeQuery:
+		h.addNumericRange(typed.FieldVal, typed.Min, typed.Max, typed.InclusiveMin, typed.InclusiveMax)
+	}
+}
+
+// analyzer returns the analyzer the index used for field, unless the query
+// names its own.
+func (h *highlighter) analyzer(field, name string) analysis.Analyzer {
+	if name == "" && h.keywordFields[field] {
+		name = keyword.Name
+	}
+	if name == "" {
+		name = h.indexMapping.AnalyzerNameForPath(field)
+	}
+	return h.indexMapping.AnalyzerNamed(name)
+}
+
+// addTokens highlights the tokens of a value that accept, built per field,
+// returns true for.
+func (h *highlighter) addTokens(field, analyzerName string, accept func(analysis.Analyzer) func(string) bool) {
+	h.clauses = append(h.clauses, highlightClause{field: field, match: func(field string, value interface{}) ([][2]int, bool) {
+		text, ok := value.(string)
+		analyzer := h.analyzer(field, analyzerName)
+		if !ok || analyzer == nil {
+			return nil, false
+		}
+		matches := accept(analyzer)
+		var ranges [][2]int
+		for _, token := range analyzer.Analyze([]byte(text)) {
+			if matches(string(token.Term)) {
+				ranges = append(ranges, [2]int{token.Start, token.End})
+			}
+		}
+		return ranges, len(ranges) > 0
+	}})
+}
+
+// addTermPattern highlights the tokens a regex matches whole, as Bleve's
+// wildcard and regexp queries match terms.
+func (h *highlighter) addTermPattern(field, pattern string) {
+	compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return
+	}
+	h.addTokens(field, "", func(analysis.Analyzer) func(string) bool {
+		return compiled.MatchString
+	})
+}
+
+// addPhrase highlights each run of tokens the phrase matches.
+func (h *highlighter) addPhrase(q *storedPhraseQuery) {
+	h.clauses = append(h.clauses, highlightClause{field: q.field, match: func(field string, value interface{}) ([][2]int, bool) {
+		text, ok := value.(string)
+		analyzer := h.analyzer(field, q.analyzer)
+		if !ok || analyzer == nil {
+			return nil, false
+		}
+		phrase := analyzePhrase(analyzer.Analyze([]byte(q.phrase)))
+		if len(phrase) == 0 {
+			return nil, false
+		}
+		tokens := analyzer.Analyze([]byte(text))
+		byPosition := make(map[int][]*analysis.Token)
+		for _, token := range tokens {
+			byPosition[token.Position] = append(byPosition[token.Position], token)
+		}
+		var ranges [][2]int
+		for _, first := range tokens {
+			start, end := first.Start, first.End
+			matched := true
+			for offset, wanted := range phrase {
+				found := false
+				for _, token := range byPosition[first.Position+offset] {
+					if matchesAnyTerm(wanted, []string{string(token.Term)}, q.fuzziness) {
+						found = true
+						end = max(end, token.End)
+						break
+					}
+				}
+				if len(wanted) > 0 && !found {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				ranges = append(ranges, [2]int{start, end})
+			}
+		}
+		return ranges, len(ranges) > 0
+	}})
+}
+
+// addNumericRange marks field as matched when its number lies in the range.
+func (h *highlighter) addNumericRange(field string, lower, upper *float64, inclusiveLower, inclusiveUpper *bool) {
+	h.clauses = append(h.clauses, highlightClause{field: field, match: func(_ string, value interface{}) ([][2]int, bool) {
+		number, ok := value.(float64)
+		if !ok {
+			return nil, false
+		}
+		if lower != nil && (number < *lower || number == *lower && inclusiveLower != nil && !*inclusiveLower) {
+			return nil, false
+		}
+		if upper != nil && (number > *upper || number == *upper && (inclusiveUpper == nil || !*inclusiveUpper)) {
+			return nil, false
+		}
+		return nil, true
+	}})
+}
+
+// mergeRanges sorts ranges and joins those that overlap or touch.
+func mergeRanges(ranges [][2]int) [][2]int {
+	if len(ranges) == 0 {
+		return [][2]int{}
+	}
+	slices.SortFunc(ranges, func(a, b [2]int) int { return a[0] - b[0] })
+	merged := ranges[:1]
+	for _, next := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if next[0] <= last[1] {
+			last[1] = max(last[1], next[1])
+			continue
+		}
+		merged = append(merged, next)
+	}
+	return merged
+}
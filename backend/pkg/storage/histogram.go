@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// ErrInvalidHistogram is returned for histogram options that do not fit
+// together or the time range.
+var ErrInvalidHistogram = errors.New("invalid histogram")
+
+const (
+	// defaultSplitValues is how many split values each bucket reports when
+	// the caller does not say.
+	defaultSplitValues = 10
+
+	// MaxSplitValues bounds the split values reported per bucket.
+	MaxSplitValues = 100
+
+	// maxSplitTracked bounds the distinct split values counted while
+	// scanning. Values first seen after that are counted in SplitOther.
+	maxSplitTracked = 1000
+)
+
+// HistogramOptions shapes SearchPage's distribution. The zero value counts
+// rows per bucket, split by source.
+type HistogramOptions struct {
+	Buckets  int           // bucket count; 0 for the default
+	Interval time.Duration // bucket width from StartDate; overrides Buckets
+
+	// SplitBy counts each bucket's rows per value of a field, keeping the
+	// SplitLimit values with the most rows overall. Rows without the field
+	// are not split. A field every shard indexes as numbers, keywords or
+	// booleans is counted from its terms, with one facet query per kept
+	// value; any other field, or a split with an aggregate, reads the stored
+	// values of every matching row.
+	SplitBy    string
+	SplitLimit int
+
+	// Aggregate computes avg, sum, min, max, median or pN (1-100) of the
+	// numeric values of AggregateField per bucket, and per split value,
+	// from the stored values of every matching row.
+	Aggregate      string
+	AggregateField string
+
+	aggregate aggregateSpec // set by resolve
+}
+
+// resolve validates the options against the time range, filling defaults.
+func (h HistogramOptions) resolve(start, end time.Time) (HistogramOptions, error) {
+	switch {
+	case h.Buckets < 0 || h.Buckets > MaxDistributionBuckets:
+		return h, fmt.Errorf("%w: bucket count must be between 1 and %d", ErrInvalidHistogram, MaxDistributionBuckets)
+	case h.Interval < 0:
+		return h, fmt.Errorf("%w: interval must be positive", ErrInvalidHistogram)
+	case h.Interval > 0 && end.Sub(start)/h.Interval >= MaxDistributionBuckets:
+		return h, fmt.Errorf("%w: interval %s makes more than %d buckets over the time range", ErrInvalidHistogram, h.Interval, MaxDistributionBuckets)
+	case h.SplitLimit < 0 || h.SplitLimit > MaxSplitValues:
+		return h, fmt.Errorf("%w: split limit must be between 1 and %d", ErrInvalidHistogram, MaxSplitValues)
+	case (h.Aggregate == "") != (h.AggregateField == ""):
+		return h, fmt.Errorf("%w: an aggregate needs both a function and a field", ErrInvalidHistogram)
+	}
+	if h.SplitLimit == 0 {
+		h.SplitLimit = defaultSplitValues
+	}
+	if h.Aggregate != "" {
+		h.aggregate = aggregateSpec{function: strings.ToLower(h.Aggregate), field: h.AggregateField}
+		if err := h.aggregate.resolve(); err != nil || h.aggregate.function == "count" {
+			return h, fmt.Errorf("%w: unknown aggregate %q; use avg, sum, min, max, median or p1-p100", ErrInvalidHistogram, h.Aggregate)
+		}
+	}
+	return h, nil
+}
+
+// scans reports whether the distribution needs the stored-value scan of
+// splitDistribution on top of the timestamp facet.
+func (h HistogramOptions) scans() bool {
+	return h.SplitBy != "" || h.Aggregate != ""
+}
+
+// splitTermKind reports the kind every shard indexes field as when each
+// shard's terms for it can be read back as whole values, as FieldFacet
+// requires; the kind is "" when no shard indexes the field.
+func (s *Storage) splitTermKind(keys []string, field string) (string, bool, error) {
+	kind := ""
+	for _, key := range keys {
+		meta, err := s.shardMetadata(key, false)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to read metadata for %s: %w", key, err)
+		}
+		shardKind, ok := facetTermKind(meta, field)
+		if !ok || shardKind != "" && kind != "" && shardKind != kind {
+			return "", false, nil
+		}
+		if shardKind != "" {
+			kind = shardKind
+		}
+	}
+	return kind, true, nil
+}
+
+// splitTerms fills the split counts of buckets from the terms of a field
+// every shard indexes as kind: a term facet finds the values with the most
+// rows, then a timestamp facet per value, and one for rows holding none of
+// them, counts each per bucket. It needs timestamp postings in every shard.
+func splitTerms(
+	ctx context.Context,
+	alias bleve.Index,
+	filterQuery query.Query,
+	options SearchOptions,
+	histogram HistogramOptions,
+	kind string,
+	buckets []SearchDistributionBucket,
+) error {
+	for i := range buckets {
+		buckets[i].SplitCounts = make(map[string]int)
+	}
+	if kind == "" || len(buckets) == 0 {
+		return nil
+	}
+	counts := newFacetCounts()
+	facet := FacetOptions{Field: histogram.SplitBy, StartDate: options.StartDate, EndDate: options.EndDate, Size: histogram.SplitLimit}
+	if err := facetTerms(ctx, alias, filterQuery, facet, kind, counts); err != nil {
+		return err
+	}
+	var top FacetResult
+	counts.top(histogram.SplitLimit, &top)
+	if len(top.Values) == 0 {
+		return nil
+	}
+
+	inclusive := true
+	timeQuery := query.NewDateRangeInclusiveQuery(options.StartDate, options.EndDate, &inclusive, &inclusive)
+	timeQuery.SetField("timestamp")
+	countBuckets := func(rows query.Query, add func(bucket, count int)) error {
+		_, timeFacet := buildTimeFacet(options.StartDate, options.EndDate, histogram.Buckets, histogram.Interval)
+		request := bleve.NewSearchRequest(bleve.NewConjunctionQuery(filterQuery, timeQuery, rows))
+		request.Size = 0
+		request.AddFacet("time", timeFacet)
+		result, err := searchShards(ctx, alias, request)
+		if err != nil {
+			return err
+		}
+		if result.Facets["time"] == nil {
+			return nil
+		}
+		for _, dateRange := range result.Facets["time"].DateRanges {
+			var i int
+			if _, err := fmt.Sscanf(dateRange.Name, "bucket-%03d", &i); err == nil && i >= 0 && i < len(buckets) && dateRange.Count > 0 {
+				add(i, dateRange.Count)
+			}
+		}
+		return nil
+	}
+
+	topQueries := make([]query.Query, 0, len(top.Values))
+	for _, value := range top.Values {
+		key := stringValue(value.Value)
+		valueQuery := splitValueQuery(histogram.SplitBy, value.Value)
+		topQueries = append(topQueries, valueQuery)
+		err := countBuckets(valueQuery, func(i, count int) { buckets[i].SplitCounts[key] = count })
+		if err != nil {
+			return err
+		}
+	}
+	others := bleve.NewBooleanQuery()
+	others.AddMust(splitAnyValueQuery(histogram.SplitBy))
+	others.AddMustNot(topQueries...)
+	return countBuckets(others, func(i, count int) { buckets[i].SplitOther = count })
+}
+
+// splitValueQuery matches the rows holding one value read back by
+// facetTermValue.
+func splitValueQuery(field string, value interface{}) query.Query {
+	switch typed := value.(type) {
+	case float64:
+		inclusive := true
+		valueQuery := query.NewNumericRangeInclusiveQuery(&typed, &typed, &inclusive, &inclusive)
+		valueQuery.SetField(field)
+		return valueQuery
+	case bool:
+		valueQuery := query.NewBoolFieldQuery(typed)
+		valueQuery.SetField(field)
+		return valueQuery
+	default:
+		valueQuery := query.NewTermQuery(stringValue(value))
+		valueQuery.SetField(field)
+		return valueQuery
+	}
+}
+
+// splitAnyValueQuery matches the rows holding any value of field: every
+// term it indexes, since compact numeric fields index no coarser terms for
+// a numeric range to use.
+func splitAnyValueQuery(field string) query.Query {
+	anyQuery := query.NewTermRangeQuery("", "")
+	anyQuery.SetField(field)
+	return anyQuery
+}
+
+// splitDistribution fills the split counts and aggregates of buckets from
+// the stored values of the matching rows.
+func splitDistribution(
+	ctx context.Context,
+	indexes []bleve.Index,
+	filterQuery query.Query,
+	options SearchOptions,
+	histogram HistogramOptions,
+	buckets []SearchDistributionBucket,
+) error {
+	if len(buckets) == 0 {
+		return nil
+	}
+	var fields []string
+	if histogram.SplitBy != "" {
+		fields = append(fields, histogram.SplitBy)
+	}
+	if histogram.Aggregate != "" && histogram.AggregateField != histogram.SplitBy {
+		fields = append(fields, histogram.AggregateField)
+	}
+	aggregating := histogram.Aggregate != ""
+	spec := histogram.aggregate
+
+	splits := make([]map[string]int, len(buckets))
+	states := make([]aggregateState, len(buckets))
+	splitStates := make([]map[string]*aggregateState, len(buckets))
+	for i := range buckets {
+		splits[i] = make(map[string]int)
+		states[i] = newAggregateState(uint64(i))
+		splitStates[i] = make(map[string]*aggregateState)
+	}
+	totals := make(map[string]int)
+	visit := func(_ string, fields map[string]interface{}, timestamp time.Time) {
+		i := distributionBucketIndex(buckets, timestamp)
+		if i < 0 {
+			return
+		}
+		if aggregating {
+			states[i].add(spec, fields)
+		}
+		if histogram.SplitBy == "" {
+			return
+		}
+		values, ok := fields[histogram.SplitBy].([]interface{})
+		if !ok {
+			values = []interface{}{fields[histogram.SplitBy]}
+		}
+		for _, value := range values {
+			if value == nil {
+				continue
+			}
+			key := stringValue(value)
+			if _, tracked := totals[key]; !tracked && len(totals) >= maxSplitTracked {
+				buckets[i].SplitOther++
+				continue
+			}
+			totals[key]++
+			splits[i][key]++
+			if aggregating {
+				state := splitStates[i][key]
+				if state == nil {
+					seeded := newAggregateState(uint64(len(totals)))
+					state = &seeded
+					splitStates[i][key] = state
+				}
+				state.add(spec, fields)
+			}
+		}
+	}
+	for _, index := range indexes {
+		if err := scanShardMatches(ctx, index, filterQuery, options.StartDate, options.EndDate, fields, visit); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if totals[keys[i]] != totals[keys[j]] {
+			return totals[keys[i]] > totals[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	top := make(map[string]bool, histogram.SplitLimit)
+	for _, key := range keys[:min(len(keys), histogram.SplitLimit)] {
+		top[key] = true
+	}
+	for i := range buckets {
+		if aggregating {
+			if value, ok := states[i].value(spec).(float64); ok {
+				buckets[i].Value = &value
+			}
+		}
+		if histogram.SplitBy == "" {
+			continue
+		}
+		buckets[i].SplitCounts = make(map[string]int)
+		for key, count := range splits[i] {
+			if !top[key] {
+				buckets[i].SplitOther += count
+				continue
+			}
+			buckets[i].SplitCounts[key] = count
+			if !aggregating {
+				continue
+			}
+			if value, ok := splitStates[i][key].value(spec).(float64); ok {
+				if buckets[i].SplitValues == nil {
+					buckets[i].SplitValues = make(map[string]float64)
+				}
+				buckets[i].SplitValues[key] = value
+			}
+		}
+	}
+	return nil
+}
@@ -71,6 +71,9 @@ func buildOptimizedDocument(indexMapping mapping.IndexMapping, id string, data i
 		}
 	}
 
+	if row, ok := data.(map[string]interface{}); ok {
+		addFingerprint(doc, row)
+	}
 	return doc, nil
 }
 
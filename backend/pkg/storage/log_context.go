@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// ErrLogNotFound is returned by LogContext for an ID no shard holds.
+var ErrLogNotFound = errors.New("log not found")
+
+const (
+	// MaxContextRows bounds the rows LogContext returns on each side.
+	MaxContextRows = 1000
+
+	// contextInitialWindow is the time on each side of the hit searched
+	// first. It grows fourfold until it holds enough rows or every shard.
+	contextInitialWindow = time.Minute
+)
+
+// ContextOptions selects a row and how many of its source's rows to return
+// before and after it.
+type ContextOptions struct {
+	ID     string
+	Before int
+	After  int
+}
+
+// ContextResult holds a row and its neighbours from the same source in the
+// order they were written: by timestamp, then _seq, then ID. Before and
+// After are oldest first. The query that found the row plays no part.
+type ContextResult struct {
+	Log        map[string]interface{}
+	Source     string
+	Before     []map[string]interface{}
+	After      []map[string]interface{}
+	MoreBefore bool // rows precede Before
+	MoreAfter  bool // rows follow After
+	QueryTime  time.Duration
+}
+
+// contextRow is a row with its sort key.
+type contextRow struct {
+	log       map[string]interface{}
+	id        string
+	timestamp time.Time
+	seq       float64
+}
+
+func newContextRow(id string, fields map[string]interface{}) (contextRow, bool) {
+	log, timestamp, ok := pageHitToLog(id, fields)
+	seq, _ := fields["_seq"].(float64)
+	return contextRow{log: log, id: id, timestamp: timestamp, seq: seq}, ok
+}
+
+func compareContextRows(a, b contextRow) int {
+	if c := a.timestamp.Compare(b.timestamp); c != 0 {
+		return c
+	}
+	if a.seq != b.seq {
+		if a.seq < b.seq {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a.id, b.id)
+}
+
+// LogContext returns the row with options.ID and the rows of the same source
+// written just before and after it, crossing shard boundaries as needed.
+func (s *Storage) LogContext(ctx context.Context, options ContextOptions) (ContextResult, error) {
+	started := time.Now()
+	result := ContextResult{Before: []map[string]interface{}{}, After: []map[string]interface{}{}}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if options.ID == "" {
+		return result, fmt.Errorf("log ID is required")
+	}
+	if options.Before < 0 || options.Before > MaxContextRows || options.After < 0 || options.After > MaxContextRows {
+		return result, fmt.Errorf("before and after must be between 0 and %d", MaxContextRows)
+	}
+
+	dates, err := s.List()
+	if err != nil {
+		return result, fmt.Errorf("failed to list existing dates: %w", err)
+	}
+	sortShardKeys(dates)
+	anchor, err := s.findContextRow(ctx, dates, options.ID)
+	if err != nil {
+		return result, err
+	}
+	result.Log = anchor.log
+	result.Source, _ = anchor.log["_src"].(string)
+
+	sides := []struct {
+		want  int
+		after bool
+		rows  *[]map[string]interface{}
+		more  *bool
+	}{
+		{options.Before, false, &result.Before, &result.MoreBefore},
+		{options.After, true, &result.After, &result.MoreAfter},
+	}
+	for _, side := range sides {
+		if side.want == 0 || result.Source == "" {
+			continue
+		}
+		rows, err := s.contextSide(ctx, dates, anchor, result.Source, side.want+1, side.after)
+		if err != nil {
+			return result, err
+		}
+		*side.more = len(rows) > side.want
+		rows = rows[:min(len(rows), side.want)]
+		if !side.after {
+			slices.Reverse(rows)
+		}
+		for _, row := range rows {
+			*side.rows = append(*side.rows, row.log)
+		}
+	}
+	result.QueryTime = time.Since(started)
+	return result, nil
+}
+
+// findContextRow looks up a row by ID. The shards around the timestamp the
+// ID starts with are tried first; the rest follow, newest first, for IDs
+// that hold none or rows not found there.
+func (s *Storage) findContextRow(ctx context.Context, dates []string, id string) (contextRow, error) {
+	tried := make(map[string]bool)
+	if timestamp, ok := docIDTimestamp(id); ok {
+		for _, date := range intersectingDates(dates, timestamp, timestamp, s.shardSlack()) {
+			row, found, err := s.findContextRowInShard(ctx, date, id)
+			if err != nil || found {
+				return row, err
+			}
+			tried[date] = true
+		}
+	}
+	for i := len(dates) - 1; i >= 0; i-- {
+		if tried[dates[i]] {
+			continue
+		}
+		row, found, err := s.findContextRowInShard(ctx, dates[i], id)
+		if err != nil || found {
+			return row, err
+		}
+	}
+	return contextRow{}, fmt.Errorf("%w: %s", ErrLogNotFound, id)
+}
+
+func (s *Storage) findContextRowInShard(ctx context.Context, date, id string) (contextRow, bool, error) {
+	index, release, err := s.acquireIndex(date, false)
+	if errors.Is(err, errShardNotFound) {
+		return contextRow{}, false, nil // removed since List
+	}
+	if err != nil {
+		return contextRow{}, false, fmt.Errorf("failed to get index for date %s: %w", date, err)
+	}
+	defer release()
+	request := bleve.NewSearchRequest(query.NewDocIDQuery([]string{id}))
+	request.Size = 1
+	request.Fields = []string{"*"}
+	result, err := index.SearchInContext(ctx, request)
+	if err != nil || len(result.Hits) == 0 {
+		return contextRow{}, false, err
+	}
+	row, ok := newContextRow(id, result.Hits[0].Fields)
+	if !ok {
+		return contextRow{}, false, fmt.Errorf("log %s in %s has no readable timestamp", id, date)
+	}
+	return row, true, nil
+}
+
+// contextSide returns up to want rows of source on one side of anchor,
+// nearest first. It searches a time window around the anchor, widening it
+// until the window holds want rows or reaches past every shard.
+func (s *Storage) contextSide(ctx context.Context, dates []string, anchor contextRow, source string, want int, after bool) ([]contextRow, error) {
+	first, _ := parseShardKey(dates[0])
+	last, _ := parseShardKey(dates[len(dates)-1])
+	slack := s.shardSlack()
+	sourceQuery, err := buildPageQuery("", []string{source}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	for window := contextInitialWindow; ; window *= 4 {
+		start, end := anchor.timestamp.Add(-window), anchor.timestamp
+		if after {
+			start, end = anchor.timestamp, anchor.timestamp.Add(window)
+		}
+		rows, err := s.contextWindow(ctx, dates, sourceQuery, anchor, start, end, want, after)
+		if err != nil {
+			return nil, err
+		}
+		covered := start.Before(first.Start.Add(-slack))
+		if after {
+			covered = end.After(last.End.Add(slack))
+		}
+		if len(rows) >= want || covered {
+			return rows[:min(len(rows), want)], nil
+		}
+	}
+}
+
+// contextWindow returns the rows of sourceQuery in [start, end] on one side
+// of anchor, nearest first, stopping once it is sure of the nearest want.
+// Shards with timestamp postings are read in timestamp order, which can stop
+// early; older shards are scanned whole.
+func (s *Storage) contextWindow(
+	ctx context.Context,
+	dates []string,
+	sourceQuery query.Query,
+	anchor contextRow,
+	start time.Time,
+	end time.Time,
+	want int,
+	after bool,
+) ([]contextRow, error) {
+	var rows []contextRow
+	beyond := func(row contextRow) bool {
+		if after {
+			return compareContextRows(row, anchor) > 0
+		}
+		return compareContextRows(row, anchor) < 0
+	}
+	var sorted []bleve.Index
+	for _, date := range intersectingDates(dates, start, end, s.shardSlack()) {
+		index, release, err := s.acquireIndex(date, false)
+		if errors.Is(err, errShardNotFound) {
+			continue // removed since List
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get index for date %s: %w", date, err)
+		}
+		defer release()
+		if timestampIsIndexed(index) {
+			sorted = append(sorted, index)
+			continue
+		}
+		err = scanShardMatches(ctx, index, sourceQuery, start, end, []string{"*"},
+			func(id string, fields map[string]interface{}, _ time.Time) {
+				if row, ok := newContextRow(id, fields); ok && beyond(row) {
+					rows = append(rows, row)
+				}
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Rows timestamped before frontier, in the direction read, are all in
+	// sortedRows; rows at frontier may be cut off by the batch.
+	var frontier *time.Time
+	if len(sorted) > 0 {
+		inclusive := true
+		timeQuery := query.NewDateRangeInclusiveQuery(start, end, &inclusive, &inclusive)
+		timeQuery.SetField("timestamp")
+		order := "desc"
+		if after {
+			order = "asc"
+		}
+		alias := bleve.NewIndexAlias(sorted...)
+		var searchAfter []string
+		var sortedRows []contextRow
+		for {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			request := bleve.NewSearchRequest(bleve.NewConjunctionQuery(sourceQuery, timeQuery))
+			request.Size = min(max(2*want, 100), searchScanBatchSize)
+			request.Fields = []string{"*"}
+			request.SortByCustom(timestampSort(order))
+			if len(searchAfter) > 0 {
+				request.SetSearchAfter(searchAfter)
+			}
+			result, err := searchShards(ctx, alias, request)
+			if err != nil {
+				return nil, err
+			}
+			for _, hit := range result.Hits {
+				if row, ok := newContextRow(hit.ID, hit.Fields); ok && beyond(row) {
+					sortedRows = append(sortedRows, row)
+				}
+			}
+			if len(result.Hits) < request.Size {
+				frontier = nil
+				break
+			}
+			searchAfter = result.Hits[len(result.Hits)-1].Sort
+			edge, ok := newContextRow("", result.Hits[len(result.Hits)-1].Fields)
+			if !ok {
+				continue
+			}
+			frontier = &edge.timestamp
+			settled := 0
+			for _, row := range sortedRows {
+				if !row.timestamp.Equal(edge.timestamp) {
+					settled++
+				}
+			}
+			if settled >= want {
+				break
+			}
+		}
+		rows = append(rows, sortedRows...)
+	}
+
+	if frontier != nil {
+		rows = slices.DeleteFunc(rows, func(row contextRow) bool {
+			if after {
+				return !row.timestamp.Before(*frontier)
+			}
+			return !row.timestamp.After(*frontier)
+		})
+	}
+	slices.SortFunc(rows, func(a, b contextRow) int {
+		if after {
+			return compareContextRows(a, b)
+		}
+		return compareContextRows(b, a)
+	})
+	return rows, nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLogContextFollowsSourceOrderAcrossShards(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	anchorTime := time.Date(2024, 1, 15, 23, 59, 0, 0, time.UTC)
+	row := func(offset time.Duration, seq int64, raw string) map[string]interface{} {
+		source := "app.log"
+		if raw == "other" {
+			source = "db.log"
+		}
+		return map[string]interface{}{"timestamp": anchorTime.Add(offset), "_seq": seq, "_raw": raw, "_src": source}
+	}
+	appLogs := []map[string]interface{}{
+		row(-72*time.Hour, 1, "a1"),
+		row(-time.Minute, 5, "a3"),
+		row(-time.Minute, 3, "a2"),
+		row(0, 7, "anchor"),
+		row(0, 6, "a4"),
+		row(0, 9, "a5"),
+		row(30*time.Second, 10, "a6"),
+		row(96*time.Hour, 11, "a7"),
+	}
+	ids, err := store.StoreWithIDs(appLogs, "app.log")
+	if err != nil {
+		t.Fatalf("store app logs: %v", err)
+	}
+	if _, err := store.StoreWithIDs([]map[string]interface{}{row(0, 8, "other"), row(10*time.Second, 2, "other")}, "db.log"); err != nil {
+		t.Fatalf("store db logs: %v", err)
+	}
+	raws := func(rows []map[string]interface{}) []string {
+		values := make([]string, 0, len(rows))
+		for _, row := range rows {
+			values = append(values, row["_raw"].(string))
+		}
+		return values
+	}
+
+	result, err := store.LogContext(context.Background(), ContextOptions{ID: ids[3], Before: 3, After: 3})
+	if err != nil {
+		t.Fatalf("LogContext: %v", err)
+	}
+	if result.Log["_raw"] != "anchor" || result.Source != "app.log" {
+		t.Fatalf("anchor = %v from %q", result.Log, result.Source)
+	}
+	if got := raws(result.Before); !reflect.DeepEqual(got, []string{"a2", "a3", "a4"}) || !result.MoreBefore {
+		t.Fatalf("before = %v (more %v)", got, result.MoreBefore)
+	}
+	if got := raws(result.After); !reflect.DeepEqual(got, []string{"a5", "a6", "a7"}) || result.MoreAfter {
+		t.Fatalf("after = %v (more %v)", got, result.MoreAfter)
+	}
+
+	wide, err := store.LogContext(context.Background(), ContextOptions{ID: ids[3], Before: 10})
+	if err != nil {
+		t.Fatalf("LogContext: %v", err)
+	}
+	if got := raws(wide.Before); !reflect.DeepEqual(got, []string{"a1", "a2", "a3", "a4"}) || wide.MoreBefore || len(wide.After) != 0 {
+		t.Fatalf("wide before = %v (more %v), after = %v", got, wide.MoreBefore, wide.After)
+	}
+
+	// IDs lead with the row's timestamp, which picks the shard to look in.
+	if timestamp, ok := docIDTimestamp(ids[0]); !ok || !timestamp.Equal(anchorTime.Add(-72*time.Hour)) {
+		t.Fatalf("docIDTimestamp(%q) = %v, %v", ids[0], timestamp, ok)
+	}
+	oldest, err := store.LogContext(context.Background(), ContextOptions{ID: ids[0], After: 1})
+	if err != nil || oldest.Log["_raw"] != "a1" || !reflect.DeepEqual(raws(oldest.After), []string{"a2"}) {
+		t.Fatalf("oldest = %v, after %v: %v", oldest.Log, oldest.After, err)
+	}
+
+	for _, id := range []string{"missing", fmt.Sprintf("%d-app.log-99", anchorTime.UnixNano())} {
+		if _, err := store.LogContext(context.Background(), ContextOptions{ID: id, Before: 1}); !errors.Is(err, ErrLogNotFound) {
+			t.Fatalf("%s: expected ErrLogNotFound, got %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/v2/document"
+	"github.com/blevesearch/bleve/v2/mapping"
+	blevesearch "github.com/blevesearch/bleve/v2/search"
+	index "github.com/blevesearch/bleve_index_api"
+)
+
+const migrateBatchSize = 1000
+
+// MigrationProgress is reported after each source shard has been processed.
+type MigrationProgress struct {
+	Shard   string
+	Scanned int
+	Moved   int
+}
+
+// MigrationResult summarises a shard re-partition.
+type MigrationResult struct {
+	ShardsScanned int
+	DocsScanned   int
+	DocsMoved     int
+	ShardsRemoved int
+}
+
+// MigrateShards re-partitions every shard into the canonical layout: UTC
+// boundaries at the configured granularity. Rows are moved with their original
+// document ID and stored fields, including _seq, so ordering and ID-based
+// operations are unaffected. Each page is indexed into its target shard before
+// it is deleted from the source, so an interrupted run leaves duplicates of the
+// same document ID rather than gaps, and re-running the migration is safe.
+// Source shards left empty are removed.
+//
+// The migration rewrites shards in place and must not run concurrently with
+// ingestion; the CLI runs it against a stopped server.
+func (s *Storage) MigrateShards(ctx context.Context, progress func(MigrationProgress)) (MigrationResult, error) {
+	var result MigrationResult
+
+	keys, err := s.List()
+	if err != nil {
+		return result, err
+	}
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		scanned, moved, remaining, err := s.migrateShard(ctx, key)
+		result.ShardsScanned++
+		result.DocsScanned += scanned
+		result.DocsMoved += moved
+		if err != nil {
+			return result, fmt.Errorf("failed to migrate shard %s: %w", key, err)
+		}
+
+		if moved > 0 && remaining == 0 {
+			if err := s.removeShard(key); err != nil {
+				return result, err
+			}
+			result.ShardsRemoved++
+		}
+		if progress != nil {
+			progress(MigrationProgress{Shard: key, Scanned: scanned, Moved: moved})
+		}
+	}
+
+	settings, err := readStorageSettings(s.baseDir)
+	if err != nil {
+		return result, err
+	}
+	settings.ShardGranularity = s.ShardGranularity()
+	settings.UTCShards = true
+	if err := writeStorageSettings(s.baseDir, settings); err != nil {
+		return result, err
+	}
+	s.utcShards = true
+	return result, nil
+}
+
+// migrateShard moves every row of one shard whose canonical key differs from
+// key, reporting the rows scanned, moved and left behind. Pages are walked in
+// document-ID order, which stays stable while earlier pages are deleted.
+func (s *Storage) migrateShard(ctx context.Context, key string) (int, int, uint64, error) {
+	source, release, err := s.acquireIndex(key, false)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to get index for date %s: %w", key, err)
+	}
+	defer release()
+	meta, err := s.shardMetadata(key, false)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to read metadata for %s: %w", key, err)
+	}
+
+	scanned, moved := 0, 0
+	var searchAfter []string
+	for {
+		n, done, err := s.migratePage(ctx, key, source, meta, &searchAfter)
+		scanned += n.scanned
+		moved += n.moved
+		if err != nil {
+			return scanned, moved, 0, err
+		}
+		if done {
+			break
+		}
+	}
+	remaining, err := source.DocCount()
+	if err != nil {
+		return scanned, moved, 0, fmt.Errorf("failed to count documents in shard %s: %w", key, err)
+	}
+	return scanned, moved, remaining, nil
+}
+
+type migrateCounts struct {
+	scanned, moved int
+}
+
+// migratePage moves one page of rows and advances searchAfter, reporting
+// whether the shard is exhausted. Rows keep the index form and kinds they had
+// in the source shard; see rebuildDocument. Target shards are pinned only for
+// the page, so a migration touching many shards stays within the open-shard
+// bound.
+func (s *Storage) migratePage(ctx context.Context, key string, source bleve.Index, meta shardMeta, searchAfter *[]string) (migrateCounts, bool, error) {
+	var n migrateCounts
+	request := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	request.Size = migrateBatchSize
+	request.SortByCustom(blevesearch.SortOrder{&blevesearch.SortDocID{}})
+	if len(*searchAfter) > 0 {
+		request.SetSearchAfter(*searchAfter)
+	}
+	page, err := source.SearchInContext(ctx, request)
+	if err != nil {
+		return n, false, err
+	}
+	if len(page.Hits) == 0 {
+		return n, true, nil
+	}
+
+	targets := make(map[string]bleve.Index)
+	batches := make(map[string]*bleve.Batch)
+	added := make(map[string]*shardAdditions)
+	var releases []func()
+	defer func() {
+		for _, release := range releases {
+			release()
+		}
+	}()
+	deletes := source.NewBatch()
+	for _, hit := range page.Hits {
+		n.scanned++
+		stored, err := source.Document(hit.ID)
+		if err != nil {
+			return n, false, fmt.Errorf("failed to read document %s: %w", hit.ID, err)
+		}
+		if stored == nil {
+			continue
+		}
+		row, timestamp, ok := storedDocumentRow(stored)
+		if !ok {
+			continue // no usable timestamp — leave the row where it is
+		}
+		target := s.granularity.shardKey(timestamp)
+		if target == key {
+			continue
+		}
+
+		targetIndex, ok := targets[target]
+		if !ok {
+			var release func()
+			if targetIndex, release, err = s.acquireIndex(target, true); err != nil {
+				return n, false, fmt.Errorf("failed to get index for date %s: %w", target, err)
+			}
+			releases = append(releases, release)
+			targets[target] = targetIndex
+			batches[target] = targetIndex.NewBatch()
+			added[target] = &shardAdditions{kinds: make(map[string][]string), rejected: make(map[string]uint64)}
+		}
+		doc, err := rebuildDocument(source, meta, targetIndex.Mapping(), hit.ID, row, added[target].rejected)
+		if err != nil {
+			return n, false, fmt.Errorf("failed to index log entry: %w", err)
+		}
+		if err := batches[target].IndexAdvanced(doc); err != nil {
+			return n, false, fmt.Errorf("failed to add log entry to batch: %w", err)
+		}
+		if src, ok := row["_src"].(string); ok {
+			added[target].sources = mergeSorted(added[target].sources, []string{src})
+		}
+		addDocumentKinds(added[target].kinds, doc)
+		deletes.Delete(hit.ID)
+	}
+
+	for target, batch := range batches {
+		if err := s.writeShard(target, targets[target], batch, *added[target], false); err != nil {
+			return n, false, fmt.Errorf("failed to commit batch for date %s: %w", target, err)
+		}
+	}
+	if deletes.Size() > 0 {
+		if err := s.writeShard(key, source, deletes, shardAdditions{}, true); err != nil {
+			return n, false, fmt.Errorf("failed to delete moved rows: %w", err)
+		}
+		n.moved += deletes.Size()
+	}
+
+	lastHit := page.Hits[len(page.Hits)-1]
+	*searchAfter = lastHit.Sort
+	return n, len(page.Hits) < request.Size || len(*searchAfter) == 0, nil
+}
+
+// storedDocumentRow rebuilds the ingest-time row for a stored document and
+// reports its timestamp, if it has one.
+// Values are read from the document rather than a search hit because hits
+// format timestamps with second precision. Repeated field names become slices,
+// matching how Bleve maps array values.
+func storedDocumentRow(stored index.Document) (map[string]interface{}, time.Time, bool) {
+	row := make(map[string]interface{})
+	stored.VisitFields(func(field index.Field) {
+		var value interface{}
+		switch typed := field.(type) {
+		case index.TextField:
+			value = typed.Text()
+		case index.NumericField:
+			number, err := typed.Number()
+			if err != nil {
+				return
+			}
+			value = number
+		case index.BooleanField:
+			boolean, err := typed.Boolean()
+			if err != nil {
+				return
+			}
+			value = boolean
+		case index.DateTimeField:
+			datetime, _, err := typed.DateTime()
+			if err != nil {
+				return
+			}
+			value = datetime.UTC()
+		default:
+			return
+		}
+
+		name := field.Name()
+		existing, ok := row[name]
+		switch {
+		case !ok:
+			row[name] = value
+		case isSlice(existing):
+			row[name] = append(existing.([]interface{}), value)
+		default:
+			row[name] = []interface{}{existing, value}
+		}
+	})
+
+	if seq, ok := row["_seq"].(float64); ok {
+		row["_seq"] = int64(seq)
+	}
+	timestamp, ok := row["timestamp"].(time.Time)
+	return row, timestamp, ok
+}
+
+// rebuildDocument indexes a row read back by storedDocumentRow the way its
+// shard indexed it. Stored documents keep no analyzer or index options, so
+// they are recovered from the kinds the shard recorded for each field: a
+// value whose kind is not among them was stored-only, either declared so or
+// refused by its declared type, and text in a keyword field is an exact
+// term. Those values are added with addDeclaredField; the rest, and every
+// row of a shard that recorded no kinds, get the dynamic mapping as at
+// ingest. Refused values are counted in rejected, when it is not nil.
+func rebuildDocument(source bleve.Index, meta shardMeta, indexMapping mapping.IndexMapping, id string, row map[string]interface{}, rejected map[string]uint64) (*document.Document, error) {
+	if !meta.KindsKnown {
+		return buildOptimizedDocument(indexMapping, id, row)
+	}
+	type pinnedValue struct {
+		name  string
+		spec  FieldSpec
+		value interface{}
+	}
+	var pinned []pinnedValue
+	dynamic := make(map[string]interface{}, len(row))
+	for name, value := range row {
+		if name == "timestamp" || strings.HasPrefix(name, "_") {
+			dynamic[name] = value
+			continue
+		}
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		var kept []interface{}
+		for _, value := range values {
+			spec, pin, err := restoredSpec(source, indexMapping, name, meta.Kinds[name], value)
+			if err != nil {
+				return nil, err
+			}
+			if !pin {
+				kept = append(kept, value)
+				continue
+			}
+			pinned = append(pinned, pinnedValue{name: name, spec: spec, value: value})
+			if spec.StoredOnly && len(meta.Kinds[name]) > 0 && rejected != nil {
+				rejected[name]++
+			}
+		}
+		switch len(kept) {
+		case 0:
+		case 1:
+			dynamic[name] = kept[0]
+		default:
+			dynamic[name] = kept
+		}
+	}
+
+	doc, err := buildOptimizedDocument(indexMapping, id, dynamic)
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range pinned {
+		if _, err := addDeclaredField(doc, indexMapping, value.name, value.spec, value.value); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// restoredSpec returns the declaration that indexes a stored value of field
+// as it was, or false when the dynamic mapping already does.
+func restoredSpec(source bleve.Index, indexMapping mapping.IndexMapping, field string, kinds []string, value interface{}) (FieldSpec, bool, error) {
+	has := make(map[string]bool, len(kinds))
+	for _, kind := range kinds {
+		has[kind] = true
+	}
+	switch typed := value.(type) {
+	case string:
+		switch {
+		case has[kindKeyword] && has[kindText]:
+			// Rows of different sources may declare the field differently;
+			// an exact term the analyzer would not produce marks a keyword.
+			keyword, err := indexedAsKeyword(source, indexMapping, field, typed)
+			if err != nil || !keyword {
+				return FieldSpec{}, false, err
+			}
+			return FieldSpec{Type: FieldKeyword}, true, nil
+		case has[kindKeyword]:
+			return FieldSpec{Type: FieldKeyword}, true, nil
+		case has[kindText]:
+			return FieldSpec{}, false, nil
+		}
+		return FieldSpec{Type: FieldText, StoredOnly: true}, true, nil
+	case float64:
+		return FieldSpec{Type: FieldDouble, StoredOnly: true}, !has[kindNumber], nil
+	case bool:
+		return FieldSpec{Type: FieldBool, StoredOnly: true}, !has[kindBool], nil
+	case time.Time:
+		return FieldSpec{Type: FieldDate, StoredOnly: true}, !has[kindDate], nil
+	}
+	return FieldSpec{}, false, nil
+}
+
+// indexedAsKeyword reports whether value is a term of field in source while
+// the standard analyzer would index it as something else.
+func indexedAsKeyword(source bleve.Index, indexMapping mapping.IndexMapping, field, value string) (bool, error) {
+	tokens := indexMapping.AnalyzerNamed(standard.Name).Analyze([]byte(value))
+	if len(tokens) == 1 && string(tokens[0].Term) == value {
+		return false, nil
+	}
+	dictionary, err := source.FieldDictRange(field, []byte(value), []byte(value))
+	if err != nil {
+		return false, err
+	}
+	defer dictionary.Close()
+	entry, err := dictionary.Next()
+	return entry != nil && entry.Term == value, err
+}
+
+func isSlice(value interface{}) bool {
+	_, ok := value.([]interface{})
+	return ok
+}
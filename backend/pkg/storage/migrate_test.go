@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/index/upsidedown/store/goleveldb"
+	index "github.com/blevesearch/bleve_index_api"
+)
+
+// storedDocument reads one document straight from a shard.
+func storedDocument(t *testing.T, store *Storage, key, id string) index.Document {
+	t.Helper()
+	shard, release, err := store.acquireIndex(key, false)
+	if err != nil {
+		t.Fatalf("open shard %s: %v", key, err)
+	}
+	defer release()
+	doc, err := shard.Document(id)
+	if err != nil || doc == nil {
+		t.Fatalf("document %s not found in shard %s: %v", id, key, err)
+	}
+	return doc
+}
+
+// writeLegacyShard builds a shard the way releases before UTC sharding did:
+// rows land in the shard named after their own local date.
+func writeLegacyShard(t *testing.T, dir, key string, rows map[string]map[string]interface{}) {
+	t.Helper()
+	index, err := bleve.NewUsing(
+		filepath.Join(dir, shardDirName(key)), buildIndexMapping(), "scorch", goleveldb.Name,
+		map[string]interface{}{"store": kvConfig},
+	)
+	if err != nil {
+		t.Fatalf("create legacy shard: %v", err)
+	}
+	defer index.Close()
+	batch := index.NewBatch()
+	for id, row := range rows {
+		doc, err := buildOptimizedDocument(index.Mapping(), id, row)
+		if err != nil {
+			t.Fatalf("build document: %v", err)
+		}
+		if err := batch.IndexAdvanced(doc); err != nil {
+			t.Fatalf("batch document: %v", err)
+		}
+	}
+	if err := index.Batch(batch); err != nil {
+		t.Fatalf("commit legacy shard: %v", err)
+	}
+}
+
+func TestNewStorage_FreshDirectoryIsUTCSharded(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	if !store.utcShards {
+		t.Fatal("expected a fresh storage directory to be UTC-normalized")
+	}
+	ts := time.Date(2024, 1, 15, 23, 30, 0, 0, time.FixedZone("EST", -5*3600))
+	if err := store.Store(makeLogs([]time.Time{ts}, "app.log"), "app.log"); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if dates, _ := store.List(); len(dates) != 1 || dates[0] != "2024-01-16" {
+		t.Fatalf("List = %v, want the UTC day [2024-01-16]", dates)
+	}
+}
+
+func TestMigrateShards_RepartitionsLocalDayShards(t *testing.T) {
+	dir := t.TempDir()
+	est := time.FixedZone("EST", -5*3600)
+	lateLocal := time.Date(2024, 1, 15, 23, 30, 0, 123456789, est) // 2024-01-16T04:30Z
+	writeLegacyShard(t, dir, "2024-01-15", map[string]map[string]interface{}{
+		"late": {"timestamp": lateLocal, "_raw": "late", "_src": "app.log", "_seq": int64(7), "status": int64(500)},
+		"noon": {"timestamp": time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC), "_raw": "noon", "_src": "app.log", "_seq": int64(3)},
+	})
+
+	store, err := NewStorage(dir)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if store.utcShards {
+		t.Fatal("existing shards without a settings file must not be treated as UTC-normalized")
+	}
+
+	// Before migrating, the widened shard selection still finds the row that
+	// sits in its local-date shard.
+	page := func() SearchPageResult {
+		t.Helper()
+		result, err := store.SearchPage(context.Background(), SearchOptions{
+			StartDate: time.Date(2024, 1, 16, 4, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2024, 1, 16, 5, 0, 0, 0, time.UTC),
+			Limit:     10,
+			SortBy:    "timestamp",
+			SortOrder: "asc",
+		})
+		if err != nil {
+			t.Fatalf("SearchPage: %v", err)
+		}
+		return result
+	}
+	if result := page(); len(result.Logs) != 1 || result.Logs[0]["_id"] != "late" {
+		t.Fatalf("expected the late row before migrating, got %#v", result.Logs)
+	}
+
+	var reported []MigrationProgress
+	result, err := store.MigrateShards(context.Background(), func(p MigrationProgress) {
+		reported = append(reported, p)
+	})
+	if err != nil {
+		t.Fatalf("MigrateShards: %v", err)
+	}
+	if result.DocsScanned != 2 || result.DocsMoved != 1 || result.ShardsRemoved != 0 {
+		t.Fatalf("unexpected migration result %+v", result)
+	}
+	if len(reported) != 1 || reported[0].Shard != "2024-01-15" || reported[0].Moved != 1 {
+		t.Fatalf("unexpected progress %+v", reported)
+	}
+	if dates, _ := store.List(); len(dates) != 2 || dates[0] != "2024-01-15" || dates[1] != "2024-01-16" {
+		t.Fatalf("List after migrate = %v, want [2024-01-15 2024-01-16]", dates)
+	}
+
+	// The moved row keeps its original ID.
+	row, timestamp, ok := storedDocumentRow(storedDocument(t, store, "2024-01-16", "late"))
+	if !ok || !timestamp.Equal(lateLocal) {
+		t.Fatalf("moved timestamp = %v, want %v", timestamp, lateLocal)
+	}
+	if row["_seq"] != int64(7) || row["status"] != float64(500) {
+		t.Fatalf("moved row lost fields: %#v", row)
+	}
+	if remaining, _ := store.GetDocCount("2024-01-15"); remaining != 1 {
+		t.Fatalf("expected one row to stay in 2024-01-15, got %d", remaining)
+	}
+
+	store.Close()
+	reopened, err := NewStorage(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	if !reopened.utcShards {
+		t.Fatal("migration must persist the UTC-normalized flag")
+	}
+}
+
+func TestMigrateShards_AppliesGranularityChange(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "regranulate")
+	dayStore, err := NewStorage(dir)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	ts := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	logs := makeLogs([]time.Time{ts, ts.Add(time.Hour), ts.Add(90 * time.Minute)}, "app.log")
+	for i := range logs {
+		logs[i]["_seq"] = int64(i + 1)
+	}
+	ids, err := dayStore.StoreWithIDs(logs, "app.log")
+	if err != nil {
+		t.Fatalf("StoreWithIDs: %v", err)
+	}
+	dayStore.Close()
+
+	hourStore, err := NewStorageWithOptions(dir, Options{ShardGranularity: ShardHour})
+	if err != nil {
+		t.Fatalf("NewStorageWithOptions: %v", err)
+	}
+	t.Cleanup(func() { hourStore.Close() })
+	result, err := hourStore.MigrateShards(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("MigrateShards: %v", err)
+	}
+	if result.DocsMoved != 3 || result.ShardsRemoved != 1 {
+		t.Fatalf("unexpected migration result %+v", result)
+	}
+	if dates, _ := hourStore.List(); len(dates) != 2 || dates[0] != "2024-01-15T10" || dates[1] != "2024-01-15T11" {
+		t.Fatalf("List after migrate = %v, want [2024-01-15T10 2024-01-15T11]", dates)
+	}
+
+	page, err := hourStore.SearchPage(context.Background(), SearchOptions{
+		StartDate: ts.Add(-time.Hour),
+		EndDate:   ts.Add(2 * time.Hour),
+		Limit:     10,
+		SortBy:    "timestamp",
+		SortOrder: "asc",
+	})
+	if err != nil {
+		t.Fatalf("SearchPage: %v", err)
+	}
+	if len(page.Logs) != len(ids) {
+		t.Fatalf("expected %d rows after migrating, got %d", len(ids), len(page.Logs))
+	}
+	for i, row := range page.Logs {
+		if row["_id"] != ids[i] {
+			t.Fatalf("row %d has ID %v, want %s", i, row["_id"], ids[i])
+		}
+	}
+}
+
+// storeDeclaredRows stores two rows whose fields are indexed as declared:
+// a keyword, a long refusing one value and a stored-only note.
+func storeDeclaredRows(t *testing.T, store *Storage, day time.Time) {
+	t.Helper()
+	schema := FieldSchema{
+		"method": {Type: FieldKeyword},
+		"status": {Type: FieldLong},
+		"note":   {Type: FieldText, StoredOnly: true},
+	}
+	logs := []map[string]interface{}{
+		{"timestamp": day.Add(time.Hour), "_raw": "a", "_src": "nginx", "_seq": int64(1), "method": "GET", "status": "200", "note": "first"},
+		{"timestamp": day.Add(2 * time.Hour), "_raw": "b", "_src": "nginx", "_seq": int64(2), "method": "POST", "status": "N/A", "note": "second"},
+	}
+	if _, err := store.StoreWithOptions(logs, "nginx", StoreOptions{Schema: schema}); err != nil {
+		t.Fatalf("StoreWithOptions: %v", err)
+	}
+}
+
+// checkDeclaredRows verifies the rows of storeDeclaredRows are still indexed
+// as declared, and that no field reports a kind it was not declared as.
+func checkDeclaredRows(t *testing.T, store *Storage, day time.Time) {
+	t.Helper()
+	search := func(query string) []map[string]interface{} {
+		t.Helper()
+		result, err := store.SearchPage(context.Background(), SearchOptions{
+			Query:     query,
+			StartDate: day,
+			EndDate:   day.AddDate(0, 0, 1),
+			Limit:     10,
+			SortBy:    "timestamp",
+			SortOrder: "asc",
+		})
+		if err != nil {
+			t.Fatalf("SearchPage(%q): %v", query, err)
+		}
+		return result.Logs
+	}
+	if rows := search("method:GET"); len(rows) != 1 || rows[0]["_raw"] != "a" {
+		t.Fatalf("keyword must still match the whole value: %v", rows)
+	}
+	if rows := search("method:get"); len(rows) != 0 {
+		t.Fatalf("keyword must still match exactly, not as analyzed text: %v", rows)
+	}
+	if rows := search("status:>=100"); len(rows) != 1 {
+		t.Fatalf("numeric range on a declared long: %v", rows)
+	}
+	if rows := search("note:first status:N"); len(rows) != 0 {
+		t.Fatalf("stored-only and refused values must stay out of the index: %v", rows)
+	}
+	if rows := search(""); len(rows) != 2 || rows[0]["note"] != "first" || rows[1]["status"] != "N/A" {
+		t.Fatalf("stored values must still be shown: %v", rows)
+	}
+	report, err := store.FieldTypeConflicts()
+	if err != nil {
+		t.Fatalf("FieldTypeConflicts: %v", err)
+	}
+	if len(report.Conflicts) != 1 || report.Conflicts[0].Field != "status" || report.Conflicts[0].Rejected != 1 ||
+		len(report.Conflicts[0].Kinds) != 1 {
+		t.Fatalf("unexpected conflicts %+v", report.Conflicts)
+	}
+}
+
+func TestMigrateShards_KeepsDeclaredFieldTypes(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "declared")
+	dayStore, err := NewStorage(dir)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	storeDeclaredRows(t, dayStore, day)
+	dayStore.Close()
+
+	hourStore, err := NewStorageWithOptions(dir, Options{ShardGranularity: ShardHour})
+	if err != nil {
+		t.Fatalf("NewStorageWithOptions: %v", err)
+	}
+	t.Cleanup(func() { hourStore.Close() })
+	result, err := hourStore.MigrateShards(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("MigrateShards: %v", err)
+	}
+	if result.DocsMoved != 2 || result.ShardsRemoved != 1 {
+		t.Fatalf("unexpected migration result %+v", result)
+	}
+	checkDeclaredRows(t, hourStore, day)
+}
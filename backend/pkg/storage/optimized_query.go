@@ -102,6 +102,24 @@ func compactNumericBound(value *float64) string {
 	return string(numeric.MustNewPrefixCodedInt64(numeric.Float64ToInt64(*value), 0))
 }
 
+// decodeNumericBound reverses compactNumericBound, returning nil for the
+// int64 limits an open bound is clamped to. It fails for non-numeric terms.
+func decodeNumericBound(term string) (*float64, bool) {
+	coded := numeric.PrefixCoded(term)
+	if shift, err := coded.Shift(); err != nil || shift != 0 {
+		return nil, false
+	}
+	value, err := coded.Int64()
+	if err != nil {
+		return nil, false
+	}
+	if value == math.MinInt64 || value == math.MaxInt64 {
+		return nil, true
+	}
+	bound := numeric.Int64ToFloat64(value)
+	return &bound, true
+}
+
 // storedPhraseQuery verifies phrase positions against stored field values
 // instead of Bleve term vectors. Candidate selection still uses the inverted
 // index, so only documents containing every phrase term are read.
@@ -111,6 +129,8 @@ type storedPhraseQuery struct {
 	analyzer  string
 	boost     float64
 	fuzziness int
+
+	unverified bool // select candidates only, as Explain counts them
 }
 
 func (q *storedPhraseQuery) Searcher(
@@ -179,7 +199,7 @@ func (q *storedPhraseQuery) Searcher(
 	if err != nil {
 		return nil, err
 	}
-	if len(phrase) == 1 {
+	if len(phrase) == 1 || q.unverified {
 		return candidateSearcher, nil
 	}
 
@@ -253,7 +273,7 @@ func searchableFields(reader index.IndexReader) ([]string, error) {
 	searchable := make([]string, 0, len(fields))
 	for _, field := range fields {
 		switch field {
-		case "_all", "_id", "_seq", "timestamp":
+		case "_all", "_id", "_seq", fingerprintField, "timestamp":
 			continue
 		default:
 			searchable = append(searchable, field)
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	blevesearch "github.com/blevesearch/bleve/v2/search"
+)
+
+// ErrInvalidCursor is returned for a cursor that does not decode or does not
+// fit the request.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+const pageCursorVersion = 1
+
+// pageCursor is the decoded form of SearchPageResult.NextCursor and
+// PrevCursor: the sort key (the sort field's value for fieldSort, then
+// timestamp, _seq and document ID) of the row to resume after, or before.
+// The key holds Bleve's raw sort terms, which may be binary, so it is kept as
+// bytes.
+type pageCursor struct {
+	Version int      `json:"v"`
+	Field   string   `json:"f"`
+	Order   string   `json:"o"`
+	Key     [][]byte `json:"k"`
+	Before  bool     `json:"b,omitempty"`
+}
+
+// decodePageCursor parses a cursor issued for the same sort. An empty cursor
+// decodes to nil.
+func decodePageCursor(value, field, order string) (*pageCursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	switch {
+	case cursor.Version != pageCursorVersion:
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidCursor, cursor.Version)
+	case cursor.Field != field || cursor.Order != order:
+		return nil, fmt.Errorf("%w: issued for sort %s %s", ErrInvalidCursor, cursor.Field, cursor.Order)
+	case len(cursor.Key) != len(pageSortOrder(field, order)):
+		return nil, fmt.Errorf("%w: malformed sort key", ErrInvalidCursor)
+	}
+	return &cursor, nil
+}
+
+// encodePageCursor returns a cursor resuming after, or before, the row with
+// the given sort key.
+func encodePageCursor(field, order string, key []string, before bool) string {
+	cursor := pageCursor{Version: pageCursorVersion, Field: field, Order: order, Before: before}
+	for _, term := range key {
+		cursor.Key = append(cursor.Key, []byte(term))
+	}
+	raw, err := json.Marshal(cursor)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// sortKey returns the cursor's key in the form search-after takes.
+func (c *pageCursor) sortKey() []string {
+	key := make([]string, len(c.Key))
+	for i, term := range c.Key {
+		key[i] = string(term)
+	}
+	return key
+}
+
+// hitSortKey returns the sort terms of a hit, which search-after and
+// search-before accept unchanged.
+func hitSortKey(hit *blevesearch.DocumentMatch) []string {
+	return hit.Sort
+}
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/numeric"
+	blevesearch "github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// pageSortOrder orders rows by field, then as timestampSort does. Rows
+// without the field sort last in either direction.
+func pageSortOrder(field, order string) blevesearch.SortOrder {
+	if field == "timestamp" {
+		return timestampSort(order)
+	}
+	return append(blevesearch.SortOrder{
+		&blevesearch.SortField{Field: field, Desc: order == "desc"},
+	}, timestampSort(order)...)
+}
+
+// docValueSortable reports whether Bleve can order rows by field from its
+// indexed terms: every selected shard indexes it as one kind of value whose
+// terms sort like the values, which holds for numbers, keywords, dates and
+// booleans. Analyzed text would sort by a token rather than the value, and
+// internal fields are not described by shard metadata, so both are ordered
+// from stored values instead. Shards written before kinds were recorded are
+// inspected directly; only purely numeric terms qualify there.
+func (s *Storage) docValueSortable(keys []string, indexes []bleve.Index, field string) (bool, error) {
+	if strings.HasPrefix(field, "_") {
+		return false, nil
+	}
+	kinds := make(map[string]bool)
+	for i, key := range keys {
+		meta, err := s.shardMetadata(key, false)
+		if err != nil {
+			return false, fmt.Errorf("failed to read metadata for %s: %w", key, err)
+		}
+		if meta.KindsKnown {
+			for _, kind := range meta.Kinds[field] {
+				kinds[kind] = true
+			}
+			continue
+		}
+		present, numericOnly, err := termsArePrefixCoded(indexes[i], field)
+		if err != nil {
+			return false, err
+		}
+		if !numericOnly {
+			return false, nil
+		}
+		if present {
+			kinds[kindNumber] = true
+		}
+	}
+	return len(kinds) <= 1 && !kinds[kindText], nil
+}
+
+// termsArePrefixCoded reports whether a shard indexes field at all, and
+// whether every term is a prefix-coded number or date.
+func termsArePrefixCoded(shard bleve.Index, field string) (present, numericOnly bool, err error) {
+	advanced, err := shard.Advanced()
+	if err != nil {
+		return false, false, err
+	}
+	reader, err := advanced.Reader()
+	if err != nil {
+		return false, false, err
+	}
+	defer reader.Close()
+	dict, err := reader.FieldDict(field)
+	if err != nil {
+		return false, false, err
+	}
+	defer dict.Close()
+	for {
+		entry, err := dict.Next()
+		if err != nil {
+			return false, false, err
+		}
+		if entry == nil {
+			return present, true, nil
+		}
+		present = true
+		if valid, _ := numeric.ValidPrefixCodedTermBytes([]byte(entry.Term)); !valid {
+			return true, false, nil
+		}
+	}
+}
+
+// sortCandidate is a row competing for a page sorted by stored value. Ties
+// fall back to the row's timestampSort key.
+type sortCandidate struct {
+	id    string
+	value interface{}
+	key   []string
+}
+
+// storedValueTop keeps the first limit candidates in page order, holding the
+// one that sorts last at the root so it can be evicted cheaply.
+type storedValueTop struct {
+	rows  []sortCandidate
+	limit int
+	desc  bool
+}
+
+func newStoredValueTop(limit int, order string) *storedValueTop {
+	return &storedValueTop{limit: limit, desc: order == "desc"}
+}
+
+// offer adds a candidate, dropping the one sorting last once full.
+func (t *storedValueTop) offer(candidate sortCandidate) {
+	if len(t.rows) < t.limit {
+		heap.Push(t, candidate)
+		return
+	}
+	if t.before(candidate, t.rows[0]) {
+		t.rows[0] = candidate
+		heap.Fix(t, 0)
+	}
+}
+
+// sorted drains the candidates in page order.
+func (t *storedValueTop) sorted() []sortCandidate {
+	out := make([]sortCandidate, len(t.rows))
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(t).(sortCandidate)
+	}
+	return out
+}
+
+// before reports whether a belongs ahead of b on the page.
+func (t *storedValueTop) before(a, b sortCandidate) bool {
+	if c := compareStoredValues(a.value, b.value, t.desc); c != 0 {
+		return c < 0
+	}
+	for i := range a.key {
+		if i >= len(b.key) || a.key[i] == b.key[i] {
+			continue
+		}
+		if t.desc {
+			return a.key[i] > b.key[i]
+		}
+		return a.key[i] < b.key[i]
+	}
+	return false
+}
+
+func (t *storedValueTop) Len() int           { return len(t.rows) }
+func (t *storedValueTop) Less(i, j int) bool { return t.before(t.rows[j], t.rows[i]) }
+func (t *storedValueTop) Swap(i, j int)      { t.rows[i], t.rows[j] = t.rows[j], t.rows[i] }
+func (t *storedValueTop) Push(x any)         { t.rows = append(t.rows, x.(sortCandidate)) }
+func (t *storedValueTop) Pop() any {
+	last := t.rows[len(t.rows)-1]
+	t.rows = t.rows[:len(t.rows)-1]
+	return last
+}
+
+// compareStoredValues orders two stored field values, returning -1 when a
+// sorts first. Missing values sort last in either direction; numbers and
+// times compare naturally, anything else as text.
+func compareStoredValues(a, b interface{}, desc bool) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+
+	natural := 0
+	switch av := a.(type) {
+	case float64:
+		if bv, ok := b.(float64); ok {
+			natural = cmp.Compare(av, bv)
+		} else {
+			natural = strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			natural = av.Compare(bv)
+		} else {
+			natural = strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+		}
+	default:
+		natural = strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+	if desc {
+		return -natural
+	}
+	return natural
+}
+
+// pageRow is a page row with the sort key that positions it.
+type pageRow struct {
+	log map[string]interface{}
+	key []string
+}
+
+// storedSortRows loads the rows of a stored-value sort that fall on the page,
+// skipping the first offset candidates.
+func storedSortRows(ctx context.Context, alias bleve.Index, candidates []sortCandidate, offset int) ([]pageRow, error) {
+	if offset >= len(candidates) {
+		return nil, nil
+	}
+	candidates = candidates[offset:]
+	ids := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		ids[i] = candidate.id
+	}
+	request := bleve.NewSearchRequest(query.NewDocIDQuery(ids))
+	request.Size = len(ids)
+	request.Fields = []string{"*"}
+	result, err := searchShards(ctx, alias, request)
+	if err != nil {
+		return nil, err
+	}
+	logs := make(map[string]map[string]interface{}, len(result.Hits))
+	for _, hit := range result.Hits {
+		if entry, _, ok := pageHitToLog(hit.ID, hit.Fields); ok {
+			logs[hit.ID] = entry
+		}
+	}
+	rows := make([]pageRow, 0, len(candidates))
+	for _, candidate := range candidates {
+		if entry, ok := logs[candidate.id]; ok {
+			rows = append(rows, pageRow{log: entry, key: candidate.key})
+		}
+	}
+	return rows, nil
+}
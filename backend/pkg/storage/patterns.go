@@ -0,0 +1,339 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+const (
+	// DefaultPatternLimit and MaxPatternLimit bound the templates Patterns
+	// returns.
+	DefaultPatternLimit = 50
+	MaxPatternLimit     = 1000
+
+	// PatternScanLimit bounds the rows one Patterns call clusters; the
+	// result is marked truncated past it.
+	PatternScanLimit = 1_000_000
+
+	// patternSamples is how many row IDs each template keeps.
+	patternSamples = 5
+
+	// patternMaxClusters bounds the templates held while scanning. Rows
+	// fitting none of them once it is reached are counted as unclustered.
+	patternMaxClusters = 20_000
+
+	// patternSimilarity is the share of a template's tokens a line must
+	// repeat, position for position, to join it. Wildcards never count as
+	// repeated, so a template that has generalised takes closer lines only.
+	patternSimilarity = 0.5
+
+	// patternPrefixDepth is how many leading tokens route a line to the
+	// templates it is compared with, after its token count.
+	patternPrefixDepth = 1
+
+	// PatternWildcard stands for the tokens that vary within a template.
+	PatternWildcard = "<*>"
+)
+
+// PatternOptions selects the rows Patterns clusters, as SearchPage takes
+// them, and the field whose text is clustered.
+type PatternOptions struct {
+	Query     string
+	StartDate time.Time
+	EndDate   time.Time
+	Sources   []string
+	Field     string // defaults to _raw
+	Limit     int    // templates returned, most frequent first
+}
+
+// Pattern is one template and the rows that fit it.
+type Pattern struct {
+	Template  string // tokens separated by spaces; PatternWildcard marks variable ones
+	Query     string // finds the template's rows by its constant text; empty when it has none to search for
+	Count     int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	SampleIDs []string
+}
+
+// PatternResult is the templates found among the matching rows.
+type PatternResult struct {
+	Patterns    []Pattern
+	Matched     int  // rows clustered
+	Missing     int  // matching rows without the field
+	Unclustered int  // rows left over once patternMaxClusters was reached
+	Clusters    int  // templates found, including those past Limit
+	Truncated   bool // PatternScanLimit rows were clustered and the rest skipped
+	QueryTime   time.Duration
+}
+
+// patternScan counts the rows one window fed a pattern miner.
+type patternScan struct {
+	matched, missing, unclustered int
+	truncated                     bool
+}
+
+// Patterns groups the values of a text field among the rows a query matches
+// into templates, the way the Drain log parser does: lines with the same
+// token count and leading tokens are compared position by position, and a
+// line close enough to a template joins it, turning the tokens they differ
+// in into wildcards. Tokens holding a digit are treated as variable from the
+// start, so IDs, numbers and addresses do not split templates.
+func (s *Storage) Patterns(ctx context.Context, options PatternOptions) (PatternResult, error) {
+	started := time.Now()
+	result := PatternResult{Patterns: []Pattern{}}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if options.Field == "" {
+		options.Field = "_raw"
+	}
+	if options.Limit == 0 {
+		options.Limit = DefaultPatternLimit
+	}
+	if options.Limit < 0 || options.Limit > MaxPatternLimit {
+		return result, fmt.Errorf("limit must be between 1 and %d", MaxPatternLimit)
+	}
+	if options.EndDate.Before(options.StartDate) {
+		result.QueryTime = time.Since(started)
+		return result, nil
+	}
+	keywordFields, err := s.keywordFields()
+	if err != nil {
+		return result, err
+	}
+	baseQuery, err := buildPageQuery(options.Query, options.Sources, keywordFields, s.newRegexpBudget())
+	if err != nil {
+		return result, err
+	}
+
+	dates, err := s.List()
+	if err != nil {
+		return result, fmt.Errorf("failed to list existing dates: %w", err)
+	}
+	miner := newPatternMiner()
+	var scan patternScan
+	err = s.minePatterns(ctx, miner, baseQuery, dates, options.StartDate, options.EndDate, options.Field, &scan, nil)
+	if err != nil {
+		return result, err
+	}
+	result.Matched, result.Missing, result.Unclustered, result.Truncated = scan.matched, scan.missing, scan.unclustered, scan.truncated
+
+	clusters := miner.clusters()
+	result.Clusters = len(clusters)
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].count != clusters[j].count {
+			return clusters[i].count > clusters[j].count
+		}
+		return clusters[i].firstSeen.Before(clusters[j].firstSeen)
+	})
+	for _, cluster := range clusters[:min(len(clusters), options.Limit)] {
+		result.Patterns = append(result.Patterns, Pattern{
+			Template:  strings.Join(cluster.tokens, " "),
+			Query:     patternQuery(options.Field, cluster.tokens),
+			Count:     cluster.count,
+			FirstSeen: cluster.firstSeen,
+			LastSeen:  cluster.lastSeen,
+			SampleIDs: cluster.samples,
+		})
+	}
+	result.QueryTime = time.Since(started)
+	return result, nil
+}
+
+// minePatterns feeds miner the field of every row baseQuery matches between
+// start and end, calling visit, when set, with each row's ID and the template
+// it joins.
+func (s *Storage) minePatterns(
+	ctx context.Context,
+	miner *patternMiner,
+	baseQuery query.Query,
+	dates []string,
+	start, end time.Time,
+	field string,
+	scan *patternScan,
+	visit func(cluster *patternCluster, id string),
+) error {
+	if end.Before(start) {
+		return nil
+	}
+	// Past PatternScanLimit the scan is stopped by canceling its context.
+	scanCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	for _, date := range intersectingDates(dates, start, end, s.shardSlack()) {
+		index, release, err := s.acquireIndex(date, false)
+		if errors.Is(err, errShardNotFound) {
+			continue // removed since List
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get index for date %s: %w", date, err)
+		}
+		err = scanShardMatches(scanCtx, index, baseQuery, start, end, []string{field},
+			func(id string, fields map[string]interface{}, timestamp time.Time) {
+				if scan.truncated {
+					return
+				}
+				if scan.matched+scan.missing >= PatternScanLimit {
+					scan.truncated = true
+					stop()
+					return
+				}
+				text, ok := fields[field].(string)
+				if !ok {
+					scan.missing++
+					return
+				}
+				scan.matched++
+				cluster := miner.add(text, id, timestamp)
+				if cluster == nil {
+					scan.unclustered++
+					return
+				}
+				if visit != nil {
+					visit(cluster, id)
+				}
+			})
+		release()
+		if scan.truncated && ctx.Err() == nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patternCluster is a template being mined and the rows that fit it.
+type patternCluster struct {
+	tokens    []string
+	count     int
+	firstSeen time.Time
+	lastSeen  time.Time
+	samples   []string
+}
+
+// patternMiner is the Drain parse tree, flattened to one map: templates are
+// grouped by token count and leading tokens, the only lines they can take.
+type patternMiner struct {
+	groups map[string][]*patternCluster
+	total  int
+}
+
+func newPatternMiner() *patternMiner {
+	return &patternMiner{groups: make(map[string][]*patternCluster)}
+}
+
+// add files one line under the template it fits best, or a new one, and
+// returns it. It returns nil when the line fit none and no more templates
+// may be made.
+func (m *patternMiner) add(text, id string, timestamp time.Time) *patternCluster {
+	tokens := strings.Fields(text)
+	for i, token := range tokens {
+		if strings.IndexFunc(token, unicode.IsDigit) >= 0 {
+			tokens[i] = PatternWildcard
+		}
+	}
+	key := strconv.Itoa(len(tokens))
+	for _, token := range tokens[:min(len(tokens), patternPrefixDepth)] {
+		key += "\x00" + token
+	}
+
+	var best *patternCluster
+	bestScore, bestWildcards := -1.0, -1
+	for _, cluster := range m.groups[key] {
+		score, wildcards := patternSimilarityScore(cluster.tokens, tokens)
+		if score > bestScore || score == bestScore && wildcards > bestWildcards {
+			best, bestScore, bestWildcards = cluster, score, wildcards
+		}
+	}
+	if best == nil || bestScore < patternSimilarity {
+		if m.total >= patternMaxClusters {
+			return nil
+		}
+		best = &patternCluster{tokens: tokens, firstSeen: timestamp, lastSeen: timestamp}
+		m.groups[key] = append(m.groups[key], best)
+		m.total++
+	} else {
+		for i, token := range tokens {
+			if best.tokens[i] != token {
+				best.tokens[i] = PatternWildcard
+			}
+		}
+	}
+
+	best.count++
+	if timestamp.Before(best.firstSeen) {
+		best.firstSeen = timestamp
+	}
+	if timestamp.After(best.lastSeen) {
+		best.lastSeen = timestamp
+	}
+	if len(best.samples) < patternSamples {
+		best.samples = append(best.samples, id)
+	}
+	return best
+}
+
+func (m *patternMiner) clusters() []*patternCluster {
+	clusters := make([]*patternCluster, 0, m.total)
+	for _, group := range m.groups {
+		clusters = append(clusters, group...)
+	}
+	return clusters
+}
+
+// patternSimilarityScore returns the share of a template's positions whose
+// constant token a line repeats, out of all its positions, wildcards
+// included, and the template's wildcard count, which breaks ties in favour
+// of the more general template.
+func patternSimilarityScore(template, tokens []string) (float64, int) {
+	if len(template) == 0 {
+		return 1, 0
+	}
+	same, wildcards := 0, 0
+	for i, token := range template {
+		if token == PatternWildcard {
+			wildcards++
+		} else if token == tokens[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(template)), wildcards
+}
+
+// patternQuery builds a query string requiring each run of a template's
+// constant tokens as a phrase in field. Runs without letters or digits are
+// left out, since an analyzer drops them and the phrase would match nothing.
+// A template with no other run gets no query rather than one matching
+// every row.
+func patternQuery(field string, tokens []string) string {
+	var clauses []string
+	var run []string
+	flush := func() {
+		phrase := strings.Join(run, " ")
+		run = run[:0]
+		if strings.IndexFunc(phrase, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+			return
+		}
+		phrase = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(phrase)
+		clauses = append(clauses, fmt.Sprintf(`+%s:"%s"`, field, phrase))
+	}
+	for _, token := range tokens {
+		if token == PatternWildcard {
+			flush()
+			continue
+		}
+		run = append(run, token)
+	}
+	flush()
+	return strings.Join(clauses, " ")
+}
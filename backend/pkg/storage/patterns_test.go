@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPatternsClustersLinesIntoTemplates(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	logs := []map[string]interface{}{
+		{"timestamp": base, "_raw": "user alice logged in from 10.0.0.1"},
+		{"timestamp": base.Add(time.Minute), "_raw": "user bob logged in from 10.0.0.2"},
+		{"timestamp": base.Add(2 * time.Minute), "_raw": "disk sda1 is 91% full"},
+		{"timestamp": base.Add(3 * time.Minute), "_raw": "user carol logged in from 10.0.0.3"},
+		{"timestamp": base.Add(4 * time.Minute), "_raw": "disk sdb1 is 97% full"},
+		{"timestamp": base.Add(5 * time.Minute), "_raw": "connection reset by peer"},
+		{"timestamp": base.Add(48 * time.Hour), "_raw": "user dave logged in from 10.0.0.4"},
+	}
+	if _, err := store.StoreWithIDs(logs, "app.log"); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+
+	result, err := store.Patterns(context.Background(), PatternOptions{
+		StartDate: base,
+		EndDate:   base.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Patterns: %v", err)
+	}
+	if result.Matched != 6 || result.Clusters != 3 || result.Truncated || len(result.Patterns) != 3 {
+		t.Fatalf("result = %+v", result)
+	}
+	login := result.Patterns[0]
+	if login.Template != "user <*> logged in from <*>" || login.Count != 3 ||
+		!login.FirstSeen.Equal(base) || !login.LastSeen.Equal(base.Add(3*time.Minute)) || len(login.SampleIDs) != 3 {
+		t.Fatalf("login pattern = %+v", login)
+	}
+	if login.Query != `+_raw:"user" +_raw:"logged in from"` {
+		t.Fatalf("login query = %q", login.Query)
+	}
+	if disk := result.Patterns[1]; disk.Template != "disk <*> is <*> full" || disk.Count != 2 {
+		t.Fatalf("disk pattern = %+v", disk)
+	}
+
+	drill, err := store.SearchPage(context.Background(), SearchOptions{
+		Query:     login.Query,
+		StartDate: base,
+		EndDate:   base.Add(time.Hour),
+		Limit:     10,
+		SortBy:    "timestamp",
+		SortOrder: "asc",
+	})
+	if err != nil {
+		t.Fatalf("SearchPage: %v", err)
+	}
+	var ids []string
+	for _, row := range drill.Logs {
+		ids = append(ids, row["_id"].(string))
+	}
+	slices.Sort(ids)
+	if !slices.Equal(ids, slices.Sorted(slices.Values(login.SampleIDs))) {
+		t.Fatalf("drill-down ids = %v, want %v", ids, login.SampleIDs)
+	}
+
+	limited, err := store.Patterns(context.Background(), PatternOptions{
+		Query:     "disk",
+		StartDate: base,
+		EndDate:   base.Add(time.Hour),
+		Limit:     1,
+	})
+	if err != nil {
+		t.Fatalf("Patterns with query: %v", err)
+	}
+	if limited.Matched != 2 || limited.Clusters != 1 || len(limited.Patterns) != 1 {
+		t.Fatalf("limited = %+v", limited)
+	}
+}
+
+func TestPatternSimilarityScoreAndQuery(t *testing.T) {
+	template := []string{"user", PatternWildcard, "logged", "in"}
+	if score, wildcards := patternSimilarityScore(template, []string{"user", "bob", "logged", "out"}); score != 0.5 || wildcards != 1 {
+		t.Fatalf("score = %v, wildcards = %d, want 0.5 and 1", score, wildcards)
+	}
+	if query := patternQuery("_raw", template); query != `+_raw:"user" +_raw:"logged in"` {
+		t.Fatalf("query = %q", query)
+	}
+	if query := patternQuery("_raw", []string{PatternWildcard, "-", PatternWildcard}); query != "" {
+		t.Fatalf("wildcard-only template query = %q, want empty", query)
+	}
+}
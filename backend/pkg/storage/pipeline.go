@@ -0,0 +1,318 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrInvalidPipeline is returned for a piped query that does not parse or
+// does not fit the data it runs on.
+var ErrInvalidPipeline = errors.New("invalid pipeline")
+
+// defaultHeadRows is how many rows a bare "head" keeps.
+const defaultHeadRows = 10
+
+// pipeline is a parsed piped query: a Bleve query string selecting rows,
+// then commands shaping them into a table, e.g.
+//
+//	level:ERROR | stats count, p95(latency) by service, span=5m | sort -count | head 20
+//
+// stats must come first; sort and head then apply to its table in order.
+type pipeline struct {
+	filter string
+	stats  statsCommand
+	steps  []tableStep
+}
+
+// statsCommand groups rows by the by fields, and by time bucket when span is
+// set, computing each aggregate per group.
+type statsCommand struct {
+	aggregates []aggregateSpec
+	by         []string
+	span       time.Duration
+}
+
+// aggregateSpec is one stats output column.
+type aggregateSpec struct {
+	name       string  // column name: the alias, or the call as written
+	function   string  // count, sum, avg, min, max or percentile
+	field      string  // empty for a bare count
+	percentile float64 // 0-100, for percentile
+}
+
+// tableStep is a sort or head applied to the stats table.
+type tableStep struct {
+	sort []sortKey
+	head int // rows to keep; 0 for a sort step
+}
+
+type sortKey struct {
+	column string
+	desc   bool
+}
+
+// pipelineCommands are the words that start a command after a "|". A "|"
+// followed by anything else is left to the query string.
+var pipelineCommands = map[string]bool{"stats": true, "sort": true, "head": true}
+
+// parsePipeline splits text into its query string and commands.
+func parsePipeline(text string) (pipeline, error) {
+	var parsed pipeline
+	parts := splitPipeline(text)
+	parsed.filter = strings.TrimSpace(parts[0])
+	if len(parts) == 1 {
+		return parsed, fmt.Errorf("%w: expected \"| stats ...\" after the query", ErrInvalidPipeline)
+	}
+	for i, part := range parts[1:] {
+		name, args := commandWord(part)
+		switch {
+		case name == "stats" && i == 0:
+			stats, err := parseStats(args)
+			if err != nil {
+				return parsed, err
+			}
+			parsed.stats = stats
+		case i == 0:
+			return parsed, fmt.Errorf("%w: the first command must be stats, not %s", ErrInvalidPipeline, name)
+		case name == "stats":
+			return parsed, fmt.Errorf("%w: only one stats command is allowed", ErrInvalidPipeline)
+		case name == "sort":
+			keys, err := parseSortKeys(args)
+			if err != nil {
+				return parsed, err
+			}
+			parsed.steps = append(parsed.steps, tableStep{sort: keys})
+		case name == "head":
+			rows := defaultHeadRows
+			if args = strings.TrimSpace(args); args != "" {
+				n, err := strconv.Atoi(args)
+				if err != nil || n <= 0 {
+					return parsed, fmt.Errorf("%w: head takes a positive row count, not %q", ErrInvalidPipeline, args)
+				}
+				rows = n
+			}
+			parsed.steps = append(parsed.steps, tableStep{head: rows})
+		default:
+			return parsed, fmt.Errorf("%w: unknown command %q", ErrInvalidPipeline, name)
+		}
+	}
+	columns := parsed.stats.columns()
+	for _, step := range parsed.steps {
+		for _, key := range step.sort {
+			if !slices.Contains(columns, key.column) {
+				return parsed, fmt.Errorf("%w: sort column %q is not one of %s", ErrInvalidPipeline, key.column, strings.Join(columns, ", "))
+			}
+		}
+	}
+	return parsed, nil
+}
+
+// splitPipeline splits text at each "|" that starts a command, ignoring any
+// inside quoted phrases and /regex/ or raw:~/regex/ terms. The query string
+// comes first.
+func splitPipeline(text string) []string {
+	var parts []string
+	start := 0
+	inQuote, inRegex := false, false
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\\':
+			i++
+		case inQuote:
+			inQuote = c != '"'
+		case inRegex:
+			inRegex = c != '/'
+		case c == '"':
+			inQuote = true
+		case c == '/' && (i == 0 || strings.IndexByte(" :(+-~", text[i-1]) >= 0):
+			inRegex = true
+		case c == '|':
+			if name, _ := commandWord(text[i+1:]); len(parts) > 0 || pipelineCommands[name] {
+				parts = append(parts, text[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, text[start:])
+}
+
+// commandWord returns the command name leading part, lower-cased, and the
+// rest of it.
+func commandWord(part string) (string, string) {
+	part = strings.TrimSpace(part)
+	end := strings.IndexFunc(part, unicode.IsSpace)
+	if end < 0 {
+		return strings.ToLower(part), ""
+	}
+	return strings.ToLower(part[:end]), part[end:]
+}
+
+// parseStats reads "agg[, agg...] [by field[, field...]]", where any item
+// may instead be span=<duration>.
+func parseStats(args string) (statsCommand, error) {
+	var stats statsCommand
+	tokens := statsTokens(args)
+	inBy := false
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		switch {
+		case token == ",":
+			continue
+		case strings.EqualFold(token, "by") && !inBy:
+			inBy = true
+			continue
+		case strings.EqualFold(token, "span") && i+2 < len(tokens) && tokens[i+1] == "=":
+			span, err := ParseSpan(tokens[i+2])
+			if err != nil {
+				return stats, fmt.Errorf("%w: %v", ErrInvalidPipeline, err)
+			}
+			stats.span = span
+			i += 2
+			continue
+		case inBy:
+			if strings.IndexAny(token, "()=") >= 0 {
+				return stats, fmt.Errorf("%w: unexpected %q in by fields", ErrInvalidPipeline, token)
+			}
+			stats.by = append(stats.by, token)
+			continue
+		}
+
+		spec := aggregateSpec{function: strings.ToLower(token)}
+		if i+3 < len(tokens) && tokens[i+1] == "(" && tokens[i+3] == ")" {
+			spec.field = tokens[i+2]
+			i += 3
+		} else if i+1 < len(tokens) && tokens[i+1] == "(" {
+			return stats, fmt.Errorf("%w: %s( must name one field and close with )", ErrInvalidPipeline, token)
+		}
+		if err := spec.resolve(); err != nil {
+			return stats, err
+		}
+		spec.name = spec.function
+		if spec.field != "" {
+			spec.name = strings.ToLower(token) + "(" + spec.field + ")"
+		}
+		if i+2 < len(tokens) && strings.EqualFold(tokens[i+1], "as") {
+			spec.name = tokens[i+2]
+			i += 2
+		}
+		stats.aggregates = append(stats.aggregates, spec)
+	}
+	if len(stats.aggregates) == 0 {
+		return stats, fmt.Errorf("%w: stats needs at least one aggregate, e.g. stats count", ErrInvalidPipeline)
+	}
+	if inBy && len(stats.by) == 0 && stats.span == 0 {
+		return stats, fmt.Errorf("%w: by needs at least one field", ErrInvalidPipeline)
+	}
+	seen := make(map[string]bool)
+	for _, column := range stats.columns() {
+		if seen[column] {
+			return stats, fmt.Errorf("%w: column %q appears twice; rename one with as", ErrInvalidPipeline, column)
+		}
+		seen[column] = true
+	}
+	return stats, nil
+}
+
+// resolve checks the function name and its field, turning pNN and median
+// into percentiles.
+func (spec *aggregateSpec) resolve() error {
+	call := spec.function
+	switch call {
+	case "count":
+		return nil
+	case "sum", "avg", "min", "max":
+	case "median":
+		spec.function, spec.percentile = "percentile", 50
+	default:
+		n, err := strconv.Atoi(strings.TrimPrefix(call, "p"))
+		if !strings.HasPrefix(call, "p") || err != nil || n < 1 || n > 100 {
+			return fmt.Errorf("%w: unknown aggregate %q; use count, sum, avg, min, max, median or p1-p100", ErrInvalidPipeline, call)
+		}
+		spec.function, spec.percentile = "percentile", float64(n)
+	}
+	if spec.field == "" {
+		return fmt.Errorf("%w: %s needs a field, e.g. %s(latency)", ErrInvalidPipeline, call, call)
+	}
+	return nil
+}
+
+// columns lists the stats table's columns: _time with a span, the by
+// fields, then the aggregates.
+func (stats statsCommand) columns() []string {
+	var columns []string
+	if stats.span > 0 {
+		columns = append(columns, "_time")
+	}
+	columns = append(columns, stats.by...)
+	for _, spec := range stats.aggregates {
+		columns = append(columns, spec.name)
+	}
+	return columns
+}
+
+// statsTokens splits stats arguments into names and the punctuation ( ) , =.
+func statsTokens(args string) []string {
+	var tokens []string
+	for i := 0; i < len(args); {
+		c := args[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.IndexByte("(),=", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			end := i
+			for end < len(args) && strings.IndexByte("(),= \t\n\r", args[end]) < 0 {
+				end++
+			}
+			tokens = append(tokens, args[i:end])
+			i = end
+		}
+	}
+	return tokens
+}
+
+// parseSortKeys reads "[-|+]column[, ...]"; a leading - sorts descending.
+func parseSortKeys(args string) ([]sortKey, error) {
+	var keys []sortKey
+	for _, item := range strings.FieldsFunc(args, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		key := sortKey{column: item}
+		if strings.HasPrefix(item, "-") {
+			key = sortKey{column: item[1:], desc: true}
+		} else if strings.HasPrefix(item, "+") {
+			key.column = item[1:]
+		}
+		if key.column == "" {
+			return nil, fmt.Errorf("%w: sort needs a column name after %q", ErrInvalidPipeline, item)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: sort needs at least one column", ErrInvalidPipeline)
+	}
+	return keys, nil
+}
+
+// ParseSpan reads a time span as a Go duration or a whole number of days
+// with a d suffix, e.g. 30s, 5m, 1h or 1d. Spans under a second are refused.
+func ParseSpan(value string) (time.Duration, error) {
+	var span time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		span = time.Duration(n) * 24 * time.Hour
+	} else {
+		span, err = time.ParseDuration(value)
+	}
+	if err != nil || span < time.Second {
+		return 0, fmt.Errorf("span must be a duration of at least 1s, e.g. 5m, 1h or 1d; got %q", value)
+	}
+	return span, nil
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// ShardEviction describes a shard deleted to bring storage under its quota.
+type ShardEviction struct {
+	Shard string
+	Bytes int64
+	Docs  uint64
+}
+
+// SizeQuotaResult reports the storage directory size around a quota run and
+// the shards evicted, oldest first.
+type SizeQuotaResult struct {
+	BytesBefore int64
+	BytesAfter  int64
+	Evicted     []ShardEviction
+}
+
+// EnforceSizeQuota deletes the oldest shards until the storage directory holds
+// at most maxBytes. Side files (settings, workspaces, the grok catalog) count
+// toward the total but are never deleted, and the newest shard is always kept
+// so ingest has somewhere to land; the directory can therefore stay above a
+// quota smaller than one shard. A non-positive maxBytes is a no-op.
+func (s *Storage) EnforceSizeQuota(maxBytes int64) (SizeQuotaResult, error) {
+	var result SizeQuotaResult
+	if maxBytes <= 0 {
+		return result, nil
+	}
+
+	// Same lock order as StoreWithIDs; holding the write lease keeps an
+	// in-flight batch from landing in a shard as it is deleted.
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total, err := dirSize(s.baseDir)
+	if err != nil {
+		return result, fmt.Errorf("failed to measure storage directory: %w", err)
+	}
+	result.BytesBefore, result.BytesAfter = total, total
+	if total <= maxBytes {
+		return result, nil
+	}
+
+	keys, err := s.List()
+	if err != nil {
+		return result, err
+	}
+	for i := 0; i < len(keys)-1 && result.BytesAfter > maxBytes; i++ {
+		key := keys[i]
+		size, err := dirSize(filepath.Join(s.baseDir, shardDirName(key)))
+		if err != nil {
+			return result, fmt.Errorf("failed to measure shard %s: %w", key, err)
+		}
+		var docs uint64
+		if meta := s.loadMetaLocked(key); meta != nil {
+			docs = meta.DocCount
+		}
+		if err := s.removeShardLocked(key); err != nil {
+			return result, err
+		}
+		result.BytesAfter -= size
+		result.Evicted = append(result.Evicted, ShardEviction{Shard: key, Bytes: size, Docs: docs})
+	}
+	return result, nil
+}
+
+// dirSize sums the sizes of the regular files under root.
+func dirSize(root string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
@@ -0,0 +1,411 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/blevesearch/bleve/v2/analysis"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/blevesearch/bleve/v2/search/searcher"
+	index "github.com/blevesearch/bleve_index_api"
+)
+
+// ErrRegexpLimit is returned when a query's raw:~/regex/ terms run past the
+// time or scan limit.
+var ErrRegexpLimit = errors.New("regex query limit exceeded")
+
+const (
+	// DefaultRegexpTimeout bounds the time one query spends verifying
+	// raw:~/regex/ matches when Options does not say.
+	DefaultRegexpTimeout = 10 * time.Second
+
+	// DefaultRegexpScanLimit bounds the stored _raw values one query reads
+	// to verify raw:~/regex/ matches when Options does not say.
+	DefaultRegexpScanLimit = 1_000_000
+
+	rawRegexpPrefix = "raw:~/"
+
+	// termScanCheckInterval is how many dictionary terms rawTermScanQuery
+	// reads between checks of its deadline.
+	termScanCheckInterval = 1024
+)
+
+// rawRegexpClause is a raw:~/regex/ term taken out of a query string.
+type rawRegexpClause struct {
+	pattern *regexp.Regexp
+	exclude bool // written as -raw:~/regex/
+}
+
+// extractRawRegexps removes the raw:~/regex/ terms from a query string,
+// which Bleve's parser does not understand, and returns the rest. A term may
+// be prefixed with + (the default) or - to exclude its matches; it cannot sit
+// inside parentheses, since it is always combined with the rest by AND. A /
+// inside the regex is written \/.
+func extractRawRegexps(text string) (string, []rawRegexpClause, error) {
+	var rest strings.Builder
+	var clauses []rawRegexpClause
+	copied := 0
+	depth := 0
+	inQuote, inRegex := false, false
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\\':
+			i++
+		case inQuote:
+			inQuote = c != '"'
+		case inRegex:
+			inRegex = c != '/'
+		case c == '"':
+			inQuote = true
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == '/' && (i == 0 || strings.IndexByte(" :(+-", text[i-1]) >= 0):
+			inRegex = true
+		case i == 0 || unicode.IsSpace(rune(text[i-1])) || text[i-1] == '(':
+			term, exclude := text[i:], false
+			if c == '+' || c == '-' {
+				term, exclude = term[1:], c == '-'
+			}
+			if !strings.HasPrefix(term, rawRegexpPrefix) {
+				continue
+			}
+			if depth > 0 {
+				return "", nil, fmt.Errorf("raw:~/regex/ cannot be grouped in parentheses")
+			}
+			source, length, err := readRawRegexp(term[len(rawRegexpPrefix):])
+			if err != nil {
+				return "", nil, err
+			}
+			pattern, err := regexp.Compile(source)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid raw regex: %w", err)
+			}
+			clauses = append(clauses, rawRegexpClause{pattern: pattern, exclude: exclude})
+			end := len(text) - len(term) + len(rawRegexpPrefix) + length
+			rest.WriteString(text[copied:i])
+			copied = end
+			i = end - 1
+		}
+	}
+	rest.WriteString(text[copied:])
+	return rest.String(), clauses, nil
+}
+
+// readRawRegexp reads a regex up to its closing /, returning it with \/
+// unescaped and the length read, closing / included.
+func readRawRegexp(text string) (string, int, error) {
+	var source strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\\' && i+1 < len(text) && text[i+1] == '/':
+			source.WriteByte('/')
+			i++
+		case c == '\\' && i+1 < len(text):
+			source.WriteString(text[i : i+2])
+			i++
+		case c == '/':
+			if i+1 < len(text) && !unicode.IsSpace(rune(text[i+1])) && text[i+1] != ')' {
+				return "", 0, fmt.Errorf("raw:~/regex/ must be followed by a space")
+			}
+			return source.String(), i + 1, nil
+		default:
+			source.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("raw:~/regex/ is missing its closing /")
+}
+
+// withRawRegexps requires searchQuery and the included clauses to match, and
+// the excluded ones not to.
+func withRawRegexps(searchQuery query.Query, clauses []rawRegexpClause, budget *regexpBudget) query.Query {
+	must := []query.Query{searchQuery}
+	var mustNot []query.Query
+	for _, clause := range clauses {
+		regexpQuery := &rawRegexpQuery{pattern: clause.pattern, budget: budget}
+		if clause.exclude {
+			mustNot = append(mustNot, regexpQuery)
+		} else {
+			must = append(must, regexpQuery)
+		}
+	}
+	return query.NewBooleanQuery(must, nil, mustNot)
+}
+
+// regexpBudget is the time and stored values one query's raw:~/regex/ terms
+// may spend, shared by every shard and request the query runs in. Documents
+// already verified are remembered, so paging through the same matches again
+// costs nothing.
+type regexpBudget struct {
+	deadline time.Time
+
+	mu        sync.Mutex
+	remaining int
+	verified  map[string]bool // by pattern and document ID
+}
+
+// newRegexpBudget starts the budget of a query about to run.
+func (s *Storage) newRegexpBudget() *regexpBudget {
+	timeout, scans := s.regexpTimeout, s.regexpScanLimit
+	if timeout <= 0 {
+		timeout = DefaultRegexpTimeout
+	}
+	if scans <= 0 {
+		scans = DefaultRegexpScanLimit
+	}
+	return &regexpBudget{
+		deadline:  time.Now().Add(timeout),
+		remaining: scans,
+		verified:  make(map[string]bool),
+	}
+}
+
+// verify reports whether the document matches pattern, calling read to get
+// its stored _raw values unless it was verified before.
+func (b *regexpBudget) verify(pattern *regexp.Regexp, id string, read func() ([]string, error)) (bool, error) {
+	key := pattern.String() + "\x00" + id
+	b.mu.Lock()
+	matched, ok := b.verified[key]
+	exhausted := !ok && b.remaining <= 0
+	if !ok && !exhausted {
+		b.remaining--
+	}
+	b.mu.Unlock()
+	switch {
+	case ok:
+		return matched, nil
+	case exhausted:
+		return false, fmt.Errorf("%w: read the most rows allowed; narrow the time range or add terms", ErrRegexpLimit)
+	case time.Now().After(b.deadline):
+		return false, fmt.Errorf("%w: ran out of time; narrow the time range or add terms", ErrRegexpLimit)
+	}
+
+	values, err := read()
+	if err != nil {
+		return false, err
+	}
+	for _, value := range values {
+		if matched = pattern.MatchString(value); matched {
+			break
+		}
+	}
+	b.mu.Lock()
+	b.verified[key] = matched
+	b.mu.Unlock()
+	return matched, nil
+}
+
+// rawRegexpQuery matches documents whose stored _raw matches pattern. Like
+// storedPhraseQuery, it selects candidates with the inverted index, here
+// from the words the pattern's literal text requires, and reads the stored
+// value of those only.
+type rawRegexpQuery struct {
+	pattern *regexp.Regexp
+	budget  *regexpBudget
+
+	unverified bool // select candidates only, as Explain counts them
+}
+
+func (q *rawRegexpQuery) Searcher(
+	ctx context.Context,
+	reader index.IndexReader,
+	indexMapping mapping.IndexMapping,
+	options search.SearcherOptions,
+) (search.Searcher, error) {
+	analyzerName := indexMapping.AnalyzerNameForPath("_raw")
+	analyzer := indexMapping.AnalyzerNamed(analyzerName)
+	if analyzer == nil {
+		return nil, fmt.Errorf("no analyzer named %q registered", analyzerName)
+	}
+
+	var candidateQuery query.Query = query.NewMatchAllQuery()
+	if candidates := rawRegexpCandidates(q.pattern, analyzer, q.budget); len(candidates) > 0 {
+		candidateQuery = query.NewConjunctionQuery(candidates)
+	}
+	candidateSearcher, err := candidateQuery.Searcher(ctx, reader, indexMapping, options)
+	if err != nil || q.unverified {
+		return candidateSearcher, err
+	}
+	return &verifyingSearcher{
+		Searcher: candidateSearcher,
+		verify: func(match *search.DocumentMatch) (bool, error) {
+			id, err := reader.ExternalID(match.IndexInternalID)
+			if err != nil {
+				return false, err
+			}
+			return q.budget.verify(q.pattern, id, func() ([]string, error) {
+				doc, err := reader.Document(id)
+				if err != nil || doc == nil {
+					return nil, err
+				}
+				var values []string
+				doc.VisitFields(func(stored index.Field) {
+					if stored.Name() == "_raw" && stored.EncodedFieldType() == 't' {
+						values = append(values, string(stored.Value()))
+					}
+				})
+				return values, nil
+			})
+		},
+	}, nil
+}
+
+// rawRegexpCandidates returns term queries on _raw that every match of
+// pattern satisfies: the analyzed words of the literal text the pattern
+// requires. A word at either end of a literal may continue past it in the
+// matched text, so it only needs to be a term's prefix or suffix. Words
+// that may be preceded by more text are found by scanning the term
+// dictionary, which budget bounds in time.
+func rawRegexpCandidates(pattern *regexp.Regexp, analyzer analysis.Analyzer, budget *regexpBudget) []query.Query {
+	parsed, err := syntax.Parse(pattern.String(), syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	parsed = parsed.Simplify()
+	for parsed.Op == syntax.OpCapture {
+		parsed = parsed.Sub[0]
+	}
+	required := []*syntax.Regexp{parsed}
+	if parsed.Op == syntax.OpConcat {
+		required = parsed.Sub
+	}
+
+	var candidates []query.Query
+	for _, part := range required {
+		if part.Op != syntax.OpLiteral {
+			continue
+		}
+		literal := string(part.Rune)
+		for _, token := range analyzer.Analyze([]byte(literal)) {
+			term := string(token.Term)
+			switch open, close := token.Start == 0, token.End == len(literal); {
+			case open:
+				candidates = append(candidates, &rawTermScanQuery{term: term, suffix: !close, budget: budget})
+			case close:
+				candidate := query.NewPrefixQuery(term)
+				candidate.SetField("_raw")
+				candidates = append(candidates, candidate)
+			default:
+				candidate := query.NewTermQuery(term)
+				candidate.SetField("_raw")
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	return candidates
+}
+
+// rawTermScanQuery matches documents with a _raw term containing term, or
+// with suffix, ending in it. It reads the whole term dictionary, as a
+// leading-wildcard query would, but stops at the regexp budget's deadline
+// or when the search is canceled.
+type rawTermScanQuery struct {
+	term   string
+	suffix bool
+	budget *regexpBudget
+}
+
+// wildcard is the query written as a wildcard, as Explain shows it.
+func (q *rawTermScanQuery) wildcard() string {
+	if q.suffix {
+		return "*" + q.term
+	}
+	return "*" + q.term + "*"
+}
+
+func (q *rawTermScanQuery) Searcher(
+	ctx context.Context,
+	reader index.IndexReader,
+	indexMapping mapping.IndexMapping,
+	options search.SearcherOptions,
+) (search.Searcher, error) {
+	terms, err := q.scan(ctx, reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return searcher.NewMatchNoneSearcher(reader)
+	}
+	return searcher.NewMultiTermSearcher(ctx, reader, terms, "_raw", 1, options, true)
+}
+
+// scan returns the _raw terms the query accepts.
+func (q *rawTermScanQuery) scan(ctx context.Context, reader index.IndexReader) (terms []string, err error) {
+	dict, err := reader.FieldDict("_raw")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := dict.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+	for read := 0; ; read++ {
+		if read%termScanCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if time.Now().After(q.budget.deadline) {
+				return nil, fmt.Errorf("%w: ran out of time; narrow the time range or add terms", ErrRegexpLimit)
+			}
+		}
+		entry, err := dict.Next()
+		if err != nil || entry == nil {
+			return terms, err
+		}
+		if q.suffix && strings.HasSuffix(entry.Term, q.term) || !q.suffix && strings.Contains(entry.Term, q.term) {
+			terms = append(terms, entry.Term)
+		}
+	}
+}
+
+// verifyingSearcher passes on the matches of the embedded searcher that
+// verify accepts, stopping at verify's first error.
+type verifyingSearcher struct {
+	search.Searcher
+	verify func(*search.DocumentMatch) (bool, error)
+}
+
+func (s *verifyingSearcher) Next(ctx *search.SearchContext) (*search.DocumentMatch, error) {
+	for {
+		next, err := s.Searcher.Next(ctx)
+		if next == nil || err != nil {
+			return nil, err
+		}
+		if ok, err := s.verify(next); err != nil {
+			return nil, err
+		} else if ok {
+			return next, nil
+		}
+		ctx.DocumentMatchPool.Put(next)
+	}
+}
+
+func (s *verifyingSearcher) Advance(ctx *search.SearchContext, id index.IndexInternalID) (*search.DocumentMatch, error) {
+	next, err := s.Searcher.Advance(ctx, id)
+	if next == nil || err != nil {
+		return nil, err
+	}
+	if ok, err := s.verify(next); err != nil {
+		return nil, err
+	} else if ok {
+		return next, nil
+	}
+	ctx.DocumentMatchPool.Put(next)
+	return s.Next(ctx)
+}
+
+var (
+	_ query.Query = (*rawRegexpQuery)(nil)
+	_ query.Query = (*rawTermScanQuery)(nil)
+)
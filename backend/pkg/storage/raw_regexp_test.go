@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExtractRawRegexps(t *testing.T) {
+	rest, clauses, err := extractRawRegexps(`level:ERROR raw:~/timeout after \d+ms/ "raw:~/quoted/" -raw:~/a\/b/ path:/x/`)
+	if err != nil {
+		t.Fatalf("extractRawRegexps: %v", err)
+	}
+	if rest != `level:ERROR  "raw:~/quoted/"  path:/x/` {
+		t.Fatalf("rest = %q", rest)
+	}
+	if len(clauses) != 2 || clauses[0].pattern.String() != `timeout after \d+ms` || clauses[0].exclude ||
+		clauses[1].pattern.String() != "a/b" || !clauses[1].exclude {
+		t.Fatalf("clauses = %+v", clauses)
+	}
+
+	for _, text := range []string{
+		"raw:~/unclosed",
+		"raw:~/a/b",
+		"raw:~/(/",
+		"(level:ERROR raw:~/x/)",
+	} {
+		if _, _, err := extractRawRegexps(text); err == nil {
+			t.Errorf("extractRawRegexps(%q) succeeded", text)
+		}
+	}
+}
+
+func TestSearchPageRawRegexp(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	logs := []map[string]interface{}{
+		{"timestamp": base, "_raw": "ERROR request timeout after 250ms", "level": "ERROR"},
+		{"timestamp": base.Add(time.Minute), "_raw": "ERROR request timeout after many retries", "level": "ERROR"},
+		{"timestamp": base.Add(24 * time.Hour), "_raw": "WARN Timeout after 31ms", "level": "WARN"},
+		{"timestamp": base.Add(25 * time.Hour), "_raw": "INFO served /api/v1/logs in 12ms", "level": "INFO"},
+	}
+	if _, err := store.StoreWithIDs(logs, "app.log"); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+	search := func(queryStr string) (SearchPageResult, error) {
+		return store.SearchPage(context.Background(), SearchOptions{
+			Query:     queryStr,
+			StartDate: base,
+			EndDate:   base.Add(48 * time.Hour),
+			Limit:     10,
+			SortBy:    "timestamp",
+			SortOrder: "asc",
+		})
+	}
+	raws := func(queryStr string) []string {
+		t.Helper()
+		result, err := search(queryStr)
+		if err != nil {
+			t.Fatalf("SearchPage(%q): %v", queryStr, err)
+		}
+		values := make([]string, 0, len(result.Logs))
+		for _, entry := range result.Logs {
+			values = append(values, entry["_raw"].(string))
+		}
+		if result.TotalCount != len(values) {
+			t.Fatalf("SearchPage(%q) total = %d for %d rows", queryStr, result.TotalCount, len(values))
+		}
+		return values
+	}
+
+	if got := raws(`raw:~/imeout after \d+ms/`); len(got) != 2 || got[0] != logs[0]["_raw"] || got[1] != logs[2]["_raw"] {
+		t.Fatalf("regex matches = %q", got)
+	}
+	if got := raws(`level:ERROR raw:~/(?i)timeout after \d+ms/`); len(got) != 1 || got[0] != logs[0]["_raw"] {
+		t.Fatalf("regex with filter matches = %q", got)
+	}
+	if got := raws(`raw:~/\/api\/v1\/logs/`); len(got) != 1 || got[0] != logs[3]["_raw"] {
+		t.Fatalf("escaped slash matches = %q", got)
+	}
+	if got := raws(`-raw:~/\d+ms$/`); len(got) != 1 || got[0] != logs[1]["_raw"] {
+		t.Fatalf("excluded regex matches = %q", got)
+	}
+
+	store.regexpScanLimit = 1
+	if _, err := search(`raw:~/ms$/`); !errors.Is(err, ErrRegexpLimit) {
+		t.Fatalf("expected ErrRegexpLimit past the scan limit, got %v", err)
+	}
+	store.regexpScanLimit = 0
+	store.regexpTimeout = time.Nanosecond
+	if _, err := search(`raw:~/ms$/`); !errors.Is(err, ErrRegexpLimit) {
+		t.Fatalf("expected ErrRegexpLimit past the time limit, got %v", err)
+	}
+	// The term dictionary scan for a word that may be preceded by more text
+	// is bounded too, even when it finds nothing to verify.
+	if _, err := search(`raw:~/zzz/`); !errors.Is(err, ErrRegexpLimit) {
+		t.Fatalf("expected ErrRegexpLimit from the term scan past the time limit, got %v", err)
+	}
+}
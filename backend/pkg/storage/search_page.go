@@ -2,8 +2,10 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -21,8 +23,21 @@ const (
 	searchScanBatchSize = 1000
 	legacyScanBatchSize = 10000
 	maxDistributionBins = 100
+
+	// MaxDistributionBuckets bounds caller-chosen histogram resolution.
+	MaxDistributionBuckets = 1000
+
+	// MaxStoredSortOffset bounds the offset of a stored-value sort, whose heap
+	// holds every row up to the page. Such sorts cannot page by cursor.
+	MaxStoredSortOffset = 10000
 )
 
+// ErrInvalidQuery is returned for a query string the read paths cannot parse.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ErrOffsetTooDeep is returned for a stored-value sort past MaxStoredSortOffset.
+var ErrOffsetTooDeep = errors.New("offset too deep")
+
 // SearchOptions contains every input needed for a bounded log page.
 type SearchOptions struct {
 	Query     string
@@ -31,16 +46,37 @@ type SearchOptions struct {
 	Sources   []string
 	Limit     int
 	Offset    int
+	// Cursor resumes from a previous page's NextCursor or PrevCursor, which
+	// keeps page cost flat with depth and stable while rows are ingested.
+	// It cannot be combined with Offset.
+	Cursor    string
 	SortBy    string
 	SortOrder string
+
+	// Histogram shapes Distribution; the zero value counts rows per bucket
+	// over up to maxDistributionBins buckets.
+	Histogram HistogramOptions
+
+	// Highlight fills SearchPageResult.Highlights.
+	Highlight bool
+
+	keywordFields map[string]bool // set by SearchPage; see useExactTerms
+	regexpBudget  *regexpBudget   // set by SearchPage; see buildPageQuery
 }
 
 // SearchDistributionBucket is a bounded aggregation result for the chart.
+// The split and aggregate fields are only set when HistogramOptions asks for
+// them.
 type SearchDistributionBucket struct {
 	StartTime    time.Time
 	EndTime      time.Time
 	Count        int
 	SourceCounts map[string]int
+
+	SplitCounts map[string]int     // rows per value of the split field
+	SplitOther  int                // rows whose split value is outside the top values
+	Value       *float64           // aggregate over the bucket; nil without values
+	SplitValues map[string]float64 // aggregate per split value that had values
 }
 
 // SearchPageResult returns only the requested rows plus bounded metadata.
@@ -50,12 +86,41 @@ type SearchPageResult struct {
 	AvailableColumns []string
 	Distribution     []SearchDistributionBucket
 	QueryTime        time.Duration
+	NextCursor       string       // rows after this page; empty when none follow
+	PrevCursor       string       // rows before this page; empty on the first page
+	Highlights       []Highlights // where the query matched each row, when asked
 }
 
-// SearchPage retrieves a timestamp-sorted page without materializing every
-// matching document. Existing offset callers are served by bounded
-// search-after scans; cursor pagination can replace the compatibility offset
-// at the HTTP boundary later without changing this storage behavior.
+// SearchPage retrieves a sorted page without materializing every matching
+// document. A cursor seeks straight to its sort key; offset callers are still
+// served by bounded search-after scans. Fields other than timestamp are
+// sorted by Bleve when their indexed terms order like their values, and
+// otherwise by a scan keeping only the rows up to the page in a heap.
+//
+// The query string follows Bleve's query syntax:
+//
+// Basic queries:
+//   - Simple term: "error" (matches any field containing "error")
+//   - Field scoped: "level:ERROR" (matches specific field)
+//   - Exact phrase: "\"connection timeout\"" (matches exact phrase)
+//
+// Boolean operators:
+//   - AND: "+level:ERROR +service:api" (both conditions must match)
+//   - OR: "service:api OR service:auth" (either condition can match)
+//   - NOT: "-level:ERROR" or "NOT level:ERROR" (negation)
+//
+// Range queries:
+//   - Numeric: "latency:>100" (greater than), "latency:<50" (less than)
+//   - Date: "timestamp:>2023-01-01" (after date)
+//
+// Wildcards:
+//   - "mess*" (prefix), "*sage" (suffix), "m*ge" (both)
+//
+// Fuzzy search:
+//   - "errro~" (matches misspelled words, with configurable edit distance)
+//
+// Complex queries can combine these features:
+//   - "+level:ERROR +(service:api OR service:auth) -message:timeout"
 func (s *Storage) SearchPage(ctx context.Context, options SearchOptions) (SearchPageResult, error) {
 	started := time.Now()
 	result := SearchPageResult{Logs: []map[string]interface{}{}}
@@ -63,8 +128,8 @@ func (s *Storage) SearchPage(ctx context.Context, options SearchOptions) (Search
 	if err := ctx.Err(); err != nil {
 		return result, err
 	}
-	if options.SortBy != "timestamp" {
-		return result, fmt.Errorf("bounded search does not support sort field %q", options.SortBy)
+	if options.SortBy == "" {
+		return result, fmt.Errorf("sort field is required")
 	}
 	if options.SortOrder != "asc" && options.SortOrder != "desc" {
 		return result, fmt.Errorf("invalid sort order %q", options.SortOrder)
@@ -72,12 +137,30 @@ func (s *Storage) SearchPage(ctx context.Context, options SearchOptions) (Search
 	if options.Limit <= 0 {
 		return result, fmt.Errorf("limit must be positive")
 	}
+	keywordFields, err := s.keywordFields()
+	if err != nil {
+		return result, err
+	}
+	options.keywordFields = keywordFields
+	options.regexpBudget = s.newRegexpBudget()
 	if options.Limit > MaxSearchPageSize {
 		return result, fmt.Errorf("limit must not exceed %d", MaxSearchPageSize)
 	}
 	if options.Offset < 0 {
 		return result, fmt.Errorf("offset must be non-negative")
 	}
+	histogram, err := options.Histogram.resolve(options.StartDate, options.EndDate)
+	if err != nil {
+		return result, err
+	}
+	position, err := decodePageCursor(options.Cursor, options.SortBy, options.SortOrder)
+	if err != nil {
+		return result, err
+	}
+	if position != nil && options.Offset > 0 {
+		return result, fmt.Errorf("%w: offset cannot be combined with a cursor", ErrInvalidCursor)
+	}
+	backward := position != nil && position.Before
 	if options.EndDate.Before(options.StartDate) {
 		return result, nil
 	}
@@ -86,33 +169,29 @@ func (s *Storage) SearchPage(ctx context.Context, options SearchOptions) (Search
 	if err != nil {
 		return result, fmt.Errorf("failed to list existing dates: %w", err)
 	}
-	selectedDates := intersectingDates(dates, options.StartDate, options.EndDate)
+	selectedDates := intersectingDates(dates, options.StartDate, options.EndDate, s.shardSlack())
 	if len(selectedDates) == 0 {
 		result.QueryTime = time.Since(started)
 		return result, nil
 	}
 
-	// Ensure every selected index is open before taking the operation lease.
-	for _, date := range selectedDates {
-		if _, err := s.getOrCreateIndex(date); err != nil {
-			return result, fmt.Errorf("failed to get index for date %s: %w", date, err)
-		}
-	}
-
-	// Keep retention/clear from closing an index between alias construction and
-	// the final facet query.
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
+	// Pin every selected index so retention/clear cannot close one between
+	// alias construction and the final facet query.
 	indexes := make([]bleve.Index, 0, len(selectedDates))
+	shardKeys := make([]string, 0, len(selectedDates))
 	timestampsIndexed := true
 	columnSet := make(map[string]struct{})
 	for _, date := range selectedDates {
-		index, ok := s.indices[date]
-		if !ok {
-			continue
+		index, release, err := s.acquireIndex(date, false)
+		if errors.Is(err, errShardNotFound) {
+			continue // removed since List
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to get index for date %s: %w", date, err)
 		}
+		defer release()
 		indexes = append(indexes, index)
+		shardKeys = append(shardKeys, date)
 		if !timestampIsIndexed(index) {
 			timestampsIndexed = false
 		}
@@ -121,7 +200,7 @@ func (s *Storage) SearchPage(ctx context.Context, options SearchOptions) (Search
 			return result, fmt.Errorf("failed to list fields for date %s: %w", date, fieldErr)
 		}
 		for _, field := range fields {
-			if field != "_seq" && field != "_all" {
+			if field != "_seq" && field != "_all" && field != fingerprintField {
 				columnSet[field] = struct{}{}
 			}
 		}
@@ -137,10 +216,27 @@ func (s *Storage) SearchPage(ctx context.Context, options SearchOptions) (Search
 	}
 	sort.Strings(result.AvailableColumns)
 
-	baseQuery, err := buildPageQuery(options.Query, options.Sources)
+	pageOrder := pageSortOrder(options.SortBy, options.SortOrder)
+	storedSort := false
+	if options.SortBy != "timestamp" {
+		sortable, err := s.docValueSortable(shardKeys, indexes, options.SortBy)
+		if err != nil {
+			return result, err
+		}
+		storedSort = !sortable
+	}
+	if storedSort && position != nil {
+		return result, fmt.Errorf("%w: %s is sorted by stored value, which pages by offset", ErrInvalidCursor, options.SortBy)
+	}
+	if storedSort && options.Offset > MaxStoredSortOffset {
+		return result, fmt.Errorf("%w: %s is sorted by stored value, which pages at most %d rows deep", ErrOffsetTooDeep, options.SortBy, MaxStoredSortOffset)
+	}
+
+	baseQuery, err := buildPageQuery(options.Query, options.Sources, options.keywordFields, options.regexpBudget)
 	if err != nil {
 		return result, err
 	}
+	filterQuery := baseQuery
 	if timestampsIndexed {
 		inclusive := true
 		timeQuery := query.NewDateRangeInclusiveQuery(options.StartDate, options.EndDate, &inclusive, &inclusive)
@@ -148,37 +244,54 @@ func (s *Storage) SearchPage(ctx context.Context, options SearchOptions) (Search
 		baseQuery = bleve.NewConjunctionQuery(baseQuery, timeQuery)
 	}
 	alias := bleve.NewIndexAlias(indexes...)
-	buckets, facetRequest := buildTimeFacet(options.StartDate, options.EndDate)
+	buckets, facetRequest := buildTimeFacet(options.StartDate, options.EndDate, histogram.Buckets, histogram.Interval)
 
+	// One row beyond the page tells whether more follow. Paging backward
+	// collects rows nearest the cursor first and reverses them afterwards.
+	// A stored-value sort instead scans every match into a bounded heap.
+	want := options.Limit + 1
+	rows := make([]pageRow, 0, want)
+	var top *storedValueTop
+	if storedSort {
+		top = newStoredValueTop(options.Offset+want, options.SortOrder)
+	}
 	remainingOffset := options.Offset
-	var searchAfter []string
+	var boundary []string
+	if position != nil {
+		boundary = position.sortKey()
+	}
 	var candidateTotal int
 	firstRequest := true
-	for len(result.Logs) < options.Limit {
+	for storedSort || len(rows) < want {
 		if err := ctx.Err(); err != nil {
 			return SearchPageResult{}, err
 		}
 
 		request := bleve.NewSearchRequest(baseQuery)
-		request.Size = options.Limit - len(result.Logs)
+		request.Size = want - len(rows)
 		if remainingOffset >= searchScanBatchSize {
 			request.Size = searchScanBatchSize
 		} else if remainingOffset > 0 {
 			request.Size += remainingOffset
 		}
-		if request.Size > searchScanBatchSize {
+		if request.Size > searchScanBatchSize || storedSort {
 			request.Size = searchScanBatchSize
 		}
 		request.Fields = []string{"*"}
-		request.SortByCustom(timestampSort(options.SortOrder))
-		if len(searchAfter) > 0 {
-			request.SetSearchAfter(searchAfter)
+		if storedSort {
+			request.Fields = []string{options.SortBy, "timestamp"}
+		}
+		request.SortByCustom(pageOrder)
+		if len(boundary) > 0 && backward {
+			request.SetSearchBefore(boundary)
+		} else if len(boundary) > 0 {
+			request.SetSearchAfter(boundary)
 		}
 		if firstRequest && timestampsIndexed {
 			request.AddFacet("time", facetRequest)
 		}
 
-		searchResult, searchErr := alias.SearchInContext(ctx, request)
+		searchResult, searchErr := searchShards(ctx, alias, request)
 		if searchErr != nil {
 			return SearchPageResult{}, searchErr
 		}
@@ -194,31 +307,81 @@ func (s *Storage) SearchPage(ctx context.Context, options SearchOptions) (Search
 			break
 		}
 
-		for _, hit := range searchResult.Hits {
+		hits := searchResult.Hits
+		for i := range hits {
+			hit := hits[i]
+			if backward {
+				hit = hits[len(hits)-1-i]
+			}
 			logEntry, timestamp, ok := pageHitToLog(hit.ID, hit.Fields)
 			if !ok || timestamp.Before(options.StartDate) || timestamp.After(options.EndDate) {
 				continue
 			}
+			if storedSort {
+				top.offer(sortCandidate{id: hit.ID, value: logEntry[options.SortBy], key: hitSortKey(hit)})
+				continue
+			}
 			if remainingOffset > 0 {
 				remainingOffset--
 				continue
 			}
-			result.Logs = append(result.Logs, logEntry)
-			if len(result.Logs) == options.Limit {
+			rows = append(rows, pageRow{log: logEntry, key: hitSortKey(hit)})
+			if len(rows) == want {
 				break
 			}
 		}
 
-		lastHit := searchResult.Hits[len(searchResult.Hits)-1]
-		searchAfter = lastHit.DecodedSort
-		if len(searchAfter) == 0 {
-			searchAfter = lastHit.Sort
+		edge := hits[len(hits)-1]
+		if backward {
+			edge = hits[0]
 		}
-		if len(searchAfter) == 0 || len(searchResult.Hits) < request.Size {
+		boundary = hitSortKey(edge)
+		if len(boundary) == 0 || len(hits) < request.Size {
 			break
 		}
 	}
 
+	if storedSort {
+		if rows, err = storedSortRows(ctx, alias, top.sorted(), options.Offset); err != nil {
+			return SearchPageResult{}, err
+		}
+	}
+	more := len(rows) > options.Limit
+	if more {
+		rows = rows[:options.Limit]
+	}
+	if backward {
+		slices.Reverse(rows)
+	}
+	for _, row := range rows {
+		result.Logs = append(result.Logs, row.log)
+	}
+	if options.Highlight {
+		// The source filter is left out: it selects rows rather than
+		// matching text in them.
+		highlightQuery, err := buildPageQuery(options.Query, nil, options.keywordFields, options.regexpBudget)
+		if err != nil {
+			return SearchPageResult{}, err
+		}
+		highlighter := newHighlighter(highlightQuery, options.keywordFields)
+		result.Highlights = make([]Highlights, 0, len(result.Logs))
+		for _, log := range result.Logs {
+			result.Highlights = append(result.Highlights, highlighter.highlight(log))
+		}
+	}
+	if len(rows) > 0 && !storedSort {
+		moreAfter, moreBefore := more, options.Offset > 0 || position != nil
+		if backward {
+			moreAfter, moreBefore = true, more
+		}
+		if moreAfter {
+			result.NextCursor = encodePageCursor(options.SortBy, options.SortOrder, rows[len(rows)-1].key, false)
+		}
+		if moreBefore {
+			result.PrevCursor = encodePageCursor(options.SortBy, options.SortOrder, rows[0].key, true)
+		}
+	}
+
 	// The selected source is already part of the base query, so one-source
 	// distributions need no second index scan. For multiple sources, bounded
 	// size-zero facet requests preserve the per-source chart breakdown.
@@ -232,7 +395,7 @@ func (s *Storage) SearchPage(ctx context.Context, options SearchOptions) (Search
 			}
 		} else if len(uniqueSources) > 1 {
 			for _, source := range uniqueSources {
-				sourceQuery, queryErr := buildPageQuery(options.Query, []string{source})
+				sourceQuery, queryErr := buildPageQuery(options.Query, []string{source}, options.keywordFields, options.regexpBudget)
 				if queryErr != nil {
 					return SearchPageResult{}, queryErr
 				}
@@ -240,11 +403,11 @@ func (s *Storage) SearchPage(ctx context.Context, options SearchOptions) (Search
 				timeQuery := query.NewDateRangeInclusiveQuery(options.StartDate, options.EndDate, &inclusive, &inclusive)
 				timeQuery.SetField("timestamp")
 				sourceQuery = bleve.NewConjunctionQuery(sourceQuery, timeQuery)
-				_, sourceFacet := buildTimeFacet(options.StartDate, options.EndDate)
+				_, sourceFacet := buildTimeFacet(options.StartDate, options.EndDate, histogram.Buckets, histogram.Interval)
 				request := bleve.NewSearchRequest(sourceQuery)
 				request.Size = 0
 				request.AddFacet("time", sourceFacet)
-				sourceResult, sourceErr := alias.SearchInContext(ctx, request)
+				sourceResult, sourceErr := searchShards(ctx, alias, request)
 				if sourceErr != nil {
 					return SearchPageResult{}, sourceErr
 				}
@@ -253,7 +416,7 @@ func (s *Storage) SearchPage(ctx context.Context, options SearchOptions) (Search
 		}
 	} else {
 		legacyBuckets, legacyTotal, legacyErr := aggregateLegacyMetadata(
-			ctx, alias, baseQuery, buckets, candidateTotal, options, uniqueSources,
+			ctx, alias, baseQuery, buckets, candidateTotal, options, uniqueSources, selectedDates,
 			rangeCoversSelectedShards(options.StartDate, options.EndDate, selectedDates),
 		)
 		if legacyErr != nil {
@@ -263,6 +426,22 @@ func (s *Storage) SearchPage(ctx context.Context, options SearchOptions) (Search
 		result.TotalCount = legacyTotal
 	}
 
+	if histogram.scans() {
+		kind, byTerms := "", false
+		if timestampsIndexed && histogram.Aggregate == "" {
+			if kind, byTerms, err = s.splitTermKind(shardKeys, histogram.SplitBy); err != nil {
+				return SearchPageResult{}, err
+			}
+		}
+		if byTerms {
+			err = splitTerms(ctx, alias, filterQuery, options, histogram, kind, buckets)
+		} else {
+			err = splitDistribution(ctx, indexes, filterQuery, options, histogram, buckets)
+		}
+		if err != nil {
+			return SearchPageResult{}, err
+		}
+	}
 	result.Distribution = buckets
 	result.QueryTime = time.Since(started)
 	return result, nil
@@ -293,15 +472,15 @@ func aggregateLegacyMetadata(
 	candidateTotal int,
 	options SearchOptions,
 	sources []string,
+	selectedDates []string,
 	coversSelectedShards bool,
 ) ([]SearchDistributionBucket, int, error) {
 	if candidateTotal == 0 {
 		return []SearchDistributionBucket{}, 0, nil
 	}
 	if coversSelectedShards {
-		firstDay, _ := time.Parse("2006-01-02", options.StartDate.Format("2006-01-02"))
-		lastDay, _ := time.Parse("2006-01-02", options.EndDate.Format("2006-01-02"))
-		return legacyWholeRangeBucket(ctx, alias, options, sources, candidateTotal, firstDay, lastDay.AddDate(0, 0, 1))
+		span, _ := selectedShardSpan(selectedDates)
+		return legacyWholeRangeBucket(ctx, alias, options, sources, candidateTotal, span.Start, span.End)
 	}
 
 	minimum, minOK, err := boundaryTimestamp(ctx, alias, baseQuery, "asc")
@@ -354,13 +533,13 @@ func legacyWholeRangeBucket(
 		bucket.SourceCounts[sources[0]] = total
 	} else {
 		for _, source := range sources {
-			sourceQuery, err := buildPageQuery(options.Query, []string{source})
+			sourceQuery, err := buildPageQuery(options.Query, []string{source}, options.keywordFields, options.regexpBudget)
 			if err != nil {
 				return nil, 0, err
 			}
 			request := bleve.NewSearchRequest(sourceQuery)
 			request.Size = 0
-			result, err := alias.SearchInContext(ctx, request)
+			result, err := searchShards(ctx, alias, request)
 			if err != nil {
 				return nil, 0, err
 			}
@@ -373,19 +552,37 @@ func legacyWholeRangeBucket(
 }
 
 func rangeCoversSelectedShards(start, end time.Time, dates []string) bool {
-	if len(dates) == 0 {
+	span, ok := selectedShardSpan(dates)
+	if !ok {
 		return false
 	}
-	firstDay, err := time.Parse("2006-01-02", dates[0])
-	if err != nil {
-		return false
+	lastCoveredInstant := span.End.Add(-time.Nanosecond)
+	return !start.After(span.Start) && !end.Before(lastCoveredInstant)
+}
+
+// selectedShardSpan returns the union of the ranges covered by the given
+// chronologically sorted shard keys.
+func selectedShardSpan(dates []string) (shardRange, bool) {
+	if len(dates) == 0 {
+		return shardRange{}, false
 	}
-	lastDay, err := time.Parse("2006-01-02", dates[len(dates)-1])
-	if err != nil {
-		return false
+	span, ok := parseShardKey(dates[0])
+	if !ok {
+		return shardRange{}, false
+	}
+	for _, date := range dates[1:] {
+		shard, ok := parseShardKey(date)
+		if !ok {
+			return shardRange{}, false
+		}
+		if shard.Start.Before(span.Start) {
+			span.Start = shard.Start
+		}
+		if shard.End.After(span.End) {
+			span.End = shard.End
+		}
 	}
-	lastCoveredInstant := lastDay.Add(24*time.Hour - time.Nanosecond)
-	return !start.After(firstDay) && !end.Before(lastCoveredInstant)
+	return span, true
 }
 
 func boundaryTimestamp(ctx context.Context, alias bleve.Index, searchQuery query.Query, order string) (time.Time, bool, error) {
@@ -393,7 +590,7 @@ func boundaryTimestamp(ctx context.Context, alias bleve.Index, searchQuery query
 	request.Size = 1
 	request.Fields = []string{"timestamp"}
 	request.SortByCustom(timestampSort(order))
-	result, err := alias.SearchInContext(ctx, request)
+	result, err := searchShards(ctx, alias, request)
 	if err != nil {
 		return time.Time{}, false, err
 	}
@@ -429,7 +626,7 @@ func scanLegacyMetadata(
 		if len(searchAfter) > 0 {
 			request.SetSearchAfter(searchAfter)
 		}
-		result, err := alias.SearchInContext(ctx, request)
+		result, err := searchShards(ctx, alias, request)
 		if err != nil {
 			return 0, err
 		}
@@ -475,54 +672,128 @@ func scanLegacyMetadata(
 	return total, nil
 }
 
-func distributionBucketIndex(buckets []SearchDistributionBucket, timestamp time.Time) int {
-	if len(buckets) == 0 || timestamp.Before(buckets[0].StartTime) || timestamp.After(buckets[len(buckets)-1].EndTime) {
-		return -1
-	}
-	if timestamp.Equal(buckets[len(buckets)-1].EndTime) {
-		return len(buckets) - 1
+// scanShardMatches visits the ID, stored fields and timestamp of each row of
+// one shard matching baseQuery inside [start, end]. Timestamps are tested on
+// stored values, which also covers shards without timestamp postings.
+func scanShardMatches(
+	ctx context.Context,
+	index bleve.Index,
+	baseQuery query.Query,
+	start time.Time,
+	end time.Time,
+	fields []string,
+	visit func(id string, fields map[string]interface{}, timestamp time.Time),
+) error {
+	searchQuery := baseQuery
+	if timestampIsIndexed(index) {
+		inclusive := true
+		timeQuery := query.NewDateRangeInclusiveQuery(start, end, &inclusive, &inclusive)
+		timeQuery.SetField("timestamp")
+		searchQuery = bleve.NewConjunctionQuery(searchQuery, timeQuery)
 	}
-	totalDuration := buckets[len(buckets)-1].EndTime.Sub(buckets[0].StartTime)
-	if totalDuration <= 0 {
-		return 0
+	var searchAfter []string
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		request := bleve.NewSearchRequest(searchQuery)
+		request.Size = legacyScanBatchSize
+		request.Fields = append([]string{"timestamp"}, fields...)
+		if slices.Contains(fields, "*") {
+			request.Fields = fields // naming timestamp as well would return it twice
+		}
+		request.SortByCustom(blevesearch.SortOrder{&blevesearch.SortDocID{}})
+		if len(searchAfter) > 0 {
+			request.SetSearchAfter(searchAfter)
+		}
+		page, err := index.SearchInContext(ctx, request)
+		if err != nil {
+			return err
+		}
+		for _, hit := range page.Hits {
+			value, _ := hit.Fields["timestamp"].(string)
+			timestamp, err := dateparse.ParseAny(value)
+			if err != nil || timestamp.Before(start) || timestamp.After(end) {
+				continue
+			}
+			visit(hit.ID, hit.Fields, timestamp)
+		}
+		if len(page.Hits) < request.Size {
+			return nil
+		}
+		searchAfter = page.Hits[len(page.Hits)-1].Sort
 	}
-	index := int(float64(timestamp.Sub(buckets[0].StartTime)) / float64(totalDuration) * float64(len(buckets)))
-	if index < 0 {
+}
+
+// distributionBucketIndex returns the bucket holding timestamp, or -1 when
+// it falls outside them. The last bucket includes its end.
+func distributionBucketIndex(buckets []SearchDistributionBucket, timestamp time.Time) int {
+	if len(buckets) == 0 || timestamp.Before(buckets[0].StartTime) || timestamp.After(buckets[len(buckets)-1].EndTime) {
 		return -1
 	}
-	if index >= len(buckets) {
-		return len(buckets) - 1
-	}
-	return index
+	index := sort.Search(len(buckets), func(i int) bool { return buckets[i].StartTime.After(timestamp) }) - 1
+	return max(index, 0)
 }
 
-func intersectingDates(existing []string, start, end time.Time) []string {
+// intersectingDates returns the shard keys whose UTC range overlaps the
+// inclusive [start, end] window widened by slack, in chronological order. Keys
+// of every known layout are understood, so shards written before a
+// granularity change are still selected.
+func intersectingDates(existing []string, start, end time.Time, slack time.Duration) []string {
 	selected := make([]string, 0, len(existing))
-	startDay := start.Format("2006-01-02")
-	endDay := end.Format("2006-01-02")
 	for _, date := range existing {
-		if date >= startDay && date <= endDay {
+		shard, ok := parseShardKey(date)
+		if ok && shard.overlaps(start.Add(-slack), end.Add(slack)) {
 			selected = append(selected, date)
 		}
 	}
-	sort.Strings(selected)
+	sortShardKeys(selected)
 	return selected
 }
 
-func buildPageQuery(queryStr string, sources []string) (query.Query, error) {
-	var searchQuery query.Query
-	if queryStr == "" {
-		searchQuery = bleve.NewMatchAllQuery()
-	} else {
+// searchShards runs request on an alias over shards. An alias reports a
+// shard's failure, such as a raw:~/regex/ term over its limit, in the result
+// status rather than as an error; searchShards returns it.
+func searchShards(ctx context.Context, alias bleve.Index, request *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	result, err := alias.SearchInContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Status.Errors) > 0 {
+		names := make([]string, 0, len(result.Status.Errors))
+		for name := range result.Status.Errors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, result.Status.Errors[names[0]]
+	}
+	return result, nil
+}
+
+// buildPageQuery parses a query string into the query the read paths run.
+// Its raw:~/regex/ terms spend budget, which the caller makes per query.
+func buildPageQuery(queryStr string, sources []string, keywordFields map[string]bool, budget *regexpBudget) (query.Query, error) {
+	var searchQuery query.Query = bleve.NewMatchAllQuery()
+	if queryStr != "" {
 		unescaped, err := url.PathUnescape(queryStr)
 		if err != nil {
-			return nil, fmt.Errorf("invalid query encoding: %w", err)
+			return nil, fmt.Errorf("%w encoding: %w", ErrInvalidQuery, err)
 		}
-		parsed, err := bleve.NewQueryStringQuery(unescaped).Parse()
+		rest, clauses, err := extractRawRegexps(unescaped)
 		if err != nil {
-			return nil, fmt.Errorf("invalid query: %w", err)
+			return nil, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+		}
+		if strings.TrimSpace(rest) != "" {
+			parsed, err := bleve.NewQueryStringQuery(rest).Parse()
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+			}
+			searchQuery = optimizeQuery(parsed)
+			useExactTerms(searchQuery, keywordFields)
+		}
+		if len(clauses) > 0 {
+			searchQuery = withRawRegexps(searchQuery, clauses, budget)
 		}
-		searchQuery = optimizeQuery(parsed)
 	}
 
 	if len(sources) == 0 {
@@ -539,11 +810,16 @@ func buildPageQuery(queryStr string, sources []string) (query.Query, error) {
 	return bleve.NewConjunctionQuery(searchQuery, bleve.NewDisjunctionQuery(sourceQueries...)), nil
 }
 
+// timestampSort orders rows by timestamp, then _seq, then document ID. The
+// fields are left untyped: both only hold prefix-coded terms, so the order is
+// the same as sorting them as a date and a number, but search-after then
+// takes a hit's sort terms verbatim. Typed fields would re-parse them and
+// misplace rows missing a value, which every row is for the unindexed _seq.
 func timestampSort(order string) blevesearch.SortOrder {
 	descending := order == "desc"
 	return blevesearch.SortOrder{
-		&blevesearch.SortField{Field: "timestamp", Type: blevesearch.SortFieldAsDate, Desc: descending},
-		&blevesearch.SortField{Field: "_seq", Type: blevesearch.SortFieldAsNumber, Desc: descending},
+		&blevesearch.SortField{Field: "timestamp", Desc: descending},
+		&blevesearch.SortField{Field: "_seq", Desc: descending},
 		&blevesearch.SortDocID{Desc: descending},
 	}
 }
@@ -576,25 +852,37 @@ func pageHitToLog(id string, fields map[string]interface{}) (map[string]interfac
 	return entry, timestamp, !timestamp.IsZero()
 }
 
-func buildTimeFacet(start, end time.Time) ([]SearchDistributionBucket, *bleve.FacetRequest) {
+// buildTimeFacet splits [start, end] into histogram buckets. An interval
+// gives buckets of that width from start, the last one cut short at end;
+// otherwise the range is split evenly into count buckets, or by default into
+// up to maxDistributionBins buckets of at least a second.
+func buildTimeFacet(start, end time.Time, count int, interval time.Duration) ([]SearchDistributionBucket, *bleve.FacetRequest) {
 	endExclusive := end.Add(time.Nanosecond)
 	duration := endExclusive.Sub(start)
-	bucketCount := maxDistributionBins
-	if duration <= 0 {
-		bucketCount = 1
-	} else if seconds := int((duration + time.Second - 1) / time.Second); seconds < bucketCount {
-		bucketCount = seconds
-	}
-	if bucketCount < 1 {
-		bucketCount = 1
+	bucketCount := count
+	switch {
+	case duration <= 0:
+		bucketCount, interval = 1, 0
+	case interval > 0:
+		bucketCount = int((duration + interval - 1) / interval)
+	case bucketCount <= 0:
+		bucketCount = maxDistributionBins
+		if seconds := int((duration + time.Second - 1) / time.Second); seconds < bucketCount {
+			bucketCount = seconds
+		}
 	}
+	bucketCount = max(1, min(bucketCount, MaxDistributionBuckets))
 
 	buckets := make([]SearchDistributionBucket, bucketCount)
 	facetRequest := bleve.NewFacetRequest("timestamp", bucketCount)
 	for i := 0; i < bucketCount; i++ {
 		bucketStart := start.Add(time.Duration(i) * duration / time.Duration(bucketCount))
 		bucketEnd := start.Add(time.Duration(i+1) * duration / time.Duration(bucketCount))
-		if i == bucketCount-1 {
+		if interval > 0 {
+			bucketStart = start.Add(time.Duration(i) * interval)
+			bucketEnd = bucketStart.Add(interval)
+		}
+		if i == bucketCount-1 || bucketEnd.After(endExclusive) {
 			bucketEnd = endExclusive
 		}
 		name := fmt.Sprintf("bucket-%03d", i)
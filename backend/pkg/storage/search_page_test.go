@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -173,7 +174,10 @@ func TestSearchPageSupportsLegacyUnindexedTimestampShard(t *testing.T) {
 	if err != nil {
 		t.Fatalf("create legacy index: %v", err)
 	}
-	store := &Storage{baseDir: dir, indices: map[string]bleve.Index{date: index}}
+	if err := index.Close(); err != nil {
+		t.Fatalf("close legacy index: %v", err)
+	}
+	store := &Storage{baseDir: dir}
 	t.Cleanup(func() { _ = store.Close() })
 
 	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
@@ -218,4 +222,370 @@ func TestSearchPageSupportsLegacyUnindexedTimestampShard(t *testing.T) {
 	if wholeDay.TotalCount != 3 || len(wholeDay.Distribution) != 1 || wholeDay.Distribution[0].Count != 3 {
 		t.Fatalf("unexpected whole-day legacy result: %#v", wholeDay)
 	}
+
+	// Rows without timestamp postings sort by document ID, and their cursors
+	// carry the missing-value marker for the timestamp.
+	rest, err := store.SearchPage(context.Background(), SearchOptions{
+		StartDate: day,
+		EndDate:   day.Add(24*time.Hour - time.Nanosecond),
+		Sources:   []string{"legacy.log"},
+		Limit:     2,
+		Cursor:    wholeDay.NextCursor,
+		SortBy:    "timestamp",
+		SortOrder: "desc",
+	})
+	if err != nil {
+		t.Fatalf("legacy SearchPage with cursor: %v", err)
+	}
+	if len(rest.Logs) != 1 || rest.Logs[0]["message"] != "before" || rest.NextCursor != "" || rest.PrevCursor == "" {
+		t.Fatalf("unexpected legacy cursor page: %#v", rest)
+	}
+}
+
+func TestSearchPageCursorsPageStablyDuringIngest(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	row := func(message string, offset time.Duration, seq int64) map[string]interface{} {
+		return map[string]interface{}{"timestamp": base.Add(offset), "_raw": message, "_src": "app.log", "message": message, "_seq": seq}
+	}
+	// r4 and r5 share a timestamp, so the page boundary falls between rows
+	// that only _seq orders.
+	logs := []map[string]interface{}{
+		row("r1", time.Minute, 1), row("r2", 2*time.Minute, 2), row("r3", 3*time.Minute, 3),
+		row("r4", 4*time.Minute, 4), row("r5", 4*time.Minute, 5), row("r6", 6*time.Minute, 6), row("r7", 7*time.Minute, 7),
+	}
+	if _, err := store.StoreWithIDs(logs, "app.log"); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+	page := func(cursor string) SearchPageResult {
+		t.Helper()
+		result, err := store.SearchPage(context.Background(), SearchOptions{
+			StartDate: base,
+			EndDate:   base.Add(time.Hour),
+			Limit:     3,
+			Cursor:    cursor,
+			SortBy:    "timestamp",
+			SortOrder: "desc",
+		})
+		if err != nil {
+			t.Fatalf("SearchPage(%q): %v", cursor, err)
+		}
+		return result
+	}
+	messages := func(result SearchPageResult) string {
+		var out []string
+		for _, entry := range result.Logs {
+			out = append(out, entry["message"].(string))
+		}
+		return strings.Join(out, ",")
+	}
+
+	first := page("")
+	if got := messages(first); got != "r7,r6,r5" || first.PrevCursor != "" || first.NextCursor == "" {
+		t.Fatalf("first page = %s (prev %q, next %q)", got, first.PrevCursor, first.NextCursor)
+	}
+	// Rows arriving between requests must not shift the next page.
+	if _, err := store.StoreWithIDs([]map[string]interface{}{row("new", 30*time.Minute, 8)}, "app.log"); err != nil {
+		t.Fatalf("store new row: %v", err)
+	}
+	second := page(first.NextCursor)
+	if got := messages(second); got != "r4,r3,r2" || second.PrevCursor == "" || second.NextCursor == "" {
+		t.Fatalf("second page = %s", got)
+	}
+	last := page(second.NextCursor)
+	if got := messages(last); got != "r1" || last.NextCursor != "" {
+		t.Fatalf("last page = %s (next %q)", got, last.NextCursor)
+	}
+
+	if got := messages(page(last.PrevCursor)); got != "r4,r3,r2" {
+		t.Fatalf("page before the last = %s", got)
+	}
+	back := page(second.PrevCursor)
+	if got := messages(back); got != "r7,r6,r5" || back.PrevCursor == "" {
+		t.Fatalf("page before the second = %s (prev %q)", got, back.PrevCursor)
+	}
+	if got := messages(page(back.PrevCursor)); got != "new" {
+		t.Fatalf("rows ingested since the first page = %s", got)
+	}
+}
+
+func TestSearchPageRejectsInvalidCursors(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	options := SearchOptions{
+		StartDate: time.Now().Add(-time.Hour),
+		EndDate:   time.Now(),
+		Limit:     10,
+		SortBy:    "timestamp",
+		SortOrder: "desc",
+	}
+	ascending := encodePageCursor("timestamp", "asc", []string{"t", "s", "id"}, false)
+	for name, mutate := range map[string]func(*SearchOptions){
+		"garbage":    func(o *SearchOptions) { o.Cursor = "not a cursor" },
+		"sort order": func(o *SearchOptions) { o.Cursor = ascending },
+		"short key":  func(o *SearchOptions) { o.Cursor = encodePageCursor("timestamp", "desc", []string{"t"}, false) },
+		"offset": func(o *SearchOptions) {
+			o.Cursor, o.Offset = encodePageCursor("timestamp", "desc", []string{"t", "s", "id"}, false), 5
+		},
+	} {
+		opts := options
+		mutate(&opts)
+		if _, err := store.SearchPage(context.Background(), opts); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: error = %v, want ErrInvalidCursor", name, err)
+		}
+	}
+}
+
+func TestSearchPageSortsByField(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	logs := []map[string]interface{}{
+		{"timestamp": base.Add(1 * time.Minute), "_raw": "a", "_src": "app.log", "latency": "250", "path": "/b", "message": "delta", "_seq": int64(1)},
+		{"timestamp": base.Add(2 * time.Minute), "_raw": "b", "_src": "app.log", "latency": "-5", "path": "/a", "message": "alpha two", "_seq": int64(2)},
+		{"timestamp": base.Add(3 * time.Minute), "_raw": "c", "_src": "app.log", "latency": "1000", "path": "/c", "message": "charlie", "_seq": int64(3)},
+		{"timestamp": base.Add(4 * time.Minute), "_raw": "d", "_src": "app.log", "path": "/a", "message": "bravo", "_seq": int64(4)},
+	}
+	if _, err := store.StoreWithOptions(logs, "app.log", StoreOptions{Schema: FieldSchema{"path": {Type: FieldKeyword}}}); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+	page := func(field, order, cursor string, offset int) SearchPageResult {
+		t.Helper()
+		result, err := store.SearchPage(context.Background(), SearchOptions{
+			StartDate: base,
+			EndDate:   base.Add(time.Hour),
+			Limit:     2,
+			Offset:    offset,
+			Cursor:    cursor,
+			SortBy:    field,
+			SortOrder: order,
+		})
+		if err != nil {
+			t.Fatalf("SearchPage(%s %s): %v", field, order, err)
+		}
+		return result
+	}
+	raws := func(results ...SearchPageResult) string {
+		var out []string
+		for _, result := range results {
+			for _, entry := range result.Logs {
+				out = append(out, entry["_raw"].(string))
+			}
+		}
+		return strings.Join(out, ",")
+	}
+
+	// Numbers sort numerically, and the row without a latency comes last
+	// in either direction.
+	asc := page("latency", "asc", "", 0)
+	if got := raws(asc, page("latency", "asc", asc.NextCursor, 0)); got != "b,a,c,d" {
+		t.Fatalf("latency asc = %s", got)
+	}
+	desc := page("latency", "desc", "", 0)
+	if got := raws(desc, page("latency", "desc", desc.NextCursor, 0)); got != "c,a,b,d" {
+		t.Fatalf("latency desc = %s", got)
+	}
+	// Keywords sort by whole value, ties by timestamp.
+	if got := raws(page("path", "asc", "", 0), page("path", "asc", "", 2)); got != "b,d,a,c" {
+		t.Fatalf("path asc = %s", got)
+	}
+	// Analyzed text is ordered from stored values and pages by offset.
+	text := page("message", "asc", "", 0)
+	if got := raws(text, page("message", "asc", "", 2)); got != "b,d,c,a" {
+		t.Fatalf("message asc = %s", got)
+	}
+	if text.NextCursor != "" {
+		t.Fatalf("stored-value sorts must not issue cursors: %q", text.NextCursor)
+	}
+	// Their heap grows with the offset, so deep pages are refused.
+	_, err := store.SearchPage(context.Background(), SearchOptions{
+		StartDate: base,
+		EndDate:   base.Add(time.Hour),
+		Limit:     2,
+		Offset:    MaxStoredSortOffset + 1,
+		SortBy:    "message",
+		SortOrder: "asc",
+	})
+	if !errors.Is(err, ErrOffsetTooDeep) {
+		t.Fatalf("deep stored-value page error = %v, want ErrOffsetTooDeep", err)
+	}
+	if got := page("path", "asc", "", MaxStoredSortOffset+1); len(got.Logs) != 0 {
+		t.Fatalf("deep keyword page = %v", got.Logs)
+	}
+}
+
+func TestSearchPageHistogramSplitsAndAggregates(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	logs := []map[string]interface{}{
+		{"timestamp": base.Add(1 * time.Minute), "_raw": "a", "level": "ERROR", "latency": "100"},
+		{"timestamp": base.Add(2 * time.Minute), "_raw": "b", "level": "INFO", "latency": "300"},
+		{"timestamp": base.Add(3 * time.Minute), "_raw": "c", "level": "ERROR", "latency": "50"},
+		{"timestamp": base.Add(16 * time.Minute), "_raw": "d", "level": "WARN", "latency": "7"},
+		{"timestamp": base.Add(17 * time.Minute), "_raw": "e", "latency": "9"},
+	}
+	if _, err := store.StoreWithIDs(logs, "app.log"); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+	result, err := store.SearchPage(context.Background(), SearchOptions{
+		StartDate: base,
+		EndDate:   base.Add(25 * time.Minute),
+		Limit:     1,
+		SortBy:    "timestamp",
+		SortOrder: "desc",
+		Histogram: HistogramOptions{
+			Interval:       10 * time.Minute,
+			SplitBy:        "level",
+			SplitLimit:     2,
+			Aggregate:      "max",
+			AggregateField: "latency",
+		},
+	})
+	if err != nil {
+		t.Fatalf("SearchPage: %v", err)
+	}
+	if len(result.Distribution) != 3 {
+		t.Fatalf("expected 3 ten-minute buckets, got %d", len(result.Distribution))
+	}
+	first, second, last := result.Distribution[0], result.Distribution[1], result.Distribution[2]
+	if first.Count != 3 || second.Count != 2 || last.Count != 0 {
+		t.Fatalf("counts = %d/%d/%d", first.Count, second.Count, last.Count)
+	}
+	if !last.EndTime.Equal(base.Add(25*time.Minute + time.Nanosecond)) {
+		t.Fatalf("last bucket must end at the range end, got %s", last.EndTime)
+	}
+	// ERROR and INFO have the most rows overall, so WARN is counted as other.
+	if first.SplitCounts["ERROR"] != 2 || first.SplitCounts["INFO"] != 1 || second.SplitOther != 1 || len(second.SplitCounts) != 0 {
+		t.Fatalf("split counts = %v/%d, %v/%d", first.SplitCounts, first.SplitOther, second.SplitCounts, second.SplitOther)
+	}
+	if first.Value == nil || *first.Value != 300 || second.Value == nil || *second.Value != 9 || last.Value != nil {
+		t.Fatalf("unexpected bucket maxima: %v %v %v", first.Value, second.Value, last.Value)
+	}
+	if first.SplitValues["ERROR"] != 100 || first.SplitValues["INFO"] != 300 {
+		t.Fatalf("split maxima = %v", first.SplitValues)
+	}
+
+	counted, err := store.SearchPage(context.Background(), SearchOptions{
+		StartDate: base,
+		EndDate:   base.Add(25 * time.Minute),
+		Limit:     1,
+		SortBy:    "timestamp",
+		SortOrder: "desc",
+		Histogram: HistogramOptions{Buckets: 5},
+	})
+	if err != nil {
+		t.Fatalf("SearchPage with bucket count: %v", err)
+	}
+	if len(counted.Distribution) != 5 || counted.Distribution[0].SplitCounts != nil {
+		t.Fatalf("unexpected distribution: %+v", counted.Distribution)
+	}
+
+	for _, histogram := range []HistogramOptions{
+		{Interval: time.Second},
+		{Buckets: MaxDistributionBuckets + 1},
+		{Aggregate: "avg"},
+		{Aggregate: "count", AggregateField: "latency"},
+	} {
+		_, err := store.SearchPage(context.Background(), SearchOptions{
+			StartDate: base, EndDate: base.Add(25 * time.Minute), Limit: 1, SortBy: "timestamp", SortOrder: "desc", Histogram: histogram,
+		})
+		if !errors.Is(err, ErrInvalidHistogram) {
+			t.Fatalf("%+v: expected ErrInvalidHistogram, got %v", histogram, err)
+		}
+	}
+}
+
+func TestSearchPageHistogramSplitsNumbersFromTerms(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	logs := []map[string]interface{}{
+		{"timestamp": base.Add(1 * time.Minute), "_raw": "a", "status": "200"},
+		{"timestamp": base.Add(2 * time.Minute), "_raw": "b", "status": "500"},
+		{"timestamp": base.Add(3 * time.Minute), "_raw": "c", "status": "200"},
+		{"timestamp": base.Add(11 * time.Minute), "_raw": "d", "status": "404"},
+		{"timestamp": base.Add(12 * time.Minute), "_raw": "e", "status": "500"},
+		{"timestamp": base.Add(13 * time.Minute), "_raw": "f"},
+	}
+	if _, err := store.StoreWithIDs(logs, "app.log"); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+	result, err := store.SearchPage(context.Background(), SearchOptions{
+		StartDate: base,
+		EndDate:   base.Add(20*time.Minute - time.Nanosecond),
+		Limit:     1,
+		SortBy:    "timestamp",
+		SortOrder: "desc",
+		Histogram: HistogramOptions{Interval: 10 * time.Minute, SplitBy: "status", SplitLimit: 2},
+	})
+	if err != nil {
+		t.Fatalf("SearchPage: %v", err)
+	}
+	if len(result.Distribution) != 2 {
+		t.Fatalf("expected 2 ten-minute buckets, got %d", len(result.Distribution))
+	}
+	first, second := result.Distribution[0], result.Distribution[1]
+	// 200 and 500 have the most rows; 404 is other and the row without a
+	// status is not split.
+	if len(first.SplitCounts) != 2 || first.SplitCounts["200"] != 2 || first.SplitCounts["500"] != 1 || first.SplitOther != 0 {
+		t.Fatalf("first bucket split = %v/%d", first.SplitCounts, first.SplitOther)
+	}
+	if len(second.SplitCounts) != 1 || second.SplitCounts["500"] != 1 || second.SplitOther != 1 {
+		t.Fatalf("second bucket split = %v/%d", second.SplitCounts, second.SplitOther)
+	}
+}
+
+func TestSearchPageHighlightsMatchesInStoredValues(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	logs := []map[string]interface{}{
+		{"timestamp": base, "_raw": "ERROR disk full on /dev/sda", "host": "web-1", "latency": "250", "level": "ERROR", "_src": "app.log"},
+		{"timestamp": base.Add(time.Minute), "_raw": "ERROR full disk on /dev/sdb", "host": "web-2", "latency": "300", "level": "ERROR", "_src": "app.log"},
+	}
+	if _, err := store.StoreWithIDs(logs, "app.log"); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+	search := func(queryStr string) SearchPageResult {
+		t.Helper()
+		result, err := store.SearchPage(context.Background(), SearchOptions{
+			Query:     queryStr,
+			StartDate: base,
+			EndDate:   base.Add(time.Hour),
+			Sources:   []string{"app.log"},
+			Limit:     10,
+			SortBy:    "timestamp",
+			SortOrder: "asc",
+			Highlight: true,
+		})
+		if err != nil {
+			t.Fatalf("SearchPage(%q): %v", queryStr, err)
+		}
+		if len(result.Highlights) != len(result.Logs) {
+			t.Fatalf("%d highlights for %d rows", len(result.Highlights), len(result.Logs))
+		}
+		return result
+	}
+
+	result := search(`+"disk full" +latency:>100 +host:web* -level:DEBUG raw:~/sd[a-z]$/`)
+	if len(result.Logs) != 1 {
+		t.Fatalf("rows = %v", result.Logs)
+	}
+	highlights := result.Highlights[0]
+	if got := highlights["_raw"]; len(got) != 2 || got[0] != [2]int{6, 15} || got[1] != [2]int{24, 27} {
+		t.Fatalf("_raw highlights = %v", got)
+	}
+	if got := highlights["host"]; len(got) != 1 || got[0] != [2]int{0, 3} {
+		t.Fatalf("host highlights = %v", got)
+	}
+	if got, ok := highlights["latency"]; !ok || len(got) != 0 {
+		t.Fatalf("latency highlights = %v (present %v)", got, ok)
+	}
+	if _, ok := highlights["level"]; ok {
+		t.Fatalf("an excluded term was highlighted: %v", highlights)
+	}
+	if _, ok := highlights["_src"]; ok {
+		t.Fatalf("the source filter was highlighted: %v", highlights)
+	}
+
+	result = search("disk")
+	if len(result.Logs) != 2 || result.Highlights[1]["_raw"][0] != [2]int{11, 15} {
+		t.Fatalf("highlights = %v", result.Highlights)
+	}
 }
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ShardGranularity controls how much time one Bleve shard covers. Shard
+// boundaries are always UTC, so the same instant lands in the same shard no
+// matter which offset its source line carried. High-volume hosts benefit from
+// hourly shards that stay small enough to search quickly, while low-volume
+// sources avoid hundreds of tiny shards with weekly ones. Shards written under
+// a different granularity remain readable: every listing, pruning and search
+// path parses all known layouts.
+type ShardGranularity string
+
+const (
+	ShardHour ShardGranularity = "hour"
+	ShardDay  ShardGranularity = "day"
+	ShardWeek ShardGranularity = "week"
+
+	// DefaultShardGranularity is used for new storage directories and for
+	// directories created before the setting existed.
+	DefaultShardGranularity = ShardDay
+)
+
+const (
+	settingsFileName      = "storage.json"
+	settingsSchemaVersion = 1
+
+	shardDirPrefix = "logs-"
+	shardDirSuffix = ".bleve"
+
+	dayShardLayout  = "2006-01-02"
+	hourShardLayout = "2006-01-02T15"
+
+	// maxZoneOffset is the widest UTC offset in use (UTC+14). Shards written
+	// before sharding was UTC-normalized used each row's own offset, so a row
+	// can sit up to this far outside its shard's UTC range until the storage
+	// is migrated.
+	maxZoneOffset = 14 * time.Hour
+)
+
+// Options configures a Storage instance. The zero value keeps whatever
+// granularity is persisted in the storage directory (day when none is).
+type Options struct {
+	ShardGranularity ShardGranularity
+
+	// MaxOpenShards bounds how many idle shards stay open; shards are opened
+	// on demand and the least recently used are closed beyond it. Shards in
+	// use by a query stay open past the bound until released. Zero means
+	// DefaultMaxOpenShards.
+	MaxOpenShards int
+
+	// RegexpTimeout and RegexpScanLimit bound the time and the stored _raw
+	// values one query's raw:~/regex/ terms may spend verifying matches.
+	// Zero means DefaultRegexpTimeout and DefaultRegexpScanLimit.
+	RegexpTimeout   time.Duration
+	RegexpScanLimit int
+}
+
+// ParseShardGranularity validates a user-supplied granularity name. An empty
+// string is accepted and means "keep the persisted setting".
+func ParseShardGranularity(value string) (ShardGranularity, error) {
+	switch granularity := ShardGranularity(strings.ToLower(strings.TrimSpace(value))); granularity {
+	case "", ShardHour, ShardDay, ShardWeek:
+		return granularity, nil
+	default:
+		return "", fmt.Errorf("invalid shard granularity %q (want hour, day or week)", value)
+	}
+}
+
+// shardKey returns the shard name component for ts in UTC. Unknown or empty
+// granularities fall back to day shards so hand-built Storage values keep the
+// historical layout.
+func (g ShardGranularity) shardKey(ts time.Time) string {
+	ts = ts.UTC()
+	switch g {
+	case ShardHour:
+		return ts.Format(hourShardLayout)
+	case ShardWeek:
+		year, week := ts.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	default:
+		return ts.Format(dayShardLayout)
+	}
+}
+
+// shardRange is the half-open [Start, End) interval covered by one shard.
+type shardRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (r shardRange) overlaps(start, end time.Time) bool {
+	return r.Start.Before(end.Add(time.Nanosecond)) && r.End.After(start)
+}
+
+// parseShardKey recognises every shard layout LogSonic has ever written:
+// "2006-01-02" (day), "2006-01-02T15" (hour) and "2006-W01" (ISO week). Keys
+// always name UTC ranges.
+func parseShardKey(key string) (shardRange, bool) {
+	switch {
+	case len(key) == len(dayShardLayout):
+		start, err := time.ParseInLocation(dayShardLayout, key, time.UTC)
+		if err != nil {
+			return shardRange{}, false
+		}
+		return shardRange{Start: start, End: start.AddDate(0, 0, 1)}, true
+	case len(key) == len(hourShardLayout):
+		start, err := time.ParseInLocation(hourShardLayout, key, time.UTC)
+		if err != nil {
+			return shardRange{}, false
+		}
+		return shardRange{Start: start, End: start.Add(time.Hour)}, true
+	case len(key) == len("2006-W01") && key[4:6] == "-W":
+		var year, week int
+		if _, err := fmt.Sscanf(key, "%04d-W%02d", &year, &week); err != nil || week < 1 || week > 53 {
+			return shardRange{}, false
+		}
+		start := isoWeekStart(year, week)
+		if checkYear, checkWeek := start.ISOWeek(); checkYear != year || checkWeek != week {
+			return shardRange{}, false
+		}
+		return shardRange{Start: start, End: start.AddDate(0, 0, 7)}, true
+	}
+	return shardRange{}, false
+}
+
+// isoWeekStart returns UTC midnight on the Monday that begins ISO week `week`
+// of `year`. January 4th always falls in week 1.
+func isoWeekStart(year, week int) time.Time {
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	offset := (int(jan4.Weekday()) + 6) % 7 // days since Monday
+	return jan4.AddDate(0, 0, -offset+(week-1)*7)
+}
+
+// shardKeyFromPath extracts the key from a "logs-<key>.bleve" directory name.
+func shardKeyFromPath(indexPath string) (string, bool) {
+	base := filepath.Base(indexPath)
+	if !strings.HasPrefix(base, shardDirPrefix) || !strings.HasSuffix(base, shardDirSuffix) {
+		return "", false
+	}
+	key := strings.TrimSuffix(strings.TrimPrefix(base, shardDirPrefix), shardDirSuffix)
+	if _, ok := parseShardKey(key); !ok {
+		return "", false
+	}
+	return key, true
+}
+
+func shardDirName(key string) string {
+	return shardDirPrefix + key + shardDirSuffix
+}
+
+// sortShardKeys orders keys chronologically by shard start. Mixed layouts can
+// coexist after a granularity change, and their lexical order does not match
+// time order ("2024-W03" sorts after every "2024-01-xx" day key).
+func sortShardKeys(keys []string) {
+	sort.SliceStable(keys, func(i, j int) bool {
+		left, _ := parseShardKey(keys[i])
+		right, _ := parseShardKey(keys[j])
+		if !left.Start.Equal(right.Start) {
+			return left.Start.Before(right.Start)
+		}
+		return keys[i] < keys[j]
+	})
+}
+
+// storageSettings is the on-disk shape of <storage>/storage.json.
+type storageSettings struct {
+	Version          int              `json:"version"`
+	ShardGranularity ShardGranularity `json:"shard_granularity"`
+	// UTCShards records that every shard on disk is partitioned by UTC
+	// boundaries: either the directory was created after sharding was
+	// normalized, or `logsonic storage migrate` has re-partitioned it.
+	UTCShards bool `json:"utc_shards"`
+}
+
+// readStorageSettings loads <dir>/storage.json. A missing or empty file yields
+// the zero value, which describes a directory created before the file existed.
+func readStorageSettings(dir string) (storageSettings, error) {
+	var settings storageSettings
+	b, err := os.ReadFile(filepath.Join(dir, settingsFileName))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return settings, nil
+	case err != nil:
+		return settings, fmt.Errorf("failed to read %s: %w", settingsFileName, err)
+	case len(strings.TrimSpace(string(b))) == 0:
+		return settings, nil
+	}
+	if err := json.Unmarshal(b, &settings); err != nil {
+		return settings, fmt.Errorf("failed to parse %s: %w", settingsFileName, err)
+	}
+	granularity, err := ParseShardGranularity(string(settings.ShardGranularity))
+	if err != nil {
+		return settings, fmt.Errorf("%s: %w", settingsFileName, err)
+	}
+	settings.ShardGranularity = granularity
+	return settings, nil
+}
+
+// writeStorageSettings atomically replaces <dir>/storage.json.
+func writeStorageSettings(dir string, settings storageSettings) error {
+	settings.Version = settingsSchemaVersion
+	b, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	path := filepath.Join(dir, settingsFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", settingsFileName, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", settingsFileName, err)
+	}
+	return nil
+}
@@ -0,0 +1,449 @@
+package storage
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/index/upsidedown/store/goleveldb"
+	blevesearch "github.com/blevesearch/bleve/v2/search"
+)
+
+const (
+	// DefaultMaxOpenShards bounds the shards kept open while idle when
+	// Options.MaxOpenShards is unset. Each open shard holds file descriptors
+	// and LevelDB caches, so a year of day shards cannot all stay open.
+	DefaultMaxOpenShards = 64
+
+	// shardMetaFileName caches a closed shard's metadata inside its
+	// directory. It is written when the shard is closed and removed before
+	// the next write, so a crash mid-write leaves no stale copy behind.
+	shardMetaFileName = "logsonic-meta.json"
+)
+
+// errShardNotFound is returned when a read asks for a shard that is not on disk.
+var errShardNotFound = errors.New("shard not found")
+
+// openShard is an open Bleve index. Shards are opened on demand and pinned by
+// every operation using them; unpinned shards sit on the idle list and the
+// least recently used are closed once more than maxOpen are open.
+type openShard struct {
+	index bleve.Index
+	refs  int
+	idle  *list.Element // position in Storage.idle while refs == 0
+}
+
+// shardMeta is what listing needs to know about a shard without opening it.
+type shardMeta struct {
+	DocCount     uint64   `json:"doc_count"`
+	Fields       []string `json:"fields"`
+	Sources      []string `json:"sources,omitempty"`
+	SourcesKnown bool     `json:"sources_known"` // false after deletes until rescanned
+	Current      bool     `json:"current"`       // written with the current layout
+
+	// Kinds lists the kinds of value each field is indexed as, and Rejected
+	// counts values kept stored-only because their declared type refused
+	// them; see FieldTypeConflicts. Both only grow, so deletes leave them a
+	// superset. KindsKnown is false for shards written before kinds were
+	// recorded, or whose metadata was rebuilt from the index.
+	Kinds      map[string][]string `json:"kinds,omitempty"`
+	KindsKnown bool                `json:"kinds_known"`
+	Rejected   map[string]uint64   `json:"rejected,omitempty"`
+
+	dirty   bool   // differs from (or has no) persisted copy
+	writing int    // batches in flight
+	version uint64 // bumped by every write
+}
+
+func (m *shardMeta) clone() shardMeta {
+	c := *m
+	c.Fields = append([]string(nil), m.Fields...)
+	c.Sources = append([]string(nil), m.Sources...)
+	c.Kinds = make(map[string][]string, len(m.Kinds))
+	for field, kinds := range m.Kinds {
+		c.Kinds[field] = append([]string(nil), kinds...)
+	}
+	c.Rejected = make(map[string]uint64, len(m.Rejected))
+	for field, count := range m.Rejected {
+		c.Rejected[field] = count
+	}
+	return c
+}
+
+// shardAdditions is what a batch adds to a shard's metadata.
+type shardAdditions struct {
+	sources  []string            // _src values
+	kinds    map[string][]string // kinds of value indexed, by field; see addDocumentKinds
+	rejected map[string]uint64   // values refused by their declared type, by field
+}
+
+// initCacheLocked prepares the open-shard cache; hand-built Storage values in
+// tests start without one. s.mu must be held for writing.
+func (s *Storage) initCacheLocked() {
+	if s.indices == nil {
+		s.indices = make(map[string]*openShard)
+	}
+	if s.meta == nil {
+		s.meta = make(map[string]*shardMeta)
+	}
+	if s.idle == nil {
+		s.idle = list.New()
+	}
+	if s.released == nil {
+		s.released = sync.NewCond(&s.mu)
+	}
+	if s.maxOpen <= 0 {
+		s.maxOpen = DefaultMaxOpenShards
+	}
+}
+
+// acquireIndex pins the shard's index open, creating the shard first when
+// create is set, and returns a release func that must be called once the
+// caller is done with it. A missing shard is reported as errShardNotFound
+// when create is false.
+func (s *Storage) acquireIndex(key string, create bool) (bleve.Index, func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shard, err := s.openShardLocked(key, create)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.pinLocked(shard)
+
+	var once sync.Once
+	return shard.index, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.unpinLocked(key, shard)
+		})
+	}, nil
+}
+
+// openShardLocked returns the open shard for key, opening or creating it as
+// needed. s.mu must be held for writing.
+func (s *Storage) openShardLocked(key string, create bool) (*openShard, error) {
+	s.initCacheLocked()
+	if shard, ok := s.indices[key]; ok {
+		return shard, nil
+	}
+
+	indexPath := filepath.Join(s.baseDir, shardDirName(key))
+	var index bleve.Index
+	var err error
+	if _, statErr := os.Stat(indexPath); os.IsNotExist(statErr) {
+		if !create {
+			return nil, fmt.Errorf("%w: %s", errShardNotFound, key)
+		}
+		indexConfig := map[string]interface{}{"store": kvConfig}
+		index, err = bleve.NewUsing(indexPath, buildIndexMapping(), "scorch", goleveldb.Name, indexConfig)
+		if err == nil {
+			if markErr := markShardCurrent(index); markErr != nil {
+				index.Close()
+				return nil, fmt.Errorf("failed to initialize index for date %s: %w", key, markErr)
+			}
+			s.meta[key] = &shardMeta{Fields: []string{}, SourcesKnown: true, KindsKnown: true, Current: true, dirty: true}
+		}
+	} else {
+		index, err = bleve.Open(indexPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize index for date %s: %w", key, err)
+	}
+
+	shard := &openShard{index: index}
+	s.indices[key] = shard
+	return shard, nil
+}
+
+func (s *Storage) pinLocked(shard *openShard) {
+	shard.refs++
+	if shard.idle != nil {
+		s.idle.Remove(shard.idle)
+		shard.idle = nil
+	}
+}
+
+// unpinLocked drops one pin. A shard no longer in use joins the front of the
+// idle list, and idle shards beyond maxOpen are closed oldest first.
+func (s *Storage) unpinLocked(key string, shard *openShard) {
+	shard.refs--
+	if shard.refs > 0 {
+		return
+	}
+	s.released.Broadcast()
+	if s.indices[key] != shard {
+		return // closed underneath us by Close
+	}
+	shard.idle = s.idle.PushFront(key)
+	s.evictIdleLocked()
+}
+
+func (s *Storage) evictIdleLocked() {
+	for len(s.indices) > s.maxOpen && s.idle.Len() > 0 {
+		key := s.idle.Back().Value.(string)
+		if err := s.closeShardLocked(key); err != nil {
+			// Forget the handle anyway; the next acquire reopens the shard.
+			delete(s.indices, key)
+		}
+	}
+}
+
+// waitIdleLocked blocks until nobody has the shard pinned and returns it, or
+// nil if it is not open. s.mu must be held for writing; it is released while
+// waiting, so callers must not rely on state read before the call.
+func (s *Storage) waitIdleLocked(key string) *openShard {
+	s.initCacheLocked()
+	for {
+		shard, ok := s.indices[key]
+		if !ok {
+			return nil
+		}
+		if shard.refs == 0 {
+			return shard
+		}
+		s.released.Wait()
+	}
+}
+
+// closeShardLocked persists the shard's metadata and closes it. The shard
+// must not be pinned. s.mu must be held for writing.
+func (s *Storage) closeShardLocked(key string) error {
+	shard, ok := s.indices[key]
+	if !ok {
+		return nil
+	}
+	if shard.idle != nil {
+		s.idle.Remove(shard.idle)
+		shard.idle = nil
+	}
+	s.persistMetaLocked(key)
+	delete(s.indices, key)
+	if err := shard.index.Close(); err != nil {
+		return fmt.Errorf("failed to close index %s: %w", key, err)
+	}
+	return nil
+}
+
+// openShardCount reports how many shard indexes are currently open.
+func (s *Storage) openShardCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.indices)
+}
+
+func (s *Storage) metaPath(key string) string {
+	return filepath.Join(s.baseDir, shardDirName(key), shardMetaFileName)
+}
+
+// loadMetaLocked returns the cached metadata for key, reading the persisted
+// copy if it is not in memory yet. It returns nil when neither exists.
+func (s *Storage) loadMetaLocked(key string) *shardMeta {
+	s.initCacheLocked()
+	if meta, ok := s.meta[key]; ok {
+		return meta
+	}
+	b, err := os.ReadFile(s.metaPath(key))
+	if err != nil {
+		return nil
+	}
+	var meta shardMeta
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil
+	}
+	s.meta[key] = &meta
+	return &meta
+}
+
+// persistMetaLocked writes dirty metadata beside the shard. Failures only cost
+// a reopen later, so they are not reported.
+func (s *Storage) persistMetaLocked(key string) {
+	meta, ok := s.meta[key]
+	if !ok || !meta.dirty || meta.writing > 0 {
+		return
+	}
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return
+	}
+	path := s.metaPath(key)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return
+	}
+	meta.dirty = false
+}
+
+// forgetMetaLocked drops the cached metadata of a removed shard.
+func (s *Storage) forgetMetaLocked(key string) {
+	delete(s.meta, key)
+}
+
+// writeShard commits batch to a pinned shard and refreshes its cached
+// metadata. The persisted copy is removed before the write so a crash cannot
+// leave it stale. added describes the rows the batch indexes; deletes marks
+// that it removes rows, which leaves the shard's sources unknown until the
+// next rescan.
+func (s *Storage) writeShard(key string, index bleve.Index, batch *bleve.Batch, added shardAdditions, deletes bool) error {
+	s.mu.Lock()
+	meta := s.loadMetaLocked(key)
+	if meta == nil {
+		meta = &shardMeta{Current: shardIsCurrent(index)}
+		s.meta[key] = meta
+	}
+	if !meta.dirty {
+		_ = os.Remove(s.metaPath(key))
+		meta.dirty = true
+	}
+	meta.writing++
+	s.mu.Unlock()
+
+	err := index.Batch(batch)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meta.writing--
+	meta.version++
+	if count, countErr := index.DocCount(); countErr == nil {
+		meta.DocCount = count
+	}
+	if fields, fieldsErr := index.Fields(); fieldsErr == nil {
+		sort.Strings(fields)
+		meta.Fields = fields
+	}
+	if deletes {
+		meta.Sources, meta.SourcesKnown = nil, false
+	} else if meta.SourcesKnown {
+		meta.Sources = mergeSorted(meta.Sources, added.sources)
+	}
+	for field, kinds := range added.kinds {
+		if meta.Kinds == nil {
+			meta.Kinds = make(map[string][]string)
+		}
+		meta.Kinds[field] = mergeSorted(meta.Kinds[field], kinds)
+	}
+	for field, count := range added.rejected {
+		if meta.Rejected == nil {
+			meta.Rejected = make(map[string]uint64)
+		}
+		meta.Rejected[field] += count
+	}
+	return err
+}
+
+// shardMetadata returns the shard's metadata, opening the shard only when
+// nothing usable is cached. needSources asks for the source list as well,
+// which costs a scan of the shard when deletes have invalidated it.
+func (s *Storage) shardMetadata(key string, needSources bool) (shardMeta, error) {
+	s.mu.Lock()
+	if meta := s.loadMetaLocked(key); meta != nil && (meta.SourcesKnown || !needSources) {
+		defer s.mu.Unlock()
+		return meta.clone(), nil
+	}
+	s.mu.Unlock()
+
+	index, release, err := s.acquireIndex(key, false)
+	if err != nil {
+		return shardMeta{}, err
+	}
+	defer release()
+
+	s.mu.Lock()
+	var version uint64
+	if meta, ok := s.meta[key]; ok {
+		version = meta.version
+	}
+	s.mu.Unlock()
+
+	fresh := shardMeta{Current: shardIsCurrent(index), dirty: true}
+	if fresh.DocCount, err = index.DocCount(); err != nil {
+		return shardMeta{}, err
+	}
+	if fresh.Fields, err = index.Fields(); err != nil {
+		return shardMeta{}, err
+	}
+	sort.Strings(fresh.Fields)
+	if needSources {
+		if fresh.Sources, err = scanShardSources(index); err != nil {
+			return shardMeta{}, err
+		}
+		fresh.SourcesKnown = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meta, ok := s.meta[key]
+	if ok && (meta.version != version || meta.writing > 0) {
+		// Written to while scanning; report what was seen without caching it.
+		return fresh, nil
+	}
+	if ok {
+		// Kinds cannot be read back from the index; keep what was recorded.
+		fresh.version = meta.version
+		fresh.Kinds, fresh.KindsKnown, fresh.Rejected = meta.Kinds, meta.KindsKnown, meta.Rejected
+	}
+	s.meta[key] = &fresh
+	s.persistMetaLocked(key)
+	return fresh.clone(), nil
+}
+
+// scanShardSources lists the distinct _src values stored in a shard.
+func scanShardSources(index bleve.Index) ([]string, error) {
+	seen := make(map[string]struct{})
+	var searchAfter []string
+	for {
+		request := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+		request.Size = migrateBatchSize
+		request.Fields = []string{"_src"}
+		request.SortByCustom(blevesearch.SortOrder{&blevesearch.SortDocID{}})
+		if len(searchAfter) > 0 {
+			request.SetSearchAfter(searchAfter)
+		}
+		page, err := index.Search(request)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range page.Hits {
+			if source, ok := hit.Fields["_src"].(string); ok {
+				seen[source] = struct{}{}
+			}
+		}
+		if len(page.Hits) < request.Size {
+			break
+		}
+		searchAfter = page.Hits[len(page.Hits)-1].Sort
+		if len(searchAfter) == 0 {
+			break
+		}
+	}
+	sources := make([]string, 0, len(seen))
+	for source := range seen {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources, nil
+}
+
+// mergeSorted adds values to the sorted set.
+func mergeSorted(set []string, values []string) []string {
+	for _, value := range values {
+		i := sort.SearchStrings(set, value)
+		if i < len(set) && set[i] == value {
+			continue
+		}
+		set = append(set, "")
+		copy(set[i+1:], set[i:])
+		set[i] = value
+	}
+	return set
+}
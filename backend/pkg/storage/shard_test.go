@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseShardKey_AllLayouts(t *testing.T) {
+	cases := []struct {
+		key   string
+		start time.Time
+		end   time.Time
+	}{
+		{"2024-01-15", time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-15T23", time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC), time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"2024-W03", time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 22, 0, 0, 0, 0, time.UTC)},
+		// ISO week 1 of 2021 starts on 2021-01-04; 2020-W53 covers the days before.
+		{"2020-W53", time.Date(2020, 12, 28, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		shard, ok := parseShardKey(tc.key)
+		if !ok {
+			t.Fatalf("parseShardKey(%q) failed", tc.key)
+		}
+		if !shard.Start.Equal(tc.start) || !shard.End.Equal(tc.end) {
+			t.Errorf("parseShardKey(%q) = [%v, %v), want [%v, %v)", tc.key, shard.Start, shard.End, tc.start, tc.end)
+		}
+	}
+
+	for _, bad := range []string{"", "backup", "2024-13-01", "2024-W54", "2021-W53", "2024-01-15T25"} {
+		if _, ok := parseShardKey(bad); ok {
+			t.Errorf("parseShardKey(%q) unexpectedly succeeded", bad)
+		}
+	}
+}
+
+func TestShardKeyRoundTripsForEveryGranularity(t *testing.T) {
+	ts := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	for _, granularity := range []ShardGranularity{ShardHour, ShardDay, ShardWeek} {
+		key := granularity.shardKey(ts)
+		shard, ok := parseShardKey(key)
+		if !ok {
+			t.Fatalf("%s: key %q does not parse", granularity, key)
+		}
+		if ts.Before(shard.Start) || !ts.Before(shard.End) {
+			t.Errorf("%s: %v not inside shard %q [%v, %v)", granularity, ts, key, shard.Start, shard.End)
+		}
+	}
+}
+
+func TestParseShardGranularity(t *testing.T) {
+	for _, value := range []string{"", "hour", "DAY", " week "} {
+		if _, err := ParseShardGranularity(value); err != nil {
+			t.Errorf("ParseShardGranularity(%q): %v", value, err)
+		}
+	}
+	if _, err := ParseShardGranularity("month"); err == nil {
+		t.Error("expected month to be rejected")
+	}
+}
+
+func TestNewStorageWithOptions_PersistsGranularity(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "granularity")
+	store, err := NewStorageWithOptions(dir, Options{ShardGranularity: ShardHour})
+	if err != nil {
+		t.Fatalf("NewStorageWithOptions: %v", err)
+	}
+	store.Close()
+	if _, err := os.Stat(filepath.Join(dir, settingsFileName)); err != nil {
+		t.Fatalf("expected %s to be written: %v", settingsFileName, err)
+	}
+
+	reopened, err := NewStorage(dir)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	defer reopened.Close()
+	if got := reopened.ShardGranularity(); got != ShardHour {
+		t.Fatalf("reopened granularity = %q, want hour", got)
+	}
+}
+
+func TestStore_HourlyShards(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "hourly")
+	store, err := NewStorageWithOptions(dir, Options{ShardGranularity: ShardHour})
+	if err != nil {
+		t.Fatalf("NewStorageWithOptions: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	ts := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	if err := store.Store(makeLogs([]time.Time{ts, ts.Add(time.Hour), ts.Add(2 * time.Hour)}, "hourly.log"), "hourly.log"); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	dates, _ := store.List()
+	want := []string{"2024-01-15T10", "2024-01-15T11", "2024-01-15T12"}
+	if len(dates) != len(want) {
+		t.Fatalf("List = %v, want %v", dates, want)
+	}
+	for i := range want {
+		if dates[i] != want[i] {
+			t.Fatalf("List = %v, want %v", dates, want)
+		}
+	}
+
+	result, err := store.SearchPage(context.Background(), SearchOptions{
+		StartDate: ts.Add(50 * time.Minute),
+		EndDate:   ts.Add(3 * time.Hour),
+		Limit:     10,
+		SortBy:    "timestamp",
+		SortOrder: "asc",
+	})
+	if err != nil {
+		t.Fatalf("SearchPage: %v", err)
+	}
+	if result.TotalCount != 2 || len(result.Logs) != 2 {
+		t.Fatalf("expected 2 rows from the last two hourly shards, got %#v", result)
+	}
+}
+
+func TestStore_MixedLayoutsAfterGranularityChange(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mixed")
+	dayStore, err := NewStorage(dir)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	day := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	if err := dayStore.Store(makeLogs([]time.Time{day}, "app.log"), "app.log"); err != nil {
+		t.Fatalf("Store day shard: %v", err)
+	}
+	dayStore.Close()
+
+	weekStore, err := NewStorageWithOptions(dir, Options{ShardGranularity: ShardWeek})
+	if err != nil {
+		t.Fatalf("NewStorageWithOptions: %v", err)
+	}
+	t.Cleanup(func() { weekStore.Close() })
+	if err := weekStore.Store(makeLogs([]time.Time{day.Add(2 * time.Hour)}, "app.log"), "app.log"); err != nil {
+		t.Fatalf("Store week shard: %v", err)
+	}
+
+	dates, _ := weekStore.List()
+	if len(dates) != 2 || dates[0] != "2024-01-15" || dates[1] != "2024-W03" {
+		t.Fatalf("List = %v, want [2024-01-15 2024-W03]", dates)
+	}
+
+	start := day.Add(-time.Hour)
+	end := day.Add(3 * time.Hour)
+	results, err := searchLogs(weekStore, "", start, end, nil)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected rows from both layouts, got %d", len(results))
+	}
+
+	removed, err := weekStore.PruneOlderThan(time.Since(day.AddDate(0, 0, 3)))
+	if err != nil {
+		t.Fatalf("PruneOlderThan: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("expected only the day shard to be pruned, removed %d", removed)
+	}
+	if dates, _ := weekStore.List(); len(dates) != 1 || dates[0] != "2024-W03" {
+		t.Fatalf("List after prune = %v, want [2024-W03]", dates)
+	}
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/blevesearch/bleve/v2"
+	index "github.com/blevesearch/bleve_index_api"
+)
+
+// indexMetaFileName is the Bleve metadata file at the root of every shard.
+// Online copies capture segment data only, so Snapshot copies it separately.
+const indexMetaFileName = "index_meta.json"
+
+// Snapshot writes a point-in-time copy of every shard, plus storage.json, into
+// dir using Bleve's online copy. Writers are paused only while a copy reader
+// is taken on each shard, so the copies agree with each other: an ingest call
+// that spans several shards is captured entirely or not at all. Shards
+// stay pinned for the duration of the copy so retention and clear cannot
+// close an index underneath it. Returns the shard keys written.
+func (s *Storage) Snapshot(ctx context.Context, dir string) ([]string, error) {
+	keys, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	// Lock order matches StoreWithIDs (write lease, then shard map), which
+	// may create a shard while holding its lease.
+	s.writeMu.Lock()
+	readers := make(map[string]index.CopyReader, len(keys))
+	var releases []func()
+	defer func() {
+		for _, reader := range readers {
+			_ = reader.CloseCopyReader()
+		}
+		for _, release := range releases {
+			release()
+		}
+	}()
+	for _, key := range keys {
+		shard, release, err := s.acquireIndex(key, false)
+		if errors.Is(err, errShardNotFound) {
+			continue // removed since List
+		}
+		if err != nil {
+			s.writeMu.Unlock()
+			return nil, fmt.Errorf("failed to get index for date %s: %w", key, err)
+		}
+		releases = append(releases, release)
+		reader, err := copyReader(key, shard)
+		if err != nil {
+			s.writeMu.Unlock()
+			return nil, err
+		}
+		readers[key] = reader
+	}
+	s.writeMu.Unlock()
+
+	written := make([]string, 0, len(readers))
+	for _, key := range keys {
+		reader, ok := readers[key]
+		if !ok {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		target := filepath.Join(dir, shardDirName(key))
+		if err := reader.CopyTo(bleve.FileSystemDirectory(target)); err != nil {
+			return nil, fmt.Errorf("failed to copy shard %s: %w", key, err)
+		}
+		if err := copyFile(filepath.Join(s.baseDir, shardDirName(key), indexMetaFileName), filepath.Join(target, indexMetaFileName)); err != nil {
+			return nil, fmt.Errorf("failed to copy shard %s: %w", key, err)
+		}
+		written = append(written, key)
+	}
+
+	err = copyFile(filepath.Join(s.baseDir, settingsFileName), filepath.Join(dir, settingsFileName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return written, nil
+}
+
+// copyReader opens an online copy reader on a pinned shard.
+func copyReader(key string, shard bleve.Index) (index.CopyReader, error) {
+	advanced, err := shard.Advanced()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open shard %s for copy: %w", key, err)
+	}
+	copyable, ok := advanced.(index.CopyIndex)
+	if !ok {
+		return nil, fmt.Errorf("shard %s does not support online copy", key)
+	}
+	reader := copyable.CopyReader()
+	if reader == nil {
+		return nil, fmt.Errorf("shard %s returned no copy reader", key)
+	}
+	return reader, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
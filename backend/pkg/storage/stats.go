@@ -0,0 +1,440 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxStatsGroups bounds the rows a stats command may produce, so a by
+	// field with a value per row cannot exhaust memory.
+	maxStatsGroups = 10_000
+
+	// percentileSampleSize bounds the values a group keeps per percentile.
+	// Larger groups are estimated from a uniform sample.
+	percentileSampleSize = 10_000
+)
+
+// StatsOptions selects the rows a piped query aggregates. Pipeline is a
+// query string followed by commands (see pipeline); the time range and
+// Sources mean what they do for SearchPage.
+type StatsOptions struct {
+	Pipeline  string
+	StartDate time.Time
+	EndDate   time.Time
+	Sources   []string
+}
+
+// StatsResult is a stats table plus the same data shaped for a chart.
+// Cells are int for counts, float64 (or nil when no value was seen) for the
+// other aggregates, time.Time for _time, and the stored value for by fields.
+type StatsResult struct {
+	Columns   []string
+	Rows      [][]interface{}
+	Chart     StatsChart
+	Matched   int // rows the query selected
+	QueryTime time.Duration
+}
+
+// StatsChart is the table as series over a shared x axis: bucket start
+// times when the query has a span, otherwise one label per row.
+type StatsChart struct {
+	X      []interface{}
+	Series []StatsSeries
+}
+
+// StatsSeries is one aggregate, for one group when the x axis is time. A nil
+// value means the group had no data at that point.
+type StatsSeries struct {
+	Name   string
+	Values []*float64
+}
+
+// Stats runs a piped stats query. Rows missing a by field are left out of
+// the table, as are values of aggregated fields that are not numbers.
+func (s *Storage) Stats(ctx context.Context, options StatsOptions) (StatsResult, error) {
+	started := time.Now()
+	result := StatsResult{Rows: [][]interface{}{}}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	parsed, err := parsePipeline(options.Pipeline)
+	if err != nil {
+		return result, err
+	}
+	result.Columns = parsed.stats.columns()
+	if span := parsed.stats.span; span > 0 && options.EndDate.Sub(options.StartDate)/span >= maxStatsGroups {
+		return result, fmt.Errorf("%w: span %s makes more than %d buckets over the time range", ErrInvalidPipeline, span, maxStatsGroups)
+	}
+	keywordFields, err := s.keywordFields()
+	if err != nil {
+		return result, err
+	}
+	baseQuery, err := buildPageQuery(parsed.filter, options.Sources, keywordFields, s.newRegexpBudget())
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", ErrInvalidPipeline, err)
+	}
+
+	dates, err := s.List()
+	if err != nil {
+		return result, fmt.Errorf("failed to list existing dates: %w", err)
+	}
+	if options.EndDate.Before(options.StartDate) {
+		dates = nil
+	}
+	groups := newStatsGroups(parsed.stats)
+	fields := append(slices.Clone(parsed.stats.by), parsed.stats.aggregateFields()...)
+	for _, date := range intersectingDates(dates, options.StartDate, options.EndDate, s.shardSlack()) {
+		index, release, err := s.acquireIndex(date, false)
+		if errors.Is(err, errShardNotFound) {
+			continue // removed since List
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to get index for date %s: %w", date, err)
+		}
+		err = scanShardMatches(ctx, index, baseQuery, options.StartDate, options.EndDate, fields,
+			func(_ string, fields map[string]interface{}, timestamp time.Time) {
+				result.Matched++
+				groups.add(fields, timestamp)
+			})
+		release()
+		if err != nil {
+			return result, err
+		}
+		if groups.overflow {
+			return result, fmt.Errorf("%w: more than %d groups; narrow the query, the by fields or the span", ErrInvalidPipeline, maxStatsGroups)
+		}
+	}
+
+	result.Rows = groups.rows()
+	for _, step := range parsed.steps {
+		if step.head > 0 {
+			result.Rows = result.Rows[:min(step.head, len(result.Rows))]
+			continue
+		}
+		sortStatsRows(result.Rows, result.Columns, step.sort)
+	}
+	result.Chart = buildStatsChart(parsed.stats, result.Rows)
+	result.QueryTime = time.Since(started)
+	return result, nil
+}
+
+// aggregateFields lists the fields the aggregates read.
+func (stats statsCommand) aggregateFields() []string {
+	var fields []string
+	for _, spec := range stats.aggregates {
+		if spec.field != "" && !slices.Contains(fields, spec.field) {
+			fields = append(fields, spec.field)
+		}
+	}
+	return fields
+}
+
+// statsGroups accumulates one statsGroup per distinct key.
+type statsGroups struct {
+	stats    statsCommand
+	groups   map[string]*statsGroup
+	overflow bool
+}
+
+type statsGroup struct {
+	key    []interface{} // _time when spanned, then the by values
+	states []aggregateState
+}
+
+func newStatsGroups(stats statsCommand) *statsGroups {
+	return &statsGroups{stats: stats, groups: make(map[string]*statsGroup)}
+}
+
+// add folds one row into its groups. A row holding several values of a by
+// field joins the group of each.
+func (g *statsGroups) add(fields map[string]interface{}, timestamp time.Time) {
+	var prefix []interface{}
+	if g.stats.span > 0 {
+		prefix = []interface{}{timestamp.Truncate(g.stats.span).UTC()}
+	}
+	g.addKeys(fields, prefix, 0)
+}
+
+func (g *statsGroups) addKeys(fields map[string]interface{}, key []interface{}, by int) {
+	if by < len(g.stats.by) {
+		switch values := fields[g.stats.by[by]].(type) {
+		case nil:
+		case []interface{}:
+			for _, value := range values {
+				g.addKeys(fields, append(slices.Clone(key), value), by+1)
+			}
+		default:
+			g.addKeys(fields, append(slices.Clone(key), values), by+1)
+		}
+		return
+	}
+
+	var id strings.Builder
+	for _, value := range key {
+		fmt.Fprintf(&id, "%T\x00%v\x00", value, value)
+	}
+	group, ok := g.groups[id.String()]
+	if !ok {
+		if len(g.groups) >= maxStatsGroups {
+			g.overflow = true
+			return
+		}
+		group = &statsGroup{key: key, states: make([]aggregateState, len(g.stats.aggregates))}
+		for i := range group.states {
+			group.states[i] = newAggregateState(uint64(len(g.groups)*len(group.states) + i))
+		}
+		g.groups[id.String()] = group
+	}
+	for i, spec := range g.stats.aggregates {
+		group.states[i].add(spec, fields)
+	}
+}
+
+// rows returns one table row per group, ordered by key. Without by fields
+// or a span there is always one row, so a count of nothing reads 0.
+func (g *statsGroups) rows() [][]interface{} {
+	if len(g.groups) == 0 && len(g.stats.by) == 0 && g.stats.span == 0 {
+		g.groups[""] = &statsGroup{states: make([]aggregateState, len(g.stats.aggregates))}
+	}
+	groups := make([]*statsGroup, 0, len(g.groups))
+	for _, group := range g.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		for k := range groups[i].key {
+			if c := compareStoredValues(groups[i].key[k], groups[j].key[k], false); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	rows := make([][]interface{}, len(groups))
+	for i, group := range groups {
+		row := append(make([]interface{}, 0, len(group.key)+len(group.states)), group.key...)
+		for k, spec := range g.stats.aggregates {
+			row = append(row, group.states[k].value(spec))
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+// aggregateState is one aggregate's running value for one group.
+type aggregateState struct {
+	count  int
+	sum    float64
+	min    float64
+	max    float64
+	sample []float64
+	seen   int // numeric values offered to sample
+	rng    *rand.Rand
+}
+
+// newAggregateState returns an empty state whose percentile sample is drawn
+// from a fixed seed, so repeated queries give the same estimate.
+func newAggregateState(seed uint64) aggregateState {
+	return aggregateState{rng: rand.New(rand.NewPCG(seed, 0))}
+}
+
+func (a *aggregateState) add(spec aggregateSpec, fields map[string]interface{}) {
+	if spec.field == "" {
+		a.count++
+		return
+	}
+	values, ok := fields[spec.field].([]interface{})
+	if !ok {
+		values = []interface{}{fields[spec.field]}
+	}
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+		if spec.function == "count" {
+			a.count++
+			continue
+		}
+		number, ok := statsNumber(value)
+		if !ok {
+			continue
+		}
+		if a.count == 0 || number < a.min {
+			a.min = number
+		}
+		if a.count == 0 || number > a.max {
+			a.max = number
+		}
+		a.count++
+		a.sum += number
+		if spec.function == "percentile" {
+			a.offer(number)
+		}
+	}
+}
+
+// offer keeps a uniform sample of at most percentileSampleSize values.
+func (a *aggregateState) offer(number float64) {
+	a.seen++
+	if len(a.sample) < percentileSampleSize {
+		a.sample = append(a.sample, number)
+		return
+	}
+	if i := a.rng.IntN(a.seen); i < percentileSampleSize {
+		a.sample[i] = number
+	}
+}
+
+func (a *aggregateState) value(spec aggregateSpec) interface{} {
+	if spec.function == "count" {
+		return a.count
+	}
+	if a.count == 0 {
+		return nil
+	}
+	switch spec.function {
+	case "sum":
+		return a.sum
+	case "avg":
+		return a.sum / float64(a.count)
+	case "min":
+		return a.min
+	case "max":
+		return a.max
+	}
+	return percentile(a.sample, spec.percentile)
+}
+
+// percentile interpolates linearly between the closest ranks.
+func percentile(values []float64, p float64) float64 {
+	slices.Sort(values)
+	rank := p / 100 * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return values[lower] + (values[upper]-values[lower])*(rank-float64(lower))
+}
+
+// statsNumber reads a stored value as a number. Numeric text counts, so
+// fields declared as keywords can still be aggregated.
+func statsNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return number, err == nil && !math.IsNaN(number) && !math.IsInf(number, 0)
+	}
+	return 0, false
+}
+
+// sortStatsRows orders rows by keys, which name columns. Missing values sort
+// last in either direction.
+func sortStatsRows(rows [][]interface{}, columns []string, keys []sortKey) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, key := range keys {
+			column := slices.Index(columns, key.column)
+			if c := compareStatsCells(rows[i][column], rows[j][column], key.desc); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+func compareStatsCells(a, b interface{}, desc bool) int {
+	if count, ok := a.(int); ok {
+		a = float64(count)
+	}
+	if count, ok := b.(int); ok {
+		b = float64(count)
+	}
+	return compareStoredValues(a, b, desc)
+}
+
+// buildStatsChart shapes rows for a chart. With a span every group and
+// aggregate pair is a series over the bucket times; otherwise each aggregate
+// is a series over the rows, labelled by their by values.
+func buildStatsChart(stats statsCommand, rows [][]interface{}) StatsChart {
+	chart := StatsChart{X: []interface{}{}, Series: []StatsSeries{}}
+	first := len(stats.by)
+	if stats.span > 0 {
+		first++
+	}
+	if stats.span == 0 {
+		for _, row := range rows {
+			label := statsLabel(row[:first])
+			if first == 0 {
+				label = "all"
+			}
+			chart.X = append(chart.X, label)
+		}
+		for k, spec := range stats.aggregates {
+			series := StatsSeries{Name: spec.name, Values: make([]*float64, len(rows))}
+			for i, row := range rows {
+				series.Values[i] = chartValue(row[first+k])
+			}
+			chart.Series = append(chart.Series, series)
+		}
+		return chart
+	}
+
+	var times []time.Time
+	for _, row := range rows {
+		if t := row[0].(time.Time); !slices.ContainsFunc(times, t.Equal) {
+			times = append(times, t)
+		}
+	}
+	slices.SortFunc(times, time.Time.Compare)
+	for _, t := range times {
+		chart.X = append(chart.X, t)
+	}
+	index := make(map[string]int)
+	for _, row := range rows {
+		label := statsLabel(row[1:first])
+		x := slices.IndexFunc(times, row[0].(time.Time).Equal)
+		for k, spec := range stats.aggregates {
+			name := spec.name
+			switch {
+			case label != "" && len(stats.aggregates) == 1:
+				name = label
+			case label != "":
+				name = label + ": " + spec.name
+			}
+			i, ok := index[name]
+			if !ok {
+				i = len(chart.Series)
+				index[name] = i
+				chart.Series = append(chart.Series, StatsSeries{Name: name, Values: make([]*float64, len(times))})
+			}
+			chart.Series[i].Values[x] = chartValue(row[first+k])
+		}
+	}
+	return chart
+}
+
+// statsLabel joins by values into one label.
+func statsLabel(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = fmt.Sprint(value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func chartValue(cell interface{}) *float64 {
+	switch v := cell.(type) {
+	case int:
+		f := float64(v)
+		return &f
+	case float64:
+		return &v
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePipeline(t *testing.T) {
+	parsed, err := parsePipeline(`level:ERROR "a | b" | stats count, p95(latency) as slow by service, span=5m | sort -count, service | head`)
+	if err != nil {
+		t.Fatalf("parsePipeline: %v", err)
+	}
+	if parsed.filter != `level:ERROR "a | b"` {
+		t.Fatalf("filter = %q", parsed.filter)
+	}
+	if got := parsed.stats.columns(); !reflect.DeepEqual(got, []string{"_time", "service", "count", "slow"}) {
+		t.Fatalf("columns = %v", got)
+	}
+	if parsed.stats.span != 5*time.Minute || parsed.stats.aggregates[1].percentile != 95 {
+		t.Fatalf("unexpected stats: %+v", parsed.stats)
+	}
+	want := []tableStep{{sort: []sortKey{{column: "count", desc: true}, {column: "service"}}}, {head: defaultHeadRows}}
+	if !reflect.DeepEqual(parsed.steps, want) {
+		t.Fatalf("steps = %+v", parsed.steps)
+	}
+
+	for _, text := range []string{
+		"level:ERROR",
+		"* | sort -count",
+		"* | stats",
+		"* | stats avg",
+		"* | stats p0(latency)",
+		"* | stats count by",
+		"* | stats count, count",
+		"* | stats count | sort latency",
+		"* | stats count | head -1",
+		"* | stats count span=0s",
+		"* | stats count | stats count",
+	} {
+		if _, err := parsePipeline(text); !errors.Is(err, ErrInvalidPipeline) {
+			t.Errorf("parsePipeline(%q) = %v, want ErrInvalidPipeline", text, err)
+		}
+	}
+}
+
+func TestStatsGroupsSortsAndCharts(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	logs := []map[string]interface{}{
+		{"timestamp": base.Add(1 * time.Minute), "_raw": "a", "service": "api", "level": "ERROR", "latency": "100"},
+		{"timestamp": base.Add(2 * time.Minute), "_raw": "b", "service": "api", "level": "ERROR", "latency": "300"},
+		{"timestamp": base.Add(7 * time.Minute), "_raw": "c", "service": "api", "level": "INFO", "latency": "50"},
+		{"timestamp": base.Add(8 * time.Minute), "_raw": "d", "service": "db", "level": "ERROR", "latency": "n/a"},
+		{"timestamp": base.Add(9 * time.Minute), "_raw": "e", "level": "ERROR", "latency": "10"},
+	}
+	if _, err := store.StoreWithIDs(logs, "app.log"); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+	run := func(pipeline string) StatsResult {
+		t.Helper()
+		result, err := store.Stats(context.Background(), StatsOptions{
+			Pipeline:  pipeline,
+			StartDate: base,
+			EndDate:   base.Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("Stats(%q): %v", pipeline, err)
+		}
+		return result
+	}
+
+	// The row without a service is left out; "n/a" is not a number.
+	result := run("level:error | stats count, avg(latency), max(latency) by service | sort -count")
+	want := [][]interface{}{{"api", 2, 200.0, 300.0}, {"db", 1, nil, nil}}
+	if !reflect.DeepEqual(result.Rows, want) {
+		t.Fatalf("rows = %#v", result.Rows)
+	}
+	if result.Matched != 4 {
+		t.Fatalf("matched = %d, want 4", result.Matched)
+	}
+	if !reflect.DeepEqual(result.Chart.X, []interface{}{"api", "db"}) || len(result.Chart.Series) != 3 ||
+		*result.Chart.Series[0].Values[0] != 2 || result.Chart.Series[1].Values[1] != nil {
+		t.Fatalf("chart = %+v", result.Chart)
+	}
+
+	spanned := run("* | stats count by service, span=5m | head 2")
+	want = [][]interface{}{{base, "api", 2}, {base.Add(5 * time.Minute), "api", 1}}
+	if !reflect.DeepEqual(spanned.Rows, want) {
+		t.Fatalf("spanned rows = %#v", spanned.Rows)
+	}
+	if len(spanned.Chart.X) != 2 || len(spanned.Chart.Series) != 1 || spanned.Chart.Series[0].Name != "api" {
+		t.Fatalf("spanned chart = %+v", spanned.Chart)
+	}
+
+	median := run("* | stats median(latency), count")
+	if !reflect.DeepEqual(median.Rows, [][]interface{}{{75.0, 5}}) {
+		t.Fatalf("median rows = %#v", median.Rows)
+	}
+	if none := run("level:debug | stats count"); !reflect.DeepEqual(none.Rows, [][]interface{}{{0}}) {
+		t.Fatalf("empty count rows = %#v", none.Rows)
+	}
+}
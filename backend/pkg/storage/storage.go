@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"container/list"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -11,23 +13,43 @@ import (
 	"time"
 
 	"github.com/blevesearch/bleve/v2"
-	"github.com/blevesearch/bleve/v2/index/upsidedown/store/goleveldb"
 	"github.com/blevesearch/bleve/v2/mapping"
 )
 
 // Storage handles log data persistence using Bleve with time-based sharding
 type Storage struct {
-	baseDir string
-	mu      sync.RWMutex           // protects indices map
-	indices map[string]bleve.Index // Map of date -> index
+	baseDir     string
+	granularity ShardGranularity      // layout used for newly written shards
+	utcShards   bool                  // every shard on disk uses UTC boundaries
+	mu          sync.RWMutex          // protects indices, idle and meta
+	released    *sync.Cond            // on mu; signalled when a shard is unpinned
+	writeMu     sync.RWMutex          // shared by writers; Snapshot takes it to pause them
+	indices     map[string]*openShard // open shards by key; see acquireIndex
+	idle        *list.List            // keys of unpinned open shards, most recently used first
+	maxOpen     int                   // idle shards kept open
+	meta        map[string]*shardMeta // cached metadata by key, open or not
+	dedupeLocks sync.Map              // *sync.Mutex by shard key; see storeShard
+
+	regexpTimeout   time.Duration // per-query limits of raw:~/regex/ terms
+	regexpScanLimit int
 }
 
 // StorageInterface defines the methods implemented by *Storage.
 type StorageInterface interface {
 	Store(logs []map[string]interface{}, source string) error
 	StoreWithIDs(logs []map[string]interface{}, source string) ([]string, error)
-	Search(query string, startDate, endDate *time.Time, sources []string) ([]map[string]interface{}, time.Duration, error)
+	StoreWithOptions(logs []map[string]interface{}, source string, options StoreOptions) (StoreResult, error)
 	SearchPage(ctx context.Context, options SearchOptions) (SearchPageResult, error)
+	FieldFacet(ctx context.Context, options FacetOptions) (FacetResult, error)
+	Stats(ctx context.Context, options StatsOptions) (StatsResult, error)
+	LogContext(ctx context.Context, options ContextOptions) (ContextResult, error)
+	Explain(ctx context.Context, options ExplainOptions) (ExplainResult, error)
+	Export(ctx context.Context, options ExportOptions, start func(columns []string) error, emit func(log map[string]interface{}) error) (int, error)
+	Patterns(ctx context.Context, options PatternOptions) (PatternResult, error)
+	Compare(ctx context.Context, options CompareOptions) (CompareResult, error)
+	Transactions(ctx context.Context, options TransactionOptions) (TransactionResult, error)
+	FieldCatalog(ctx context.Context, options FieldCatalogOptions) (FieldCatalogResult, error)
+	Suggest(ctx context.Context, options SuggestOptions) (SuggestResult, error)
 	List() ([]string, error)
 	GetSourceNames() ([]string, error)
 	Clear() error
@@ -35,10 +57,25 @@ type StorageInterface interface {
 	GetDocCount(date string) (uint64, error)
 	DeleteByIds(ids []string) (int, error)
 	PruneOlderThan(maxAge time.Duration) (int, error)
+	EnforceSizeQuota(maxBytes int64) (SizeQuotaResult, error)
+	DeleteSourceBefore(ctx context.Context, source string, cutoff time.Time) (SourcePurgeResult, error)
+	DeleteByQuery(ctx context.Context, options DeleteQueryOptions) (DeleteQueryResult, error)
+	FieldTypeConflicts() (FieldTypeReport, error)
+	Snapshot(ctx context.Context, dir string) ([]string, error)
+	PendingUpgrades() ([]string, error)
+	UpgradeShards(ctx context.Context, progress func(UpgradeProgress)) (UpgradeResult, error)
 }
 
-// NewStorage initializes a new Storage instance
+// NewStorage initializes a new Storage instance using the shard granularity
+// persisted in baseDir (day shards when none is).
 func NewStorage(baseDir string) (*Storage, error) {
+	return NewStorageWithOptions(baseDir, Options{})
+}
+
+// NewStorageWithOptions initializes a Storage instance. A non-empty
+// options.ShardGranularity is persisted to <baseDir>/storage.json so later
+// boots keep writing the same shard layout.
+func NewStorageWithOptions(baseDir string, options Options) (*Storage, error) {
 	// Canonicalize the path to prevent directory traversal via symlinks or
 	// relative segments before we create or open anything under it.
 	absDir, err := filepath.Abs(baseDir)
@@ -51,49 +88,72 @@ func NewStorage(baseDir string) (*Storage, error) {
 		return nil, fmt.Errorf("failed to create storage directory: %w", err)
 	}
 
-	storage := &Storage{
-		baseDir: baseDir,
-		indices: make(map[string]bleve.Index),
+	requested, err := ParseShardGranularity(string(options.ShardGranularity))
+	if err != nil {
+		return nil, err
+	}
+	settings, err := readStorageSettings(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recoverInterruptedUpgrades(baseDir); err != nil {
+		return nil, fmt.Errorf("failed to recover interrupted shard upgrade: %w", err)
 	}
 
-	// Attempt to load existing indices
+	// Shards are opened on first use, not here; only the layout matters now.
 	pattern := filepath.Join(baseDir, "logs-*.bleve")
 	matches, err := filepath.Glob(pattern)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list existing indices: %w", err)
 	}
 
-	// Load each existing index
-	for _, indexPath := range matches {
-		// Extract date from filename
-		base := filepath.Base(indexPath)
-		date := base[5 : len(base)-6] // Extract date from "logs-2024-01-01.bleve"
-
-		// Open the existing index
-		index, err := bleve.Open(indexPath)
-		if err != nil {
-			continue // Skip this index if it can't be opened
+	// A non-empty requested granularity wins and is persisted so later boots
+	// keep writing the same layout. A directory without shards has nothing
+	// written under local-offset boundaries, so it starts out UTC-normalized.
+	changed := false
+	if requested != "" && requested != settings.ShardGranularity {
+		settings.ShardGranularity = requested
+		changed = true
+	}
+	if !settings.UTCShards && len(matches) == 0 {
+		settings.UTCShards = true
+		changed = true
+	}
+	if changed {
+		if err := writeStorageSettings(baseDir, settings); err != nil {
+			return nil, err
 		}
+	}
 
-		// Add to indices map
-		storage.indices[date] = index
+	storage := &Storage{
+		baseDir:     baseDir,
+		granularity: settings.ShardGranularity,
+		utcShards:   settings.UTCShards,
+		maxOpen:     options.MaxOpenShards,
+
+		regexpTimeout:   options.RegexpTimeout,
+		regexpScanLimit: options.RegexpScanLimit,
 	}
+	storage.mu.Lock()
+	storage.initCacheLocked()
+	storage.mu.Unlock()
 
 	return storage, nil
 }
 
-// Close cleanly shuts down all open Bleve indices. Should be called on
-// server shutdown or at the end of tests to prevent goroutine leaks.
+// Close cleanly shuts down all open Bleve indices, saving their cached
+// metadata. Should be called on server shutdown or at the end of tests to
+// prevent goroutine leaks. Shards still pinned by a query are closed too.
 func (s *Storage) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	var firstErr error
-	for date, index := range s.indices {
-		if err := index.Close(); err != nil && firstErr == nil {
-			firstErr = fmt.Errorf("failed to close index %s: %w", date, err)
+	for date := range s.indices {
+		if err := s.closeShardLocked(date); err != nil && firstErr == nil {
+			firstErr = err
 		}
-		delete(s.indices, date)
 	}
 	return firstErr
 }
@@ -107,7 +167,7 @@ func buildIndexMapping() mapping.IndexMapping {
 	dateField.Store = true
 	// Timestamp postings enable bounded date-range queries and facets. Older
 	// shards created with Index=false remain readable through SearchPage's
-	// compatibility aggregation path.
+	// compatibility aggregation path until UpgradeShards rewrites them.
 	dateField.Index = true
 	dateField.IncludeInAll = false
 	logMapping.AddFieldMappingsAt("timestamp", dateField)
@@ -118,8 +178,8 @@ func buildIndexMapping() mapping.IndexMapping {
 	textField.IncludeTermVectors = false
 	textField.IncludeInAll = false
 	// Doc values build a columnar copy of every term, used only for sorting
-	// and faceting at the Bleve layer. LogSonic sorts results in Go and never
-	// facets, so the _raw doc-values payload is dead weight — disable it.
+	// and faceting at the Bleve layer. LogSonic never sorts or facets on the
+	// analyzed _raw terms, so its doc-values payload is dead weight — disable it.
 	textField.DocValues = false
 	logMapping.AddFieldMappingsAt("_raw", textField)
 
@@ -155,41 +215,23 @@ var kvConfig = map[string]interface{}{
 	"compression":               "snappy",
 }
 
-// getOrCreateIndex returns the Bleve index for the given date, creating it if
-// it does not exist yet.  s.mu is held for the duration of index creation to
-// prevent concurrent goroutines from initialising the same shard twice.
-func (s *Storage) getOrCreateIndex(date string) (bleve.Index, error) {
-	// Fast path: index already open.
-	s.mu.RLock()
-	index, exists := s.indices[date]
-	s.mu.RUnlock()
-	if exists {
-		return index, nil
+// shardSlack is how far a row may lie outside its shard's UTC range. It is
+// zero once the storage is UTC-normalized; before that, shard selection is
+// widened by the largest zone offset so rows written under their local date
+// are still found.
+func (s *Storage) shardSlack() time.Duration {
+	if s.utcShards {
+		return 0
 	}
+	return maxZoneOffset
+}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Re-check inside write lock (another goroutine may have created it).
-	if index, exists = s.indices[date]; exists {
-		return index, nil
-	}
-
-	indexPath := filepath.Join(s.baseDir, fmt.Sprintf("logs-%s.bleve", date))
-	var err error
-
-	if _, statErr := os.Stat(indexPath); os.IsNotExist(statErr) {
-		indexConfig := map[string]interface{}{"store": kvConfig}
-		index, err = bleve.NewUsing(indexPath, buildIndexMapping(), "scorch", goleveldb.Name, indexConfig)
-	} else {
-		index, err = bleve.Open(indexPath)
-	}
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize index for date %s: %w", date, err)
+// ShardGranularity reports the layout used for newly written shards.
+func (s *Storage) ShardGranularity() ShardGranularity {
+	if s.granularity == "" {
+		return DefaultShardGranularity
 	}
-
-	s.indices[date] = index
-	return index, nil
+	return s.granularity
 }
 
 // BuildDocID returns the Bleve document ID used for a row. It is shared by
@@ -202,7 +244,21 @@ func BuildDocID(log map[string]interface{}, source string, fallbackSeq int) stri
 	return fmt.Sprintf("%d-%s-%d", log["timestamp"].(time.Time).UnixNano(), source, seqID)
 }
 
-// Store saves the parsed log data to appropriate daily indices.
+// docIDTimestamp reads back the timestamp BuildDocID puts at the start of
+// an ID. IDs from elsewhere, or rows before 1970, are reported false.
+func docIDTimestamp(id string) (time.Time, bool) {
+	nanos, _, ok := strings.Cut(id, "-")
+	if !ok {
+		return time.Time{}, false
+	}
+	unixNano, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, unixNano).UTC(), true
+}
+
+// Store saves the parsed log data to the appropriate time shards.
 func (s *Storage) Store(logs []map[string]interface{}, source string) error {
 	_, err := s.StoreWithIDs(logs, source)
 	return err
@@ -216,68 +272,163 @@ type datedLog struct {
 // StoreWithIDs saves parsed log data and returns the generated document IDs in
 // the same order as the input rows.
 func (s *Storage) StoreWithIDs(logs []map[string]interface{}, source string) ([]string, error) {
-	docIDs := make([]string, len(logs))
+	result, err := s.StoreWithOptions(logs, source, StoreOptions{})
+	return result.IDs, err
+}
+
+// StoreOptions adjusts how StoreWithOptions indexes rows.
+type StoreOptions struct {
+	// Schema declares field types. Fields it names are indexed as their type
+	// instead of being coerced to numbers whenever they look numeric.
+	Schema FieldSchema
+	// SkipDuplicates drops rows whose raw line, source and timestamp match a
+	// row already in the target shard or earlier in the same call. Rows
+	// stored by releases that predate fingerprints never match.
+	SkipDuplicates bool
+}
+
+// StoreResult reports what StoreWithOptions stored.
+type StoreResult struct {
+	IDs        []string // document ID per input row; empty for a skipped duplicate
+	Duplicates int
+}
+
+// StoreWithOptions saves parsed log data like StoreWithIDs, applying options.
+func (s *Storage) StoreWithOptions(logs []map[string]interface{}, source string, options StoreOptions) (StoreResult, error) {
+	// A call's batches span shards; hold the write lease so a snapshot sees
+	// either all of them or none.
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
 
-	// Group logs by date.
+	result := StoreResult{IDs: make([]string, len(logs))}
+
+	// Group logs by shard.
 	logsByDate := make(map[string][]datedLog)
 	for i, log := range logs {
 		ts := log["timestamp"].(time.Time)
-		date := ts.Format("2006-01-02")
+		date := s.granularity.shardKey(ts)
 		logsByDate[date] = append(logsByDate[date], datedLog{index: i, log: log})
 	}
 
 	for date, dateLogs := range logsByDate {
-		index, err := s.getOrCreateIndex(date)
+		duplicates, err := s.storeShard(date, dateLogs, source, options, result.IDs)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get index for date %s: %w", date, err)
+			return StoreResult{}, err
 		}
+		result.Duplicates += duplicates
+	}
 
-		batch := index.NewBatch()
-		for _, entry := range dateLogs {
-			log := entry.log
-			logCopy := make(map[string]interface{}, len(log))
-			for k, v := range log {
-				logCopy[k] = v
-			}
-			for k, v := range log {
-				if k == "timestamp" || strings.HasPrefix(k, "_") {
-					continue
-				}
-				str, ok := v.(string)
-				if !ok {
-					continue
+	return result, nil
+}
+
+// storeShard indexes the rows that fall into one shard, recording their
+// document IDs in docIDs, and returns how many duplicates it skipped.
+func (s *Storage) storeShard(date string, dateLogs []datedLog, source string, options StoreOptions, docIDs []string) (int, error) {
+	index, release, err := s.acquireIndex(date, true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get index for date %s: %w", date, err)
+	}
+	defer release()
+
+	var existing *fingerprintIndex
+	seen := make(map[string]struct{})
+	if options.SkipDuplicates {
+		// The fingerprints are read from a snapshot, so deduplicating writes
+		// to one shard take turns from the check until their batch is in.
+		// Rows stored without the option are not held off and can still
+		// repeat a fingerprint.
+		lock, _ := s.dedupeLocks.LoadOrStore(date, &sync.Mutex{})
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
+		if existing, err = openFingerprintIndex(index); err != nil {
+			return 0, fmt.Errorf("failed to read index for date %s: %w", date, err)
+		}
+		defer existing.Close()
+	}
+	duplicates := 0
+	schema := options.Schema
+
+	batch := index.NewBatch()
+	added := shardAdditions{kinds: make(map[string][]string), rejected: make(map[string]uint64)}
+	for _, entry := range dateLogs {
+		log := entry.log
+		if existing != nil {
+			if fingerprint, ok := recordFingerprint(log); ok {
+				_, repeated := seen[fingerprint]
+				if !repeated {
+					if repeated, err = existing.contains(fingerprint); err != nil {
+						return 0, fmt.Errorf("failed to check for duplicates in %s: %w", date, err)
+					}
 				}
-				if intVal, err := strconv.ParseInt(str, 10, 64); err == nil {
-					logCopy[k] = intVal
+				seen[fingerprint] = struct{}{}
+				if repeated {
+					duplicates++
 					continue
 				}
-				if floatVal, err := strconv.ParseFloat(str, 64); err == nil {
-					logCopy[k] = floatVal
-				}
 			}
-			// Disambiguate by the session-global `_seq` when present:
-			// the batch index resets every ingest chunk, so two lines from
-			// different chunks that share a timestamp + source would otherwise
-			// produce the same docID and silently overwrite each other. `_seq`
-			// is monotonic across the whole session, so it keeps every line a
-			// distinct document. Falls back to the original input index for
-			// callers (tests) that don't stamp `_seq`.
-			docID := BuildDocID(log, source, entry.index)
-			docIDs[entry.index] = docID
-			doc, err := buildOptimizedDocument(index.Mapping(), docID, logCopy)
+		}
+		if src, ok := log["_src"].(string); ok {
+			added.sources = mergeSorted(added.sources, []string{src})
+		}
+		logCopy := make(map[string]interface{}, len(log))
+		declared := make(map[string]interface{})
+		for k, v := range log {
+			if _, ok := schema[k]; ok && k != "timestamp" && !strings.HasPrefix(k, "_") {
+				declared[k] = v
+				continue
+			}
+			logCopy[k] = v
+		}
+		for k, v := range logCopy {
+			if k == "timestamp" || strings.HasPrefix(k, "_") {
+				continue
+			}
+			str, ok := v.(string)
+			if !ok {
+				continue
+			}
+			if intVal, err := strconv.ParseInt(str, 10, 64); err == nil {
+				logCopy[k] = intVal
+				continue
+			}
+			if floatVal, err := strconv.ParseFloat(str, 64); err == nil {
+				logCopy[k] = floatVal
+			}
+		}
+		// Disambiguate by the session-global `_seq` when present:
+		// the batch index resets every ingest chunk, so two lines from
+		// different chunks that share a timestamp + source would otherwise
+		// produce the same docID and silently overwrite each other. `_seq`
+		// is monotonic across the whole session, so it keeps every line a
+		// distinct document. Falls back to the original input index for
+		// callers (tests) that don't stamp `_seq`.
+		docID := BuildDocID(log, source, entry.index)
+		docIDs[entry.index] = docID
+		doc, err := buildOptimizedDocument(index.Mapping(), docID, logCopy)
+		if err != nil {
+			return 0, fmt.Errorf("failed to index log entry: %w", err)
+		}
+		for name, value := range declared {
+			accepted, err := addDeclaredField(doc, index.Mapping(), name, schema[name], value)
 			if err != nil {
-				return nil, fmt.Errorf("failed to index log entry: %w", err)
+				return 0, fmt.Errorf("failed to index log entry: %w", err)
 			}
-			if err := batch.IndexAdvanced(doc); err != nil {
-				return nil, fmt.Errorf("failed to add log entry to batch: %w", err)
+			if !accepted {
+				added.rejected[name]++
 			}
 		}
-		if err := index.Batch(batch); err != nil {
-			return nil, fmt.Errorf("failed to commit batch for date %s: %w", date, err)
+		addDocumentKinds(added.kinds, doc)
+		if err := batch.IndexAdvanced(doc); err != nil {
+			return 0, fmt.Errorf("failed to add log entry to batch: %w", err)
 		}
 	}
-
-	return docIDs, nil
+	if batch.Size() == 0 {
+		return duplicates, nil
+	}
+	if err := s.writeShard(date, index, batch, added, false); err != nil {
+		return 0, fmt.Errorf("failed to commit batch for date %s: %w", date, err)
+	}
+	return duplicates, nil
 }
 
 // Clear removes all indices
@@ -285,13 +436,18 @@ func (s *Storage) Clear() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Close all open indices first
-	for date, index := range s.indices {
-		if err := index.Close(); err != nil {
-			return fmt.Errorf("failed to close index for date %s: %w", date, err)
+	// Close all open indices first, once in-flight queries release them
+	s.initCacheLocked()
+	open := make([]string, 0, len(s.indices))
+	for date := range s.indices {
+		open = append(open, date)
+	}
+	for _, date := range open {
+		if err := s.discardShardLocked(date); err != nil {
+			return err
 		}
-		delete(s.indices, date)
 	}
+	s.meta = make(map[string]*shardMeta)
 
 	// Remove all .bleve directories in baseDir
 	pattern := filepath.Join(s.baseDir, "logs-*.bleve")
@@ -309,10 +465,10 @@ func (s *Storage) Clear() error {
 	return nil
 }
 
-// PruneOlderThan deletes every per-day index whose date is older than maxAge,
-// returning the number of indices removed. A non-positive maxAge is a no-op
-// (retention disabled), so callers can pass the configured window unconditionally.
-// Each index dir is named "logs-2006-01-02.bleve"; dates that don't parse are
+// PruneOlderThan deletes every shard whose whole time range is older than
+// maxAge, returning the number of indices removed. A non-positive maxAge is a
+// no-op (retention disabled), so callers can pass the configured window
+// unconditionally. Shard names that don't parse as any known layout are
 // skipped rather than treated as expired, so a stray directory is never deleted.
 func (s *Storage) PruneOlderThan(maxAge time.Duration) (int, error) {
 	if maxAge <= 0 {
@@ -331,28 +487,17 @@ func (s *Storage) PruneOlderThan(maxAge time.Duration) (int, error) {
 
 	removed := 0
 	for _, indexPath := range matches {
-		base := filepath.Base(indexPath)
-		if len(base) < 12 { // "logs-".."\.bleve" guard
-			continue
-		}
-		date := base[5 : len(base)-6]
-		t, err := time.Parse("2006-01-02", date)
-		if err != nil {
-			continue // not a dated index dir — leave it alone
+		date, ok := shardKeyFromPath(indexPath)
+		if !ok {
+			continue // not a shard directory — leave it alone
 		}
-		if !t.Before(cutoff) {
+		shard, _ := parseShardKey(date)
+		if shard.End.After(cutoff) {
 			continue
 		}
 
-		// Close the open handle (if any) before removing the directory.
-		if index, ok := s.indices[date]; ok {
-			if err := index.Close(); err != nil {
-				return removed, fmt.Errorf("failed to close index %s: %w", date, err)
-			}
-			delete(s.indices, date)
-		}
-		if err := os.RemoveAll(indexPath); err != nil {
-			return removed, fmt.Errorf("failed to remove index directory %s: %w", indexPath, err)
+		if err := s.removeShardLocked(date); err != nil {
+			return removed, err
 		}
 		removed++
 	}
@@ -360,7 +505,49 @@ func (s *Storage) PruneOlderThan(maxAge time.Duration) (int, error) {
 	return removed, nil
 }
 
-// List returns all available dates that have indices
+// removeShard closes and deletes the shard with the given key.
+func (s *Storage) removeShard(date string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.removeShardLocked(date)
+}
+
+// removeShardLocked closes the open handle (if any) before removing the shard
+// directory, waiting for queries that have it pinned. s.mu must be held for
+// writing.
+func (s *Storage) removeShardLocked(date string) error {
+	if err := s.discardShardLocked(date); err != nil {
+		return err
+	}
+	indexPath := filepath.Join(s.baseDir, shardDirName(date))
+	if err := os.RemoveAll(indexPath); err != nil {
+		return fmt.Errorf("failed to remove index directory %s: %w", indexPath, err)
+	}
+	return nil
+}
+
+// discardShardLocked closes a shard that is about to be deleted once nobody
+// has it pinned, dropping its cached metadata. s.mu must be held for writing.
+func (s *Storage) discardShardLocked(date string) error {
+	defer s.forgetMetaLocked(date)
+	shard := s.waitIdleLocked(date)
+	if shard == nil {
+		return nil
+	}
+	if shard.idle != nil {
+		s.idle.Remove(shard.idle)
+		shard.idle = nil
+	}
+	delete(s.indices, date)
+	if err := shard.index.Close(); err != nil {
+		return fmt.Errorf("failed to close index %s: %w", date, err)
+	}
+	return nil
+}
+
+// List returns the keys of every shard on disk in chronological order. Keys
+// may mix layouts ("2006-01-02", "2006-01-02T15", "2006-W01") when the shard
+// granularity was changed after data had been written.
 func (s *Storage) List() ([]string, error) {
 
 	// Get all .bleve directories in baseDir
@@ -370,15 +557,14 @@ func (s *Storage) List() ([]string, error) {
 		return nil, fmt.Errorf("failed to list indices: %w", err)
 	}
 
-	// Extract dates from directory names
+	// Extract shard keys from directory names
 	dates := make([]string, 0, len(matches))
 	for _, match := range matches {
-		base := filepath.Base(match)
-		// Extract date from "logs-2006-01-02.bleve"
-		if date := base[5 : len(base)-6]; len(date) == 10 {
+		if date, ok := shardKeyFromPath(match); ok {
 			dates = append(dates, date)
 		}
 	}
+	sortShardKeys(dates)
 
 	return dates, nil
 }
@@ -388,15 +574,19 @@ func (s *Storage) BaseDir() string {
 	return s.baseDir
 }
 
-// GetDocCount returns the number of documents in the index for a specific date
+// GetDocCount returns the number of documents in the index for a specific
+// shard key. The count is served from cached shard metadata, so it does not
+// open the shard unless nothing is cached for it yet. A shard that does not
+// exist holds no documents.
 func (s *Storage) GetDocCount(date string) (uint64, error) {
-
-	index, err := s.getOrCreateIndex(date)
+	meta, err := s.shardMetadata(date, false)
+	if errors.Is(err, errShardNotFound) {
+		return 0, nil
+	}
 	if err != nil {
 		return 0, fmt.Errorf("failed to get index for date %s: %w", date, err)
 	}
-
-	return index.DocCount()
+	return meta.DocCount, nil
 }
 
 // DeleteByIds removes logs with matching document IDs from storage
@@ -420,6 +610,9 @@ func (s *Storage) DeleteByIds(ids []string) (int, error) {
 		return 0, nil
 	}
 
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+
 	// Get all available dates
 	dates, err := s.List()
 	if err != nil {
@@ -428,37 +621,47 @@ func (s *Storage) DeleteByIds(ids []string) (int, error) {
 
 	// For each date index, check for matching document IDs
 	for _, date := range dates {
-		index, err := s.getOrCreateIndex(date)
+		deleted, err := s.deleteIdsInShard(date, idMap)
+		deletedCount += deleted
 		if err != nil {
-			return deletedCount, fmt.Errorf("failed to get index for date %s: %w", date, err)
+			return deletedCount, err
 		}
+	}
 
-		// Create a batch for deletions
-		batch := index.NewBatch()
-		batchDeletes := 0
+	return deletedCount, nil
+}
 
-		// Process each ID
-		for id := range idMap {
-			doc, err := index.Document(id)
-			if err != nil {
-				return deletedCount, fmt.Errorf("failed to read document %s from index %s: %w", id, date, err)
-			}
-			if doc == nil {
-				continue
-			}
+func (s *Storage) deleteIdsInShard(date string, idMap map[string]struct{}) (int, error) {
+	index, release, err := s.acquireIndex(date, false)
+	if errors.Is(err, errShardNotFound) {
+		return 0, nil // removed since List
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get index for date %s: %w", date, err)
+	}
+	defer release()
 
-			batch.Delete(id)
-			batchDeletes++
-		}
+	// Create a batch for deletions
+	batch := index.NewBatch()
 
-		// Only execute the batch if there are operations to perform
-		if batch.Size() > 0 {
-			if err := index.Batch(batch); err != nil {
-				return deletedCount, fmt.Errorf("error deleting documents from index %s: %w", date, err)
-			}
-			deletedCount += batchDeletes
+	// Process each ID
+	for id := range idMap {
+		doc, err := index.Document(id)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read document %s from index %s: %w", id, date, err)
+		}
+		if doc == nil {
+			continue
 		}
+		batch.Delete(id)
 	}
 
-	return deletedCount, nil
+	// Only execute the batch if there are operations to perform
+	if batch.Size() == 0 {
+		return 0, nil
+	}
+	if err := s.writeShard(date, index, batch, shardAdditions{}, true); err != nil {
+		return 0, fmt.Errorf("error deleting documents from index %s: %w", date, err)
+	}
+	return batch.Size(), nil
 }
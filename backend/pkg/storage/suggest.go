@@ -0,0 +1,382 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/numeric"
+)
+
+const (
+	// DefaultSuggestLimit and MaxSuggestLimit bound the completions Suggest
+	// returns.
+	DefaultSuggestLimit = 20
+	MaxSuggestLimit     = 100
+
+	// suggestScanTerms bounds the terms read per field and shard. Numbers
+	// cannot be narrowed by a decimal prefix, so their terms are all read up
+	// to it.
+	suggestScanTerms = 10_000
+
+	// suggestValueShards bounds the shards whose term dictionaries supply
+	// values: the newest in the time range, so a keystroke opens the same
+	// few shards however wide the range is.
+	suggestValueShards = 7
+
+	// Suggestion kinds.
+	SuggestField    = "field"
+	SuggestValue    = "value"
+	SuggestOperator = "operator"
+)
+
+// SuggestOptions is a query being typed and where the cursor is in it, in
+// characters. Shards overlapping the time range supply field names, and the
+// newest suggestValueShards of them values.
+type SuggestOptions struct {
+	Text      string
+	Cursor    int
+	StartDate time.Time
+	EndDate   time.Time
+	Limit     int
+}
+
+// Suggestion is one completion. Text replaces the result's range of the
+// query, modifier and field included.
+type Suggestion struct {
+	Text   string
+	Kind   string // SuggestField, SuggestValue or SuggestOperator
+	Detail string // a field's kinds, or what an operator does
+	Count  int    // rows holding a value
+}
+
+// SuggestResult holds completions for the term under the cursor, which
+// spans [ReplaceFrom, ReplaceTo) in characters.
+type SuggestResult struct {
+	Suggestions []Suggestion
+	ReplaceFrom int
+	ReplaceTo   int
+	Field       string // the field whose values are completed, if any
+	QueryTime   time.Duration
+}
+
+// suggestTerm is the query term under the cursor, split into its parts:
+// modifier + field : operator quote value.
+type suggestTerm struct {
+	modifier string // "+", "-" or ""
+	field    string
+	hasField bool
+	operator string // ">", ">=", "<", "<=" or ""
+	quoted   bool
+	value    string
+}
+
+// Suggest completes the query term under the cursor: a field name before
+// any colon, a value after it and operators where they fit. Field names
+// come from the shards' cached metadata, without opening them; a shard not
+// described yet adds no fields. Values come from prefix scans of the newest
+// shards' term dictionaries, ranked by how many rows hold them. Numeric
+// fields are scanned for their full-precision terms only, which compact and
+// legacy shards both index, and decoded before matching the typed digits.
+func (s *Storage) Suggest(ctx context.Context, options SuggestOptions) (SuggestResult, error) {
+	started := time.Now()
+	result := SuggestResult{Suggestions: []Suggestion{}}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if options.Limit == 0 {
+		options.Limit = DefaultSuggestLimit
+	}
+	if options.Limit < 0 || options.Limit > MaxSuggestLimit {
+		return result, fmt.Errorf("limit must be between 1 and %d", MaxSuggestLimit)
+	}
+	runes := []rune(options.Text)
+	if options.Cursor < 0 || options.Cursor > len(runes) {
+		return result, fmt.Errorf("cursor must be between 0 and %d", len(runes))
+	}
+	from := suggestTermStart(runes[:options.Cursor])
+	result.ReplaceFrom, result.ReplaceTo = from, options.Cursor
+	term := parseSuggestTerm(string(runes[from:options.Cursor]))
+
+	dates, err := s.List()
+	if err != nil {
+		return result, fmt.Errorf("failed to list existing dates: %w", err)
+	}
+	var keys []string
+	if !options.EndDate.Before(options.StartDate) {
+		keys = intersectingDates(dates, options.StartDate, options.EndDate, s.shardSlack())
+	}
+	fieldKinds := make(map[string]map[string]bool)
+	kindsKnown := true
+	s.mu.Lock()
+	for _, key := range keys {
+		meta := s.loadMetaLocked(key)
+		if meta == nil {
+			kindsKnown = false
+			continue
+		}
+		kindsKnown = kindsKnown && meta.KindsKnown
+		for _, field := range meta.Fields {
+			if field == "_all" || field == "_seq" || field == "_id" || field == fingerprintField {
+				continue
+			}
+			if fieldKinds[field] == nil {
+				fieldKinds[field] = make(map[string]bool)
+			}
+			for _, kind := range meta.Kinds[field] {
+				fieldKinds[field][kind] = true
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if !term.hasField {
+		result.Suggestions = suggestFields(term, fieldKinds)
+	} else if kinds, ok := fieldKinds[term.field]; ok {
+		result.Field = term.field
+		result.Suggestions = suggestOperators(term, kinds)
+		values, err := s.suggestValues(ctx, keys[max(0, len(keys)-suggestValueShards):], term, kinds, kindsKnown)
+		if err != nil {
+			return result, err
+		}
+		result.Suggestions = append(result.Suggestions, values...)
+	}
+	result.Suggestions = result.Suggestions[:min(len(result.Suggestions), options.Limit)]
+	result.QueryTime = time.Since(started)
+	return result, nil
+}
+
+// suggestTermStart finds where the term ending at the cursor begins: after
+// the last space or parenthesis, unless an open quote holds it.
+func suggestTermStart(before []rune) int {
+	quote := -1
+	for i := 0; i < len(before); i++ {
+		switch before[i] {
+		case '\\':
+			i++
+		case '"':
+			if quote < 0 {
+				quote = i
+			} else {
+				quote = -1
+			}
+		}
+	}
+	end := len(before)
+	if quote >= 0 {
+		end = quote
+	}
+	for end > 0 && !unicode.IsSpace(before[end-1]) && before[end-1] != '(' && before[end-1] != ')' {
+		end--
+	}
+	return end
+}
+
+func parseSuggestTerm(text string) suggestTerm {
+	var term suggestTerm
+	if strings.HasPrefix(text, "+") || strings.HasPrefix(text, "-") {
+		term.modifier, text = text[:1], text[1:]
+	}
+	colon := strings.IndexByte(text, ':')
+	if colon < 0 || strings.HasPrefix(text, `"`) {
+		term.field = text // a field name being typed, or a bare phrase
+		return term
+	}
+	term.field, term.hasField, text = text[:colon], true, text[colon+1:]
+	for _, operator := range []string{">=", "<=", ">", "<"} {
+		if strings.HasPrefix(text, operator) {
+			term.operator, text = operator, text[len(operator):]
+			break
+		}
+	}
+	if strings.HasPrefix(text, `"`) {
+		term.quoted, text = true, text[1:]
+	}
+	term.value = text
+	return term
+}
+
+// suggestFields completes field names, then the operators that can start a
+// term.
+func suggestFields(term suggestTerm, fieldKinds map[string]map[string]bool) []Suggestion {
+	var suggestions []Suggestion
+	prefix := strings.ToLower(term.field)
+	if strings.HasPrefix(prefix, `"`) {
+		return []Suggestion{}
+	}
+	names := make([]string, 0, len(fieldKinds))
+	for field := range fieldKinds {
+		if strings.HasPrefix(strings.ToLower(field), prefix) {
+			names = append(names, field)
+		}
+	}
+	sort.Strings(names)
+	for _, field := range names {
+		suggestions = append(suggestions, Suggestion{
+			Text:   term.modifier + field + ":",
+			Kind:   SuggestField,
+			Detail: strings.Join(sortedKeys(fieldKinds[field]), ", "),
+		})
+	}
+	if strings.HasPrefix(rawRegexpPrefix, prefix) {
+		suggestions = append(suggestions, Suggestion{Text: term.modifier + rawRegexpPrefix, Kind: SuggestOperator, Detail: "regex over stored _raw lines"})
+	}
+	if term.modifier == "" && term.field == "" {
+		suggestions = append(suggestions,
+			Suggestion{Text: "+", Kind: SuggestOperator, Detail: "rows must match the term"},
+			Suggestion{Text: "-", Kind: SuggestOperator, Detail: "rows must not match the term"},
+			Suggestion{Text: `"`, Kind: SuggestOperator, Detail: "phrase"},
+		)
+	}
+	if suggestions == nil {
+		suggestions = []Suggestion{}
+	}
+	return suggestions
+}
+
+// suggestOperators lists what may follow a field's colon before a value
+// has been typed.
+func suggestOperators(term suggestTerm, kinds map[string]bool) []Suggestion {
+	suggestions := []Suggestion{}
+	if term.operator != "" || term.quoted || term.value != "" {
+		return suggestions
+	}
+	base := term.modifier + term.field + ":"
+	if kinds[kindNumber] || kinds[kindDate] {
+		for _, operator := range []string{">", ">=", "<", "<="} {
+			suggestions = append(suggestions, Suggestion{Text: base + operator, Kind: SuggestOperator, Detail: "range"})
+		}
+	}
+	if kinds[kindText] {
+		suggestions = append(suggestions, Suggestion{Text: base + `"`, Kind: SuggestOperator, Detail: "phrase"})
+	}
+	return suggestions
+}
+
+// suggestValues completes the value of term.field from the term
+// dictionaries of the given shards.
+func (s *Storage) suggestValues(ctx context.Context, keys []string, term suggestTerm, kinds map[string]bool, kindsKnown bool) ([]Suggestion, error) {
+	if term.field == "timestamp" || (kinds[kindDate] && len(kinds) == 1) {
+		return []Suggestion{}, nil
+	}
+	if kinds[kindBool] {
+		var suggestions []Suggestion
+		for _, value := range []string{"true", "false"} {
+			if strings.HasPrefix(value, term.value) {
+				suggestions = append(suggestions, Suggestion{Text: term.modifier + term.field + ":" + value, Kind: SuggestValue})
+			}
+		}
+		return suggestions, nil
+	}
+	numbers := kinds[kindNumber] || !kindsKnown
+	texts := !kinds[kindNumber] || len(kinds) > 1 || !kindsKnown
+	if term.operator != "" {
+		texts = false
+	}
+	textPrefix := term.value
+	if !kinds[kindKeyword] {
+		textPrefix = strings.ToLower(textPrefix)
+	}
+
+	counts := make(map[string]int)
+	isNumber := make(map[string]bool)
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		index, release, err := s.acquireIndex(key, false)
+		if errors.Is(err, errShardNotFound) {
+			continue // removed since List
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get index for date %s: %w", key, err)
+		}
+		if numbers {
+			err = scanTermPrefix(index, term.field, string([]byte{numeric.ShiftStartInt64}), func(value string, count int) {
+				if number, ok := decodeNumericBound(value); ok && number != nil {
+					if formatted := strconv.FormatFloat(*number, 'f', -1, 64); strings.HasPrefix(formatted, term.value) {
+						counts[formatted] += count
+						isNumber[formatted] = true
+					}
+				}
+			})
+		}
+		if err == nil && texts {
+			err = scanTermPrefix(index, term.field, textPrefix, func(value string, count int) {
+				if _, isNumber := decodeNumericBound(value); !isNumber {
+					counts[value] += count
+				}
+			})
+		}
+		release()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read terms for date %s: %w", key, err)
+		}
+	}
+
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] != counts[values[j]] {
+			return counts[values[i]] > counts[values[j]]
+		}
+		return values[i] < values[j]
+	})
+	suggestions := make([]Suggestion, 0, len(values))
+	for _, value := range values {
+		suggestions = append(suggestions, Suggestion{
+			Text:  term.modifier + term.field + ":" + term.operator + quoteSuggestValue(value, term.quoted, isNumber[value]),
+			Kind:  SuggestValue,
+			Count: counts[value],
+		})
+	}
+	return suggestions, nil
+}
+
+// scanTermPrefix visits up to suggestScanTerms terms of field starting with
+// prefix, with the number of rows holding each.
+func scanTermPrefix(index bleve.Index, field, prefix string, visit func(term string, count int)) error {
+	dictionary, err := index.FieldDictPrefix(field, []byte(prefix))
+	if err != nil {
+		return err
+	}
+	defer dictionary.Close()
+	for read := 0; read < suggestScanTerms; read++ {
+		entry, err := dictionary.Next()
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return nil
+		}
+		visit(entry.Term, int(entry.Count))
+	}
+	return nil
+}
+
+// quoteSuggestValue quotes a text value the query string parser would
+// otherwise split or read as syntax, and always when the user opened a
+// quote. Numbers are left bare so they still parse as numbers.
+func quoteSuggestValue(value string, quoted, number bool) string {
+	if number || !quoted && !strings.ContainsAny(value, " \t+-=&|><!(){}[]^\"~*?:\\/") {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
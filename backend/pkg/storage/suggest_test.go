@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSuggestCompletesFieldsValuesAndOperators(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	logs := []map[string]interface{}{
+		{"timestamp": base, "_raw": "a", "_src": "app.log", "level": "error", "latency": "120"},
+		{"timestamp": base.Add(time.Second), "_raw": "b", "_src": "app.log", "level": "error", "latency": "125"},
+		{"timestamp": base.Add(2 * time.Second), "_raw": "c", "_src": "app.log", "level": "warn", "latency": "-3"},
+		{"timestamp": base.Add(3 * time.Second), "_raw": "d", "_src": "app.log", "level": "Eek now", "latency": "900"},
+	}
+	if _, err := store.StoreWithIDs(logs, "app.log"); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+	suggest := func(text string, cursor int) SuggestResult {
+		t.Helper()
+		if cursor < 0 {
+			cursor = len([]rune(text))
+		}
+		result, err := store.Suggest(context.Background(), SuggestOptions{Text: text, Cursor: cursor, StartDate: base, EndDate: base.Add(time.Hour)})
+		if err != nil {
+			t.Fatalf("Suggest(%q): %v", text, err)
+		}
+		return result
+	}
+	texts := func(result SuggestResult) string {
+		var texts []string
+		for _, suggestion := range result.Suggestions {
+			texts = append(texts, suggestion.Text)
+		}
+		return fmt.Sprint(texts)
+	}
+
+	result := suggest("message:x +l", -1)
+	if texts(result) != "[+latency: +level:]" || result.ReplaceFrom != 10 || result.ReplaceTo != 12 {
+		t.Fatalf("field completions = %s, range [%d, %d)", texts(result), result.ReplaceFrom, result.ReplaceTo)
+	}
+	if result.Suggestions[0].Kind != SuggestField || result.Suggestions[0].Detail != kindNumber {
+		t.Fatalf("latency suggestion = %+v", result.Suggestions[0])
+	}
+
+	result = suggest("level:e", -1)
+	if texts(result) != "[level:error level:eek]" || result.Field != "level" || result.Suggestions[0].Count != 2 {
+		t.Fatalf("level values = %s (%+v)", texts(result), result.Suggestions)
+	}
+	// Text is completed from its analyzed terms, lowercased.
+	if result = suggest(`-level:"N`, -1); texts(result) != `[-level:"now"]` {
+		t.Fatalf("quoted level values = %s", texts(result))
+	}
+
+	result = suggest("latency:>12", -1)
+	if texts(result) != "[latency:>120 latency:>125]" {
+		t.Fatalf("latency values = %s", texts(result))
+	}
+	if result = suggest(`latency:"-`, -1); texts(result) != "[latency:-3]" {
+		t.Fatalf("negative values = %s", texts(result))
+	}
+
+	result = suggest("latency:", -1)
+	if len(result.Suggestions) < 4 || result.Suggestions[0].Text != "latency:>" || result.Suggestions[0].Kind != SuggestOperator {
+		t.Fatalf("latency operators = %s", texts(result))
+	}
+
+	// The cursor sits inside the query; only the text before it is completed.
+	if result = suggest("lev AND x", 3); texts(result) != "[level:]" || result.ReplaceTo != 3 {
+		t.Fatalf("mid-query completion = %s", texts(result))
+	}
+	if result = suggest("", 0); len(result.Suggestions) == 0 || result.Suggestions[len(result.Suggestions)-1].Text != `"` {
+		t.Fatalf("empty query completions = %s", texts(result))
+	}
+	if _, err := store.Suggest(context.Background(), SuggestOptions{Text: "abc", Cursor: 4}); err == nil {
+		t.Fatal("expected an error for a cursor past the text")
+	}
+}
+
+func TestSuggestReadsNewestShardsAndCachedFieldsOnly(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	var logs []map[string]interface{}
+	for day := 0; day < suggestValueShards+2; day++ {
+		logs = append(logs, map[string]interface{}{
+			"timestamp": base.AddDate(0, 0, day), "_raw": "x", "_src": "app.log", "host": fmt.Sprintf("h%d", day),
+		})
+	}
+	logs[0]["extra"] = "only in the oldest shard"
+	if _, err := store.StoreWithIDs(logs, "app.log"); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+	suggest := func(text string) []Suggestion {
+		t.Helper()
+		result, err := store.Suggest(context.Background(), SuggestOptions{
+			Text: text, Cursor: len(text), StartDate: base, EndDate: base.AddDate(0, 0, suggestValueShards+2),
+		})
+		if err != nil {
+			t.Fatalf("Suggest(%q): %v", text, err)
+		}
+		return result.Suggestions
+	}
+
+	values := suggest("host:h")
+	if len(values) != suggestValueShards {
+		t.Fatalf("host values = %+v, want the newest %d shards' values", values, suggestValueShards)
+	}
+	for _, value := range values {
+		if value.Text == "host:h0" || value.Text == "host:h1" {
+			t.Fatalf("value from an older shard suggested: %+v", values)
+		}
+	}
+
+	if fields := suggest("ext"); len(fields) != 1 || fields[0].Text != "extra:" {
+		t.Fatalf("extra before dropping metadata = %+v", fields)
+	}
+	keys, err := store.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	store.mu.Lock()
+	delete(store.meta, keys[0])
+	os.Remove(store.metaPath(keys[0]))
+	store.mu.Unlock()
+	if fields := suggest("ext"); len(fields) != 0 {
+		t.Fatalf("shard without cached metadata supplied fields: %+v", fields)
+	}
+}
@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+const (
+	// DefaultTransactionLimit and MaxTransactionLimit bound one page of
+	// transactions.
+	DefaultTransactionLimit = 50
+	MaxTransactionLimit     = 1000
+
+	// TransactionScanLimit bounds the rows one Transactions call groups; the
+	// result is marked truncated past it.
+	TransactionScanLimit = 1_000_000
+
+	// DefaultTransactionErrorQuery marks the rows that flag a transaction as
+	// failed when no other query is given.
+	DefaultTransactionErrorQuery = "level:error level:err level:fatal level:critical level:crit level:panic level:alert level:emerg"
+
+	// transactionEventIDs is how many row IDs each transaction keeps.
+	transactionEventIDs = 20
+)
+
+// ErrInvalidTransactions is returned for transaction options that cannot be
+// grouped or sorted.
+var ErrInvalidTransactions = errors.New("invalid transactions")
+
+// TransactionOptions selects the rows Transactions groups, as SearchPage
+// takes them, how they are grouped and which page of groups is returned.
+type TransactionOptions struct {
+	Query     string
+	StartDate time.Time
+	EndDate   time.Time
+	Sources   []string
+	Fields    []string // correlation fields; rows sharing all their values group together
+
+	// MaxSpan ends a transaction once a row is further than it from the
+	// transaction's first row, and MaxPause once a row is further than it
+	// from the previous one. Zero leaves either unbounded.
+	MaxSpan  time.Duration
+	MaxPause time.Duration
+
+	ErrorQuery string // rows flagging a transaction as failed; defaults to DefaultTransactionErrorQuery
+	SortBy     string // duration or start (default)
+	SortOrder  string // asc or desc (default)
+	Limit      int
+	Offset     int
+}
+
+// Transaction is one group of correlated rows.
+type Transaction struct {
+	Fields   map[string]interface{} // the correlation values shared by its rows
+	Start    time.Time
+	End      time.Time
+	Duration time.Duration
+	Count    int
+	Errors   int      // rows matching the error query
+	Sources  []string // sorted
+	EventIDs []string // the first transactionEventIDs rows, oldest first
+	Query    string   // finds its rows by their correlation values
+}
+
+// TransactionResult is one page of transactions.
+type TransactionResult struct {
+	Transactions []Transaction
+	Total        int  // transactions across all pages
+	Grouped      int  // rows in a transaction
+	Ungrouped    int  // matching rows missing a correlation field
+	Truncated    bool // TransactionScanLimit rows were grouped and the rest skipped
+	QueryTime    time.Duration
+}
+
+// transactionEvent is one grouped row, kept until its group is split.
+type transactionEvent struct {
+	id        string
+	timestamp time.Time
+	source    string
+}
+
+// Transactions groups the rows a query matches by the values of
+// options.Fields, across sources and shards, then splits each group by
+// MaxSpan and MaxPause into transactions. Rows are held in memory until
+// every shard has been read, so at most TransactionScanLimit are grouped.
+// Rows matching the error query as well are counted as each transaction's
+// errors, by a second scan of each shard that keeps only the grouped ones.
+func (s *Storage) Transactions(ctx context.Context, options TransactionOptions) (TransactionResult, error) {
+	started := time.Now()
+	result := TransactionResult{Transactions: []Transaction{}}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	fields := deduplicateStrings(options.Fields)
+	if len(fields) == 0 {
+		return result, fmt.Errorf("%w: at least one correlation field is required", ErrInvalidTransactions)
+	}
+	if options.SortBy == "" {
+		options.SortBy = "start"
+	}
+	if options.SortBy != "start" && options.SortBy != "duration" {
+		return result, fmt.Errorf("%w: sort must be by start or duration", ErrInvalidTransactions)
+	}
+	if options.SortOrder == "" {
+		options.SortOrder = "desc"
+	}
+	if options.SortOrder != "asc" && options.SortOrder != "desc" {
+		return result, fmt.Errorf("%w: sort order must be asc or desc", ErrInvalidTransactions)
+	}
+	if options.Limit == 0 {
+		options.Limit = DefaultTransactionLimit
+	}
+	if options.Limit < 0 || options.Limit > MaxTransactionLimit || options.Offset < 0 {
+		return result, fmt.Errorf("%w: limit must be between 1 and %d and offset not negative", ErrInvalidTransactions, MaxTransactionLimit)
+	}
+	if options.MaxSpan < 0 || options.MaxPause < 0 {
+		return result, fmt.Errorf("%w: max span and max pause must not be negative", ErrInvalidTransactions)
+	}
+	if options.ErrorQuery == "" {
+		options.ErrorQuery = DefaultTransactionErrorQuery
+	}
+	if options.EndDate.Before(options.StartDate) {
+		result.QueryTime = time.Since(started)
+		return result, nil
+	}
+	keywordFields, err := s.keywordFields()
+	if err != nil {
+		return result, err
+	}
+	budget := s.newRegexpBudget()
+	baseQuery, err := buildPageQuery(options.Query, options.Sources, keywordFields, budget)
+	if err != nil {
+		return result, err
+	}
+	errorQuery, err := buildPageQuery(options.ErrorQuery, nil, keywordFields, budget)
+	if err != nil {
+		return result, err
+	}
+
+	dates, err := s.List()
+	if err != nil {
+		return result, fmt.Errorf("failed to list existing dates: %w", err)
+	}
+	groups := make(map[string][]transactionEvent)
+	groupValues := make(map[string]map[string]interface{})
+	errorIDs := make(map[string]bool)
+	// Past TransactionScanLimit the scan is stopped by canceling its context.
+	scanCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	for _, date := range intersectingDates(dates, options.StartDate, options.EndDate, s.shardSlack()) {
+		index, release, err := s.acquireIndex(date, false)
+		if errors.Is(err, errShardNotFound) {
+			continue // removed since List
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to get index for date %s: %w", date, err)
+		}
+		collected := make(map[string]bool) // this shard's grouped rows
+		err = scanShardMatches(scanCtx, index, baseQuery, options.StartDate, options.EndDate, append([]string{"_src"}, fields...),
+			func(id string, stored map[string]interface{}, timestamp time.Time) {
+				if result.Truncated {
+					return
+				}
+				if result.Grouped+result.Ungrouped >= TransactionScanLimit {
+					result.Truncated = true
+					stop()
+					return
+				}
+				key, values, ok := transactionKey(fields, stored)
+				if !ok {
+					result.Ungrouped++
+					return
+				}
+				result.Grouped++
+				collected[id] = true
+				if _, seen := groupValues[key]; !seen {
+					groupValues[key] = values
+				}
+				source, _ := stored["_src"].(string)
+				groups[key] = append(groups[key], transactionEvent{id: id, timestamp: timestamp, source: source})
+			})
+		if result.Truncated && ctx.Err() == nil {
+			err = nil
+		}
+		if err == nil {
+			err = scanShardMatches(ctx, index, bleve.NewConjunctionQuery(baseQuery, errorQuery), options.StartDate, options.EndDate, nil,
+				func(id string, _ map[string]interface{}, _ time.Time) {
+					// Ungrouped rows and rows past the scan limit are not
+					// kept, so errorIDs stays within TransactionScanLimit.
+					if collected[id] {
+						errorIDs[id] = true
+					}
+				})
+		}
+		release()
+		if err != nil {
+			return result, err
+		}
+		if result.Truncated {
+			break
+		}
+	}
+
+	var transactions []Transaction
+	for key, events := range groups {
+		sort.Slice(events, func(i, j int) bool {
+			if !events[i].timestamp.Equal(events[j].timestamp) {
+				return events[i].timestamp.Before(events[j].timestamp)
+			}
+			return events[i].id < events[j].id
+		})
+		first := 0
+		for i := 1; i <= len(events); i++ {
+			if i < len(events) &&
+				(options.MaxSpan == 0 || events[i].timestamp.Sub(events[first].timestamp) <= options.MaxSpan) &&
+				(options.MaxPause == 0 || events[i].timestamp.Sub(events[i-1].timestamp) <= options.MaxPause) {
+				continue
+			}
+			transactions = append(transactions, newTransaction(fields, groupValues[key], events[first:i], errorIDs))
+			first = i
+		}
+	}
+	result.Total = len(transactions)
+	sort.Slice(transactions, func(i, j int) bool {
+		a, b := transactions[i], transactions[j]
+		if options.SortOrder == "desc" {
+			a, b = b, a
+		}
+		if options.SortBy == "duration" && a.Duration != b.Duration {
+			return a.Duration < b.Duration
+		}
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		return a.Query < b.Query
+	})
+	if options.Offset < len(transactions) {
+		result.Transactions = transactions[options.Offset:min(len(transactions), options.Offset+options.Limit)]
+	}
+	result.QueryTime = time.Since(started)
+	return result, nil
+}
+
+// transactionKey returns the group a row belongs to and its correlation
+// values, or false when it lacks one or holds several values for one.
+func transactionKey(fields []string, stored map[string]interface{}) (string, map[string]interface{}, bool) {
+	values := make(map[string]interface{}, len(fields))
+	var key strings.Builder
+	for _, field := range fields {
+		value := stored[field]
+		switch value.(type) {
+		case string, float64, bool:
+		default:
+			return "", nil, false
+		}
+		values[field] = value
+		fmt.Fprintf(&key, "%T:%v\x00", value, value)
+	}
+	return key.String(), values, true
+}
+
+func newTransaction(fields []string, values map[string]interface{}, events []transactionEvent, errorIDs map[string]bool) Transaction {
+	transaction := Transaction{
+		Fields:   values,
+		Start:    events[0].timestamp,
+		End:      events[len(events)-1].timestamp,
+		Count:    len(events),
+		EventIDs: []string{},
+		Query:    transactionQuery(fields, values),
+	}
+	transaction.Duration = transaction.End.Sub(transaction.Start)
+	sources := make([]string, 0, 1)
+	for _, event := range events {
+		if errorIDs[event.id] {
+			transaction.Errors++
+		}
+		if event.source != "" {
+			sources = append(sources, event.source)
+		}
+		if len(transaction.EventIDs) < transactionEventIDs {
+			transaction.EventIDs = append(transaction.EventIDs, event.id)
+		}
+	}
+	transaction.Sources = deduplicateStrings(sources)
+	sort.Strings(transaction.Sources)
+	return transaction
+}
+
+// transactionQuery builds a query string requiring each correlation value.
+// Text is matched as a phrase and numbers by a one-value range.
+func transactionQuery(fields []string, values map[string]interface{}) string {
+	clauses := make([]string, 0, len(fields))
+	for _, field := range fields {
+		switch value := values[field].(type) {
+		case string:
+			escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+			clauses = append(clauses, fmt.Sprintf(`+%s:"%s"`, field, escaped))
+		case float64:
+			number := strconv.FormatFloat(value, 'f', -1, 64)
+			clauses = append(clauses, fmt.Sprintf("+%s:>=%s +%s:<=%s", field, number, field, number))
+		case bool:
+			clauses = append(clauses, fmt.Sprintf("+%s:%t", field, value))
+		}
+	}
+	return strings.Join(clauses, " ")
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTransactionsGroupsRowsByCorrelationFields(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	appLogs := []map[string]interface{}{
+		{"timestamp": base, "_raw": "start", "_src": "app.log", "trace_id": "t1", "level": "info"},
+		{"timestamp": base.Add(2 * time.Second), "_raw": "done", "_src": "app.log", "trace_id": "t1", "level": "info"},
+		{"timestamp": base.Add(time.Minute), "_raw": "start", "_src": "app.log", "trace_id": "t2", "level": "info"},
+		{"timestamp": base.Add(time.Hour), "_raw": "start again", "_src": "app.log", "trace_id": "t2", "level": "info"},
+		{"timestamp": base.Add(time.Hour), "_raw": "no trace", "_src": "app.log", "level": "info"},
+	}
+	if _, err := store.StoreWithIDs(appLogs, "app.log"); err != nil {
+		t.Fatalf("store app logs: %v", err)
+	}
+	dbLogs := []map[string]interface{}{
+		{"timestamp": base.Add(time.Second), "_raw": "query failed", "_src": "db.log", "trace_id": "t1", "level": "ERROR"},
+		{"timestamp": base.Add(24*time.Hour + time.Second), "_raw": "late", "_src": "db.log", "trace_id": "t3", "level": "info"},
+	}
+	if _, err := store.StoreWithIDs(dbLogs, "db.log"); err != nil {
+		t.Fatalf("store db logs: %v", err)
+	}
+
+	result, err := store.Transactions(context.Background(), TransactionOptions{
+		StartDate: base,
+		EndDate:   base.Add(48 * time.Hour),
+		Fields:    []string{"trace_id"},
+		MaxPause:  10 * time.Minute,
+		SortBy:    "duration",
+	})
+	if err != nil {
+		t.Fatalf("Transactions: %v", err)
+	}
+	if result.Total != 4 || result.Grouped != 6 || result.Ungrouped != 1 || len(result.Transactions) != 4 {
+		t.Fatalf("result = %+v", result)
+	}
+	first := result.Transactions[0]
+	if first.Fields["trace_id"] != "t1" || first.Count != 3 || first.Duration != 2*time.Second || first.Errors != 1 ||
+		len(first.Sources) != 2 || first.Sources[0] != "app.log" || first.Sources[1] != "db.log" || len(first.EventIDs) != 3 {
+		t.Fatalf("first transaction = %+v", first)
+	}
+	for _, transaction := range result.Transactions[1:] {
+		if transaction.Count != 1 || transaction.Duration != 0 || transaction.Errors != 0 {
+			t.Fatalf("transaction = %+v", transaction)
+		}
+	}
+
+	paged, err := store.Transactions(context.Background(), TransactionOptions{
+		StartDate: base,
+		EndDate:   base.Add(48 * time.Hour),
+		Fields:    []string{"trace_id"},
+		SortOrder: "asc",
+		Limit:     1,
+		Offset:    1,
+	})
+	if err != nil {
+		t.Fatalf("paged Transactions: %v", err)
+	}
+	if paged.Total != 3 || len(paged.Transactions) != 1 || paged.Transactions[0].Fields["trace_id"] != "t2" ||
+		paged.Transactions[0].Duration != time.Hour-time.Minute {
+		t.Fatalf("paged = %+v", paged)
+	}
+
+	drill, err := store.SearchPage(context.Background(), SearchOptions{
+		Query:     first.Query,
+		StartDate: base,
+		EndDate:   base.Add(48 * time.Hour),
+		Limit:     10,
+		SortBy:    "timestamp",
+		SortOrder: "asc",
+	})
+	if err != nil {
+		t.Fatalf("SearchPage: %v", err)
+	}
+	if drill.TotalCount != 3 {
+		t.Fatalf("drill-down matched %d rows with %q", drill.TotalCount, first.Query)
+	}
+
+	if _, err := store.Transactions(context.Background(), TransactionOptions{StartDate: base, EndDate: base}); err == nil {
+		t.Fatal("expected an error without correlation fields")
+	}
+}
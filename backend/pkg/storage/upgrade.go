@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/index/upsidedown/store/goleveldb"
+	blevesearch "github.com/blevesearch/bleve/v2/search"
+)
+
+const (
+	// layoutVersionKey is a Bleve internal key stamped on shards created with
+	// the current buildIndexMapping and compact numeric encoding. Shards
+	// without it predate the stamp, and nothing cheaper than a rewrite can
+	// tell whether their numeric terms are compact.
+	layoutVersionKey     = "logsonic.layout"
+	currentLayoutVersion = "1"
+
+	// Upgrades build the new shard beside the live one and swap directories.
+	// Both names are hidden from the logs-*.bleve glob.
+	upgradeDirPrefix = ".upgrade-"
+	retiredDirPrefix = ".retired-"
+)
+
+// UpgradeProgress is reported as each shard is rewritten.
+type UpgradeProgress struct {
+	Shard      string
+	ShardIndex int // 1-based position among pending shards
+	ShardCount int
+	Docs       int // documents rewritten so far in this shard
+	DocTotal   uint64
+	Done       bool // the shard has been swapped in
+}
+
+// UpgradeResult summarises an upgrade run.
+type UpgradeResult struct {
+	ShardsUpgraded int
+	DocsRewritten  int
+}
+
+func markShardCurrent(index bleve.Index) error {
+	return index.SetInternal([]byte(layoutVersionKey), []byte(currentLayoutVersion))
+}
+
+func shardIsCurrent(index bleve.Index) bool {
+	version, err := index.GetInternal([]byte(layoutVersionKey))
+	return err == nil && string(version) == currentLayoutVersion && timestampIsIndexed(index)
+}
+
+// PendingUpgrades returns the shards, in chronological order, that were
+// written with an older mapping or numeric encoding. The answer comes from
+// cached shard metadata, so only shards with nothing cached are opened.
+func (s *Storage) PendingUpgrades() ([]string, error) {
+	keys, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]string, 0)
+	for _, key := range keys {
+		meta, err := s.shardMetadata(key, false)
+		if errors.Is(err, errShardNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get index for date %s: %w", key, err)
+		}
+		if !meta.Current {
+			pending = append(pending, key)
+		}
+	}
+	return pending, nil
+}
+
+// UpgradeShards rewrites every pending shard into the current mapping and
+// compact numeric encoding, keeping document IDs and stored fields. Each
+// shard is copied into a fresh index and swapped in; queries keep reading the
+// old shard until the swap, and writers pause while that shard is copied. A
+// cancelled run leaves the remaining shards untouched and can be resumed.
+func (s *Storage) UpgradeShards(ctx context.Context, progress func(UpgradeProgress)) (UpgradeResult, error) {
+	var result UpgradeResult
+	pending, err := s.PendingUpgrades()
+	if err != nil {
+		return result, err
+	}
+	for i, key := range pending {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		report := func(p UpgradeProgress) {
+			if progress != nil {
+				p.Shard, p.ShardIndex, p.ShardCount = key, i+1, len(pending)
+				progress(p)
+			}
+		}
+		docs, err := s.upgradeShard(ctx, key, report)
+		result.DocsRewritten += docs
+		if err != nil {
+			return result, fmt.Errorf("failed to upgrade shard %s: %w", key, err)
+		}
+		result.ShardsUpgraded++
+	}
+	return result, nil
+}
+
+func (s *Storage) upgradeShard(ctx context.Context, key string, report func(UpgradeProgress)) (int, error) {
+	// Same lock order as StoreWithIDs: write lease, then the shard map.
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	old, release, err := s.acquireIndex(key, false)
+	if errors.Is(err, errShardNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	total, _ := old.DocCount()
+	meta, err := s.shardMetadata(key, false)
+	if err != nil {
+		release()
+		return 0, fmt.Errorf("failed to read metadata for %s: %w", key, err)
+	}
+	stagingPath := filepath.Join(s.baseDir, upgradeDirPrefix+shardDirName(key))
+	if err := os.RemoveAll(stagingPath); err != nil {
+		release()
+		return 0, err
+	}
+	fresh, err := bleve.NewUsing(stagingPath, buildIndexMapping(), "scorch", goleveldb.Name, map[string]interface{}{"store": kvConfig})
+	if err != nil {
+		release()
+		return 0, fmt.Errorf("failed to create upgraded index: %w", err)
+	}
+	copied, err := copyShardDocuments(ctx, old, meta, fresh, func(done int) {
+		report(UpgradeProgress{Docs: done, DocTotal: total})
+	})
+	release()
+	if err == nil {
+		err = markShardCurrent(fresh)
+	}
+	if closeErr := fresh.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.RemoveAll(stagingPath)
+		return copied, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	livePath := filepath.Join(s.baseDir, shardDirName(key))
+	retiredPath := filepath.Join(s.baseDir, retiredDirPrefix+shardDirName(key))
+	// Queries may still have the old shard pinned; swap once they let go.
+	// Writers are held off, so the shard can only have changed by being
+	// cleared or pruned; an idle eviction or reopen leaves it as copied.
+	s.waitIdleLocked(key)
+	if _, err := os.Stat(livePath); err != nil {
+		return copied, os.RemoveAll(stagingPath)
+	}
+	cached := s.loadMetaLocked(key)
+	if err := s.closeShardLocked(key); err != nil {
+		return copied, err
+	}
+	if err := os.Rename(livePath, retiredPath); err != nil {
+		return copied, fmt.Errorf("failed to install upgraded shard: %w", err)
+	}
+	if err := os.Rename(stagingPath, livePath); err != nil {
+		if restoreErr := os.Rename(retiredPath, livePath); restoreErr != nil {
+			return copied, fmt.Errorf("failed to install upgraded shard: %v (restoring the old shard also failed: %w)", err, restoreErr)
+		}
+		return copied, fmt.Errorf("failed to install upgraded shard: %w", err)
+	}
+	// The upgraded shard is opened again on first use. Its contents match
+	// the old one, so the cached metadata carries over.
+	if cached != nil {
+		cached.Current, cached.dirty = true, true
+		s.persistMetaLocked(key)
+	}
+	if err := os.RemoveAll(retiredPath); err != nil {
+		return copied, fmt.Errorf("failed to remove retired shard: %w", err)
+	}
+	report(UpgradeProgress{Docs: copied, DocTotal: total, Done: true})
+	return copied, nil
+}
+
+// copyShardDocuments copies every document of src into dst, reading stored
+// values so timestamps keep full precision. Each keeps the index form it had
+// in src, recovered from src's metadata by rebuildDocument.
+func copyShardDocuments(ctx context.Context, src bleve.Index, meta shardMeta, dst bleve.Index, progress func(int)) (int, error) {
+	copied := 0
+	var searchAfter []string
+	for {
+		request := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+		request.Size = migrateBatchSize
+		request.SortByCustom(blevesearch.SortOrder{&blevesearch.SortDocID{}})
+		if len(searchAfter) > 0 {
+			request.SetSearchAfter(searchAfter)
+		}
+		page, err := src.SearchInContext(ctx, request)
+		if err != nil {
+			return copied, err
+		}
+		if len(page.Hits) == 0 {
+			break
+		}
+
+		batch := dst.NewBatch()
+		for _, hit := range page.Hits {
+			stored, err := src.Document(hit.ID)
+			if err != nil {
+				return copied, fmt.Errorf("failed to read document %s: %w", hit.ID, err)
+			}
+			if stored == nil {
+				continue
+			}
+			row, _, _ := storedDocumentRow(stored)
+			doc, err := rebuildDocument(src, meta, dst.Mapping(), hit.ID, row, nil)
+			if err != nil {
+				return copied, fmt.Errorf("failed to index log entry: %w", err)
+			}
+			if err := batch.IndexAdvanced(doc); err != nil {
+				return copied, fmt.Errorf("failed to add log entry to batch: %w", err)
+			}
+		}
+		if err := dst.Batch(batch); err != nil {
+			return copied, err
+		}
+		copied += batch.Size()
+		progress(copied)
+
+		searchAfter = page.Hits[len(page.Hits)-1].Sort
+		if len(page.Hits) < request.Size || len(searchAfter) == 0 {
+			break
+		}
+	}
+	return copied, nil
+}
+
+// recoverInterruptedUpgrades finishes or rolls back swaps cut short by a
+// crash. A retired shard whose live directory is missing is restored; one
+// whose replacement is already live is deleted. Partial staging directories
+// are always discarded.
+func recoverInterruptedUpgrades(baseDir string) error {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasPrefix(name, upgradeDirPrefix):
+			if err := os.RemoveAll(filepath.Join(baseDir, name)); err != nil {
+				return err
+			}
+		case strings.HasPrefix(name, retiredDirPrefix):
+			livePath := filepath.Join(baseDir, strings.TrimPrefix(name, retiredDirPrefix))
+			retiredPath := filepath.Join(baseDir, name)
+			if _, err := os.Stat(livePath); errors.Is(err, fs.ErrNotExist) {
+				if err := os.Rename(retiredPath, livePath); err != nil {
+					return err
+				}
+			} else if err := os.RemoveAll(retiredPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+)
+
+func TestPendingUpgrades_NewShardsAreCurrent(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	ts := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	if err := store.Store(makeLogs([]time.Time{ts}, "app.log"), "app.log"); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	pending, err := store.PendingUpgrades()
+	if err != nil {
+		t.Fatalf("PendingUpgrades: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("freshly created shards should not need an upgrade, got %v", pending)
+	}
+}
+
+func TestUpgradeShards_RewritesLegacyShards(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	// A shard from before the custom mapping: Bleve defaults, _all composite
+	// and full trie-encoded numerics.
+	defaultIndex, err := bleve.New(filepath.Join(dir, shardDirName("2024-01-15")), bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatalf("create default-mapping shard: %v", err)
+	}
+	for i, status := range []int{200, 503} {
+		if err := defaultIndex.Index([]string{"a", "b"}[i], map[string]interface{}{
+			"timestamp": day.Add(time.Duration(i+10) * time.Hour),
+			"_raw":      "request done",
+			"_src":      "api.log",
+			"_seq":      int64(i + 1),
+			"status":    status,
+		}); err != nil {
+			t.Fatalf("index default-mapping doc: %v", err)
+		}
+	}
+	defaultIndex.Close()
+
+	// A shard with the custom mapping but an unindexed timestamp.
+	legacyMapping := buildIndexMapping().(*mapping.IndexMappingImpl)
+	legacyMapping.DefaultMapping.Properties["timestamp"].Fields[0].Index = false
+	unindexed, err := bleve.New(filepath.Join(dir, shardDirName("2024-01-16")), legacyMapping)
+	if err != nil {
+		t.Fatalf("create unindexed-timestamp shard: %v", err)
+	}
+	if err := unindexed.Index("c", map[string]interface{}{
+		"timestamp": day.AddDate(0, 0, 1).Add(10*time.Hour + 123*time.Millisecond),
+		"_raw":      "request failed",
+		"_src":      "api.log",
+		"_seq":      int64(3),
+		"status":    int64(500),
+	}); err != nil {
+		t.Fatalf("index unindexed-timestamp doc: %v", err)
+	}
+	unindexed.Close()
+
+	store, err := NewStorage(dir)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	pending, err := store.PendingUpgrades()
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("PendingUpgrades = %v, %v; want both shards", pending, err)
+	}
+
+	var finished []string
+	result, err := store.UpgradeShards(context.Background(), func(p UpgradeProgress) {
+		if p.ShardCount != 2 {
+			t.Errorf("progress reports %d shards, want 2", p.ShardCount)
+		}
+		if p.Done {
+			finished = append(finished, p.Shard)
+		}
+	})
+	if err != nil {
+		t.Fatalf("UpgradeShards: %v", err)
+	}
+	if result.ShardsUpgraded != 2 || result.DocsRewritten != 3 {
+		t.Fatalf("unexpected upgrade result %+v", result)
+	}
+	if len(finished) != 2 || finished[0] != "2024-01-15" || finished[1] != "2024-01-16" {
+		t.Fatalf("finished shards = %v", finished)
+	}
+	if pending, _ := store.PendingUpgrades(); len(pending) != 0 {
+		t.Fatalf("shards still pending after upgrade: %v", pending)
+	}
+	if entries, _ := filepath.Glob(filepath.Join(dir, ".*")); len(entries) != 0 {
+		t.Fatalf("upgrade left staging directories behind: %v", entries)
+	}
+
+	if _, timestamp, _ := storedDocumentRow(storedDocument(t, store, "2024-01-16", "c")); timestamp.Nanosecond() != int(123*time.Millisecond) {
+		t.Fatalf("upgrade lost timestamp precision: %v", timestamp)
+	}
+
+	end := day.AddDate(0, 0, 2)
+	results, err := searchLogs(store, "status:>=500", day, end, nil)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("numeric range over upgraded shards returned %d rows, want 2", len(results))
+	}
+
+	// Rewritten shards index timestamps, so SearchPage takes the facet path
+	// and returns a real histogram instead of one legacy bucket.
+	page, err := store.SearchPage(context.Background(), SearchOptions{
+		StartDate: day,
+		EndDate:   end.Add(-time.Nanosecond),
+		Limit:     10,
+		SortBy:    "timestamp",
+		SortOrder: "asc",
+	})
+	if err != nil {
+		t.Fatalf("SearchPage: %v", err)
+	}
+	if page.TotalCount != 3 || len(page.Distribution) != maxDistributionBins {
+		t.Fatalf("expected facet distribution over 3 rows, got total %d with %d buckets", page.TotalCount, len(page.Distribution))
+	}
+	if ids := []interface{}{page.Logs[0]["_id"], page.Logs[1]["_id"], page.Logs[2]["_id"]}; ids[0] != "a" || ids[1] != "b" || ids[2] != "c" {
+		t.Fatalf("document IDs changed: %v", ids)
+	}
+}
+
+func TestUpgradeShards_KeepsDeclaredFieldTypes(t *testing.T) {
+	store, _ := setupTestStorage(t)
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	storeDeclaredRows(t, store, day)
+	// Mark the shard as written by an older release so it is rewritten.
+	store.mu.Lock()
+	store.loadMetaLocked("2024-01-15").Current = false
+	store.mu.Unlock()
+
+	result, err := store.UpgradeShards(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("UpgradeShards: %v", err)
+	}
+	if result.ShardsUpgraded != 1 || result.DocsRewritten != 2 {
+		t.Fatalf("unexpected upgrade result %+v", result)
+	}
+	checkDeclaredRows(t, store, day)
+}
+
+func TestRecoverInterruptedUpgrades(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		retiredDirPrefix + shardDirName("2024-01-15"), // swap cut short: restore it
+		retiredDirPrefix + shardDirName("2024-01-16"), // replacement is live: drop it
+		shardDirName("2024-01-16"),
+		upgradeDirPrefix + shardDirName("2024-01-17"), // partial copy: drop it
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recoverInterruptedUpgrades(dir); err != nil {
+		t.Fatalf("recoverInterruptedUpgrades: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if len(names) != 2 || names[0] != shardDirName("2024-01-15") || names[1] != shardDirName("2024-01-16") {
+		t.Fatalf("directory after recovery = %v", names)
+	}
+}
@@ -37,6 +37,11 @@ type IngestSessionOptions struct {
 	// behaviour. Folding is applied on /parse (including autosuggest)
 	// as well as ingest and live tail.
 	Multiline *MultilineConfig `json:"multiline,omitempty"`
+	// Deduplicate skips records whose raw line, source and timestamp
+	// match a record already stored in the target shard, so re-importing
+	// a file does not double its rows. Skipped records are reported in
+	// IngestResponse.Duplicates.
+	Deduplicate bool `json:"deduplicate,omitempty"`
 }
 
 // MultilineConfig describes how physical lines should be folded into
@@ -101,7 +106,10 @@ type IngestResponse struct {
 	Error     string `json:"error,omitempty"`
 	Processed int    `json:"processed"`
 	Failed    int    `json:"failed"`
-	SessionID string `json:"session_id,omitempty"`
+	// Duplicates counts processed records skipped because an identical
+	// record was already stored; only set for deduplicating sessions.
+	Duplicates int    `json:"duplicates"`
+	SessionID  string `json:"session_id,omitempty"`
 }
 
 type LiveFileRequest struct {
@@ -188,6 +196,44 @@ type ErrorResponse struct {
 	Details string `json:"details,omitempty"`
 }
 
+// ShardUpgradeStatus reports the legacy shard upgrade job. PendingShards
+// lists shards still written with an older mapping or numeric encoding.
+type ShardUpgradeStatus struct {
+	Status         string     `json:"status"`
+	Running        bool       `json:"running"`
+	PendingShards  []string   `json:"pending_shards"`
+	CurrentShard   string     `json:"current_shard,omitempty"`
+	ShardIndex     int        `json:"shard_index,omitempty"`
+	ShardCount     int        `json:"shard_count,omitempty"`
+	ShardDocs      int        `json:"shard_docs,omitempty"`
+	ShardDocTotal  uint64     `json:"shard_doc_total,omitempty"`
+	ShardsUpgraded int        `json:"shards_upgraded"`
+	DocsRewritten  int        `json:"docs_rewritten"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// ShardEviction records a shard deleted to keep storage under its size quota.
+type ShardEviction struct {
+	Shard     string    `json:"shard"`
+	Bytes     int64     `json:"bytes"`
+	Docs      uint64    `json:"docs"`
+	EvictedAt time.Time `json:"evicted_at"`
+}
+
+// StorageQuotaInfo reports the size quota and the shards it has evicted
+// since the server started, most recent first.
+type StorageQuotaInfo struct {
+	MaxBytes        int64           `json:"max_bytes"`
+	UsedBytes       int64           `json:"used_bytes"`
+	LastCheckedAt   *time.Time      `json:"last_checked_at,omitempty"`
+	LastError       string          `json:"last_error,omitempty"`
+	EvictedShards   int             `json:"evicted_shards"`
+	EvictedBytes    int64           `json:"evicted_bytes"`
+	RecentEvictions []ShardEviction `json:"recent_evictions"`
+}
+
 // SystemInfoResponse contains detailed information about the system and storage
 type SystemInfoResponse struct {
 	Status      string `json:"status"`
@@ -198,6 +244,10 @@ type SystemInfoResponse struct {
 		StorageDirectory string   `json:"storage_directory"`
 		StorageSize      int64    `json:"storage_size_bytes"`
 		SourceNames      []string `json:"source_names"`
+		// FieldTypeConflicts flags fields indexed inconsistently. Shards
+		// written before value kinds were recorded are only counted.
+		FieldTypeConflicts  []FieldTypeConflict `json:"field_type_conflicts"`
+		FieldTypesUnchecked int                 `json:"field_types_unchecked_shards"`
 	} `json:"storage_info"`
 	SystemInfo struct {
 		Hostname     string `json:"hostname"`
@@ -212,6 +262,8 @@ type SystemInfoResponse struct {
 			NumGC      uint32 `json:"num_gc"`
 		} `json:"memory_usage"`
 	} `json:"system_info"`
+	// StorageQuota is present when a size quota is configured.
+	StorageQuota *StorageQuotaInfo `json:"storage_quota,omitempty"`
 }
 
 // GrokPatternRequest represents the structure for managing Grok patterns
@@ -250,6 +302,14 @@ type LogDistributionEntry struct {
 	EndTime      string         `json:"end_time"`
 	Count        int            `json:"count"`
 	SourceCounts map[string]int `json:"source_counts"`
+	// SplitCounts counts the bucket's rows per split_by value, keeping the
+	// values with the most rows overall; SplitOther counts the rest.
+	SplitCounts map[string]int `json:"split_counts,omitempty"`
+	SplitOther  int            `json:"split_other,omitempty"`
+	// Value is the agg of agg_field over the bucket, and SplitValues the
+	// same per split value; absent where no row holds a number.
+	Value       *float64           `json:"value,omitempty"`
+	SplitValues map[string]float64 `json:"split_values,omitempty"`
 }
 
 // LogResponse represents the response for log retrieval with distribution
@@ -269,6 +329,314 @@ type LogResponse struct {
 	EndDate          string                   `json:"end_date"`
 	AvailableColumns []string                 `json:"available_columns"`
 	LogDistribution  []LogDistributionEntry   `json:"log_distribution"`
+	// NextCursor and PrevCursor page forward and back when passed as
+	// cursor=; empty at either end of the results. Sorts on analyzed text
+	// page by offset and return neither.
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	// Highlights follows Logs when highlight=true: the fields the query
+	// matched in each row, each with the [start, end) byte ranges of its
+	// value that matched. Fields matched as a whole, like numbers in a
+	// range, have no ranges.
+	Highlights []map[string][][2]int `json:"highlights,omitempty"`
+}
+
+// FacetValueCount is one value of a faceted field and the rows holding it.
+type FacetValueCount struct {
+	Value interface{} `json:"value"`
+	Count int         `json:"count"`
+}
+
+// FacetResponse lists a field's most frequent values among the rows a /logs
+// query matches. Other counts the values outside the top size, and Missing
+// the matching rows without the field.
+type FacetResponse struct {
+	Status    string            `json:"status"`
+	Field     string            `json:"field"`
+	Values    []FacetValueCount `json:"values"`
+	Other     int               `json:"other"`
+	Missing   int               `json:"missing"`
+	Total     int               `json:"total"`
+	Size      int               `json:"size"`
+	Query     string            `json:"query"`
+	StartDate string            `json:"start_date"`
+	EndDate   string            `json:"end_date"`
+	TimeTaken int               `json:"time_taken"`
+}
+
+// LogContextResponse is a log and the logs of its source written around it,
+// oldest first on each side.
+type LogContextResponse struct {
+	Status     string                   `json:"status"`
+	Log        map[string]interface{}   `json:"log"`
+	Source     string                   `json:"source"`
+	Before     []map[string]interface{} `json:"before"`
+	After      []map[string]interface{} `json:"after"`
+	MoreBefore bool                     `json:"more_before"`
+	MoreAfter  bool                     `json:"more_after"`
+	TimeTaken  int                      `json:"time_taken"`
+}
+
+// ExplainResponse describes how a /logs query runs: its query tree after
+// rewriting, the shards the time range selects and what each step cost.
+// Times are in microseconds, like time_taken.
+type ExplainResponse struct {
+	Status    string         `json:"status"`
+	Query     string         `json:"query"`
+	Tree      ExplainNode    `json:"tree"`
+	Path      string         `json:"path"`
+	Shards    []ExplainShard `json:"shards"`
+	Phases    []ExplainPhase `json:"phases"`
+	Total     int            `json:"total"`
+	StartDate string         `json:"start_date"`
+	EndDate   string         `json:"end_date"`
+	TimeTaken int            `json:"time_taken"`
+}
+
+// ExplainNode is one query of an explained tree. Tokens are what the
+// analyzer makes of text; verified queries check their index candidates
+// against stored values.
+type ExplainNode struct {
+	Type     string        `json:"type"`
+	Role     string        `json:"role,omitempty"`
+	Field    string        `json:"field,omitempty"`
+	Text     string        `json:"text,omitempty"`
+	Analyzer string        `json:"analyzer,omitempty"`
+	Tokens   []string      `json:"tokens,omitempty"`
+	Verified bool          `json:"verified,omitempty"`
+	Children []ExplainNode `json:"children,omitempty"`
+}
+
+// ExplainShard is the work an explained query did in one shard. Path is
+// facet where timestamps are indexed and legacy where stored timestamps are
+// read instead.
+type ExplainShard struct {
+	Key           string `json:"key"`
+	Docs          uint64 `json:"docs"`
+	Path          string `json:"path"`
+	Candidates    int    `json:"candidates"`
+	Matches       int    `json:"matches"`
+	CandidateTime int    `json:"candidate_time"`
+	MatchTime     int    `json:"match_time"`
+}
+
+// ExplainPhase is one step of an explained query over every shard.
+type ExplainPhase struct {
+	Name      string `json:"name"`
+	TimeTaken int    `json:"time_taken"`
+	Count     int    `json:"count"`
+}
+
+// PatternsResponse is the templates /logs/patterns found among the values
+// of one field. Each pattern's query, with the same time range and sources,
+// finds the rows of its template, and rows sharing its constant text. A
+// template with no searchable constant text has no query; its sample IDs
+// are the way to its rows.
+type PatternsResponse struct {
+	Status      string    `json:"status"`
+	Query       string    `json:"query"`
+	Field       string    `json:"field"`
+	Patterns    []Pattern `json:"patterns"`
+	Matched     int       `json:"matched"`
+	Missing     int       `json:"missing"`
+	Unclustered int       `json:"unclustered"`
+	Clusters    int       `json:"clusters"`
+	Truncated   bool      `json:"truncated"`
+	StartDate   string    `json:"start_date"`
+	EndDate     string    `json:"end_date"`
+	TimeTaken   int       `json:"time_taken"`
+}
+
+// Pattern is one template; <*> marks its variable tokens.
+type Pattern struct {
+	Template  string   `json:"template"`
+	Query     string   `json:"query,omitempty"`
+	Count     int      `json:"count"`
+	FirstSeen string   `json:"first_seen"`
+	LastSeen  string   `json:"last_seen"`
+	SampleIDs []string `json:"sample_ids"`
+}
+
+// CompareResponse lists what /logs/compare found over-represented in the
+// selection window against the baseline: field values and log templates,
+// significant differences first, then by lift.
+type CompareResponse struct {
+	Status         string           `json:"status"`
+	Query          string           `json:"query"`
+	Values         []CompareValue   `json:"values"`
+	Patterns       []ComparePattern `json:"patterns"`
+	Fields         []string         `json:"fields"`
+	SelectionTotal int              `json:"selection_total"`
+	BaselineTotal  int              `json:"baseline_total"`
+	Truncated      bool             `json:"truncated"`
+	StartDate      string           `json:"start_date"`
+	EndDate        string           `json:"end_date"`
+	BaselineStart  string           `json:"baseline_start"`
+	BaselineEnd    string           `json:"baseline_end"`
+	TimeTaken      int              `json:"time_taken"`
+}
+
+// CompareValue is one field value's counts in each window. Lift is its
+// share of the selection over its share of the baseline, smoothed so new
+// values stay finite; significance is a two-proportion z-score. Approximate
+// marks a baseline count that is an upper bound.
+type CompareValue struct {
+	Field          string      `json:"field"`
+	Value          interface{} `json:"value"`
+	SelectionCount int         `json:"selection_count"`
+	BaselineCount  int         `json:"baseline_count"`
+	Lift           float64     `json:"lift"`
+	Significance   float64     `json:"significance"`
+	Significant    bool        `json:"significant"`
+	New            bool        `json:"new"`
+	Approximate    bool        `json:"approximate,omitempty"`
+}
+
+// ComparePattern is one log template's counts in each window, as for
+// CompareValue. Query and sample_ids drill into its selection rows; a
+// template with nothing to search for has no query.
+type ComparePattern struct {
+	Template       string   `json:"template"`
+	Query          string   `json:"query,omitempty"`
+	SelectionCount int      `json:"selection_count"`
+	BaselineCount  int      `json:"baseline_count"`
+	Lift           float64  `json:"lift"`
+	Significance   float64  `json:"significance"`
+	Significant    bool     `json:"significant"`
+	New            bool     `json:"new"`
+	SampleIDs      []string `json:"sample_ids"`
+}
+
+// TransactionsResponse is one page of the transactions /logs/transactions
+// grouped from matching rows by their correlation fields.
+type TransactionsResponse struct {
+	Status       string        `json:"status"`
+	Query        string        `json:"query"`
+	Fields       []string      `json:"fields"`
+	Transactions []Transaction `json:"transactions"`
+	Total        int           `json:"total"`
+	Limit        int           `json:"limit"`
+	Offset       int           `json:"offset"`
+	Grouped      int           `json:"grouped"`
+	Ungrouped    int           `json:"ungrouped"`
+	Truncated    bool          `json:"truncated"`
+	StartDate    string        `json:"start_date"`
+	EndDate      string        `json:"end_date"`
+	TimeTaken    int           `json:"time_taken"`
+}
+
+// Transaction is one group of correlated rows. Duration is in
+// microseconds, like time_taken; error is set when any row matched the
+// error query, and errors counts those rows.
+type Transaction struct {
+	Fields   map[string]interface{} `json:"fields"`
+	Start    string                 `json:"start"`
+	End      string                 `json:"end"`
+	Duration int64                  `json:"duration"`
+	Count    int                    `json:"count"`
+	Error    bool                   `json:"error"`
+	Errors   int                    `json:"errors"`
+	Sources  []string               `json:"sources"`
+	EventIDs []string               `json:"event_ids"`
+	Query    string                 `json:"query"`
+}
+
+// FieldsResponse describes every field of the rows /fields read, by name.
+// Counts are over the rows read; truncated reports that more matched.
+type FieldsResponse struct {
+	Status    string       `json:"status"`
+	Query     string       `json:"query"`
+	Fields    []FieldStats `json:"fields"`
+	Total     int          `json:"total"`
+	Truncated bool         `json:"truncated"`
+	StartDate string       `json:"start_date"`
+	EndDate   string       `json:"end_date"`
+	TimeTaken int          `json:"time_taken"`
+}
+
+// FieldStats describes one field. Type is the kind it is indexed as, or
+// mixed when types lists several; distinct is estimated unless
+// distinct_exact is set; min, max and avg cover its numeric values.
+type FieldStats struct {
+	Name          string            `json:"name"`
+	Type          string            `json:"type"`
+	Types         []string          `json:"types"`
+	Count         int               `json:"count"`
+	Distinct      int               `json:"distinct"`
+	DistinctExact bool              `json:"distinct_exact"`
+	Min           *float64          `json:"min,omitempty"`
+	Max           *float64          `json:"max,omitempty"`
+	Avg           *float64          `json:"avg,omitempty"`
+	TopValues     []FacetValueCount `json:"top_values"`
+}
+
+// QuerySuggestResponse holds completions for the query term ending at
+// cursor_pos. Each suggestion's text replaces the characters of prefix from
+// replace_from to replace_to; field names the field whose values are
+// completed, if any.
+type QuerySuggestResponse struct {
+	Status      string            `json:"status"`
+	Prefix      string            `json:"prefix"`
+	CursorPos   int               `json:"cursor_pos"`
+	Suggestions []QuerySuggestion `json:"suggestions"`
+	ReplaceFrom int               `json:"replace_from"`
+	ReplaceTo   int               `json:"replace_to"`
+	Field       string            `json:"field,omitempty"`
+	TimeTaken   int               `json:"time_taken"`
+}
+
+// QuerySuggestion is one completion: a field, value or operator. Detail lists a
+// field's kinds or describes an operator; count is the rows holding a value.
+type QuerySuggestion struct {
+	Text   string `json:"text"`
+	Kind   string `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+	Count  int    `json:"count,omitempty"`
+}
+
+// SearchResponse is the table a piped stats query produces, plus the same
+// data as chart series. Rows follow Columns; _time cells are RFC3339 bucket
+// starts.
+type SearchResponse struct {
+	Status    string          `json:"status"`
+	Query     string          `json:"query"`
+	Columns   []string        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	Chart     SearchChart     `json:"chart"`
+	Matched   int             `json:"matched"`
+	StartDate string          `json:"start_date"`
+	EndDate   string          `json:"end_date"`
+	TimeTaken int             `json:"time_taken"`
+}
+
+// SearchChart holds one series per aggregate (and per group when x is
+// time), aligned with X. Null values mark points without data.
+type SearchChart struct {
+	X      []interface{}  `json:"x"`
+	Series []SearchSeries `json:"series"`
+}
+
+// SearchSeries is one line or bar set of a SearchChart.
+type SearchSeries struct {
+	Name   string     `json:"name"`
+	Values []*float64 `json:"values"`
+}
+
+// DeleteByQueryResponse reports a delete-by-query run. Truncated means the
+// limit was reached and more rows may match; repeat the request to continue.
+type DeleteByQueryResponse struct {
+	Status        string   `json:"status"`
+	DryRun        bool     `json:"dry_run"`
+	Matched       int      `json:"matched"`
+	Deleted       int      `json:"deleted"`
+	Truncated     bool     `json:"truncated"`
+	Limit         int      `json:"limit"`
+	ShardsScanned int      `json:"shards_scanned"`
+	ShardsRemoved []string `json:"shards_removed"`
+	Query         string   `json:"query"`
+	StartDate     string   `json:"start_date"`
+	EndDate       string   `json:"end_date"`
+	TimeTaken     int      `json:"time_taken"`
 }
 
 // WorkspaceTime captures either a relative time range that should be
@@ -329,6 +697,73 @@ type WorkspaceResponse struct {
 	Workspace Workspace `json:"workspace"`
 }
 
+// RetentionRule keeps the rows of one source (_src) for at most MaxAge,
+// written as a count and unit such as "3d", "12h" or "2w".
+type RetentionRule struct {
+	Source string `json:"source"`
+	MaxAge string `json:"max_age"`
+}
+
+// RetentionRequest replaces the whole per-source retention rule set.
+type RetentionRequest struct {
+	Rules []RetentionRule `json:"rules"`
+}
+
+// RetentionRunInfo summarises the latest per-source retention sweep.
+type RetentionRunInfo struct {
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	Deleted       int       `json:"deleted"`
+	ShardsRemoved []string  `json:"shards_removed"`
+	Error         string    `json:"error,omitempty"`
+}
+
+type RetentionResponse struct {
+	Status  string            `json:"status"`
+	Rules   []RetentionRule   `json:"rules"`
+	LastRun *RetentionRunInfo `json:"last_run,omitempty"`
+}
+
+// FieldSchemaField declares the type of one parsed field: keyword, text,
+// long, double, bool, ip or date. Stored-only fields are kept for display
+// but cannot be searched.
+type FieldSchemaField struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	StoredOnly bool   `json:"stored_only,omitempty"`
+}
+
+// FieldSchemaRule declares field types for the rows of one source (_src) or
+// of one grok pattern name; exactly one of Source and Pattern is set. When
+// both a source and a pattern rule apply, the source rule wins per field.
+type FieldSchemaRule struct {
+	Source  string             `json:"source,omitempty"`
+	Pattern string             `json:"pattern,omitempty"`
+	Fields  []FieldSchemaField `json:"fields"`
+}
+
+// FieldSchemaRequest replaces the whole field schema.
+type FieldSchemaRequest struct {
+	Rules []FieldSchemaRule `json:"rules"`
+}
+
+type FieldSchemaResponse struct {
+	Status string            `json:"status"`
+	Rules  []FieldSchemaRule `json:"rules"`
+	Types  []string          `json:"types"`
+}
+
+// FieldTypeConflict flags a field indexed as more than one kind of value
+// (text, keyword, number, bool or date), or holding values its declared type
+// refused, which are kept stored-only. Kinds counts the shards indexing each
+// kind; Declared lists the types schema rules give the field.
+type FieldTypeConflict struct {
+	Field    string         `json:"field"`
+	Kinds    map[string]int `json:"kinds"`
+	Rejected uint64         `json:"rejected"`
+	Declared []string       `json:"declared,omitempty"`
+}
+
 // AutosuggestResult represents the result of pattern matching
 type AutosuggestResult struct {
 	PatternName        string  `json:"pattern_name"`
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"logsonic/pkg/storage"
+)
+
+// runStorageCommand dispatches offline maintenance subcommands that operate
+// directly on the storage directory. They open the Bleve shards themselves, so
+// the server must be stopped first.
+func runStorageCommand(args []string) int {
+	if len(args) == 0 {
+		printStorageUsage()
+		return 2
+	}
+	switch args[0] {
+	case "migrate":
+		return runStorageMigrate(args[1:])
+	case "upgrade":
+		return runStorageUpgrade(args[1:])
+	default:
+		printStorageUsage()
+		return 2
+	}
+}
+
+func runStorageMigrate(args []string) int {
+	fs := flag.NewFlagSet("storage migrate", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	storageFlag := fs.String("storage", "", "Path to storage directory (default: STORAGE_PATH or per-user app data dir)")
+	shardFlag := fs.String("shard-granularity", "", "Re-partition into hour, day or week shards (default: persisted setting)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	storagePath, err := resolveStoragePath(*storageFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "storage migrate: %v\n", err)
+		return 1
+	}
+	granularity := *shardFlag
+	if granularity == "" {
+		granularity = os.Getenv("SHARD_GRANULARITY")
+	}
+
+	store, err := storage.NewStorageWithOptions(storagePath, storage.Options{
+		ShardGranularity: storage.ShardGranularity(granularity),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "storage migrate: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "migrating %s to UTC %s shards\n", store.BaseDir(), store.ShardGranularity())
+	result, err := store.MigrateShards(ctx, func(p storage.MigrationProgress) {
+		fmt.Fprintf(os.Stderr, "  %s: scanned %d, moved %d\n", p.Shard, p.Scanned, p.Moved)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "storage migrate: %v\n", err)
+		fmt.Fprintln(os.Stderr, "re-run the command to resume; moved rows keep their IDs")
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "done: %d shards scanned, %d of %d rows moved, %d empty shards removed\n",
+		result.ShardsScanned, result.DocsMoved, result.DocsScanned, result.ShardsRemoved)
+	return 0
+}
+
+func runStorageUpgrade(args []string) int {
+	fs := flag.NewFlagSet("storage upgrade", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	storageFlag := fs.String("storage", "", "Path to storage directory (default: STORAGE_PATH or per-user app data dir)")
+	dryRun := fs.Bool("dry-run", false, "List shards that need an upgrade without rewriting them")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	storagePath, err := resolveStoragePath(*storageFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "storage upgrade: %v\n", err)
+		return 1
+	}
+	store, err := storage.NewStorage(storagePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "storage upgrade: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	pending, err := store.PendingUpgrades()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "storage upgrade: %v\n", err)
+		return 1
+	}
+	if len(pending) == 0 {
+		fmt.Fprintf(os.Stderr, "all shards in %s are current\n", store.BaseDir())
+		return 0
+	}
+	if *dryRun {
+		fmt.Fprintf(os.Stderr, "%d shards need an upgrade:\n", len(pending))
+		for _, key := range pending {
+			fmt.Fprintf(os.Stderr, "  %s\n", key)
+		}
+		return 0
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "upgrading %d shards in %s\n", len(pending), store.BaseDir())
+	result, err := store.UpgradeShards(ctx, func(p storage.UpgradeProgress) {
+		if p.Done {
+			fmt.Fprintf(os.Stderr, "  [%d/%d] %s: %d rows rewritten\n", p.ShardIndex, p.ShardCount, p.Shard, p.Docs)
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "storage upgrade: %v\n", err)
+		fmt.Fprintln(os.Stderr, "re-run the command to resume; finished shards are kept")
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "done: %d shards upgraded, %d rows rewritten\n", result.ShardsUpgraded, result.DocsRewritten)
+	return 0
+}
+
+func printStorageUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  logsonic storage migrate [-storage DIR] [-shard-granularity hour|day|week]")
+	fmt.Fprintln(os.Stderr, "  logsonic storage upgrade [-storage DIR] [-dry-run]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Stop the LogSonic server before running storage commands.")
+}